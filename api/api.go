@@ -0,0 +1,124 @@
+// Package api holds the types the container manager REST API sends and receives.
+// The container manager itself is package main so they live here where
+// clients such as databox-ctl can import them.
+package api
+
+import (
+	"time"
+
+	"github.com/docker/docker/api/types/swarm"
+	libDatabox "github.com/me-box/lib-go-databox"
+)
+
+// InstallRequest is the body of an install or upgrade, the manifest of the component
+type InstallRequest struct {
+	Manifest libDatabox.Manifest `json:"manifest"`
+}
+
+// NameRequest is the body of requests that only name a component such as a restart
+type NameRequest struct {
+	Name string `json:"name"`
+}
+
+// UninstallRequest is the body of the Uninstall FUNC, POST /api/v1/uninstall and the value
+// core-ui writes to the uninstall key of the CM API datasource
+type UninstallRequest struct {
+	Name string `json:"name"`
+	//uninstall even if other components use its datasources
+	Force bool `json:"force"`
+}
+
+// Error is the body of every REST API error response
+type Error struct {
+	Error string `json:"error"`
+	//the components that use the datasources of one that could not be uninstalled
+	Dependents []string `json:"dependents,omitempty"`
+}
+
+// JobType is the kind of operation a job tracks
+type JobType string
+
+const (
+	JobTypeInstall   JobType = "install"
+	JobTypeUninstall JobType = "uninstall"
+	JobTypeRestart   JobType = "restart"
+	JobTypeUpgrade   JobType = "upgrade"
+	JobTypeReload    JobType = "reload"
+	JobTypeStop      JobType = "stop"
+	JobTypeStart     JobType = "start"
+)
+
+// JobState is the step a job has reached. Installs and upgrades move through
+// queued -> pulling -> networking -> store -> permissions -> starting -> running,
+// restarts through queued -> starting -> running and uninstalls through
+// queued -> removed. Any job can end in failed. A reload whose dependencies
+// did not start ends in blocked without being attempted.
+type JobState string
+
+const (
+	JobStateQueued      JobState = "queued"
+	JobStatePulling     JobState = "pulling"
+	JobStateNetworking  JobState = "networking"
+	JobStateStore       JobState = "store"
+	JobStatePermissions JobState = "permissions"
+	JobStateStarting    JobState = "starting"
+	JobStateRunning     JobState = "running"
+	JobStateRemoved     JobState = "removed"
+	JobStateFailed      JobState = "failed"
+	JobStateBlocked     JobState = "blocked"
+	JobStateStopped     JobState = "stopped"
+)
+
+// Job is a single tracked install, uninstall, restart, upgrade or reload
+type Job struct {
+	ID      string    `json:"id"`
+	Type    JobType   `json:"type"`
+	Name    string    `json:"name"`
+	State   JobState  `json:"state"`
+	Error   string    `json:"error,omitempty"`
+	Created time.Time `json:"created"`
+	Updated time.Time `json:"updated"`
+}
+
+// Finished reports if the job has reached a terminal state
+func (j Job) Finished() bool {
+	switch j.State {
+	case JobStateRunning, JobStateRemoved, JobStateFailed, JobStateBlocked, JobStateStopped:
+		return true
+	}
+	return false
+}
+
+// JobAccepted is returned when a REST API request has started a job
+type JobAccepted struct {
+	Job Job    `json:"job"`
+	URL string `json:"url"`
+}
+
+// ComponentStatus is the state of a databox service
+type ComponentStatus struct {
+	Name         string          `json:"name"`
+	Type         string          `json:"type"`
+	DesiredState swarm.TaskState `json:"desiredState"`
+	State        swarm.TaskState `json:"state"`
+	Status       swarm.TaskState `json:"status"`
+}
+
+// LogLine is one line of the log of a databox component
+type LogLine struct {
+	Time   time.Time `json:"time"`
+	Stream string    `json:"stream"`
+	Line   string    `json:"line"`
+}
+
+// DashboardInfo is what the mobile app needs to connect, it is encoded in the dashboard QR code
+type DashboardInfo struct {
+	IP         string   `json:"ip"`
+	IPs        []string `json:"ips"`
+	IPExternal string   `json:"ipExternal"`
+	Hostname   string   `json:"hostname"`
+	//only the password hash is stored so this is blank unless the CM has seen the password
+	Token string `json:"token,omitempty"`
+	//set when Token is blank, the mobile app has to ask for the password
+	PasswordRequired bool `json:"passwordRequired,omitempty"`
+}
@@ -19,15 +19,33 @@ func main() {
 	var options libDatabox.ContainerManagerOptions
 	err = json.Unmarshal(cmOptionsJSON, &options)
 	libDatabox.ChkErrFatal(err)
+	//the resource and readiness settings are not part of libDatabox.ContainerManagerOptions
+	var cmOptions CMOptions
+	err = json.Unmarshal(cmOptionsJSON, &cmOptions)
+	libDatabox.ChkErrFatal(err)
+
+	//put back the certificates and arbiter tokens from a backup before any are generated
+	var restore *RestoreArchive
+	if cmOptions.RestoreArchive != "" {
+		restore, err = OpenRestoreArchive(cmOptions.RestoreArchive, cmOptions.RestorePassphrase)
+		libDatabox.ChkErrFatal(err)
+	}
+	if restore != nil {
+		err = restore.RestoreCerts(certsBasePath, systemCertificates(options.InternalIPs, options.ExternalIP, options.Hostname))
+		libDatabox.ChkErrFatal(err)
+	}
 
 	generateDataboxCertificates(options.InternalIPs, options.ExternalIP, options.Hostname)
 	generateArbiterTokens()
 
-	databox := NewDataboxLoader(&options)
+	cli := NewDockerOrchestrator()
+
+	databox := NewDataboxLoader(cli, &options)
 	rootCASecretID, zmqPublic, zmqPrivate := databox.Start()
 	libDatabox.Debug("key IDs :: " + rootCASecretID + " " + zmqPublic + " " + zmqPrivate)
 
-	cm := NewContainerManager(rootCASecretID, zmqPublic, zmqPrivate, &options)
+	cm := NewContainerManager(cli, rootCASecretID, zmqPublic, zmqPrivate, &options, cmOptions)
+	cm.Restore = restore
 	_, err = cm.WaitForService("arbiter", 10)
 	libDatabox.ChkErrFatal(err)
 
@@ -60,26 +78,30 @@ func generateArbiterTokens() {
 	}
 }
 
-func generateDataboxCertificates(IPs []string, externalIP string, hostname string) {
-
-	rootCAPath := certsBasePath + "/containerManager.crt"
-	rootCAPathPub := certsBasePath + "/containerManagerPub.crt"
+var rootCAPath = certsBasePath + "/containerManager.crt"
+var rootCAPathPub = certsBasePath + "/containerManagerPub.crt"
+
+// systemCert is a certificate of a core component kept in certsBasePath
+type systemCert struct {
+	Name  string
+	IPs   []string
+	Hosts []string
+	Path  string
+	//the swarm secret made from the file by the databox loader, if there is one
+	Secret string
+}
 
-	if _, err := os.Stat(rootCAPath); err != nil {
-		GenRootCA(rootCAPath, rootCAPathPub)
-	}
+// systemCertificates lists the certificates generateDataboxCertificates makes
+func systemCertificates(IPs []string, externalIP string, hostname string) []systemCert {
 
 	//container-manager needs extra information
-	if _, err := os.Stat(certsBasePath + "/container-manager.pem"); err != nil {
-		libDatabox.Debug("[generateDataboxCertificates] making cert for container-manager")
-		GenCertToFile(
-			rootCAPath,
-			"container-manager",
-			append([]string{externalIP, "127.0.0.1"}, IPs...), //“…” is syntax for variadic arguments
-			[]string{"container-manager", "localhost", hostname},
-			certsBasePath+"/container-manager.pem",
-		)
-	}
+	certs := []systemCert{{
+		Name:   "container-manager",
+		IPs:    append([]string{externalIP, "127.0.0.1"}, IPs...), //“…” is syntax for variadic arguments
+		Hosts:  []string{"container-manager", "localhost", hostname},
+		Path:   certsBasePath + "/container-manager.pem",
+		Secret: "DATABOX.pem",
+	}}
 
 	components := []string{
 		"databox-network",
@@ -89,18 +111,34 @@ func generateDataboxCertificates(IPs []string, externalIP string, hostname strin
 	}
 
 	for _, name := range components {
-		if _, err := os.Stat(certsBasePath + "/" + name + ".pem"); err == nil {
+		c := systemCert{
+			Name:  name,
+			IPs:   []string{"127.0.0.1"},
+			Hosts: []string{name, "localhost"},
+			Path:  certsBasePath + "/" + name + ".pem",
+		}
+		if name == "arbiter" {
+			c.Secret = "DATABOX_ARBITER.pem"
+		}
+		certs = append(certs, c)
+	}
+
+	return certs
+}
+
+func generateDataboxCertificates(IPs []string, externalIP string, hostname string) {
+
+	if _, err := os.Stat(rootCAPath); err != nil {
+		GenRootCA(rootCAPath, rootCAPathPub)
+	}
+
+	for _, c := range systemCertificates(IPs, externalIP, hostname) {
+		if _, err := os.Stat(c.Path); err == nil {
 			continue
 		}
-		libDatabox.Debug("[generateDataboxCertificates] making cert for " + name)
-		libDatabox.Info("Making cert " + certsBasePath + "/" + name + ".pem")
-		GenCertToFile(
-			rootCAPath,
-			name,
-			[]string{"127.0.0.1"},
-			[]string{name, "localhost"},
-			certsBasePath+"/"+name+".pem",
-		)
+		libDatabox.Debug("[generateDataboxCertificates] making cert for " + c.Name)
+		libDatabox.Info("Making cert " + c.Path)
+		GenCertToFile(rootCAPath, c.Name, c.IPs, c.Hosts, c.Path)
 	}
 
 }
@@ -0,0 +1,825 @@
+package main
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"hash"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/mount"
+	"github.com/docker/docker/api/types/network"
+	libDatabox "github.com/me-box/lib-go-databox"
+	"golang.org/x/crypto/pbkdf2"
+)
+
+// backupVersion is written to the manifest, archives from a newer version are refused
+const backupVersion = 1
+
+// backupManifestName is the last entry of every archive, it lists the hash of every other entry
+const backupManifestName = "manifest.json"
+
+// backupMagic starts every encrypted archive. It is followed by the salt, the IV,
+// the AES-256-CTR ciphertext of the tar.gz and an HMAC-SHA256 of all that went before.
+const backupMagic = "DBXBAK1\n"
+
+// backupKDFIterations is how many PBKDF2 rounds turn the passphrase into keys
+const backupKDFIterations = 100000
+
+const backupSaltSize = 16
+
+// backupSections are the top level directories of an archive
+var backupSections = []string{"slas", "resources", "stopped", "certs", "volumes"}
+
+// BackupOptions restore a backup when the CM starts. RestoreArchive is the path of an
+// archive made by WriteBackup, RestorePassphrase is needed if it was encrypted.
+type BackupOptions struct {
+	RestoreArchive    string
+	RestorePassphrase string
+}
+
+// backupRequest is the body of a backup request, the archive is encrypted if Passphrase is set
+type backupRequest struct {
+	Passphrase string `json:"passphrase"`
+}
+
+type backupFile struct {
+	Path   string `json:"path"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+type backupManifest struct {
+	Version        int          `json:"version"`
+	Created        time.Time    `json:"created"`
+	Hostname       string       `json:"hostname"`
+	DataboxVersion string       `json:"databoxVersion"`
+	Files          []backupFile `json:"files"`
+}
+
+// backupWriter adds entries to a tar archive and records their hashes for the manifest
+type backupWriter struct {
+	tw       *tar.Writer
+	manifest backupManifest
+}
+
+func (bw *backupWriter) add(name string, r io.Reader, size int64) error {
+
+	err := bw.tw.WriteHeader(&tar.Header{
+		Name:     name,
+		Mode:     0600,
+		Size:     size,
+		ModTime:  time.Now(),
+		Typeflag: tar.TypeReg,
+	})
+	if err != nil {
+		return err
+	}
+
+	h := sha256.New()
+	n, err := io.Copy(bw.tw, io.TeeReader(r, h))
+	if err != nil {
+		return errors.New("Can't write " + name + " " + err.Error())
+	}
+	if n != size {
+		return errors.New("Can't write " + name + " it changed while being read")
+	}
+
+	bw.manifest.Files = append(bw.manifest.Files, backupFile{
+		Path:   name,
+		Size:   size,
+		SHA256: hex.EncodeToString(h.Sum(nil)),
+	})
+	return nil
+}
+
+func (bw *backupWriter) addJSON(name string, v interface{}) error {
+	payload, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return bw.add(name, strings.NewReader(string(payload)), int64(len(payload)))
+}
+
+func (bw *backupWriter) addFile(name string, filePath string) error {
+	f, err := os.Open(filePath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	return bw.add(name, f, info.Size())
+}
+
+// WriteBackup writes a tar.gz to w holding the saved SLAs, resource limits and stopped
+// components, the certs directory (CA, certificates and arbiter tokens) and the contents
+// of every store volume. If passphrase is not blank the archive is encrypted.
+func (cm ContainerManager) WriteBackup(w io.Writer, passphrase string) error {
+
+	var enc *encryptWriter
+	out := w
+	if passphrase != "" {
+		var err error
+		enc, err = newEncryptWriter(w, passphrase)
+		if err != nil {
+			return err
+		}
+		out = enc
+	}
+
+	gz := gzip.NewWriter(out)
+	bw := &backupWriter{
+		tw: tar.NewWriter(gz),
+		manifest: backupManifest{
+			Version:        backupVersion,
+			Created:        time.Now(),
+			Hostname:       cm.Options.Hostname,
+			DataboxVersion: cm.Options.Version,
+			Files:          []backupFile{},
+		},
+	}
+
+	libDatabox.Info("Writing backup")
+
+	err := cm.backupSaved(bw)
+	if err != nil {
+		return err
+	}
+
+	err = cm.backupCerts(bw)
+	if err != nil {
+		return err
+	}
+
+	err = cm.backupVolumes(bw)
+	if err != nil {
+		return err
+	}
+
+	//the manifest goes last so an archive cut short is never accepted
+	err = bw.addJSON(backupManifestName, bw.manifest)
+	if err != nil {
+		return err
+	}
+
+	err = bw.tw.Close()
+	if err != nil {
+		return err
+	}
+	err = gz.Close()
+	if err != nil {
+		return err
+	}
+	if enc != nil {
+		err = enc.Close()
+		if err != nil {
+			return err
+		}
+	}
+
+	libDatabox.Info("Backup written with " + strconv.Itoa(len(bw.manifest.Files)) + " files")
+	return nil
+}
+
+// backupSaved adds what the CM store knows about each installed app and driver
+func (cm ContainerManager) backupSaved(bw *backupWriter) error {
+
+	slaList, err := cm.Store.GetAllSLAs()
+	if err != nil {
+		return errors.New("Can't read saved SLAs " + err.Error())
+	}
+
+	for _, sla := range slaList {
+		err = bw.addJSON("slas/"+sla.Name+".json", sla)
+		if err != nil {
+			return err
+		}
+		if limits, err := cm.Store.GetResources(sla.Name); err == nil {
+			err = bw.addJSON("resources/"+sla.Name+".json", limits)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	stopped, err := cm.Store.GetAllStopped()
+	if err != nil {
+		return errors.New("Can't read stopped components " + err.Error())
+	}
+	for name, rec := range stopped {
+		err = bw.addJSON("stopped/"+name+".json", rec)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// backupCerts adds the CA, certificates and arbiter tokens
+func (cm ContainerManager) backupCerts(bw *backupWriter) error {
+
+	files, err := ioutil.ReadDir(certsBasePath)
+	if err != nil {
+		return errors.New("Can't read " + certsBasePath + " " + err.Error())
+	}
+
+	for _, f := range files {
+		if !f.Mode().IsRegular() || f.Name() == filepath.Base(initialPasswordFile) {
+			continue
+		}
+		err = bw.addFile("certs/"+f.Name(), filepath.Join(certsBasePath, f.Name()))
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// backupVolumes adds the contents of the CM store volume and the store volume of every
+// installed app and driver. Each is a tar archive as returned by docker cp.
+func (cm ContainerManager) backupVolumes(bw *backupWriter) error {
+
+	vols, err := cm.storeVolumes()
+	if err != nil {
+		return err
+	}
+
+	for _, vol := range vols {
+		if !cm.volumeExists(vol) {
+			libDatabox.Warn("[Backup] store volume " + vol + " does not exist")
+			continue
+		}
+		err = cm.backupVolume(bw, vol)
+		if err != nil {
+			return errors.New("Can't back up " + vol + " " + err.Error())
+		}
+	}
+
+	return nil
+}
+
+// backupVolume adds the contents of vol. Its store is paused while it is copied so
+// the copy is not torn by writes made during it. It is as consistent as the volume
+// would be after a power cut, which the stores are built to recover from.
+func (cm ContainerManager) backupVolume(bw *backupWriter, vol string) error {
+
+	id, err := cm.volumeContainer(vol)
+	if err != nil {
+		return err
+	}
+	defer cm.cli.ContainerRemove(context.Background(), id, types.ContainerRemoveOptions{Force: true})
+
+	//the tar header needs the size up front
+	tmp, err := ioutil.TempFile("", "databox-backup-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	size, err := cm.copyVolumePaused(vol, id, tmp)
+	if err != nil {
+		return err
+	}
+	_, err = tmp.Seek(0, io.SeekStart)
+	if err != nil {
+		return err
+	}
+
+	return bw.add("volumes/"+vol+".tar", tmp, size)
+}
+
+// copyVolumePaused copies /database from the volume container id to w with the store
+// using vol paused. The store is unpaused as soon as the copy is written.
+func (cm ContainerManager) copyVolumePaused(vol string, id string, w io.Writer) (int64, error) {
+
+	//the store service has the same name as its volume
+	if store, err := cm.runningContainerFor(vol); err == nil && store.State == "running" {
+		err = cm.cli.ContainerPause(context.Background(), store.ID)
+		if err != nil {
+			return 0, errors.New("Can't pause the store " + vol + " " + err.Error())
+		}
+		defer func() {
+			err := cm.cli.ContainerUnpause(context.Background(), store.ID)
+			if err != nil {
+				libDatabox.Err("[Backup] Can't unpause the store " + vol + " " + err.Error())
+			}
+		}()
+	}
+
+	content, _, err := cm.cli.CopyFromContainer(context.Background(), id, "/database")
+	if err != nil {
+		return 0, err
+	}
+	defer content.Close()
+
+	return io.Copy(w, content)
+}
+
+// storeVolumes lists the volumes made by launchStore for the CM and every saved SLA
+func (cm ContainerManager) storeVolumes() ([]string, error) {
+
+	slaList, err := cm.Store.GetAllSLAs()
+	if err != nil {
+		return nil, errors.New("Can't read saved SLAs " + err.Error())
+	}
+
+	vols := []string{"container-manager-" + cm.CoreStoreName}
+	for _, sla := range slaList {
+		if sla.ResourceRequirements.Store != "" {
+			vols = append(vols, sla.Name+"-"+sla.ResourceRequirements.Store)
+		}
+	}
+	return vols, nil
+}
+
+// volumeContainer creates, but does not start, a container with vol mounted at /database
+// so its contents can be copied in and out. The caller must remove it.
+func (cm ContainerManager) volumeContainer(vol string) (string, error) {
+
+	image := cm.Options.DefaultStoreImage
+	pullImageIfRequired(cm.cli, image, cm.Options.DefaultRegistry, cm.Options.DefaultRegistryHost)
+
+	config := &container.Config{
+		Image: image,
+	}
+	hostConfig := &container.HostConfig{
+		Mounts: []mount.Mount{
+			mount.Mount{
+				Source: vol,
+				Target: "/database",
+				Type:   mount.TypeVolume,
+			},
+		},
+	}
+
+	body, err := cm.cli.ContainerCreate(context.Background(), config, hostConfig, &network.NetworkingConfig{}, "")
+	if err != nil {
+		return "", err
+	}
+	return body.ID, nil
+}
+
+// encryptWriter encrypts everything written to it, Close writes the HMAC
+type encryptWriter struct {
+	w      io.Writer
+	stream cipher.Stream
+	mac    hash.Hash
+}
+
+func newEncryptWriter(w io.Writer, passphrase string) (*encryptWriter, error) {
+
+	salt := make([]byte, backupSaltSize)
+	iv := make([]byte, aes.BlockSize)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	if _, err := rand.Read(iv); err != nil {
+		return nil, err
+	}
+
+	encKey, macKey := backupKeys(passphrase, salt)
+	block, err := aes.NewCipher(encKey)
+	if err != nil {
+		return nil, err
+	}
+
+	ew := &encryptWriter{
+		w:      w,
+		stream: cipher.NewCTR(block, iv),
+		mac:    hmac.New(sha256.New, macKey),
+	}
+
+	header := append(append([]byte(backupMagic), salt...), iv...)
+	ew.mac.Write(header)
+	_, err = w.Write(header)
+	return ew, err
+}
+
+func (ew *encryptWriter) Write(p []byte) (int, error) {
+	buf := make([]byte, len(p))
+	ew.stream.XORKeyStream(buf, p)
+	ew.mac.Write(buf)
+	return ew.w.Write(buf)
+}
+
+func (ew *encryptWriter) Close() error {
+	_, err := ew.w.Write(ew.mac.Sum(nil))
+	return err
+}
+
+// backupKeys derives the AES and HMAC keys from passphrase
+func backupKeys(passphrase string, salt []byte) ([]byte, []byte) {
+	key := pbkdf2.Key([]byte(passphrase), salt, backupKDFIterations, 64, sha256.New)
+	return key[:32], key[32:]
+}
+
+// openBackupStream returns the tar.gz stream of the archive at archivePath.
+// Encrypted archives have their HMAC checked before anything is decrypted.
+func openBackupStream(archivePath string, passphrase string) (io.Reader, io.Closer, error) {
+
+	f, err := os.Open(archivePath)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	headerLen := int64(len(backupMagic) + backupSaltSize + aes.BlockSize)
+	header := make([]byte, headerLen)
+	n, _ := io.ReadFull(f, header)
+	if n < len(backupMagic) || string(header[:len(backupMagic)]) != backupMagic {
+		//not encrypted
+		_, err = f.Seek(0, io.SeekStart)
+		if err != nil {
+			f.Close()
+			return nil, nil, err
+		}
+		return f, f, nil
+	}
+
+	if passphrase == "" {
+		f.Close()
+		return nil, nil, errors.New(archivePath + " is encrypted and no passphrase was given")
+	}
+	if int64(n) < headerLen {
+		f.Close()
+		return nil, nil, errors.New(archivePath + " is too short")
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, nil, err
+	}
+	bodyLen := info.Size() - headerLen - sha256.Size
+	if bodyLen < 0 {
+		f.Close()
+		return nil, nil, errors.New(archivePath + " is too short")
+	}
+
+	salt := header[len(backupMagic) : len(backupMagic)+backupSaltSize]
+	iv := header[len(backupMagic)+backupSaltSize:]
+	encKey, macKey := backupKeys(passphrase, salt)
+
+	mac := hmac.New(sha256.New, macKey)
+	mac.Write(header)
+	_, err = io.Copy(mac, io.NewSectionReader(f, headerLen, bodyLen))
+	if err != nil {
+		f.Close()
+		return nil, nil, err
+	}
+	tag := make([]byte, sha256.Size)
+	_, err = f.ReadAt(tag, headerLen+bodyLen)
+	if err != nil {
+		f.Close()
+		return nil, nil, err
+	}
+	if !hmac.Equal(tag, mac.Sum(nil)) {
+		f.Close()
+		return nil, nil, errors.New(archivePath + " can't be decrypted, the passphrase is wrong or the archive has been changed")
+	}
+
+	block, err := aes.NewCipher(encKey)
+	if err != nil {
+		f.Close()
+		return nil, nil, err
+	}
+	r := cipher.StreamReader{
+		S: cipher.NewCTR(block, iv),
+		R: io.NewSectionReader(f, headerLen, bodyLen),
+	}
+	return r, f, nil
+}
+
+// RestoreArchive is a backup unpacked to a temporary directory with every file checked against the manifest
+type RestoreArchive struct {
+	Path     string
+	Manifest backupManifest
+	dir      string
+}
+
+// OpenRestoreArchive unpacks and checks the archive at archivePath. It returns nil
+// if the archive has already been restored.
+func OpenRestoreArchive(archivePath string, passphrase string) (*RestoreArchive, error) {
+
+	if _, err := os.Stat(archivePath + ".restored"); err == nil {
+		libDatabox.Info(archivePath + " has already been restored, remove " + archivePath + ".restored to restore it again")
+		return nil, nil
+	}
+
+	libDatabox.Info("Restoring backup " + archivePath)
+
+	r, closer, err := openBackupStream(archivePath, passphrase)
+	if err != nil {
+		return nil, err
+	}
+	defer closer.Close()
+
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, errors.New(archivePath + " is not a backup archive " + err.Error())
+	}
+
+	dir, err := ioutil.TempDir("", "databox-restore-")
+	if err != nil {
+		return nil, err
+	}
+
+	ra := &RestoreArchive{Path: archivePath, dir: dir}
+	err = ra.extract(gz)
+	if err != nil {
+		ra.Close()
+		return nil, errors.New("Can't restore " + archivePath + " " + err.Error())
+	}
+
+	return ra, nil
+}
+
+// extract unpacks the tar stream and checks it against the manifest
+func (ra *RestoreArchive) extract(r io.Reader) error {
+
+	hashes := map[string]backupFile{}
+	var manifest []byte
+
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if hdr.Typeflag != tar.TypeReg && hdr.Typeflag != tar.TypeRegA {
+			return errors.New("unexpected entry " + hdr.Name)
+		}
+		if !validBackupPath(hdr.Name) {
+			return errors.New("bad path " + hdr.Name)
+		}
+		if _, dup := hashes[hdr.Name]; dup {
+			return errors.New("duplicate entry " + hdr.Name)
+		}
+
+		target := filepath.Join(ra.dir, filepath.FromSlash(hdr.Name))
+		err = os.MkdirAll(filepath.Dir(target), 0700)
+		if err != nil {
+			return err
+		}
+		f, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+		if err != nil {
+			return err
+		}
+		h := sha256.New()
+		size, err := io.Copy(io.MultiWriter(f, h), tr)
+		f.Close()
+		if err != nil {
+			return err
+		}
+
+		hashes[hdr.Name] = backupFile{Path: hdr.Name, Size: size, SHA256: hex.EncodeToString(h.Sum(nil))}
+		if hdr.Name == backupManifestName {
+			manifest, err = ioutil.ReadFile(target)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	if manifest == nil {
+		return errors.New("it has no " + backupManifestName + ", it may be incomplete")
+	}
+	err := json.Unmarshal(manifest, &ra.Manifest)
+	if err != nil {
+		return errors.New("bad " + backupManifestName + " " + err.Error())
+	}
+	if ra.Manifest.Version > backupVersion {
+		return errors.New("it was made by a newer version of databox (backup version " + strconv.Itoa(ra.Manifest.Version) + ")")
+	}
+
+	for _, want := range ra.Manifest.Files {
+		got, ok := hashes[want.Path]
+		if !ok {
+			return errors.New(want.Path + " is missing")
+		}
+		if got != want {
+			return errors.New(want.Path + " does not match the manifest")
+		}
+		delete(hashes, want.Path)
+	}
+	delete(hashes, backupManifestName)
+	if len(hashes) > 0 {
+		names := []string{}
+		for name := range hashes {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return errors.New(strings.Join(names, ", ") + " not in the manifest")
+	}
+
+	return nil
+}
+
+// validBackupPath reports if name is a clean relative path under one of the backupSections
+func validBackupPath(name string) bool {
+	if name == backupManifestName {
+		return true
+	}
+	if path.Clean(name) != name || path.IsAbs(name) || strings.Contains(name, "\\") {
+		return false
+	}
+	parts := strings.Split(name, "/")
+	if len(parts) != 2 || parts[1] == "" || parts[1] == "." || parts[1] == ".." {
+		return false
+	}
+	for _, s := range backupSections {
+		if parts[0] == s {
+			return true
+		}
+	}
+	return false
+}
+
+// files lists the manifest entries in section
+func (ra *RestoreArchive) files(section string) []string {
+	files := []string{}
+	for _, f := range ra.Manifest.Files {
+		if strings.HasPrefix(f.Path, section+"/") {
+			files = append(files, f.Path)
+		}
+	}
+	sort.Strings(files)
+	return files
+}
+
+func (ra *RestoreArchive) open(name string) (*os.File, error) {
+	return os.Open(filepath.Join(ra.dir, filepath.FromSlash(name)))
+}
+
+func (ra *RestoreArchive) readJSON(name string, v interface{}) error {
+	payload, err := ioutil.ReadFile(filepath.Join(ra.dir, filepath.FromSlash(name)))
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(payload, v)
+}
+
+// RestoreCerts puts the CA, certificates and arbiter tokens back in dir, replacing any already there.
+// A certificate of a core component that is not for the addresses in current, as the container
+// manager's is not when restoring onto another host, is left out so a new one is made for this host.
+func (ra *RestoreArchive) RestoreCerts(dir string, current []systemCert) error {
+
+	err := os.MkdirAll(dir, 0700)
+	if err != nil {
+		return err
+	}
+
+	system := map[string]systemCert{}
+	for _, sc := range current {
+		system[path.Base(sc.Path)] = sc
+	}
+
+	for _, name := range ra.files("certs") {
+		payload, err := ioutil.ReadFile(filepath.Join(ra.dir, filepath.FromSlash(name)))
+		if err != nil {
+			return err
+		}
+		dst := filepath.Join(dir, path.Base(name))
+		if sc, ok := system[path.Base(name)]; ok && !certCovers(payload, sc.IPs, sc.Hosts) {
+			libDatabox.Warn("[Restore] not restoring " + path.Base(name) + " it is for another host")
+			//any already here was signed by the CA being replaced
+			err = os.Remove(dst)
+			if err != nil && !os.IsNotExist(err) {
+				return err
+			}
+			continue
+		}
+		err = ioutil.WriteFile(dst, payload, 0600)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Close removes the unpacked archive
+func (ra *RestoreArchive) Close() {
+	os.RemoveAll(ra.dir)
+}
+
+// finish records that the archive has been restored so it is not restored again on the next start
+func (ra *RestoreArchive) finish() {
+	err := ioutil.WriteFile(ra.Path+".restored", []byte(time.Now().Format(time.RFC3339)+"\n"), 0600)
+	if err != nil {
+		libDatabox.Err("Can't mark " + ra.Path + " as restored " + err.Error())
+	}
+	ra.Close()
+	libDatabox.Info("Restored backup " + ra.Path + " made " + ra.Manifest.Created.Format(time.RFC3339))
+}
+
+// restoreVolumes copies the store volumes in ra back into docker volumes.
+// It must run before the stores are launched. Volumes whose store is already
+// running are left alone. Every volume is tried, the last error is returned.
+func (cm ContainerManager) restoreVolumes(ra *RestoreArchive) error {
+
+	var lastErr error
+	for _, name := range ra.files("volumes") {
+		vol := strings.TrimSuffix(path.Base(name), ".tar")
+		if _, err := cm.serviceByName(vol); err == nil {
+			libDatabox.Warn("[Restore] not restoring " + vol + " its store is already running")
+			continue
+		}
+
+		libDatabox.Info("[Restore] restoring volume " + vol)
+		err := cm.restoreVolume(ra, name, vol)
+		if err != nil {
+			libDatabox.Err("[Restore] Can't restore volume " + vol + " " + err.Error())
+			lastErr = errors.New("Can't restore volume " + vol + " " + err.Error())
+		}
+	}
+	return lastErr
+}
+
+func (cm ContainerManager) restoreVolume(ra *RestoreArchive, name string, vol string) error {
+
+	f, err := ra.open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	id, err := cm.volumeContainer(vol)
+	if err != nil {
+		return err
+	}
+	defer cm.cli.ContainerRemove(context.Background(), id, types.ContainerRemoveOptions{Force: true})
+
+	//the archive came from docker cp of /database so its entries start with database/
+	return cm.cli.CopyToContainer(context.Background(), id, "/", f, types.CopyToContainerOptions{})
+}
+
+// restoreSaved writes the SLAs, resource limits and stopped components in ra to the CM store
+// so reloadApps launches them.
+func (cm ContainerManager) restoreSaved(ra *RestoreArchive) error {
+
+	for _, name := range ra.files("slas") {
+		var sla libDatabox.SLA
+		err := ra.readJSON(name, &sla)
+		if err != nil {
+			return errors.New("Can't read " + name + " " + err.Error())
+		}
+		err = cm.Store.SaveSLA(sla)
+		if err != nil {
+			return errors.New("Can't save SLA for " + sla.Name + " " + err.Error())
+		}
+	}
+
+	for _, name := range ra.files("resources") {
+		var limits ResourceLimits
+		err := ra.readJSON(name, &limits)
+		if err != nil {
+			return errors.New("Can't read " + name + " " + err.Error())
+		}
+		err = cm.Store.SaveResources(strings.TrimSuffix(path.Base(name), ".json"), limits)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, name := range ra.files("stopped") {
+		var rec stoppedRecord
+		err := ra.readJSON(name, &rec)
+		if err != nil {
+			return errors.New("Can't read " + name + " " + err.Error())
+		}
+		err = cm.Store.SaveStopped(strings.TrimSuffix(path.Base(name), ".json"), rec)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
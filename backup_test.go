@@ -0,0 +1,274 @@
+package main
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	libDatabox "github.com/me-box/lib-go-databox"
+)
+
+// writeTestArchive writes an archive to a temporary file the way WriteBackup does.
+// build adds the entries, the manifest is added after them if withManifest is set.
+func writeTestArchive(t *testing.T, passphrase string, build func(bw *backupWriter), withManifest bool) string {
+
+	f, err := ioutil.TempFile("", "databox-backup-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	var enc *encryptWriter
+	var gz *gzip.Writer
+	if passphrase != "" {
+		enc, err = newEncryptWriter(f, passphrase)
+		if err != nil {
+			t.Fatal(err)
+		}
+		gz = gzip.NewWriter(enc)
+	} else {
+		gz = gzip.NewWriter(f)
+	}
+
+	bw := &backupWriter{
+		tw:       tar.NewWriter(gz),
+		manifest: backupManifest{Version: backupVersion, Files: []backupFile{}},
+	}
+	build(bw)
+	if withManifest {
+		if err := bw.addJSON(backupManifestName, bw.manifest); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := bw.tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if enc != nil {
+		if err := enc.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return f.Name()
+}
+
+func addSLA(bw *backupWriter, name string) {
+	bw.addJSON("slas/"+name+".json", libDatabox.SLA{Name: name, DataboxType: libDatabox.DataboxTypeDriver})
+}
+
+func TestBackupEncryption(t *testing.T) {
+
+	tests := []struct {
+		name       string
+		passphrase string
+		tamper     bool
+		restoreAs  string
+		wantErr    string
+	}{
+		{name: "plain", restoreAs: "ignored"},
+		{name: "encrypted", passphrase: "open sesame", restoreAs: "open sesame"},
+		{name: "wrong passphrase", passphrase: "open sesame", restoreAs: "open sesame!", wantErr: "can't be decrypted"},
+		{name: "no passphrase", passphrase: "open sesame", wantErr: "no passphrase was given"},
+		{name: "tampered", passphrase: "open sesame", restoreAs: "open sesame", tamper: true, wantErr: "can't be decrypted"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			archive := writeTestArchive(t, tt.passphrase, func(bw *backupWriter) { addSLA(bw, "driver-backup") }, true)
+			defer os.Remove(archive)
+
+			if tt.tamper {
+				payload, err := ioutil.ReadFile(archive)
+				if err != nil {
+					t.Fatal(err)
+				}
+				payload[len(payload)/2] ^= 0x01
+				if err := ioutil.WriteFile(archive, payload, 0600); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			ra, err := OpenRestoreArchive(archive, tt.restoreAs)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer ra.Close()
+
+			var sla libDatabox.SLA
+			err = ra.readJSON("slas/driver-backup.json", &sla)
+			if err != nil || sla.Name != "driver-backup" {
+				t.Errorf("restored SLA = %+v, %v", sla, err)
+			}
+			if files := ra.files("slas"); len(files) != 1 {
+				t.Errorf("restored slas = %v, want one", files)
+			}
+		})
+	}
+}
+
+// archives with entries that could land outside the restore directory or that
+// do not match the manifest are refused before anything is restored
+func TestBackupRefused(t *testing.T) {
+
+	tests := []struct {
+		name         string
+		build        func(bw *backupWriter)
+		withManifest bool
+		wantErr      string
+	}{
+		{
+			name:         "parent directory",
+			build:        func(bw *backupWriter) { addSLA(bw, "../../outside") },
+			withManifest: true,
+			wantErr:      "bad path",
+		},
+		{
+			name: "absolute path",
+			build: func(bw *backupWriter) {
+				bw.add("/slas/driver-backup.json", strings.NewReader("{}"), 2)
+			},
+			withManifest: true,
+			wantErr:      "bad path",
+		},
+		{
+			name: "outside the sections",
+			build: func(bw *backupWriter) {
+				bw.add("home/driver-backup.json", strings.NewReader("{}"), 2)
+			},
+			withManifest: true,
+			wantErr:      "bad path",
+		},
+		{
+			name: "duplicate entry",
+			build: func(bw *backupWriter) {
+				addSLA(bw, "driver-backup")
+				addSLA(bw, "driver-backup")
+			},
+			withManifest: true,
+			wantErr:      "duplicate entry",
+		},
+		{
+			name: "not in the manifest",
+			build: func(bw *backupWriter) {
+				addSLA(bw, "driver-backup")
+				bw.tw.WriteHeader(&tar.Header{Name: "certs/extra.pem", Mode: 0600, Size: 1, Typeflag: tar.TypeReg})
+				bw.tw.Write([]byte("x"))
+			},
+			withManifest: true,
+			wantErr:      "certs/extra.pem not in the manifest",
+		},
+		{
+			name: "changed after the manifest",
+			build: func(bw *backupWriter) {
+				addSLA(bw, "driver-backup")
+				bw.manifest.Files[0].SHA256 = strings.Repeat("0", 64)
+			},
+			withManifest: true,
+			wantErr:      "does not match the manifest",
+		},
+		{
+			name: "listed but missing",
+			build: func(bw *backupWriter) {
+				bw.manifest.Files = append(bw.manifest.Files, backupFile{Path: "slas/driver-backup.json"})
+			},
+			withManifest: true,
+			wantErr:      "is missing",
+		},
+		{
+			name:    "no manifest",
+			build:   func(bw *backupWriter) { addSLA(bw, "driver-backup") },
+			wantErr: "has no " + backupManifestName,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			archive := writeTestArchive(t, "", tt.build, tt.withManifest)
+			defer os.Remove(archive)
+
+			ra, err := OpenRestoreArchive(archive, "")
+			if err == nil {
+				ra.Close()
+				t.Fatalf("the archive was accepted, want %q", tt.wantErr)
+			}
+			if !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// the container manager certificate names the host it was made on, restored onto
+// another host it is left out so a new one is made. The rest are restored.
+func TestRestoreCerts(t *testing.T) {
+
+	oldHost := systemCertificates([]string{"10.0.0.1"}, "192.168.1.1", "old-host")
+	cmCert := GenCert(rootCAPath, "container-manager", oldHost[0].IPs, oldHost[0].Hosts)
+	arbiterCert := GenCert(rootCAPath, "arbiter", []string{"127.0.0.1"}, []string{"arbiter", "localhost"})
+
+	archive := writeTestArchive(t, "", func(bw *backupWriter) {
+		bw.add("certs/container-manager.pem", strings.NewReader(string(cmCert)), int64(len(cmCert)))
+		bw.add("certs/arbiter.pem", strings.NewReader(string(arbiterCert)), int64(len(arbiterCert)))
+		bw.add("certs/arbiterToken-arbiter", strings.NewReader("token"), 5)
+	}, true)
+	defer os.Remove(archive)
+
+	tests := []struct {
+		name      string
+		current   []systemCert
+		wantCMPem bool
+	}{
+		{name: "same host", current: oldHost, wantCMPem: true},
+		{name: "new hostname", current: systemCertificates([]string{"10.0.0.1"}, "192.168.1.1", "new-host")},
+		{name: "new IP", current: systemCertificates([]string{"10.0.0.2"}, "192.168.1.1", "old-host")},
+		{name: "no external IP", current: systemCertificates([]string{"10.0.0.1"}, "", "old-host"), wantCMPem: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ra, err := OpenRestoreArchive(archive, "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer ra.Close()
+
+			dir, err := ioutil.TempDir("", "databox-restore-certs-")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+			//made by a CA the restored one replaces
+			ioutil.WriteFile(filepath.Join(dir, "container-manager.pem"), []byte("stale"), 0600)
+
+			err = ra.RestoreCerts(dir, tt.current)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			restored, err := ioutil.ReadFile(filepath.Join(dir, "container-manager.pem"))
+			if tt.wantCMPem && string(restored) != string(cmCert) {
+				t.Errorf("container-manager.pem = %.20q, %v want the restored certificate", restored, err)
+			}
+			if !tt.wantCMPem && !os.IsNotExist(err) {
+				t.Errorf("container-manager.pem for another host was kept %.20q, %v", restored, err)
+			}
+			for _, name := range []string{"arbiter.pem", "arbiterToken-arbiter"} {
+				if _, err := os.Stat(filepath.Join(dir, name)); err != nil {
+					t.Errorf("%s was not restored %v", name, err)
+				}
+			}
+		})
+	}
+}
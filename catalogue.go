@@ -0,0 +1,342 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	libDatabox "github.com/me-box/lib-go-databox"
+)
+
+// catalogueMaxAge is how long a catalogue is served from the cache before the stores are asked again
+var catalogueMaxAge = 10 * time.Second
+
+// catalogueStoreTimeout is how long a store has to return its catalogue before it is reported unreachable
+var catalogueStoreTimeout = 5 * time.Second
+
+// hypercat rels used to filter datasources
+const (
+	relType     = "urn:X-databox:rels:hasType"
+	relVendor   = "urn:X-databox:rels:hasVendor"
+	relLocation = "urn:X-databox:rels:hasLocation"
+)
+
+// catalogueFilter selects datasources, blank fields match anything
+type catalogueFilter struct {
+	Type     string `json:"type"`
+	Vendor   string `json:"vendor"`
+	Location string `json:"location"`
+	Store    string `json:"store"`
+	Refresh  bool   `json:"refresh"`
+}
+
+// UnreachableStore is a store that did not return its catalogue on the last refresh
+// or an entry in the root catalogue that is not a store the CM can ask
+type UnreachableStore struct {
+	Store string `json:"store"`
+	Error string `json:"error"`
+}
+
+// CatalogueReport is every datasource known to the arbiter. Version goes up each time
+// the set of datasources changes so callers can tell if they need to redraw.
+type CatalogueReport struct {
+	Datasources []libDatabox.HypercatItem `json:"datasources"`
+	Unreachable []UnreachableStore        `json:"unreachable"`
+	Updated     time.Time                 `json:"updated"`
+	Version     int                       `json:"version"`
+}
+
+// CatalogueSource is the part of the arbiter API used to find the stores.
+// A libDatabox.ArbiterClient satisfies it.
+type CatalogueSource interface {
+	GetRootDataSourceCatalogue() (libDatabox.HypercatRoot, error)
+}
+
+// catalogueClient is a connection to one store kept between refreshes
+type catalogueClient interface {
+	GetStoreDataSourceCatalogue(href string) (libDatabox.HypercatRoot, error)
+	Close()
+}
+
+// coreStoreCatalogueClient is a catalogueClient whose requests give up after catalogueStoreTimeout
+type coreStoreCatalogueClient struct {
+	*libDatabox.CoreStoreClient
+	close func()
+}
+
+func newCoreStoreCatalogueClient(arbiter *libDatabox.ArbiterClient, storeURL string) catalogueClient {
+	client := libDatabox.NewCoreStoreClient(arbiter, "/run/secrets/ZMQ_PUBLIC_KEY", storeURL, false)
+	return &coreStoreCatalogueClient{
+		CoreStoreClient: client,
+		close:           closeProbeSocket(client.ZestC.ZMQsoc, catalogueStoreTimeout),
+	}
+}
+
+// Close closes the connection to the store
+func (c *coreStoreCatalogueClient) Close() {
+	c.close()
+}
+
+type storeCatalogue struct {
+	items []libDatabox.HypercatItem
+	raw   string
+	err   error
+	//set for root catalogue entries that were skipped, they have no store URL
+	href string
+}
+
+// CatalogueAggregator merges the catalogues of every store registered with the arbiter.
+// Store clients are kept between refreshes and results are cached for catalogueMaxAge.
+type CatalogueAggregator struct {
+	arbiter   CatalogueSource
+	newClient func(storeURL string) catalogueClient
+
+	//held for the whole of a refresh so only one runs at a time
+	refreshMu sync.Mutex
+
+	mu      sync.Mutex
+	clients map[string]catalogueClient
+	stores  map[string]storeCatalogue
+	updated time.Time
+	version int
+}
+
+// NewCatalogueAggregator returns an empty CatalogueAggregator using arbiter to find the stores
+func NewCatalogueAggregator(arbiter *libDatabox.ArbiterClient) *CatalogueAggregator {
+	return &CatalogueAggregator{
+		arbiter: arbiter,
+		newClient: func(storeURL string) catalogueClient {
+			return newCoreStoreCatalogueClient(arbiter, storeURL)
+		},
+		clients: make(map[string]catalogueClient),
+		stores:  make(map[string]storeCatalogue),
+	}
+}
+
+// Report returns the datasources matching filter. The stores are only asked again
+// if the cache is older than catalogueMaxAge or filter.Refresh is set.
+func (ca *CatalogueAggregator) Report(filter catalogueFilter) (CatalogueReport, error) {
+
+	ca.mu.Lock()
+	stale := filter.Refresh || time.Since(ca.updated) > catalogueMaxAge
+	ca.mu.Unlock()
+
+	if stale {
+		err := ca.refresh(filter.Refresh)
+		if err != nil {
+			return CatalogueReport{}, err
+		}
+	}
+
+	ca.mu.Lock()
+	defer ca.mu.Unlock()
+
+	report := CatalogueReport{
+		Datasources: []libDatabox.HypercatItem{},
+		Unreachable: []UnreachableStore{},
+		Updated:     ca.updated,
+		Version:     ca.version,
+	}
+
+	urls := []string{}
+	for storeURL := range ca.stores {
+		urls = append(urls, storeURL)
+	}
+	sort.Strings(urls)
+
+	for _, storeURL := range urls {
+		cat := ca.stores[storeURL]
+		name := storeName(storeURL)
+		if cat.href != "" {
+			name = cat.href
+		}
+		if filter.Store != "" && name != filter.Store {
+			continue
+		}
+		if cat.err != nil {
+			report.Unreachable = append(report.Unreachable, UnreachableStore{Store: name, Error: cat.err.Error()})
+			continue
+		}
+		for _, item := range cat.items {
+			if filter.matches(item) {
+				report.Datasources = append(report.Datasources, item)
+			}
+		}
+	}
+
+	return report, nil
+}
+
+// refresh asks every store for its catalogue in parallel. Unless force is set it does
+// nothing if another caller refreshed while this one was waiting its turn.
+func (ca *CatalogueAggregator) refresh(force bool) error {
+
+	requested := time.Now()
+	ca.refreshMu.Lock()
+	defer ca.refreshMu.Unlock()
+
+	ca.mu.Lock()
+	fresh := !force && ca.updated.After(requested)
+	ca.mu.Unlock()
+	if fresh {
+		return nil
+	}
+
+	hyperCatRoot, err := ca.arbiter.GetRootDataSourceCatalogue()
+	if err != nil {
+		libDatabox.Err("[Catalogue] GetRootDataSourceCatalogue " + err.Error())
+		return err
+	}
+
+	storeURLs := map[string]string{}
+	results := map[string]storeCatalogue{}
+	for _, item := range hyperCatRoot.Items {
+		storeURL, err := libDatabox.GetStoreURLFromDsHref(item.Href)
+		if err != nil {
+			libDatabox.Warn("[Catalogue] bad store href " + item.Href + " " + err.Error())
+			results[item.Href] = storeCatalogue{err: errors.New("bad store href " + err.Error()), raw: "skipped", href: item.Href}
+			continue
+		}
+		storeURLs[storeURL] = item.Href
+	}
+
+	var wg sync.WaitGroup
+	var resMu sync.Mutex
+	for storeURL, href := range storeURLs {
+		wg.Add(1)
+		go func(storeURL string, href string) {
+			defer wg.Done()
+			cat := ca.fetch(storeURL, href)
+			resMu.Lock()
+			results[storeURL] = cat
+			resMu.Unlock()
+		}(storeURL, href)
+	}
+	wg.Wait()
+
+	ca.mu.Lock()
+	defer ca.mu.Unlock()
+
+	changed := len(results) != len(ca.stores)
+	for storeURL, cat := range results {
+		old, ok := ca.stores[storeURL]
+		if !ok || old.raw != cat.raw {
+			changed = true
+		}
+	}
+	for storeURL, client := range ca.clients {
+		if _, ok := results[storeURL]; !ok {
+			//the store has gone
+			delete(ca.clients, storeURL)
+			client.Close()
+		}
+	}
+	ca.stores = results
+	ca.updated = time.Now()
+	if changed {
+		ca.version++
+	}
+
+	return nil
+}
+
+// fetch gets the catalogue of one store giving up after catalogueStoreTimeout
+func (ca *CatalogueAggregator) fetch(storeURL string, href string) storeCatalogue {
+
+	client := ca.client(storeURL)
+
+	type result struct {
+		cat libDatabox.HypercatRoot
+		err error
+	}
+	done := make(chan result, 1)
+	go func() {
+		cat, err := client.GetStoreDataSourceCatalogue(href)
+		done <- result{cat, err}
+	}()
+
+	var res result
+	timedOut := false
+	select {
+	case res = <-done:
+	case <-time.After(catalogueStoreTimeout):
+		res.err = errors.New(storeName(storeURL) + " did not reply within " + catalogueStoreTimeout.String())
+		timedOut = true
+	}
+
+	if res.err != nil {
+		libDatabox.Warn("[Catalogue] GetStoreDataSourceCatalogue " + href + " " + res.err.Error())
+		//start again with a new connection next time
+		ca.mu.Lock()
+		if ca.clients[storeURL] == client {
+			delete(ca.clients, storeURL)
+		}
+		ca.mu.Unlock()
+		if timedOut {
+			//the request still has the client, it gives up after catalogueStoreTimeout too
+			go func() {
+				<-done
+				client.Close()
+			}()
+		} else {
+			client.Close()
+		}
+		return storeCatalogue{err: res.err, raw: "unreachable"}
+	}
+
+	raw, _ := json.Marshal(res.cat.Items)
+	return storeCatalogue{items: res.cat.Items, raw: string(raw)}
+}
+
+// client returns the kept client for storeURL, making one if needed
+func (ca *CatalogueAggregator) client(storeURL string) catalogueClient {
+	ca.mu.Lock()
+	defer ca.mu.Unlock()
+
+	client, ok := ca.clients[storeURL]
+	if !ok {
+		client = ca.newClient(storeURL)
+		ca.clients[storeURL] = client
+	}
+	return client
+}
+
+func (f catalogueFilter) matches(item libDatabox.HypercatItem) bool {
+	return (f.Type == "" || hypercatRel(item, relType) == f.Type) &&
+		(f.Vendor == "" || hypercatRel(item, relVendor) == f.Vendor) &&
+		(f.Location == "" || hypercatRel(item, relLocation) == f.Location)
+}
+
+// hypercatRel returns the value of rel in the item metadata of item
+func hypercatRel(item libDatabox.HypercatItem, rel string) string {
+	for _, m := range item.ItemMetadata {
+		//item metadata is a mix of RelValPair, RelValPairBool or decoded JSON
+		b, err := json.Marshal(m)
+		if err != nil {
+			continue
+		}
+		var pair struct {
+			Rel string      `json:"rel"`
+			Val interface{} `json:"val"`
+		}
+		if json.Unmarshal(b, &pair) != nil || pair.Rel != rel {
+			continue
+		}
+		if s, ok := pair.Val.(string); ok {
+			return s
+		}
+	}
+	return ""
+}
+
+// storeName returns the host of a store URL like tcp://app-core-store:5555
+func storeName(storeURL string) string {
+	name := strings.TrimPrefix(storeURL, "tcp://")
+	if i := strings.Index(name, ":"); i >= 0 {
+		name = name[:i]
+	}
+	return name
+}
@@ -0,0 +1,361 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	libDatabox "github.com/me-box/lib-go-databox"
+)
+
+// fakeStores stands in for the arbiter root catalogue and the stores it lists
+type fakeStores struct {
+	mu      sync.Mutex
+	hrefs   []string
+	items   map[string][]libDatabox.HypercatItem
+	errs    map[string]error
+	hang    map[string]chan struct{}
+	clients []*fakeCatalogueClient
+}
+
+func newFakeStores() *fakeStores {
+	return &fakeStores{
+		items: map[string][]libDatabox.HypercatItem{},
+		errs:  map[string]error{},
+		hang:  map[string]chan struct{}{},
+	}
+}
+
+func (fs *fakeStores) GetRootDataSourceCatalogue() (libDatabox.HypercatRoot, error) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	root := libDatabox.HypercatRoot{}
+	for _, href := range fs.hrefs {
+		root.Items = append(root.Items, libDatabox.HypercatItem{Href: href})
+	}
+	return root, nil
+}
+
+func (fs *fakeStores) newClient(storeURL string) catalogueClient {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	c := &fakeCatalogueClient{stores: fs, storeURL: storeURL}
+	fs.clients = append(fs.clients, c)
+	return c
+}
+
+// add lists a store holding items in the root catalogue
+func (fs *fakeStores) add(store string, items ...libDatabox.HypercatItem) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	fs.hrefs = append(fs.hrefs, "tcp://"+store+":5555")
+	fs.items["tcp://"+store+":5555"] = items
+}
+
+func (fs *fakeStores) set(store string, items ...libDatabox.HypercatItem) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	fs.items["tcp://"+store+":5555"] = items
+}
+
+// clientsFor returns the clients made for store and how many of them are closed
+func (fs *fakeStores) clientsFor(store string) (made int, closed int) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	for _, c := range fs.clients {
+		if c.storeURL == "tcp://"+store+":5555" {
+			made++
+			if c.closed {
+				closed++
+			}
+		}
+	}
+	return made, closed
+}
+
+type fakeCatalogueClient struct {
+	stores   *fakeStores
+	storeURL string
+	closed   bool
+}
+
+func (c *fakeCatalogueClient) GetStoreDataSourceCatalogue(href string) (libDatabox.HypercatRoot, error) {
+	c.stores.mu.Lock()
+	hang := c.stores.hang[c.storeURL]
+	c.stores.mu.Unlock()
+	if hang != nil {
+		<-hang
+	}
+
+	c.stores.mu.Lock()
+	defer c.stores.mu.Unlock()
+	if c.closed {
+		return libDatabox.HypercatRoot{}, errors.New("socket closed")
+	}
+	return libDatabox.HypercatRoot{Items: c.stores.items[c.storeURL]}, c.stores.errs[c.storeURL]
+}
+
+func (c *fakeCatalogueClient) Close() {
+	c.stores.mu.Lock()
+	defer c.stores.mu.Unlock()
+	c.closed = true
+}
+
+func newTestCatalogue(fs *fakeStores) *CatalogueAggregator {
+	ca := NewCatalogueAggregator(nil)
+	ca.arbiter = fs
+	ca.newClient = fs.newClient
+	return ca
+}
+
+func catalogueItem(store string, datasource string, metadata ...interface{}) libDatabox.HypercatItem {
+	return libDatabox.HypercatItem{
+		ItemMetadata: metadata,
+		Href:         "tcp://" + store + ":5555/kv/" + datasource,
+	}
+}
+
+func TestHypercatRel(t *testing.T) {
+
+	tests := []struct {
+		name     string
+		metadata []interface{}
+		want     string
+	}{
+		{name: "rel val pair", metadata: []interface{}{libDatabox.RelValPair{Rel: relType, Val: "temperature"}}, want: "temperature"},
+		{name: "decoded JSON", metadata: []interface{}{map[string]interface{}{"rel": relType, "val": "temperature"}}, want: "temperature"},
+		{
+			name: "among others",
+			metadata: []interface{}{
+				libDatabox.RelValPairBool{Rel: "urn:X-databox:rels:isActuator", Val: true},
+				libDatabox.RelValPair{Rel: relVendor, Val: "databox"},
+				libDatabox.RelValPair{Rel: relType, Val: "temperature"},
+			},
+			want: "temperature",
+		},
+		{name: "not a string", metadata: []interface{}{libDatabox.RelValPairBool{Rel: relType, Val: true}}},
+		{name: "missing", metadata: []interface{}{libDatabox.RelValPair{Rel: relVendor, Val: "databox"}}},
+		{name: "not a pair", metadata: []interface{}{"temperature", 42}},
+		{name: "no metadata"},
+	}
+
+	for _, tt := range tests {
+		item := libDatabox.HypercatItem{ItemMetadata: tt.metadata}
+		if got := hypercatRel(item, relType); got != tt.want {
+			t.Errorf("%s: hypercatRel() = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestCatalogueFilterMatches(t *testing.T) {
+
+	item := catalogueItem("driver-sensor-core-store", "temp",
+		libDatabox.RelValPair{Rel: relType, Val: "temperature"},
+		libDatabox.RelValPair{Rel: relVendor, Val: "databox"},
+		libDatabox.RelValPair{Rel: relLocation, Val: "kitchen"},
+	)
+	untyped := catalogueItem("driver-sensor-core-store", "raw")
+
+	tests := []struct {
+		name   string
+		filter catalogueFilter
+		item   libDatabox.HypercatItem
+		want   bool
+	}{
+		{name: "no filter", item: item, want: true},
+		{name: "no filter untyped", item: untyped, want: true},
+		{name: "type", filter: catalogueFilter{Type: "temperature"}, item: item, want: true},
+		{name: "all", filter: catalogueFilter{Type: "temperature", Vendor: "databox", Location: "kitchen"}, item: item, want: true},
+		{name: "other type", filter: catalogueFilter{Type: "humidity"}, item: item},
+		{name: "other vendor", filter: catalogueFilter{Type: "temperature", Vendor: "acme"}, item: item},
+		{name: "other location", filter: catalogueFilter{Location: "garden"}, item: item},
+		{name: "untyped", filter: catalogueFilter{Type: "temperature"}, item: untyped},
+	}
+
+	for _, tt := range tests {
+		if got := tt.filter.matches(tt.item); got != tt.want {
+			t.Errorf("%s: matches() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestCatalogueReport(t *testing.T) {
+
+	fs := newFakeStores()
+	temp := catalogueItem("driver-a-core-store", "temp", libDatabox.RelValPair{Rel: relType, Val: "temperature"})
+	light := catalogueItem("driver-b-core-store", "light", libDatabox.RelValPair{Rel: relType, Val: "light"})
+	fs.add("driver-a-core-store", temp)
+	fs.add("driver-b-core-store", light)
+	ca := newTestCatalogue(fs)
+
+	names := func(report CatalogueReport) string {
+		hrefs := []string{}
+		for _, item := range report.Datasources {
+			hrefs = append(hrefs, item.Href)
+		}
+		for _, u := range report.Unreachable {
+			hrefs = append(hrefs, "unreachable "+u.Store)
+		}
+		return strings.Join(hrefs, ",")
+	}
+
+	steps := []struct {
+		name        string
+		change      func()
+		filter      catalogueFilter
+		wantVersion int
+		want        string
+	}{
+		{
+			name:        "first",
+			wantVersion: 1,
+			want:        temp.Href + "," + light.Href,
+		},
+		{
+			name:        "unchanged",
+			filter:      catalogueFilter{Refresh: true},
+			wantVersion: 1,
+			want:        temp.Href + "," + light.Href,
+		},
+		{
+			name:        "by type",
+			filter:      catalogueFilter{Type: "light"},
+			wantVersion: 1,
+			want:        light.Href,
+		},
+		{
+			name:        "by store",
+			filter:      catalogueFilter{Store: "driver-a-core-store"},
+			wantVersion: 1,
+			want:        temp.Href,
+		},
+		{
+			name: "datasource added",
+			change: func() {
+				fs.set("driver-a-core-store", temp, catalogueItem("driver-a-core-store", "humidity"))
+			},
+			filter:      catalogueFilter{Refresh: true, Store: "driver-a-core-store"},
+			wantVersion: 2,
+			want:        temp.Href + ",tcp://driver-a-core-store:5555/kv/humidity",
+		},
+		{
+			name: "store unreachable",
+			change: func() {
+				fs.mu.Lock()
+				fs.errs["tcp://driver-b-core-store:5555"] = errors.New("connection refused")
+				fs.mu.Unlock()
+			},
+			filter:      catalogueFilter{Refresh: true, Type: "light"},
+			wantVersion: 3,
+			want:        "unreachable driver-b-core-store",
+		},
+		{
+			name: "store back",
+			change: func() {
+				fs.mu.Lock()
+				delete(fs.errs, "tcp://driver-b-core-store:5555")
+				fs.mu.Unlock()
+			},
+			filter:      catalogueFilter{Refresh: true, Type: "light"},
+			wantVersion: 4,
+			want:        light.Href,
+		},
+		{
+			name: "bad href",
+			change: func() {
+				fs.mu.Lock()
+				fs.hrefs = append(fs.hrefs, "::not a store")
+				fs.mu.Unlock()
+			},
+			filter:      catalogueFilter{Refresh: true, Type: "light"},
+			wantVersion: 5,
+			want:        light.Href + ",unreachable ::not a store",
+		},
+		{
+			name: "store removed",
+			change: func() {
+				fs.mu.Lock()
+				fs.hrefs = fs.hrefs[:1]
+				fs.mu.Unlock()
+			},
+			filter:      catalogueFilter{Refresh: true},
+			wantVersion: 6,
+			want:        temp.Href + ",tcp://driver-a-core-store:5555/kv/humidity",
+		},
+	}
+
+	for _, step := range steps {
+		if step.change != nil {
+			step.change()
+		}
+		report, err := ca.Report(step.filter)
+		if err != nil {
+			t.Fatalf("%s: %v", step.name, err)
+		}
+		if report.Version != step.wantVersion {
+			t.Errorf("%s: version = %d, want %d", step.name, report.Version, step.wantVersion)
+		}
+		if got := names(report); got != step.want {
+			t.Errorf("%s: report = %s, want %s", step.name, got, step.want)
+		}
+	}
+
+	for _, u := range ca.stores {
+		if u.href == "::not a store" && (u.err == nil || !strings.Contains(u.err.Error(), "bad store href")) {
+			t.Errorf("bad href error = %v", u.err)
+		}
+	}
+	if made, closed := fs.clientsFor("driver-b-core-store"); made != 2 || closed != 2 {
+		t.Errorf("driver-b-core-store made %d clients and closed %d, want both closed after it failed and was removed", made, closed)
+	}
+	if made, closed := fs.clientsFor("driver-a-core-store"); made != 1 || closed != 0 {
+		t.Errorf("driver-a-core-store made %d clients and closed %d, want the one kept", made, closed)
+	}
+}
+
+// a store that does not answer in time is reported unreachable and its client
+// closed once the request gives up, the next refresh uses a new one
+func TestCatalogueStoreTimeout(t *testing.T) {
+
+	defer func(timeout time.Duration) { catalogueStoreTimeout = timeout }(catalogueStoreTimeout)
+	catalogueStoreTimeout = 20 * time.Millisecond
+
+	fs := newFakeStores()
+	fs.add("driver-slow-core-store", catalogueItem("driver-slow-core-store", "temp"))
+	hang := make(chan struct{})
+	fs.hang["tcp://driver-slow-core-store:5555"] = hang
+	ca := newTestCatalogue(fs)
+
+	report, err := ca.Report(catalogueFilter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Unreachable) != 1 || !strings.Contains(report.Unreachable[0].Error, "did not reply within") {
+		t.Fatalf("unreachable = %+v, want driver-slow-core-store to time out", report.Unreachable)
+	}
+	if _, closed := fs.clientsFor("driver-slow-core-store"); closed != 0 {
+		t.Error("the client was closed while its request was still running")
+	}
+
+	fs.mu.Lock()
+	delete(fs.hang, "tcp://driver-slow-core-store:5555")
+	fs.mu.Unlock()
+	close(hang)
+	waitFor(t, "the timed out client to be closed", func() bool {
+		_, closed := fs.clientsFor("driver-slow-core-store")
+		return closed == 1
+	})
+
+	report, err = ca.Report(catalogueFilter{Refresh: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Datasources) != 1 || len(report.Unreachable) != 0 {
+		t.Errorf("report after the store answered = %+v", report)
+	}
+	if made, closed := fs.clientsFor("driver-slow-core-store"); made != 2 || closed != 1 {
+		t.Errorf("made %d clients and closed %d, want a new one kept", made, closed)
+	}
+}
@@ -0,0 +1,600 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/api/types/swarm"
+	libDatabox "github.com/me-box/lib-go-databox"
+)
+
+// default renewal settings, used if CertOptions does not say
+const (
+	defaultCertRenewBefore   = 30
+	defaultCertCheckInterval = 24
+)
+
+// labels on the swarm secrets holding certificates
+const (
+	//the name the secret had before it was first renewed, renewed copies get a suffix
+	secretLabelBase = "databox.secret.base"
+	//the component a certificate made by genorateSecrets is for
+	certLabelComponent = "databox.cert.component"
+	//when the certificate in the secret expires, RFC 3339
+	certLabelNotAfter = "databox.cert.notAfter"
+)
+
+// CertStatus kinds
+const (
+	certKindRootCA    = "root-ca"
+	certKindSystem    = "system"
+	certKindComponent = "component"
+)
+
+// rootCASecret is the swarm secret every service trusts the root CA from
+const rootCASecret = "DATABOX_ROOT_CA"
+
+// containerManagerService is the swarm service the container manager itself runs in
+const containerManagerService = "container-manager"
+
+// legacyCertValidity is assumed for certificate secrets made before they were labelled with their expiry
+var legacyCertValidity = 365 * 24 * time.Hour
+
+// CertOptions control certificate renewal. A certificate is re-issued once it has less
+// than CertRenewBefore days left, they are checked every CertCheckInterval hours.
+type CertOptions struct {
+	CertRenewBefore   int
+	CertCheckInterval int
+}
+
+// CertStatus is the expiry of one certificate
+type CertStatus struct {
+	Name     string    `json:"name"`
+	Kind     string    `json:"kind"`
+	NotAfter time.Time `json:"notAfter"`
+	RenewAt  time.Time `json:"renewAt"`
+	DaysLeft int       `json:"daysLeft"`
+	//set if the certificate could not be read or the last renewal failed
+	Error string `json:"error,omitempty"`
+}
+
+// CertTracker keeps the expiry of every certificate seen by the last check
+type CertTracker struct {
+	renewBefore time.Duration
+	interval    time.Duration
+
+	//held for a whole check so renewals never overlap
+	checking sync.Mutex
+
+	mu      sync.Mutex
+	certs   []CertStatus
+	checked time.Time
+}
+
+// trackedCert is a certificate and how to renew it
+type trackedCert struct {
+	status CertStatus
+	renew  func() error
+}
+
+// NewCertTracker returns a CertTracker configured from opt
+func NewCertTracker(opt CertOptions) *CertTracker {
+
+	ct := &CertTracker{
+		renewBefore: time.Duration(defaultCertRenewBefore) * 24 * time.Hour,
+		interval:    time.Duration(defaultCertCheckInterval) * time.Hour,
+		certs:       []CertStatus{},
+	}
+	if opt.CertRenewBefore > 0 {
+		ct.renewBefore = time.Duration(opt.CertRenewBefore) * 24 * time.Hour
+	}
+	if opt.CertCheckInterval > 0 {
+		ct.interval = time.Duration(opt.CertCheckInterval) * time.Hour
+	}
+	return ct
+}
+
+// List returns every certificate, the first to expire first
+func (ct *CertTracker) List() []CertStatus {
+	ct.mu.Lock()
+	defer ct.mu.Unlock()
+	return append([]CertStatus{}, ct.certs...)
+}
+
+// Expiring returns the certificates that expire within twice the renewal period
+// or could not be renewed, the first to expire first
+func (ct *CertTracker) Expiring() []CertStatus {
+
+	soon := time.Now().Add(2 * ct.renewBefore)
+	res := []CertStatus{}
+	for _, c := range ct.List() {
+		if c.Error != "" || c.NotAfter.Before(soon) {
+			res = append(res, c)
+		}
+	}
+	return res
+}
+
+func (ct *CertTracker) record(certs []CertStatus) {
+	sort.SliceStable(certs, func(a, b int) bool {
+		return certs[a].NotAfter.Before(certs[b].NotAfter)
+	})
+
+	ct.mu.Lock()
+	ct.certs = certs
+	ct.checked = time.Now()
+	ct.mu.Unlock()
+}
+
+func (ct *CertTracker) newStatus(name string, kind string, notAfter time.Time) CertStatus {
+	return CertStatus{
+		Name:     name,
+		Kind:     kind,
+		NotAfter: notAfter,
+		RenewAt:  notAfter.Add(-ct.renewBefore),
+		DaysLeft: int(time.Until(notAfter).Hours() / 24),
+	}
+}
+
+// renewCertificates checks the certificates every CertCheckInterval, it never returns
+func (cm ContainerManager) renewCertificates() {
+	for {
+		cm.CheckCertificates()
+		time.Sleep(cm.Certs.interval)
+	}
+}
+
+// CheckCertificates re-issues every certificate that is due, rolling the services that
+// use it, and returns the expiry of them all. The root CA goes first so the others are
+// signed by the renewed one.
+func (cm ContainerManager) CheckCertificates() []CertStatus {
+
+	cm.Certs.checking.Lock()
+	defer cm.Certs.checking.Unlock()
+
+	failed := map[string]string{}
+	renewed := 0
+
+	now := time.Now()
+	for _, c := range cm.trackedCerts() {
+		if c.renew == nil || c.status.NotAfter.IsZero() || now.Before(c.status.RenewAt) {
+			continue
+		}
+
+		libDatabox.Info("[Certs] renewing " + c.status.Name + " it expires " + c.status.NotAfter.Format(time.RFC3339))
+		err := c.renew()
+		if err != nil {
+			libDatabox.Err("[Certs] failed to renew " + c.status.Name + " " + err.Error())
+			failed[c.status.Name] = err.Error()
+			cm.Journal.RecordErr(EventCertRenewFailed, c.status.Name, err, nil)
+			continue
+		}
+		renewed++
+		cm.Journal.Record(EventCertRenewed, c.status.Name, "", nil)
+	}
+
+	statuses := []CertStatus{}
+	for _, c := range cm.trackedCerts() {
+		if msg, ok := failed[c.status.Name]; ok {
+			c.status.Error = msg
+		}
+		statuses = append(statuses, c.status)
+	}
+	cm.Certs.record(statuses)
+
+	if renewed > 0 {
+		//last as it restarts us
+		cm.updateSelfSecrets()
+	}
+	return cm.Certs.List()
+}
+
+// trackedCerts finds the root CA, the core component certificates and those made by
+// genorateSecrets for each installed component
+func (cm ContainerManager) trackedCerts() []trackedCert {
+
+	certs := []trackedCert{cm.fileCert(rootCAPath, "root-ca", certKindRootCA, cm.renewRootCA)}
+
+	for _, sc := range systemCertificates(cm.Options.InternalIPs, cm.Options.ExternalIP, cm.Options.Hostname) {
+		sc := sc
+		certs = append(certs, cm.fileCert(sc.Path, sc.Name, certKindSystem, func() error {
+			return cm.renewSystemCert(sc)
+		}))
+	}
+
+	_, newest, err := cm.secretVersions()
+	if err != nil {
+		libDatabox.Err("[Certs] Can't list secrets " + err.Error())
+		return certs
+	}
+	services := map[string]bool{}
+	serList, err := cm.cli.ServiceList(context.Background(), types.ServiceListOptions{})
+	if err != nil {
+		libDatabox.Err("[Certs] Can't list services " + err.Error())
+		return certs
+	}
+	for _, s := range serList {
+		services[s.Spec.Name] = true
+	}
+
+	for base, sec := range newest {
+		component := componentForCertSecret(base, sec)
+		if component == "" || !services[component] {
+			continue
+		}
+
+		notAfter, err := time.Parse(time.RFC3339, sec.Spec.Labels[certLabelNotAfter])
+		if err != nil {
+			notAfter = sec.CreatedAt.Add(legacyCertValidity)
+		}
+		certs = append(certs, trackedCert{
+			status: cm.Certs.newStatus(component, certKindComponent, notAfter),
+			renew: func() error {
+				return cm.renewComponentCert(component)
+			},
+		})
+	}
+
+	return certs
+}
+
+// componentForCertSecret returns the component the certificate secret is for or
+// blank if sec is not one made by genorateSecrets
+func componentForCertSecret(base string, sec swarm.Secret) string {
+	if component, ok := sec.Spec.Labels[certLabelComponent]; ok {
+		return component
+	}
+	if !strings.HasSuffix(base, ".pem") || base == "DATABOX.pem" || base == "DATABOX_ARBITER.pem" {
+		return ""
+	}
+	return strings.ToLower(strings.TrimSuffix(base, ".pem"))
+}
+
+// fileCert tracks the certificate in the PEM file path
+func (cm ContainerManager) fileCert(path string, name string, kind string, renew func() error) trackedCert {
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return trackedCert{status: CertStatus{Name: name, Kind: kind, Error: err.Error()}}
+	}
+	cert, err := parseCertPEM(data)
+	if err != nil {
+		return trackedCert{status: CertStatus{Name: name, Kind: kind, Error: path + " " + err.Error()}}
+	}
+	return trackedCert{status: cm.Certs.newStatus(name, kind, cert.NotAfter), renew: renew}
+}
+
+// certSecretLabels labels the secret holding cert, the certificate of component
+func certSecretLabels(component string, cert []byte) map[string]string {
+	labels := map[string]string{
+		secretLabelBase:    strings.ToUpper(component) + ".pem",
+		certLabelComponent: component,
+	}
+	if parsed, err := parseCertPEM(cert); err == nil {
+		labels[certLabelNotAfter] = parsed.NotAfter.Format(time.RFC3339)
+	}
+	return labels
+}
+
+func (cm ContainerManager) renewRootCA() error {
+
+	err := RenewRootCA(rootCAPath, rootCAPathPub)
+	if err != nil {
+		return errors.New("Can't re-issue the root CA " + err.Error())
+	}
+
+	pub, err := ioutil.ReadFile(rootCAPathPub)
+	if err != nil {
+		return err
+	}
+	err = cm.rotateSecret(rootCASecret, pub, nil)
+	if err != nil {
+		return err
+	}
+
+	//the mobile app QR code and the cert download hold the CA
+	go populateMobileAppQrCodeAndCerts(&cm)
+	return nil
+}
+
+func (cm ContainerManager) renewSystemCert(sc systemCert) error {
+
+	cert := GenCert(rootCAPath, sc.Name, sc.IPs, sc.Hosts)
+	err := writeFileAtomic(sc.Path, cert, 0600)
+	if err != nil {
+		return errors.New("Can't write " + sc.Path + " " + err.Error())
+	}
+
+	if sc.Secret != "" {
+		err = cm.rotateSecret(sc.Secret, cert, nil)
+		if err != nil {
+			return err
+		}
+	}
+
+	if sc.Name == "databox-network" {
+		return cm.reloadNetworkCert(cert)
+	}
+	return nil
+}
+
+func (cm ContainerManager) renewComponentCert(component string) error {
+	cert := GenCert(rootCAPath, component, []string{"127.0.0.1"}, []string{component})
+	return cm.rotateSecret(strings.ToUpper(component)+".pem", cert, certSecretLabels(component, cert))
+}
+
+// reloadNetworkCert copies cert into databox-network, which is not a swarm service,
+// and restarts it
+func (cm ContainerManager) reloadNetworkCert(cert []byte) error {
+
+	contFilters := filters.NewArgs()
+	contFilters.Add("name", "databox-network")
+	contList, err := cm.cli.ContainerList(context.Background(), types.ContainerListOptions{Filters: contFilters})
+	if err != nil {
+		return err
+	}
+	if len(contList) < 1 {
+		return errors.New("databox-network is not running")
+	}
+
+	err = copyFileToContainer(cm.cli, "/run/secrets/DATABOX_NETWORK.pem", bytes.NewReader(cert), contList[0].ID)
+	if err != nil {
+		return errors.New("Can't copy the certificate to databox-network " + err.Error())
+	}
+	timeout := 10 * time.Second
+	return cm.cli.ContainerRestart(context.Background(), contList[0].ID, &timeout)
+}
+
+// secretVersions returns every secret by ID and the newest version of each by the
+// name it was first created with. Swarm will not change a secret or remove one in use
+// so a renewed secret is a new one with a suffix on the name.
+func (cm ContainerManager) secretVersions() (map[string]swarm.Secret, map[string]swarm.Secret, error) {
+
+	all := map[string]swarm.Secret{}
+	newest := map[string]swarm.Secret{}
+
+	secList, err := cm.cli.SecretList(context.Background(), types.SecretListOptions{})
+	if err != nil {
+		return all, newest, err
+	}
+	for _, s := range secList {
+		all[s.ID] = s
+		base := secretBase(s)
+		if n, ok := newest[base]; !ok || s.CreatedAt.After(n.CreatedAt) {
+			newest[base] = s
+		}
+	}
+	return all, newest, nil
+}
+
+func secretBase(s swarm.Secret) string {
+	if base, ok := s.Spec.Labels[secretLabelBase]; ok {
+		return base
+	}
+	return s.Spec.Name
+}
+
+// rotateSecret makes a new version of the secret base holding data and moves every
+// service using the old one over to it. The old versions are removed once nothing uses them.
+func (cm ContainerManager) rotateSecret(base string, data []byte, labels map[string]string) error {
+
+	spec := swarm.SecretSpec{
+		Annotations: swarm.Annotations{
+			Name:   base + "." + strconv.FormatInt(time.Now().Unix(), 10),
+			Labels: map[string]string{secretLabelBase: base},
+		},
+		Data: data,
+	}
+	for k, v := range labels {
+		spec.Labels[k] = v
+	}
+	_, err := cm.cli.SecretCreate(context.Background(), spec)
+	if err != nil {
+		return errors.New("Can't create secret " + spec.Name + " " + err.Error())
+	}
+	libDatabox.Debug("[Certs] created secret " + spec.Name)
+
+	all, newest, err := cm.secretVersions()
+	if err != nil {
+		return err
+	}
+	serList, err := cm.cli.ServiceList(context.Background(), types.ServiceListOptions{})
+	if err != nil {
+		return err
+	}
+
+	var lastErr error
+	for _, service := range serList {
+		if service.Spec.Name == containerManagerService {
+			//done last by updateSelfSecrets
+			continue
+		}
+		newSpec := service.Spec
+		if !refreshSecretRefs(&newSpec, all, newest) {
+			continue
+		}
+		err := cm.rollService(service, newSpec)
+		if err != nil {
+			libDatabox.Err("[Certs] " + err.Error())
+			lastErr = err
+		}
+	}
+
+	cm.removeOldSecrets(base)
+	return lastErr
+}
+
+// refreshSecretRefs points the secrets of spec at the newest version of each, it reports
+// if any changed. The references are copied so the service spec spec came from is untouched.
+func refreshSecretRefs(spec *swarm.ServiceSpec, all map[string]swarm.Secret, newest map[string]swarm.Secret) bool {
+
+	if spec.TaskTemplate.ContainerSpec == nil {
+		return false
+	}
+
+	changed := false
+	containerSpec := *spec.TaskTemplate.ContainerSpec
+	containerSpec.Secrets = []*swarm.SecretReference{}
+	for _, ref := range spec.TaskTemplate.ContainerSpec.Secrets {
+		if s, ok := all[ref.SecretID]; ok {
+			if n := newest[secretBase(s)]; n.ID != s.ID {
+				updated := *ref
+				updated.SecretID = n.ID
+				updated.SecretName = n.Spec.Name
+				ref = &updated
+				changed = true
+			}
+		}
+		containerSpec.Secrets = append(containerSpec.Secrets, ref)
+	}
+
+	if changed {
+		spec.TaskTemplate.ContainerSpec = &containerSpec
+	}
+	return changed
+}
+
+// rollService updates service to spec and waits for the new task like an upgrade,
+// rolling back if it does not start
+func (cm ContainerManager) rollService(service swarm.Service, spec swarm.ServiceSpec) error {
+
+	name := service.Spec.Name
+	libDatabox.Info("[Certs] restarting " + name + " with renewed certificates")
+
+	oldIP := ""
+	oldTasks := map[string]bool{}
+	if oldCont, err := cm.runningContainerFor(name); err == nil {
+		oldIP = cm.ipOnServiceNetwork(oldCont, name)
+	}
+	if tasks, err := cm.tasksFor(name); err == nil {
+		for _, t := range tasks {
+			oldTasks[t.ID] = true
+		}
+	}
+
+	//the old task stopping is not a crash
+	cm.Updating.Begin(name)
+	defer cm.Updating.End(name)
+	_, err := cm.cli.ServiceUpdate(context.Background(), service.ID, service.Version, spec, types.ServiceUpdateOptions{})
+	if err != nil {
+		return errors.New("Can't update " + name + " " + err.Error())
+	}
+
+	if _, stopped := cm.Stopped.Stopped(name); stopped {
+		//it gets the new secrets when it is started again
+		return nil
+	}
+
+	newCont, err := cm.waitForNewTask(name, oldTasks)
+	if err != nil {
+		rollbackErr := cm.rollbackService(name, service.Spec, oldIP)
+		if rollbackErr != nil {
+			return errors.New(name + " did not start with renewed certificates (" + err.Error() + ") and could not be rolled back " + rollbackErr.Error())
+		}
+		return errors.New(name + " did not start with renewed certificates and was rolled back. " + err.Error())
+	}
+
+	return cm.CoreNetworkClient.ServiceRestart(name, oldIP, cm.ipOnServiceNetwork(newCont, name))
+}
+
+// removeOldSecrets removes the versions of base that are not the newest. One still
+// in use, by the container manager until it restarts, is left for the next check.
+func (cm ContainerManager) removeOldSecrets(base string) {
+
+	all, newest, err := cm.secretVersions()
+	if err != nil {
+		return
+	}
+	for id, s := range all {
+		if secretBase(s) != base || newest[base].ID == id {
+			continue
+		}
+		err := cm.cli.SecretRemove(context.Background(), id)
+		if err != nil {
+			libDatabox.Debug("[Certs] leaving secret " + s.Spec.Name + " " + err.Error())
+			continue
+		}
+		libDatabox.Debug("[Certs] removed secret " + s.Spec.Name)
+	}
+}
+
+// updateSelfSecrets moves the container manager service to the newest secrets. Swarm
+// then replaces this container so it starts with the renewed root CA.
+func (cm ContainerManager) updateSelfSecrets() {
+
+	service, err := cm.serviceByName(containerManagerService)
+	if err != nil {
+		libDatabox.Debug("[Certs] not running as a service " + err.Error())
+		return
+	}
+	all, newest, err := cm.secretVersions()
+	if err != nil {
+		libDatabox.Err("[Certs] Can't list secrets " + err.Error())
+		return
+	}
+	spec := service.Spec
+	if !refreshSecretRefs(&spec, all, newest) {
+		return
+	}
+
+	libDatabox.Info("Restarting the Container Manager to use the renewed certificates")
+	_, err = cm.cli.ServiceUpdate(context.Background(), service.ID, service.Version, spec, types.ServiceUpdateOptions{})
+	if err != nil {
+		libDatabox.Err("[Certs] Can't update " + containerManagerService + " " + err.Error())
+	}
+}
+
+// certFileLoader serves the certificate in a PEM file over TLS, reloading it when
+// the file changes so a renewed certificate is used without a restart
+type certFileLoader struct {
+	path string
+
+	mu      sync.Mutex
+	cert    *tls.Certificate
+	modTime time.Time
+}
+
+func newCertFileLoader(path string) *certFileLoader {
+	return &certFileLoader{path: path}
+}
+
+// GetCertificate is for tls.Config
+func (l *certFileLoader) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	info, err := os.Stat(l.path)
+	if err != nil {
+		if l.cert != nil {
+			return l.cert, nil
+		}
+		return nil, err
+	}
+	if l.cert != nil && info.ModTime().Equal(l.modTime) {
+		return l.cert, nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(l.path, l.path)
+	if err != nil {
+		libDatabox.Err("Can't load " + l.path + " " + err.Error())
+		if l.cert != nil {
+			return l.cert, nil
+		}
+		return nil, err
+	}
+	l.cert = &cert
+	l.modTime = info.ModTime()
+	return l.cert, nil
+}
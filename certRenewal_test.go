@@ -0,0 +1,270 @@
+package main
+
+import (
+	"context"
+	"crypto/x509"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/swarm"
+	libDatabox "github.com/me-box/lib-go-databox"
+)
+
+// once the root CA secret has been renewed and the original removed new components
+// are given the renewed one, as is the container manager when it restarts
+func TestRootCARotationThenInstall(t *testing.T) {
+
+	defer func(settle time.Duration) { upgradeSettleTime = settle }(upgradeSettleTime)
+	upgradeSettleTime = time.Second
+
+	td := newTestDatabox(t)
+	err := td.cm.LaunchFromSLA(td.testSLA("app-before", libDatabox.DataboxTypeApp), true, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = td.cm.rotateSecret(rootCASecret, []byte("renewed root CA"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if td.secretExists(rootCASecret) {
+		t.Fatal("the original root CA secret is still in use")
+	}
+
+	err = td.cm.LaunchFromSLA(td.testSLA("app-after", libDatabox.DataboxTypeApp), true, nil)
+	if err != nil {
+		t.Fatalf("install after the root CA was renewed: %v", err)
+	}
+
+	for _, name := range []string{"app-before", "app-after"} {
+		found := false
+		for _, ref := range td.service(name).Spec.TaskTemplate.ContainerSpec.Secrets {
+			if ref.File.Name == rootCASecret {
+				found = true
+				if !strings.HasPrefix(ref.SecretName, rootCASecret+".") {
+					t.Errorf("%s has root CA secret %s, want the renewed one", name, ref.SecretName)
+				}
+			}
+		}
+		if !found {
+			t.Errorf("%s has no root CA", name)
+		}
+	}
+
+	renewed, _ := newestSecret(td.cli, rootCASecret)
+	if id := createSecretIfNotExists(td.cli, rootCASecret, "root CA"); id != renewed.ID {
+		t.Errorf("at start up the root CA secret is %s, want the renewed %s", id, renewed.ID)
+	}
+}
+
+func TestCreateSecretIfNotExists(t *testing.T) {
+
+	create := func(td *testDatabox, name string, labels map[string]string) string {
+		res, err := td.cli.SecretCreate(context.Background(), swarm.SecretSpec{
+			Annotations: swarm.Annotations{Name: name, Labels: labels},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return res.ID
+	}
+
+	tests := []struct {
+		name   string
+		before func(td *testDatabox) string
+	}{
+		{
+			name:   "new",
+			before: func(td *testDatabox) string { return "" },
+		},
+		{
+			name:   "exists",
+			before: func(td *testDatabox) string { return create(td, "DATABOX.pem", nil) },
+		},
+		{
+			name: "longer name exists",
+			before: func(td *testDatabox) string {
+				create(td, "DATABOX.pem.old", nil)
+				return ""
+			},
+		},
+		{
+			name: "renewed",
+			before: func(td *testDatabox) string {
+				create(td, "DATABOX.pem", nil)
+				return create(td, "DATABOX.pem.1600000000", map[string]string{secretLabelBase: "DATABOX.pem"})
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			td := newTestDatabox(t)
+			wantID := tt.before(td)
+
+			id := createSecretIfNotExists(td.cli, "DATABOX.pem", "cert")
+			if wantID != "" && id != wantID {
+				t.Errorf("secret = %s, want %s", id, wantID)
+			}
+			list, _ := td.cli.SecretList(context.Background(), types.SecretListOptions{})
+			for _, s := range list {
+				if s.ID == id && secretBase(s) != "DATABOX.pem" {
+					t.Errorf("got secret %s for DATABOX.pem", s.Spec.Name)
+				}
+			}
+			if wantID == "" && !td.secretExists("DATABOX.pem") {
+				t.Error("DATABOX.pem was not created")
+			}
+		})
+	}
+}
+
+func TestComponentForCertSecret(t *testing.T) {
+
+	tests := []struct {
+		base   string
+		labels map[string]string
+		want   string
+	}{
+		{base: "APP-ONE.pem", labels: map[string]string{certLabelComponent: "app-one"}, want: "app-one"},
+		{base: "DRIVER-ONE.pem", want: "driver-one"},
+		{base: "DATABOX.pem"},
+		{base: "DATABOX_ARBITER.pem"},
+		{base: "APP-ONE_KEY"},
+		{base: rootCASecret},
+	}
+
+	for _, tt := range tests {
+		sec := swarm.Secret{Spec: swarm.SecretSpec{Annotations: swarm.Annotations{Name: tt.base, Labels: tt.labels}}}
+		if got := componentForCertSecret(tt.base, sec); got != tt.want {
+			t.Errorf("componentForCertSecret(%s) = %q, want %q", tt.base, got, tt.want)
+		}
+	}
+}
+
+func TestRefreshSecretRefs(t *testing.T) {
+
+	secret := func(id string, name string, base string) swarm.Secret {
+		s := swarm.Secret{ID: id, Spec: swarm.SecretSpec{Annotations: swarm.Annotations{Name: name}}}
+		if base != "" {
+			s.Spec.Labels = map[string]string{secretLabelBase: base}
+		}
+		return s
+	}
+	oldCA := secret("ca-1", rootCASecret, "")
+	newCA := secret("ca-2", rootCASecret+".2", rootCASecret)
+	key := secret("key-1", "APP-ONE_KEY", "")
+	all := map[string]swarm.Secret{oldCA.ID: oldCA, newCA.ID: newCA, key.ID: key}
+	newest := map[string]swarm.Secret{rootCASecret: newCA, "APP-ONE_KEY": key}
+
+	ref := func(s swarm.Secret) *swarm.SecretReference {
+		return &swarm.SecretReference{SecretID: s.ID, SecretName: s.Spec.Name, File: &swarm.SecretReferenceFileTarget{Name: secretBase(s)}}
+	}
+
+	tests := []struct {
+		name        string
+		refs        []*swarm.SecretReference
+		noContainer bool
+		wantChanged bool
+		wantIDs     []string
+	}{
+		{name: "old root CA", refs: []*swarm.SecretReference{ref(oldCA), ref(key)}, wantChanged: true, wantIDs: []string{"ca-2", "key-1"}},
+		{name: "up to date", refs: []*swarm.SecretReference{ref(newCA), ref(key)}, wantIDs: []string{"ca-2", "key-1"}},
+		{name: "unknown secret", refs: []*swarm.SecretReference{{SecretID: "other", SecretName: "OTHER"}}, wantIDs: []string{"other"}},
+		{name: "no container spec", noContainer: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			original := swarm.ServiceSpec{}
+			if !tt.noContainer {
+				original.TaskTemplate.ContainerSpec = &swarm.ContainerSpec{Secrets: tt.refs}
+			}
+			spec := original
+			originalIDs := []string{}
+			for _, r := range tt.refs {
+				originalIDs = append(originalIDs, r.SecretID)
+			}
+
+			if changed := refreshSecretRefs(&spec, all, newest); changed != tt.wantChanged {
+				t.Errorf("changed = %v, want %v", changed, tt.wantChanged)
+			}
+			if tt.noContainer {
+				return
+			}
+			ids := []string{}
+			for _, r := range spec.TaskTemplate.ContainerSpec.Secrets {
+				ids = append(ids, r.SecretID)
+				if s, ok := all[r.SecretID]; ok && r.SecretName != s.Spec.Name {
+					t.Errorf("reference to %s is called %s", s.Spec.Name, r.SecretName)
+				}
+			}
+			if strings.Join(ids, ",") != strings.Join(tt.wantIDs, ",") {
+				t.Errorf("secrets = %v, want %v", ids, tt.wantIDs)
+			}
+			for i, r := range original.TaskTemplate.ContainerSpec.Secrets {
+				if r.SecretID != originalIDs[i] {
+					t.Errorf("the original spec now has secret %s, want %s", r.SecretID, originalIDs[i])
+				}
+			}
+		})
+	}
+}
+
+// the renewed root CA keeps its key and subject so certificates it signed before
+// are still trusted by it and those it signs are trusted by the old one
+func TestRenewRootCAKeepsLeafCerts(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "root-ca")
+	if err != nil {
+		t.Fatal(err)
+	}
+	priv := filepath.Join(dir, "ca.crt")
+	pub := filepath.Join(dir, "ca-pub.crt")
+	GenRootCA(priv, pub)
+	oldPub, _ := ioutil.ReadFile(pub)
+	before, err := parseCertPEM(GenCert(priv, "app-one", []string{"127.0.0.1"}, []string{"app-one"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = RenewRootCA(priv, pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	newPub, _ := ioutil.ReadFile(pub)
+	oldCA, _ := parseCertPEM(oldPub)
+	newCA, err := parseCertPEM(newPub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if newCA.SerialNumber.Cmp(oldCA.SerialNumber) == 0 || !newCA.NotAfter.After(oldCA.NotAfter) {
+		t.Errorf("root CA was not re-issued, serial %v expires %v", newCA.SerialNumber, newCA.NotAfter)
+	}
+	after, err := parseCertPEM(GenCert(priv, "app-one", []string{"127.0.0.1"}, []string{"app-one"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		leaf *x509.Certificate
+		ca   []byte
+	}{
+		{name: "old certificate, renewed CA", leaf: before, ca: newPub},
+		{name: "new certificate, old CA", leaf: after, ca: oldPub},
+		{name: "new certificate, renewed CA", leaf: after, ca: newPub},
+	}
+	for _, tt := range tests {
+		roots := x509.NewCertPool()
+		roots.AppendCertsFromPEM(tt.ca)
+		_, err := tt.leaf.Verify(x509.VerifyOptions{Roots: roots, DNSName: "app-one"})
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+		}
+	}
+}
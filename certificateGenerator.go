@@ -8,6 +8,7 @@ import (
 	"crypto/x509/pkix"
 	b64 "encoding/base64"
 	"encoding/pem"
+	"errors"
 	"io/ioutil"
 	"math/big"
 	"net"
@@ -18,6 +19,9 @@ import (
 	libDatabox "github.com/me-box/lib-go-databox"
 )
 
+// certValidity is how long the certificates made by GenCert and GenRootCA last
+var certValidity = 365 * 24 * time.Hour
+
 func GenCert(CAFilePath string, commonName string, ips []string, hostNames []string) []byte {
 
 	libDatabox.Debug("[GenCert] " + commonName)
@@ -38,7 +42,7 @@ func GenCert(CAFilePath string, commonName string, ips []string, hostNames []str
 	libDatabox.ChkErrFatal(err)
 
 	notBefore := time.Now()
-	notAfter := notBefore.Add(365 * 24 * time.Hour)
+	notAfter := notBefore.Add(certValidity)
 	serialNumberLimit := new(big.Int).Lsh(big.NewInt(1), 128)
 	serialNumber, snErr := rand.Int(rand.Reader, serialNumberLimit)
 	libDatabox.ChkErrFatal(snErr)
@@ -105,7 +109,7 @@ func GenRootCA(CAFilePathPriv string, CAFilePathPub string) {
 	libDatabox.ChkErrFatal(err)
 
 	notBefore := time.Now()
-	notAfter := notBefore.Add(365 * 24 * time.Hour)
+	notAfter := notBefore.Add(certValidity)
 	serialNumberLimit := new(big.Int).Lsh(big.NewInt(1), 128)
 	serialNumber, snErr := rand.Int(rand.Reader, serialNumberLimit)
 	libDatabox.ChkErrFatal(snErr)
@@ -153,6 +157,119 @@ func GenRootCA(CAFilePathPriv string, CAFilePathPub string) {
 
 }
 
+// RenewRootCA re-issues the root CA certificate in CAFilePathPriv for another certValidity.
+// The key and subject are kept so certificates it has already signed stay valid.
+func RenewRootCA(CAFilePathPriv string, CAFilePathPub string) error {
+	libDatabox.Info("RenewRootCA called")
+
+	rootCertPem, err := ioutil.ReadFile(CAFilePathPriv)
+	if err != nil {
+		return err
+	}
+	rootCertBytes, rest := pem.Decode(rootCertPem)
+	if rootCertBytes == nil {
+		return errors.New("No certificate in " + CAFilePathPriv)
+	}
+	rootCert, err := x509.ParseCertificate(rootCertBytes.Bytes)
+	if err != nil {
+		return err
+	}
+	rootPrivateKeyBytes, _ := pem.Decode(rest)
+	if rootPrivateKeyBytes == nil {
+		return errors.New("No private key in " + CAFilePathPriv)
+	}
+	priv, err := x509.ParsePKCS1PrivateKey(rootPrivateKeyBytes.Bytes)
+	if err != nil {
+		return err
+	}
+
+	notBefore := time.Now()
+	notAfter := notBefore.Add(certValidity)
+	serialNumberLimit := new(big.Int).Lsh(big.NewInt(1), 128)
+	serialNumber, err := rand.Int(rand.Reader, serialNumberLimit)
+	if err != nil {
+		return err
+	}
+
+	template := x509.Certificate{
+		SerialNumber: serialNumber,
+		Subject:      rootCert.Subject,
+		NotBefore:    notBefore,
+		NotAfter:     notAfter,
+
+		KeyUsage:              rootCert.KeyUsage,
+		ExtKeyUsage:           rootCert.ExtKeyUsage,
+		BasicConstraintsValid: true,
+		SubjectKeyId:          rootCert.SubjectKeyId,
+	}
+	template.IsCA = true
+
+	derBytes, err := x509.CreateCertificate(rand.Reader, &template, &template, &priv.PublicKey, priv)
+	if err != nil {
+		return err
+	}
+
+	pub := new(bytes.Buffer)
+	pem.Encode(pub, &pem.Block{Type: "CERTIFICATE", Bytes: derBytes})
+	err = writeFileAtomic(CAFilePathPub, pub.Bytes(), 0644)
+	if err != nil {
+		return err
+	}
+
+	//the private key file is written last, until then the old CA is still used to sign
+	full := new(bytes.Buffer)
+	pem.Encode(full, &pem.Block{Type: "CERTIFICATE", Bytes: derBytes})
+	pem.Encode(full, &pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(priv)})
+	return writeFileAtomic(CAFilePathPriv, full.Bytes(), 0600)
+}
+
+// parseCertPEM returns the first certificate in the PEM data
+func parseCertPEM(data []byte) (*x509.Certificate, error) {
+	for {
+		block, rest := pem.Decode(data)
+		if block == nil {
+			return nil, errors.New("No certificate found")
+		}
+		if block.Type == "CERTIFICATE" {
+			return x509.ParseCertificate(block.Bytes)
+		}
+		data = rest
+	}
+}
+
+// certCovers reports if the certificate in the PEM data is valid for every one of ips and hosts
+func certCovers(data []byte, ips []string, hosts []string) bool {
+	cert, err := parseCertPEM(data)
+	if err != nil {
+		return false
+	}
+	for _, ip := range ips {
+		if net.ParseIP(ip) == nil {
+			//a blank external IP is not in the certificate
+			continue
+		}
+		if cert.VerifyHostname(ip) != nil {
+			return false
+		}
+	}
+	for _, h := range hosts {
+		if h != "" && cert.VerifyHostname(h) != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// writeFileAtomic replaces path with data so readers see the old or new file, never half of one
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	tmp := path + ".new"
+	err := ioutil.WriteFile(tmp, data, perm)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
 func GenerateArbiterToken() []byte {
 	len := 32
 	data := make([]byte, len)
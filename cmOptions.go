@@ -0,0 +1,13 @@
+package main
+
+// CMOptions are the settings in DATABOX_CM_OPTIONS that libDatabox.ContainerManagerOptions
+// does not know about. They are read from the same JSON so all the keys sit side by side.
+type CMOptions struct {
+	ResourceOptions
+	ReadinessOptions
+	StatsOptions
+	BackupOptions
+	SessionOptions
+	LoginOptions
+	CertOptions
+}
@@ -6,12 +6,42 @@ import (
 	libDatabox "github.com/me-box/lib-go-databox"
 )
 
+// KeyValueStore is the part of the core-store KV API used by CMStore.
+// The KVJSON and KVText clients of a libDatabox.CoreStoreClient satisfy it.
+type KeyValueStore interface {
+	Write(dataSourceID string, key string, payload []byte) error
+	Read(dataSourceID string, key string) ([]byte, error)
+	ListKeys(dataSourceID string) ([]string, error)
+	Delete(dataSourceID string, key string) error
+	DeleteAll(dataSourceID string) error
+}
+
 type CMStore struct {
-	Store *libDatabox.CoreStoreClient
+	Store KeyValueStore
+	//only used to read the plain text password saved by older versions
+	Text KeyValueStore
 }
 
 const slaStoreID = "slaStore"
 
+// resourceStoreID holds resource limits apart from the SLAs as libDatabox.SLA has nowhere to put them
+const resourceStoreID = "resourceStore"
+
+// credentialStoreID holds the salted hash of the dashboard password
+const credentialStoreID = "credentialStore"
+
+// userStoreID holds the dashboard accounts other than the built-in admin
+const userStoreID = "userStore"
+
+// sessionStoreID holds the hashed IDs of dashboard sessions when SessionOptions.PersistSessions is set
+const sessionStoreID = "sessionStore"
+
+// stoppedStoreID records the components a user has stopped so they stay stopped after a reboot
+const stoppedStoreID = "stoppedStore"
+
+// quarantineStoreID records the components quarantined for crashing so they stay quarantined after a reboot
+const quarantineStoreID = "quarantineStore"
+
 func NewCMStore(store *libDatabox.CoreStoreClient) *CMStore {
 
 	//setup SLAStore
@@ -27,7 +57,79 @@ func NewCMStore(store *libDatabox.CoreStoreClient) *CMStore {
 		Unit:           "",
 	})
 
-	return &CMStore{Store: store}
+	store.RegisterDatasource(libDatabox.DataSourceMetadata{
+		Description:    "Persistent resource limit storage",
+		ContentType:    "json",
+		Vendor:         "databox",
+		DataSourceType: "databox:container-manager:resources",
+		DataSourceID:   resourceStoreID,
+		StoreType:      "kv",
+		IsActuator:     false,
+		Location:       "",
+		Unit:           "",
+	})
+
+	store.RegisterDatasource(libDatabox.DataSourceMetadata{
+		Description:    "Persistent stopped component storage",
+		ContentType:    "json",
+		Vendor:         "databox",
+		DataSourceType: "databox:container-manager:stopped",
+		DataSourceID:   stoppedStoreID,
+		StoreType:      "kv",
+		IsActuator:     false,
+		Location:       "",
+		Unit:           "",
+	})
+
+	store.RegisterDatasource(libDatabox.DataSourceMetadata{
+		Description:    "Persistent quarantined component storage",
+		ContentType:    "json",
+		Vendor:         "databox",
+		DataSourceType: "databox:container-manager:quarantined",
+		DataSourceID:   quarantineStoreID,
+		StoreType:      "kv",
+		IsActuator:     false,
+		Location:       "",
+		Unit:           "",
+	})
+
+	store.RegisterDatasource(libDatabox.DataSourceMetadata{
+		Description:    "Dashboard password hash storage",
+		ContentType:    "json",
+		Vendor:         "databox",
+		DataSourceType: "databox:container-manager:credentials",
+		DataSourceID:   credentialStoreID,
+		StoreType:      "kv",
+		IsActuator:     false,
+		Location:       "",
+		Unit:           "",
+	})
+
+	store.RegisterDatasource(libDatabox.DataSourceMetadata{
+		Description:    "Dashboard user account storage",
+		ContentType:    "json",
+		Vendor:         "databox",
+		DataSourceType: "databox:container-manager:users",
+		DataSourceID:   userStoreID,
+		StoreType:      "kv",
+		IsActuator:     false,
+		Location:       "",
+		Unit:           "",
+	})
+
+	store.RegisterDatasource(libDatabox.DataSourceMetadata{
+		Description:    "Persistent dashboard session storage",
+		ContentType:    "json",
+		Vendor:         "databox",
+		DataSourceType: "databox:container-manager:sessions",
+		DataSourceID:   sessionStoreID,
+		StoreType:      "kv",
+		IsActuator:     false,
+		Location:       "",
+		Unit:           "",
+	})
+
+	return &CMStore{Store: store.KVJSON, Text: store.KVText}
 }
 
 func (s CMStore) SaveSLA(sla libDatabox.SLA) error {
@@ -37,22 +139,35 @@ func (s CMStore) SaveSLA(sla libDatabox.SLA) error {
 		return err
 	}
 
-	return s.Store.KVJSON.Write(slaStoreID, sla.Name, payload)
+	return s.Store.Write(slaStoreID, sla.Name, payload)
 
 }
 
+func (s CMStore) GetSLA(name string) (libDatabox.SLA, error) {
+
+	var sla libDatabox.SLA
+
+	payload, err := s.Store.Read(slaStoreID, name)
+	if err != nil {
+		return sla, err
+	}
+
+	err = json.Unmarshal(payload, &sla)
+	return sla, err
+}
+
 func (s CMStore) GetAllSLAs() ([]libDatabox.SLA, error) {
 
 	var slaList []libDatabox.SLA
 
-	keys, err := s.Store.KVJSON.ListKeys(slaStoreID)
+	keys, err := s.Store.ListKeys(slaStoreID)
 	if err != nil {
 		return nil, err
 	}
 
 	for _, k := range keys {
 		var sla libDatabox.SLA
-		payload, err := s.Store.KVJSON.Read(slaStoreID, k)
+		payload, err := s.Store.Read(slaStoreID, k)
 		if err != nil {
 			libDatabox.Err("[GetAllSLAs] failed to get  " + slaStoreID + ". " + err.Error())
 			continue
@@ -70,18 +185,238 @@ func (s CMStore) GetAllSLAs() ([]libDatabox.SLA, error) {
 }
 
 func (s CMStore) DeleteSLA(name string) error {
-	return s.Store.KVJSON.Delete(slaStoreID, name)
+	return s.Store.Delete(slaStoreID, name)
 }
 
 func (s CMStore) ClearSLADatabase() error {
-	return s.Store.KVJSON.DeleteAll(slaStoreID)
+	return s.Store.DeleteAll(slaStoreID)
+}
+
+func (s CMStore) SaveResources(name string, limits ResourceLimits) error {
+
+	payload, err := json.Marshal(limits)
+	if err != nil {
+		return err
+	}
+
+	return s.Store.Write(resourceStoreID, name, payload)
+}
+
+func (s CMStore) GetResources(name string) (ResourceLimits, error) {
+
+	var limits ResourceLimits
+
+	payload, err := s.Store.Read(resourceStoreID, name)
+	if err != nil {
+		return limits, err
+	}
+
+	err = json.Unmarshal(payload, &limits)
+	return limits, err
+}
+
+func (s CMStore) DeleteResources(name string) error {
+	return s.Store.Delete(resourceStoreID, name)
+}
+
+func (s CMStore) SaveStopped(name string, rec stoppedRecord) error {
+
+	payload, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+
+	return s.Store.Write(stoppedStoreID, name, payload)
+}
+
+func (s CMStore) GetAllStopped() (map[string]stoppedRecord, error) {
+
+	stopped := map[string]stoppedRecord{}
+
+	keys, err := s.Store.ListKeys(stoppedStoreID)
+	if err != nil {
+		return stopped, err
+	}
+
+	for _, k := range keys {
+		var rec stoppedRecord
+		payload, err := s.Store.Read(stoppedStoreID, k)
+		if err != nil {
+			libDatabox.Err("[GetAllStopped] failed to get " + k + ". " + err.Error())
+			continue
+		}
+		err = json.Unmarshal(payload, &rec)
+		if err != nil {
+			libDatabox.Err("[GetAllStopped] failed decode " + k + ". " + err.Error())
+			continue
+		}
+		stopped[k] = rec
+	}
+
+	return stopped, nil
+}
+
+func (s CMStore) DeleteStopped(name string) error {
+	return s.Store.Delete(stoppedStoreID, name)
+}
+
+func (s CMStore) SaveQuarantined(name string, rec quarantineRecord) error {
+
+	payload, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+
+	return s.Store.Write(quarantineStoreID, name, payload)
+}
+
+func (s CMStore) GetAllQuarantined() (map[string]quarantineRecord, error) {
+
+	quarantined := map[string]quarantineRecord{}
+
+	keys, err := s.Store.ListKeys(quarantineStoreID)
+	if err != nil {
+		return quarantined, err
+	}
+
+	for _, k := range keys {
+		var rec quarantineRecord
+		payload, err := s.Store.Read(quarantineStoreID, k)
+		if err != nil {
+			libDatabox.Err("[GetAllQuarantined] failed to get " + k + ". " + err.Error())
+			continue
+		}
+		err = json.Unmarshal(payload, &rec)
+		if err != nil {
+			libDatabox.Err("[GetAllQuarantined] failed decode " + k + ". " + err.Error())
+			continue
+		}
+		quarantined[k] = rec
+	}
+
+	return quarantined, nil
+}
+
+func (s CMStore) DeleteQuarantined(name string) error {
+	return s.Store.Delete(quarantineStoreID, name)
+}
+
+func (s CMStore) SaveSession(session Session) error {
+
+	payload, err := json.Marshal(session)
+	if err != nil {
+		return err
+	}
+
+	return s.Store.Write(sessionStoreID, session.ID, payload)
+}
+
+func (s CMStore) GetAllSessions() (map[string]Session, error) {
+
+	sessions := map[string]Session{}
+
+	keys, err := s.Store.ListKeys(sessionStoreID)
+	if err != nil {
+		return sessions, err
+	}
+
+	for _, k := range keys {
+		var session Session
+		payload, err := s.Store.Read(sessionStoreID, k)
+		if err != nil {
+			libDatabox.Err("[GetAllSessions] failed to get " + k + ". " + err.Error())
+			continue
+		}
+		err = json.Unmarshal(payload, &session)
+		if err != nil {
+			libDatabox.Err("[GetAllSessions] failed decode " + k + ". " + err.Error())
+			continue
+		}
+		sessions[k] = session
+	}
+
+	return sessions, nil
+}
+
+func (s CMStore) DeleteSession(id string) error {
+	return s.Store.Delete(sessionStoreID, id)
+}
+
+func (s CMStore) ClearSessions() error {
+	return s.Store.DeleteAll(sessionStoreID)
+}
+
+func (s CMStore) SaveUser(user storedUser) error {
+
+	payload, err := json.Marshal(user)
+	if err != nil {
+		return err
+	}
+
+	return s.Store.Write(userStoreID, user.Name, payload)
+}
+
+func (s CMStore) GetAllUsers() (map[string]storedUser, error) {
+
+	users := map[string]storedUser{}
+
+	keys, err := s.Store.ListKeys(userStoreID)
+	if err != nil {
+		return users, err
+	}
+
+	for _, k := range keys {
+		var user storedUser
+		payload, err := s.Store.Read(userStoreID, k)
+		if err != nil {
+			libDatabox.Err("[GetAllUsers] failed to get " + k + ". " + err.Error())
+			continue
+		}
+		err = json.Unmarshal(payload, &user)
+		if err != nil {
+			libDatabox.Err("[GetAllUsers] failed decode " + k + ". " + err.Error())
+			continue
+		}
+		users[k] = user
+	}
+
+	return users, nil
 }
 
-func (s CMStore) SavePassword(password string) error {
-	return s.Store.KVText.Write(slaStoreID, "CMPassword", []byte(password))
+func (s CMStore) DeleteUser(name string) error {
+	return s.Store.Delete(userStoreID, name)
 }
 
+// LoadPassword reads the plain text password saved by older versions
 func (s CMStore) LoadPassword() (string, error) {
-	password, err := s.Store.KVText.Read(slaStoreID, "CMPassword")
+	password, err := s.Text.Read(slaStoreID, "CMPassword")
 	return string(password), err
 }
+
+func (s CMStore) DeletePassword() error {
+	return s.Text.Delete(slaStoreID, "CMPassword")
+}
+
+func (s CMStore) SavePasswordHash(hash PasswordHash) error {
+
+	payload, err := json.Marshal(hash)
+	if err != nil {
+		return err
+	}
+
+	return s.Store.Write(credentialStoreID, "dashboard", payload)
+}
+
+// LoadPasswordHash returns a blank PasswordHash if none has been saved
+func (s CMStore) LoadPasswordHash() (PasswordHash, error) {
+
+	var hash PasswordHash
+
+	payload, err := s.Store.Read(credentialStoreID, "dashboard")
+	if err != nil || len(payload) == 0 {
+		return hash, err
+	}
+
+	err = json.Unmarshal(payload, &hash)
+	return hash, err
+}
@@ -1,30 +1,28 @@
 package main
 
 import (
-	"context"
 	"encoding/json"
+	"errors"
 	"io/ioutil"
-	"strings"
 	"time"
 
-	"github.com/docker/docker/api/types"
-	"github.com/docker/docker/api/types/filters"
-	"github.com/docker/docker/api/types/swarm"
+	"github.com/me-box/core-container-manager/api"
 	libDatabox "github.com/me-box/lib-go-databox"
 	qrcode "github.com/skip2/go-qrcode"
 )
 
-//data required for an install request
-type installRequest struct {
-	Manifest libDatabox.Manifest `json:"manifest"`
+type jobStatusRequest struct {
+	ID string `json:"id"`
 }
 
-type restartRequest struct {
-	Name string `json:"name"`
-}
-
-type uninstallRequest struct {
-	Name string `json:"name"`
+//result of the synchronous Install, Uninstall and Restart FUNCs
+type funcResult struct {
+	Name       string            `json:"name"`
+	Success    bool              `json:"success"`
+	Error      string            `json:"error,omitempty"`
+	Job        Job               `json:"job"`
+	Validation *ValidationResult `json:"validation,omitempty"`
+	Dependents []string          `json:"dependents,omitempty"`
 }
 
 func CmZestAPI(cm *ContainerManager) {
@@ -32,6 +30,24 @@ func CmZestAPI(cm *ContainerManager) {
 	//expose functions
 	cm.CmgrStoreClient.FUNC.Register("databox", "ServiceStatus", libDatabox.ContentTypeJSON, ServiceStatus(cm))
 	cm.CmgrStoreClient.FUNC.Register("databox", "ListAllDatasources", libDatabox.ContentTypeJSON, ListAllDatasources(cm))
+	cm.CmgrStoreClient.FUNC.Register("databox", "JobStatus", libDatabox.ContentTypeJSON, JobStatus(cm))
+	cm.CmgrStoreClient.FUNC.Register("databox", "Validate", libDatabox.ContentTypeJSON, Validate(cm))
+	cm.CmgrStoreClient.FUNC.Register("databox", "LifecycleEvents", libDatabox.ContentTypeJSON, LifecycleEvents(cm))
+	cm.CmgrStoreClient.FUNC.Register("databox", "Install", libDatabox.ContentTypeJSON, InstallFunc(cm))
+	cm.CmgrStoreClient.FUNC.Register("databox", "Uninstall", libDatabox.ContentTypeJSON, UninstallFunc(cm))
+	cm.CmgrStoreClient.FUNC.Register("databox", "Restart", libDatabox.ContentTypeJSON, RestartFunc(cm))
+	cm.CmgrStoreClient.FUNC.Register("databox", "Logs", libDatabox.ContentTypeJSON, Logs(cm))
+	cm.CmgrStoreClient.FUNC.Register("databox", "ResourceUsage", libDatabox.ContentTypeJSON, ResourceUsage(cm))
+	cm.CmgrStoreClient.FUNC.Register("databox", "DatasourceCatalogue", libDatabox.ContentTypeJSON, DatasourceCatalogue(cm))
+	cm.CmgrStoreClient.FUNC.Register("databox", "Consumers", libDatabox.ContentTypeJSON, Consumers(cm))
+	cm.CmgrStoreClient.FUNC.Register("databox", "Dependencies", libDatabox.ContentTypeJSON, Dependencies(cm))
+	cm.CmgrStoreClient.FUNC.Register("databox", "Stop", libDatabox.ContentTypeJSON, StopFunc(cm))
+	cm.CmgrStoreClient.FUNC.Register("databox", "Start", libDatabox.ContentTypeJSON, StartFunc(cm))
+	cm.CmgrStoreClient.FUNC.Register("databox", "ListSessions", libDatabox.ContentTypeJSON, ListSessions(cm))
+	cm.CmgrStoreClient.FUNC.Register("databox", "RevokeSession", libDatabox.ContentTypeJSON, RevokeSession(cm))
+	cm.CmgrStoreClient.FUNC.Register("databox", "LoginLockouts", libDatabox.ContentTypeJSON, LoginLockouts(cm))
+	cm.CmgrStoreClient.FUNC.Register("databox", "Unlock", libDatabox.ContentTypeJSON, Unlock(cm))
+	cm.CmgrStoreClient.FUNC.Register("databox", "Certificates", libDatabox.ContentTypeJSON, Certificates(cm))
 
 	//
 	//Register and observe API command endpoints
@@ -70,7 +86,7 @@ func CmZestAPI(cm *ContainerManager) {
 	}
 	cm.CmgrStoreClient.RegisterDatasource(DataMetadata)
 	//go populateDataSources(cm)
-	//go populateServiceStatus(cm)
+	go populateServiceStatus(cm)
 	go populateMobileAppQrCodeAndCerts(cm)
 
 }
@@ -79,54 +95,7 @@ func ServiceStatus(cm *ContainerManager) libDatabox.FuncHandler {
 	libDatabox.Info("API: registering ServiceStatus")
 	return func(contnetType libDatabox.StoreContentType, payload []byte) ([]byte, error) {
 		//libDatabox.Info("API: ServiceStatus called contentType=" + string(contnetType) + "Payload=" + string(payload))
-		type listResult struct {
-			Name         string          `json:"name"`
-			Type         string          `json:"type"`
-			DesiredState swarm.TaskState `json:"desiredState"`
-			State        swarm.TaskState `json:"state"`
-			Status       swarm.TaskState `json:"status"`
-		}
-
-		services, _ := cm.cli.ServiceList(context.Background(), types.ServiceListOptions{})
-		res := []listResult{}
-		for _, service := range services {
-
-			_, exists := service.Spec.Labels["databox.type"]
-			if exists == false {
-				//its not a databox service
-				continue
-			}
-
-			lr := listResult{
-				Name: service.Spec.Name,
-				Type: service.Spec.Labels["databox.type"],
-			}
-
-			taskFilters := filters.NewArgs()
-			taskFilters.Add("service", service.Spec.Name)
-			tasks, _ := cm.cli.TaskList(context.Background(), types.TaskListOptions{
-				Filters: taskFilters,
-			})
-			if len(tasks) > 0 {
-				latestTasks := tasks[0]
-				latestTime := latestTasks.UpdatedAt
-
-				for _, t := range tasks {
-					if t.UpdatedAt.After(latestTime) {
-						latestTasks = t
-						latestTime = latestTasks.UpdatedAt
-					}
-				}
-
-				lr.DesiredState = latestTasks.DesiredState
-				lr.State = latestTasks.Status.State
-				lr.Status = latestTasks.Status.State
-			}
-
-			res = append(res, lr)
-		}
-
-		jsonString, err := json.Marshal(res)
+		jsonString, err := json.Marshal(cm.serviceStatus())
 		if err != nil {
 			libDatabox.Err("[ServiceStatus] Error " + err.Error())
 		}
@@ -136,38 +105,391 @@ func ServiceStatus(cm *ContainerManager) libDatabox.FuncHandler {
 	}
 }
 
+// ListAllDatasources returns the datasources in every store, optionally filtered by
+// type, vendor, location or store, see catalogueFilter
 func ListAllDatasources(cm *ContainerManager) libDatabox.FuncHandler {
 	libDatabox.Debug("API: ListAllDatasources Install")
 	return func(contnetType libDatabox.StoreContentType, payload []byte) ([]byte, error) {
 		libDatabox.Debug("API: ListAllDatasources called contentType=" + string(contnetType))
-		hyperCatRoot, err := cm.ArbiterClient.GetRootDataSourceCatalogue()
+		report, err := datasourceReport(cm, payload)
+		if err != nil {
+			libDatabox.Err("[ListAllDatasources] " + err.Error())
+			return []byte{}, err
+		}
+		for _, s := range report.Unreachable {
+			libDatabox.Warn("[ListAllDatasources] store " + s.Store + " unreachable " + s.Error)
+		}
+
+		jsonString, err := json.Marshal(report.Datasources)
+		if err != nil {
+			libDatabox.Err("[ListAllDatasources] Error " + err.Error())
+			return []byte{}, err
+		}
+
+		return jsonString, err
+	}
+}
+
+// DatasourceCatalogue is ListAllDatasources with the stores that could not be reached
+// and a version that changes when the catalogue does
+func DatasourceCatalogue(cm *ContainerManager) libDatabox.FuncHandler {
+	libDatabox.Info("API: registering DatasourceCatalogue")
+	return func(contnetType libDatabox.StoreContentType, payload []byte) ([]byte, error) {
+		report, err := datasourceReport(cm, payload)
+		if err != nil {
+			libDatabox.Err("[DatasourceCatalogue] " + err.Error())
+			return []byte{}, err
+		}
+		return json.Marshal(report)
+	}
+}
+
+func datasourceReport(cm *ContainerManager, payload []byte) (CatalogueReport, error) {
+	var filter catalogueFilter
+	if len(payload) > 0 {
+		err := json.Unmarshal(payload, &filter)
 		if err != nil {
-			libDatabox.Err("[ListAllDatasources] GetRootDataSourceCatalogue " + err.Error())
+			return CatalogueReport{}, errors.New("invalid JSON " + err.Error())
 		}
-		var datasources []libDatabox.HypercatItem
-		for _, item := range hyperCatRoot.Items {
-			if strings.Contains(item.Href, "-core-store:5555") == false {
-				//TODO Tell john this should not be here !!
-				continue
+	}
+	return cm.Catalogue.Report(filter)
+}
+
+// Consumers returns the installed components that read a datasource, the
+// datasources in a store or the datasources in the stores of a component
+func Consumers(cm *ContainerManager) libDatabox.FuncHandler {
+	libDatabox.Info("API: registering Consumers")
+	return func(contnetType libDatabox.StoreContentType, payload []byte) ([]byte, error) {
+		var request consumersRequest
+		err := json.Unmarshal(payload, &request)
+		if err != nil {
+			libDatabox.Err("[Consumers] invalid JSON " + err.Error())
+			return []byte{}, err
+		}
+		if request.Href == "" && request.Store == "" && request.Component == "" {
+			return []byte{}, errors.New("Consumers needs an href, store or component")
+		}
+
+		ci, err := cm.consumerIndex()
+		if err != nil {
+			libDatabox.Err("[Consumers] " + err.Error())
+			return []byte{}, err
+		}
+		return json.Marshal(ci.consumers(request))
+	}
+}
+
+// Dependencies returns the datasources the named component reads
+func Dependencies(cm *ContainerManager) libDatabox.FuncHandler {
+	libDatabox.Info("API: registering Dependencies")
+	return func(contnetType libDatabox.StoreContentType, payload []byte) ([]byte, error) {
+		var request struct {
+			Name string `json:"name"`
+		}
+		err := json.Unmarshal(payload, &request)
+		if err != nil {
+			libDatabox.Err("[Dependencies] invalid JSON " + err.Error())
+			return []byte{}, err
+		}
+		if request.Name == "" {
+			return []byte{}, errors.New("Dependencies request.name is blank")
+		}
+
+		ci, err := cm.consumerIndex()
+		if err != nil {
+			libDatabox.Err("[Dependencies] " + err.Error())
+			return []byte{}, err
+		}
+		return json.Marshal(ci.dependencies(request.Name))
+	}
+}
+
+// ListSessions returns the logged in dashboard sessions
+func ListSessions(cm *ContainerManager) libDatabox.FuncHandler {
+	libDatabox.Info("API: registering ListSessions")
+	return func(contnetType libDatabox.StoreContentType, payload []byte) ([]byte, error) {
+		return json.Marshal(cm.ActiveSessions.List(""))
+	}
+}
+
+// RevokeSession logs out the dashboard session with the requested id
+func RevokeSession(cm *ContainerManager) libDatabox.FuncHandler {
+	libDatabox.Info("API: registering RevokeSession")
+	return func(contnetType libDatabox.StoreContentType, payload []byte) ([]byte, error) {
+		var request revokeSessionRequest
+		err := json.Unmarshal(payload, &request)
+		if err != nil {
+			libDatabox.Err("[RevokeSession] invalid JSON " + err.Error())
+			return []byte{}, err
+		}
+		if !cm.ActiveSessions.Revoke(request.ID) {
+			return []byte{}, errors.New("Session " + request.ID + " not found")
+		}
+		return json.Marshal(funcResult{Name: request.ID, Success: true})
+	}
+}
+
+// LoginLockouts returns the clients with failed dashboard logins and any lockouts
+func LoginLockouts(cm *ContainerManager) libDatabox.FuncHandler {
+	libDatabox.Info("API: registering LoginLockouts")
+	return func(contnetType libDatabox.StoreContentType, payload []byte) ([]byte, error) {
+		return json.Marshal(cm.Logins.Status())
+	}
+}
+
+// Unlock lifts the login lockout of the requested client IP
+func Unlock(cm *ContainerManager) libDatabox.FuncHandler {
+	libDatabox.Info("API: registering Unlock")
+	return func(contnetType libDatabox.StoreContentType, payload []byte) ([]byte, error) {
+		var request unlockRequest
+		err := json.Unmarshal(payload, &request)
+		if err != nil {
+			libDatabox.Err("[Unlock] invalid JSON " + err.Error())
+			return []byte{}, err
+		}
+		if !cm.Logins.Unlock(request.IP) {
+			return []byte{}, errors.New("No failed logins from " + request.IP)
+		}
+		return json.Marshal(funcResult{Name: request.IP, Success: true})
+	}
+}
+
+// Certificates returns the expiry of the root CA and every certificate issued from it
+func Certificates(cm *ContainerManager) libDatabox.FuncHandler {
+	libDatabox.Info("API: registering Certificates")
+	return func(contnetType libDatabox.StoreContentType, payload []byte) ([]byte, error) {
+		return json.Marshal(cm.Certs.List())
+	}
+}
+
+// JobStatus returns the install, uninstall and restart job with the requested id
+// or all known jobs if no id is given.
+func JobStatus(cm *ContainerManager) libDatabox.FuncHandler {
+	libDatabox.Info("API: registering JobStatus")
+	return func(contnetType libDatabox.StoreContentType, payload []byte) ([]byte, error) {
+		var request jobStatusRequest
+		if len(payload) > 0 {
+			err := json.Unmarshal(payload, &request)
+			if err != nil {
+				libDatabox.Err("[JobStatus] invalid JSON " + err.Error())
+				return []byte{}, err
 			}
-			//get the store cat
-			storeURL, _ := libDatabox.GetStoreURLFromDsHref(item.Href)
-			sc := libDatabox.NewCoreStoreClient(cm.ArbiterClient, "/run/secrets/ZMQ_PUBLIC_KEY", storeURL, false)
-			storeCat, err := sc.GetStoreDataSourceCatalogue(item.Href)
+		}
+
+		if request.ID == "" {
+			return json.Marshal(cm.Jobs.List())
+		}
+
+		job, ok := cm.Jobs.Get(request.ID)
+		if !ok {
+			return []byte{}, errors.New("Job " + request.ID + " not found")
+		}
+		return json.Marshal(job)
+	}
+}
+
+// Validate checks the manifest in an install request and returns the errors and warnings
+// found so they can be shown before the user confirms the install.
+func Validate(cm *ContainerManager) libDatabox.FuncHandler {
+	libDatabox.Info("API: registering Validate")
+	return func(contnetType libDatabox.StoreContentType, payload []byte) ([]byte, error) {
+		var request api.InstallRequest
+		err := json.Unmarshal(payload, &request)
+		if err != nil {
+			libDatabox.Err("[Validate] invalid JSON " + err.Error())
+			return []byte{}, err
+		}
+
+		validation := cm.ValidateSLA(convertManifestToSLA(request))
+		limits, _ := parseResourceRequest(payload)
+		validateResources(limits, &validation)
+
+		return json.Marshal(validation)
+	}
+}
+
+// LifecycleEvents returns the journal entries for a component in a time range,
+// see journalQuery. All components are included if no component is given.
+func LifecycleEvents(cm *ContainerManager) libDatabox.FuncHandler {
+	libDatabox.Info("API: registering LifecycleEvents")
+	return func(contnetType libDatabox.StoreContentType, payload []byte) ([]byte, error) {
+		var query journalQuery
+		if len(payload) > 0 {
+			err := json.Unmarshal(payload, &query)
 			if err != nil {
-				libDatabox.Warn("[ListAllDatasources] GetStoreDataSourceCatalogue " + item.Href + " " + err.Error())
+				libDatabox.Err("[LifecycleEvents] invalid JSON " + err.Error())
+				return []byte{}, err
 			}
-			for _, ds := range storeCat.Items {
-				datasources = append(datasources, ds)
+		}
+
+		events, err := cm.Journal.Query(query)
+		if err != nil {
+			libDatabox.Err("[LifecycleEvents] " + err.Error())
+			return []byte{}, err
+		}
+		return json.Marshal(events)
+	}
+}
+
+// ResourceUsage returns the latest resource usage of each component and the samples
+// recorded in a time range, see statsQuery. All components are included if no component is given.
+func ResourceUsage(cm *ContainerManager) libDatabox.FuncHandler {
+	libDatabox.Info("API: registering ResourceUsage")
+	return func(contnetType libDatabox.StoreContentType, payload []byte) ([]byte, error) {
+		var query statsQuery
+		if len(payload) > 0 {
+			err := json.Unmarshal(payload, &query)
+			if err != nil {
+				libDatabox.Err("[ResourceUsage] invalid JSON " + err.Error())
+				return []byte{}, err
 			}
 		}
-		jsonString, err := json.Marshal(datasources)
+
+		history, err := cm.Usage.Query(query)
 		if err != nil {
-			libDatabox.Err("[ListAllDatasources] Error " + err.Error())
+			libDatabox.Err("[ResourceUsage] " + err.Error())
 			return []byte{}, err
 		}
+		return json.Marshal(statsResult{
+			Current: cm.Usage.Current(query.Component),
+			History: history,
+		})
+	}
+}
 
-		return jsonString, err
+// InstallFunc installs the app or driver in an install request and only returns once
+// it is running or the install has failed. Unlike the api datasource the caller gets
+// the outcome, including the validation errors if the manifest was rejected.
+func InstallFunc(cm *ContainerManager) libDatabox.FuncHandler {
+	libDatabox.Info("API: registering Install")
+	return func(contnetType libDatabox.StoreContentType, payload []byte) ([]byte, error) {
+		var request api.InstallRequest
+		err := json.Unmarshal(payload, &request)
+		if err != nil {
+			libDatabox.Err("[Install] invalid JSON " + err.Error())
+			return []byte{}, err
+		}
+
+		sla := convertManifestToSLA(request)
+		limits, _ := parseResourceRequest(payload)
+		job := cm.Jobs.NewJob(JobTypeInstall, sla.Name)
+
+		validation := cm.ValidateSLA(sla)
+		validateResources(limits, &validation)
+
+		res := runFuncJob(cm, sla.Name, job, func() error {
+			return cm.Install(sla, limits, job)
+		})
+		if !validation.Valid {
+			res.Validation = &validation
+		}
+		return json.Marshal(res)
+	}
+}
+
+// UninstallFunc uninstalls the named app or driver and returns the outcome. If other
+// components use its datasources it is refused, listing them, unless force is set.
+func UninstallFunc(cm *ContainerManager) libDatabox.FuncHandler {
+	libDatabox.Info("API: registering Uninstall")
+	return func(contnetType libDatabox.StoreContentType, payload []byte) ([]byte, error) {
+		var request api.UninstallRequest
+		err := json.Unmarshal(payload, &request)
+		if err != nil {
+			libDatabox.Err("[Uninstall] invalid JSON " + err.Error())
+			return []byte{}, err
+		}
+		if request.Name == "" {
+			return []byte{}, errors.New("Uninstall request.name is blank")
+		}
+
+		var dependents []string
+		if ci, err := cm.consumerIndex(); err == nil {
+			dependents = ci.dependents(request.Name)
+		}
+		job := cm.Jobs.NewJob(JobTypeUninstall, request.Name)
+		res := runFuncJob(cm, request.Name, job, func() error {
+			return cm.Uninstall(request.Name, request.Force, job)
+		})
+		res.Dependents = dependents
+		return json.Marshal(res)
+	}
+}
+
+// RestartFunc restarts the named app or driver and returns once it is running again
+func RestartFunc(cm *ContainerManager) libDatabox.FuncHandler {
+	libDatabox.Info("API: registering Restart")
+	return namedFunc(cm, "Restart", JobTypeRestart, cm.Restart)
+}
+
+// StopFunc stops the named app or driver keeping it installed
+func StopFunc(cm *ContainerManager) libDatabox.FuncHandler {
+	libDatabox.Info("API: registering Stop")
+	return namedFunc(cm, "Stop", JobTypeStop, cm.StopService)
+}
+
+// StartFunc starts the named app or driver after it was stopped
+func StartFunc(cm *ContainerManager) libDatabox.FuncHandler {
+	libDatabox.Info("API: registering Start")
+	return namedFunc(cm, "Start", JobTypeStart, cm.StartService)
+}
+
+// namedFunc is a FUNC that runs a job against the component named in the request
+func namedFunc(cm *ContainerManager, funcName string, jobType JobType, run func(name string, job *Job) error) libDatabox.FuncHandler {
+	return func(contnetType libDatabox.StoreContentType, payload []byte) ([]byte, error) {
+		var request struct {
+			Name string `json:"name"`
+		}
+		err := json.Unmarshal(payload, &request)
+		if err != nil {
+			libDatabox.Err("[" + funcName + "] invalid JSON " + err.Error())
+			return []byte{}, err
+		}
+		if request.Name == "" {
+			return []byte{}, errors.New(funcName + " request.name is blank")
+		}
+
+		job := cm.Jobs.NewJob(jobType, request.Name)
+		res := runFuncJob(cm, request.Name, job, func() error {
+			return run(request.Name, job)
+		})
+		return json.Marshal(res)
+	}
+}
+
+// runFuncJob waits for run to finish and reports how job ended
+func runFuncJob(cm *ContainerManager, name string, job *Job, run func() error) funcResult {
+	err := run()
+	res := funcResult{
+		Name:    name,
+		Success: err == nil,
+	}
+	if err != nil {
+		res.Error = err.Error()
+	}
+	res.Job, _ = cm.Jobs.Get(job.ID)
+	return res
+}
+
+// Logs returns the last lines of the log of a databox component
+func Logs(cm *ContainerManager) libDatabox.FuncHandler {
+	libDatabox.Info("API: registering Logs")
+	return func(contnetType libDatabox.StoreContentType, payload []byte) ([]byte, error) {
+		var request logsRequest
+		err := json.Unmarshal(payload, &request)
+		if err != nil {
+			libDatabox.Err("[Logs] invalid JSON " + err.Error())
+			return []byte{}, err
+		}
+		if request.Name == "" {
+			return []byte{}, errors.New("Logs request.name is blank")
+		}
+
+		lines, err := cm.ServiceLogs(request.Name, request.Lines)
+		if err != nil {
+			libDatabox.Err("[Logs] " + err.Error())
+			return []byte{}, err
+		}
+		return json.Marshal(lines)
 	}
 }
 
@@ -181,26 +503,45 @@ func processAPICommands(cm *ContainerManager) {
 			select {
 			case ObserveResponse := <-ObserveResponseChan:
 				if ObserveResponse.Key == "install" {
-					var installData installRequest
+					var installData api.InstallRequest
 					err := json.Unmarshal(ObserveResponse.Data, &installData)
 					if err == nil {
 						sla := convertManifestToSLA(installData)
+						limits, _ := parseResourceRequest(ObserveResponse.Data)
+						job := cm.Jobs.NewJob(JobTypeInstall, sla.Name)
 						go func() {
-							err := cm.LaunchFromSLA(sla, true)
+							err := cm.Install(sla, limits, job)
 							libDatabox.ChkErr(err)
 						}()
 					} else {
 						libDatabox.Err("Install command received invalid JSON " + err.Error())
 					}
 				}
+				if ObserveResponse.Key == "upgrade" {
+					var upgradeData api.InstallRequest
+					err := json.Unmarshal(ObserveResponse.Data, &upgradeData)
+					if err == nil && upgradeData.Manifest.Name != "" {
+						sla := convertManifestToSLA(upgradeData)
+						limits, _ := parseResourceRequest(ObserveResponse.Data)
+						job := cm.Jobs.NewJob(JobTypeUpgrade, sla.Name)
+						go func() {
+							err := cm.Upgrade(sla, limits, job)
+							libDatabox.ChkErr(err)
+						}()
+					} else if err == nil {
+						libDatabox.Err("Upgrade command received invalid JSON manifest.name is blank")
+					} else {
+						libDatabox.Err("Upgrade command received invalid JSON " + err.Error())
+					}
+				}
 				if ObserveResponse.Key == "restart" {
-					var request restartRequest
+					var request api.NameRequest
 					err := json.Unmarshal(ObserveResponse.Data, &request)
 					libDatabox.ChkErr(err)
 					libDatabox.Debug("ObserveResponse data = " + string(ObserveResponse.Data))
 					libDatabox.Debug("request.Name data = " + request.Name)
 					if err == nil && request.Name != "" {
-						go cm.Restart(request.Name)
+						go cm.Restart(request.Name, cm.Jobs.NewJob(JobTypeRestart, request.Name))
 					} else if err == nil {
 						libDatabox.Err("Restart command received invalid JSON request.name is blank")
 					} else {
@@ -208,25 +549,48 @@ func processAPICommands(cm *ContainerManager) {
 					}
 				}
 				if ObserveResponse.Key == "uninstall" {
-					var request uninstallRequest
+					var request api.UninstallRequest
 					err := json.Unmarshal(ObserveResponse.Data, &request)
 					libDatabox.ChkErr(err)
 					libDatabox.Debug("ObserveResponse data = " + string(ObserveResponse.Data))
 					libDatabox.Debug("request.Name data = " + request.Name)
 					if err == nil && request.Name != "" {
-						go cm.Uninstall(request.Name)
+						job := cm.Jobs.NewJob(JobTypeUninstall, request.Name)
+						if _, err := cm.checkDependents(request.Name, request.Force); err != nil {
+							libDatabox.Err("Uninstall command refused " + err.Error() + ". Set force in the request to uninstall anyway")
+							job.Fail(err)
+						} else {
+							go cm.Uninstall(request.Name, request.Force, job)
+						}
 					} else if err == nil {
 						libDatabox.Err("Uninstall command received invalid JSON request.name is blank")
 					} else {
 						libDatabox.Err("Uninstall command received invalid JSON " + err.Error())
 					}
 				}
+				if ObserveResponse.Key == "stop" || ObserveResponse.Key == "start" {
+					var request api.NameRequest
+					err := json.Unmarshal(ObserveResponse.Data, &request)
+					libDatabox.ChkErr(err)
+					libDatabox.Debug("ObserveResponse data = " + string(ObserveResponse.Data))
+					if err == nil && request.Name != "" {
+						if ObserveResponse.Key == "stop" {
+							go cm.StopService(request.Name, cm.Jobs.NewJob(JobTypeStop, request.Name))
+						} else {
+							go cm.StartService(request.Name, cm.Jobs.NewJob(JobTypeStart, request.Name))
+						}
+					} else if err == nil {
+						libDatabox.Err(ObserveResponse.Key + " command received invalid JSON request.name is blank")
+					} else {
+						libDatabox.Err(ObserveResponse.Key + " command received invalid JSON " + err.Error())
+					}
+				}
 			}
 		}
 	}
 }
 
-func convertManifestToSLA(ir installRequest) libDatabox.SLA {
+func convertManifestToSLA(ir api.InstallRequest) libDatabox.SLA {
 
 	sla := libDatabox.SLA{
 		Name:                 ir.Manifest.Name,
@@ -246,6 +610,23 @@ func convertManifestToSLA(ir installRequest) libDatabox.SLA {
 	return sla
 }
 
+// dashboardInfo is what the mobile app needs to connect, it is encoded in the dashboard QR code
+func (cm ContainerManager) dashboardInfo() (api.DashboardInfo, error) {
+
+	info := api.DashboardInfo{
+		IP:         cm.Options.InternalIPs[0],
+		IPs:        cm.Options.InternalIPs,
+		IPExternal: cm.Options.ExternalIP,
+		Hostname:   cm.Options.Hostname,
+	}
+	if password := cm.Credentials.Plain(); password != "" {
+		info.Token = "Token=" + password
+	} else {
+		info.PasswordRequired = true
+	}
+	return info, nil
+}
+
 func populateMobileAppQrCodeAndCerts(cm *ContainerManager) {
 
 	//Make the public key available
@@ -266,23 +647,11 @@ func populateMobileAppQrCodeAndCerts(cm *ContainerManager) {
 	}
 
 	//make the config qr-code  available
-	type qrData struct {
-		IP         string   `json:"ip"`
-		IPs        []string `json:"ips"`
-		IPExternal string   `json:"ipExternal"`
-		Hostname   string   `json:hostname`
-		Token      string   `json:"token"`
-	}
-
-	password, err := cm.Store.LoadPassword()
+	data, err := cm.dashboardInfo()
 	libDatabox.ChkErr(err)
-
-	data := qrData{
-		IP:         cm.Options.InternalIPs[0],
-		IPs:        cm.Options.InternalIPs,
-		IPExternal: cm.Options.ExternalIP,
-		Hostname:   cm.Options.Hostname,
-		Token:      "Token=" + password,
+	if data.PasswordRequired {
+		libDatabox.Warn("The dashboard QR code does not hold the password as only its hash is kept. " +
+			"Enter the password in the mobile app after scanning it or use the QR code from databox-ctl dashboard")
 	}
 
 	json, err := json.Marshal(data)
@@ -301,70 +670,21 @@ func populateMobileAppQrCodeAndCerts(cm *ContainerManager) {
 	libDatabox.ChkErr(err)
 }
 
+// populateServiceStatus writes the state of every service to the data datasource
+// each time the status cache changes
 func populateServiceStatus(cm *ContainerManager) {
 
-	//reset the list after restart
-	cm.CmgrStoreClient.KVJSON.Write("data", "containerStatus", []byte("{}"))
-
-	type listResult struct {
-		Name         string          `json:"name"`
-		Type         string          `json:"type"`
-		DesiredState swarm.TaskState `json:"desiredState"`
-		State        swarm.TaskState `json:"state"`
-		Status       swarm.TaskState `json:"status"`
-	}
-
+	changes := cm.Status.Subscribe()
 	for {
-		//libDatabox.Debug("[populateServiceStatus] Updating ...")
-		services, _ := cm.cli.ServiceList(context.Background(), types.ServiceListOptions{})
-		res := []listResult{}
-		for _, service := range services {
-
-			_, exists := service.Spec.Labels["databox.type"]
-			if exists == false {
-				//its not a databox service
-				continue
-			}
-
-			lr := listResult{
-				Name: service.Spec.Name,
-				Type: service.Spec.Labels["databox.type"],
-			}
-
-			taskFilters := filters.NewArgs()
-			taskFilters.Add("service", service.Spec.Name)
-			tasks, _ := cm.cli.TaskList(context.Background(), types.TaskListOptions{
-				Filters: taskFilters,
-			})
-			if len(tasks) > 0 {
-				latestTasks := tasks[0]
-				latestTime := latestTasks.UpdatedAt
-
-				for _, t := range tasks {
-					if t.UpdatedAt.After(latestTime) {
-						latestTasks = t
-						latestTime = latestTasks.UpdatedAt
-					}
-				}
-
-				lr.DesiredState = latestTasks.DesiredState
-				lr.State = latestTasks.Status.State
-				lr.Status = latestTasks.Status.State
-			}
-
-			res = append(res, lr)
-		}
-
-		jsonString, err := json.Marshal(res)
+		jsonString, err := json.Marshal(cm.serviceStatus())
 		if err != nil {
-			libDatabox.Err("[populateDataSources] Error " + err.Error())
+			libDatabox.Err("[populateServiceStatus] Error " + err.Error())
 		}
 		err = cm.CmgrStoreClient.KVJSON.Write("data", "containerStatus", jsonString)
 		if err != nil {
-			libDatabox.Err("[populateDataSources] Error " + err.Error())
+			libDatabox.Err("[populateServiceStatus] Error " + err.Error())
 		}
-		//sleep for a bit
-		time.Sleep(time.Second * 1)
+		<-changes
 	}
 }
 
@@ -372,37 +692,25 @@ func populateDataSources(cm *ContainerManager) {
 	//reset the list after restart
 	cm.CmgrStoreClient.KVJSON.Write("data", "dataSources", []byte("{}"))
 
+	version := -1
 	for {
 		//libDatabox.Debug("[populateDataSources] Updating ...")
-		hyperCatRoot, err := cm.ArbiterClient.GetRootDataSourceCatalogue()
+		report, err := cm.Catalogue.Report(catalogueFilter{})
 		if err != nil {
-			libDatabox.Err("[populateDataSources] GetRootDataSourceCatalogue " + err.Error())
+			libDatabox.Err("[populateDataSources] " + err.Error())
 		}
-		var datasources []libDatabox.HypercatItem
-		for _, item := range hyperCatRoot.Items {
-			if strings.Contains(item.Href, "-core-store:5555") == false {
-				//TODO Tell john this should not be here !!
-				continue
-			}
-			//get the store cat
-			storeURL, _ := libDatabox.GetStoreURLFromDsHref(item.Href)
-			sc := libDatabox.NewCoreStoreClient(cm.ArbiterClient, "/run/secrets/ZMQ_PUBLIC_KEY", storeURL, false)
-			storeCat, err := sc.GetStoreDataSourceCatalogue(item.Href)
+		if err == nil && report.Version != version {
+			jsonString, err := json.Marshal(report.Datasources)
 			if err != nil {
-				libDatabox.Warn("[populateDataSources] GetStoreDataSourceCatalogue item.Href=" + item.Href + " storeURL=" + storeURL + " " + err.Error())
+				libDatabox.Err("[populateDataSources] Error " + err.Error())
 			}
-			for _, ds := range storeCat.Items {
-				datasources = append(datasources, ds)
+			err = cm.CmgrStoreClient.KVJSON.Write("data", "dataSources", jsonString)
+			if err != nil {
+				libDatabox.Err("[populateDataSources] Error " + err.Error())
+			} else {
+				version = report.Version
 			}
 		}
-		jsonString, err := json.Marshal(datasources)
-		if err != nil {
-			libDatabox.Err("[populateDataSources] Error " + err.Error())
-		}
-		err = cm.CmgrStoreClient.KVJSON.Write("data", "dataSources", jsonString)
-		if err != nil {
-			libDatabox.Err("[populateDataSources] Error " + err.Error())
-		}
 		//sleep for a bit
 		time.Sleep(time.Second * 15)
 	}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/me-box/core-container-manager/api"
+)
+
+// client talks to the REST API of the container manager
+type client struct {
+	host     string
+	user     string
+	password string
+	tls      *tls.Config
+	http     *http.Client
+}
+
+// newClient returns a client for the container manager at host trusting only the CA in caPath.
+// If user is blank it logs in as the built-in admin.
+func newClient(host string, caPath string, user string, password string) (*client, error) {
+
+	caCert, err := ioutil.ReadFile(caPath)
+	if err != nil {
+		return nil, errors.New("Can't read the container manager CA " + err.Error())
+	}
+	roots := x509.NewCertPool()
+	if !roots.AppendCertsFromPEM(caCert) {
+		return nil, errors.New("No certificates found in " + caPath)
+	}
+
+	tlsConfig := &tls.Config{RootCAs: roots}
+	return &client{
+		host:     host,
+		user:     user,
+		password: password,
+		tls:      tlsConfig,
+		http: &http.Client{
+			Timeout:   30 * time.Second,
+			Transport: &http.Transport{TLSClientConfig: tlsConfig},
+		},
+	}, nil
+}
+
+// do sends body as JSON to the API path and decodes the reply into res if it is not nil
+func (c *client) do(method string, path string, body interface{}, res interface{}) error {
+
+	var reqBody []byte
+	if body != nil {
+		var err error
+		reqBody, err = json.Marshal(body)
+		if err != nil {
+			return err
+		}
+	}
+
+	req, err := http.NewRequest(method, "https://"+c.host+"/api/v1"+path, bytes.NewReader(reqBody))
+	if err != nil {
+		return err
+	}
+	c.setAuth(req.Header)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	payload, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode >= 300 {
+		var apiErr api.Error
+		if json.Unmarshal(payload, &apiErr) != nil || apiErr.Error == "" {
+			return errors.New(resp.Status)
+		}
+		msg := apiErr.Error
+		if len(apiErr.Dependents) > 0 {
+			msg = msg + " (used by " + strings.Join(apiErr.Dependents, ", ") + ")"
+		}
+		return errors.New(msg)
+	}
+
+	if res == nil {
+		return nil
+	}
+	if raw, ok := res.(*[]byte); ok {
+		*raw = payload
+		return nil
+	}
+	return json.Unmarshal(payload, res)
+}
+
+// setAuth adds the credentials of c to header
+func (c *client) setAuth(header http.Header) {
+	if c.user == "" {
+		header.Set("Authorization", "Token "+c.password)
+		return
+	}
+	req := http.Request{Header: header}
+	req.SetBasicAuth(c.user, c.password)
+}
+
+// startJob sends a request that starts a job and returns the job
+func (c *client) startJob(path string, body interface{}) (api.Job, error) {
+	var accepted api.JobAccepted
+	err := c.do("POST", path, body, &accepted)
+	return accepted.Job, err
+}
+
+// waitJob polls j until it has finished, calling progress each time its state changes
+func (c *client) waitJob(j api.Job, progress func(api.Job)) (api.Job, error) {
+
+	var lastState api.JobState
+	for {
+		if j.State != lastState {
+			progress(j)
+			lastState = j.State
+		}
+		if j.Finished() {
+			return j, nil
+		}
+
+		time.Sleep(time.Second)
+		err := c.do("GET", "/jobs/"+url.PathEscape(j.ID), nil, &j)
+		if err != nil {
+			return j, err
+		}
+	}
+}
+
+// followLogs streams the log of name calling emit for each line until the log ends
+func (c *client) followLogs(name string, lines int, emit func(api.LogLine)) error {
+
+	dialer := &websocket.Dialer{
+		TLSClientConfig:  c.tls,
+		HandshakeTimeout: 10 * time.Second,
+	}
+	header := http.Header{}
+	c.setAuth(header)
+
+	logsURL := "wss://" + c.host + "/api/v1/components/" + url.PathEscape(name) + "/logs?lines=" + strconv.Itoa(lines)
+	conn, resp, err := dialer.Dial(logsURL, header)
+	if err != nil {
+		if resp != nil {
+			return errors.New("Can't follow the log of " + name + " " + resp.Status)
+		}
+		return err
+	}
+	defer conn.Close()
+
+	for {
+		var l api.LogLine
+		err := conn.ReadJSON(&l)
+		if err != nil {
+			if websocket.IsCloseError(err, websocket.CloseNormalClosure) {
+				return nil
+			}
+			return err
+		}
+		emit(l)
+	}
+}
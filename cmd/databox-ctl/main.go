@@ -0,0 +1,313 @@
+// databox-ctl is a command line client for the container manager REST API
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/me-box/core-container-manager/api"
+	libDatabox "github.com/me-box/lib-go-databox"
+	qrcode "github.com/skip2/go-qrcode"
+)
+
+const usage = `usage: databox-ctl [options] <command> [arguments]
+
+commands:
+  list                       list components and their states
+  install [-nowait] <file>   install the app or driver in a manifest file
+  uninstall [-force] <name>  uninstall an app or driver
+  restart <name>             restart an app or driver
+  logs [-n lines] [-f] [-t] <name>
+                             print the log of a component, -f follows it
+  dashboard                  print the dashboard address, password and QR code
+
+options:
+`
+
+func main() {
+
+	flag.Usage = func() {
+		fmt.Fprint(os.Stderr, usage)
+		flag.PrintDefaults()
+	}
+	host := flag.String("host", envOr("DATABOX_HOST", "localhost"), "container manager host, or set DATABOX_HOST")
+	caPath := flag.String("ca", envOr("DATABOX_CA", "/certs/containerManagerPub.crt"), "container manager CA certificate, or set DATABOX_CA")
+	user := flag.String("user", envOr("DATABOX_USER", ""), "dashboard user, the built-in admin if blank, or set DATABOX_USER")
+	password := flag.String("password", "", "dashboard password, or set DATABOX_PASSWORD")
+	flag.Parse()
+
+	if *password == "" {
+		*password = os.Getenv("DATABOX_PASSWORD")
+	}
+	if flag.NArg() < 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	c, err := newClient(*host, *caPath, *user, *password)
+	if err != nil {
+		fail(err)
+	}
+
+	cmd, args := flag.Arg(0), flag.Args()[1:]
+	switch cmd {
+	case "list", "ls":
+		err = list(c)
+	case "install":
+		err = install(c, args)
+	case "uninstall":
+		err = uninstall(c, args)
+	case "restart":
+		err = restart(c, args)
+	case "logs":
+		err = logs(c, args)
+	case "dashboard":
+		err = dashboard(c)
+	default:
+		fmt.Fprintln(os.Stderr, "databox-ctl: unknown command "+cmd)
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	if err != nil {
+		fail(err)
+	}
+}
+
+func envOr(name string, def string) string {
+	if v := os.Getenv(name); v != "" {
+		return v
+	}
+	return def
+}
+
+func fail(err error) {
+	fmt.Fprintln(os.Stderr, "databox-ctl: "+err.Error())
+	os.Exit(1)
+}
+
+// oneName parses a subcommand that takes flags and exactly one argument
+func oneName(fs *flag.FlagSet, args []string, what string) (string, error) {
+	err := fs.Parse(args)
+	if err != nil {
+		return "", err
+	}
+	if fs.NArg() != 1 {
+		return "", errors.New(fs.Name() + " needs a " + what)
+	}
+	return fs.Arg(0), nil
+}
+
+func list(c *client) error {
+
+	var components []api.ComponentStatus
+	err := c.do("GET", "/components", nil, &components)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tTYPE\tSTATE")
+	for _, comp := range components {
+		fmt.Fprintln(w, comp.Name+"\t"+comp.Type+"\t"+string(comp.Status))
+	}
+	return w.Flush()
+}
+
+func install(c *client, args []string) error {
+
+	fs := flag.NewFlagSet("install", flag.ExitOnError)
+	noWait := fs.Bool("nowait", false, "return once the install has started")
+	file, err := oneName(fs, args, "manifest file")
+	if err != nil {
+		return err
+	}
+
+	payload, err := ioutil.ReadFile(file)
+	if err != nil {
+		return err
+	}
+	var manifest libDatabox.Manifest
+	err = json.Unmarshal(payload, &manifest)
+	if err != nil {
+		return errors.New(file + " is not a valid manifest " + err.Error())
+	}
+
+	j, err := c.startJob("/install", api.InstallRequest{Manifest: manifest})
+	if err != nil {
+		return err
+	}
+	return waitFor(c, j, *noWait)
+}
+
+func uninstall(c *client, args []string) error {
+
+	fs := flag.NewFlagSet("uninstall", flag.ExitOnError)
+	force := fs.Bool("force", false, "uninstall even if other components use its datasources")
+	name, err := oneName(fs, args, "component name")
+	if err != nil {
+		return err
+	}
+
+	j, err := c.startJob("/uninstall", api.UninstallRequest{Name: name, Force: *force})
+	if err != nil {
+		return err
+	}
+	return waitFor(c, j, false)
+}
+
+func restart(c *client, args []string) error {
+
+	fs := flag.NewFlagSet("restart", flag.ExitOnError)
+	name, err := oneName(fs, args, "component name")
+	if err != nil {
+		return err
+	}
+
+	j, err := c.startJob("/restart", api.NameRequest{Name: name})
+	if err != nil {
+		return err
+	}
+	return waitFor(c, j, false)
+}
+
+// waitFor prints the progress of j until it finishes and returns its error if it failed
+func waitFor(c *client, j api.Job, noWait bool) error {
+
+	if noWait {
+		fmt.Println(string(j.Type) + " " + j.Name + " started, job " + j.ID)
+		return nil
+	}
+
+	j, err := c.waitJob(j, func(j api.Job) {
+		fmt.Println(j.Name + ": " + string(j.State))
+	})
+	if err != nil {
+		return err
+	}
+	if j.State == api.JobStateFailed || j.State == api.JobStateBlocked {
+		return errors.New(string(j.Type) + " " + j.Name + " " + string(j.State) + " " + j.Error)
+	}
+	return nil
+}
+
+func logs(c *client, args []string) error {
+
+	fs := flag.NewFlagSet("logs", flag.ExitOnError)
+	lines := fs.Int("n", 100, "number of lines to show")
+	follow := fs.Bool("f", false, "follow the log")
+	timestamps := fs.Bool("t", false, "show timestamps")
+	name, err := oneName(fs, args, "component name")
+	if err != nil {
+		return err
+	}
+
+	printLine := func(l api.LogLine) {
+		if *timestamps {
+			fmt.Println(l.Time.Format(time.RFC3339Nano) + " " + l.Line)
+			return
+		}
+		fmt.Println(l.Line)
+	}
+
+	if *follow {
+		return c.followLogs(name, *lines, printLine)
+	}
+
+	var logLines []api.LogLine
+	err = c.do("GET", "/components/"+url.PathEscape(name)+"/logs?lines="+strconv.Itoa(*lines), nil, &logLines)
+	if err != nil {
+		return err
+	}
+	for _, l := range logLines {
+		printLine(l)
+	}
+	return nil
+}
+
+func dashboard(c *client) error {
+
+	var raw []byte
+	err := c.do("GET", "/dashboard", nil, &raw)
+	if err != nil {
+		return err
+	}
+	var info api.DashboardInfo
+	err = json.Unmarshal(raw, &info)
+	if err != nil {
+		return err
+	}
+
+	fmt.Println("Dashboard: https://" + info.IP)
+	if info.IPExternal != "" && info.IPExternal != info.IP {
+		fmt.Println("External:  https://" + info.IPExternal)
+	}
+	switch {
+	case info.Token != "":
+		fmt.Println("Password:  " + strings.TrimPrefix(info.Token, "Token="))
+	case (c.user == "" || c.user == "admin") && c.password != "":
+		//the container manager only keeps a hash, use the password we logged in with
+		raw, err = withToken(raw, "Token="+c.password)
+		if err != nil {
+			return err
+		}
+		fmt.Println("Password:  the one given to databox-ctl, the QR code holds it")
+	default:
+		fmt.Println("Password:  not known, only its hash is kept. Enter it in the mobile app after scanning")
+	}
+	fmt.Println()
+
+	//the same JSON the dashboard QR code holds so the mobile app can scan either
+	qr, err := qrcode.New(string(raw), qrcode.Medium)
+	if err != nil {
+		return err
+	}
+	fmt.Print(qrString(qr.Bitmap()))
+	return nil
+}
+
+// withToken sets the token of the dashboard JSON raw keeping every other field
+func withToken(raw []byte, token string) ([]byte, error) {
+	var fields map[string]interface{}
+	err := json.Unmarshal(raw, &fields)
+	if err != nil {
+		return nil, err
+	}
+	fields["token"] = token
+	delete(fields, "passwordRequired")
+	return json.Marshal(fields)
+}
+
+// qrString draws bitmap with half blocks, two rows per line. Light modules are drawn
+// so it scans on a terminal with a dark background.
+func qrString(bitmap [][]bool) string {
+
+	var b strings.Builder
+	for y := 0; y < len(bitmap); y += 2 {
+		for x := range bitmap[y] {
+			top := !bitmap[y][x]
+			bottom := y+1 < len(bitmap) && !bitmap[y+1][x]
+			switch {
+			case top && bottom:
+				b.WriteString("█")
+			case top:
+				b.WriteString("▀")
+			case bottom:
+				b.WriteString("▄")
+			default:
+				b.WriteString(" ")
+			}
+		}
+		b.WriteString("\n")
+	}
+	return b.String()
+}
@@ -19,14 +19,21 @@ import (
 	"github.com/docker/docker/api/types/filters"
 	"github.com/docker/docker/api/types/mount"
 	"github.com/docker/docker/api/types/swarm"
-	"github.com/docker/docker/client"
-
-	"crypto/rand"
 )
 
+// Arbiter is the part of the arbiter API used to register components and their permissions.
+// A libDatabox.ArbiterClient satisfies it.
+type Arbiter interface {
+	RegesterDataboxComponent(name string, token string, databoxType libDatabox.DataboxType) error
+	RemoveDataboxComponent(name string) error
+	GrantContainerPermissions(permissions libDatabox.ContainerPermissions) error
+	RevokeContainerPermissions(permissions libDatabox.ContainerPermissions) error
+}
+
 type ContainerManager struct {
-	cli                 *client.Client
+	cli                 Orchestrator
 	ArbiterClient       *libDatabox.ArbiterClient
+	Arbiter             Arbiter
 	CoreNetworkClient   *CoreNetworkClient
 	CmgrStoreClient     *libDatabox.CoreStoreClient
 	Request             *http.Client
@@ -38,26 +45,45 @@ type ContainerManager struct {
 	cmStoreURL          string
 	Store               *CMStore
 	Options             *libDatabox.ContainerManagerOptions
+	Resources           ResourceOptions
+	Readiness           ReadinessOptions
+	Stats               StatsOptions
+	Sessions            SessionOptions
+	Login               LoginOptions
 	AppStoreName        string
 	CoreIUName          string
 	CoreStoreName       string
-	InstalledComponents map[string]string
+	InstalledComponents *InstalledSet
+	Jobs                *JobTracker
+	Crashes             *CrashTracker
+	Journal             *Journal
+	Usage               *StatsSampler
+	Status              *StatusCache
+	Catalogue           *CatalogueAggregator
+	Stopped             *StopTracker
+	Restore             *RestoreArchive
+	ActiveSessions      *SessionManager
+	Credentials         *Credentials
+	Users               *UserStore
+	Logins              *LoginGuard
+	Certs               *CertTracker
+	Updating            *UpdateTracker
+	Budget              *ResourceBudget
 }
 
 // New returns a configured ContainerManager
-func NewContainerManager(rootCASecretId string, zmqPublicId string, zmqPrivateId string, opt *libDatabox.ContainerManagerOptions) ContainerManager {
-
-	cli, _ := client.NewEnvClient()
+func NewContainerManager(cli Orchestrator, rootCASecretId string, zmqPublicId string, zmqPrivateId string, opt *libDatabox.ContainerManagerOptions, cmOpt CMOptions) ContainerManager {
 
 	request := libDatabox.NewDataboxHTTPsAPIWithPaths("/certs/containerManager.crt")
 	ac, err := libDatabox.NewArbiterClient("/certs/arbiterToken-container-manager", "/run/secrets/ZMQ_PUBLIC_KEY", "tcp://arbiter:4444")
 	libDatabox.ChkErr(err)
 
-	cnc := NewCoreNetworkClient("/certs/arbiterToken-databox-network", request)
+	cnc := NewCoreNetworkClient(cli, "/certs/arbiterToken-databox-network", request)
 
 	cm := ContainerManager{
 		cli:                 cli,
 		ArbiterClient:       ac,
+		Arbiter:             ac,
 		CoreNetworkClient:   cnc,
 		Request:             request,
 		DATABOX_DNS_IP:      os.Getenv("DATABOX_DNS_IP"),
@@ -65,10 +91,20 @@ func NewContainerManager(rootCASecretId string, zmqPublicId string, zmqPrivateId
 		ZMQ_PUBLIC_KEY_ID:   zmqPublicId,
 		ZMQ_PRIVATE_KEY_ID:  zmqPrivateId,
 		Options:             opt,
+		Resources:           cmOpt.ResourceOptions,
+		Readiness:           cmOpt.ReadinessOptions,
+		Stats:               cmOpt.StatsOptions,
+		Sessions:            cmOpt.SessionOptions,
+		Login:               cmOpt.LoginOptions,
 		AppStoreName:        "app-store",
 		CoreIUName:          "core-ui",
 		CoreStoreName:       "core-store",
-		InstalledComponents: make(map[string]string),
+		InstalledComponents: NewInstalledSet(),
+		Crashes:             NewCrashTracker(nil),
+		Catalogue:           NewCatalogueAggregator(ac),
+		Certs:               NewCertTracker(cmOpt.CertOptions),
+		Updating:            NewUpdateTracker(),
+		Budget:              NewResourceBudget(),
 	}
 
 	if opt.Arch != "" {
@@ -102,6 +138,12 @@ func (cm ContainerManager) Start() {
 	if err != nil {
 		libDatabox.Err("Filed to register the cm with the arbiter. " + err.Error())
 	}
+	//put back the store volumes from a backup before any store is launched
+	var restoreVolumesErr error
+	if cm.Restore != nil {
+		restoreVolumesErr = cm.restoreVolumes(cm.Restore)
+	}
+
 	//launch the CM store
 	cm.cmStoreURL = cm.launchCMStore()
 
@@ -111,6 +153,19 @@ func (cm ContainerManager) Start() {
 	//setup the cmStore
 	cm.Store = NewCMStore(cm.CmgrStoreClient)
 
+	//track install, uninstall and restart progress in the data datasource
+	cm.Jobs = NewJobTracker(cm.CmgrStoreClient)
+
+	//keep a history of what happens to each component
+	cm.Journal = NewJournal(cm.CmgrStoreClient)
+
+	//keep track of the state of every service from docker events
+	cm.Status = NewStatusCache(cm.cli)
+	go cm.Status.run()
+
+	//record how much cpu, memory and network each component uses
+	cm.Usage = NewStatsSampler(cm.CmgrStoreClient, cm.Stats.StatsRetention)
+
 	//clear the saved slas if needed
 	if cm.Options.ClearSLAs && err == nil {
 		libDatabox.Info("Clearing SLA database to remove saved apps and drivers")
@@ -118,24 +173,43 @@ func (cm ContainerManager) Start() {
 		libDatabox.ChkErr(err)
 	}
 
-	//Load the password from the store or create a new one
-	password := ""
-	if cm.Options.OverridePasword != "" {
-		libDatabox.Warn("OverridePasword used!")
-		password = cm.Options.OverridePasword
-	} else {
-		libDatabox.Debug("Getting password from DB")
-		password, err = cm.Store.LoadPassword()
-		libDatabox.ChkErr(err)
-		if password == "" {
-			libDatabox.Debug("Password not set genorating one")
-			password = cm.genoratePassword()
-			libDatabox.Debug("Saving new Password")
-			err := cm.Store.SavePassword(password)
-			libDatabox.ChkErr(err)
+	//put back the saved apps and drivers from a backup so reloadApps starts them
+	if cm.Restore != nil {
+		err := cm.restoreSaved(cm.Restore)
+		if err != nil {
+			//the archive is not marked as restored so it is tried again on the next start
+			libDatabox.ChkErrFatal(errors.New("Restoring backup " + cm.Restore.Path + " failed, the saved apps and drivers were not restored " + err.Error()))
+		}
+		if restoreVolumesErr != nil {
+			libDatabox.Err("Restoring backup " + cm.Restore.Path + " did not restore every store volume, it will be tried again on the next start " +
+				"for stores that are not running. " + restoreVolumesErr.Error())
+			cm.Journal.RecordErr(EventRestoreFailed, "container-manager", restoreVolumesErr, nil)
+			cm.Restore.Close()
+		} else {
+			cm.Restore.finish()
 		}
 	}
-	libDatabox.Info("Password=" + password)
+
+	//remember which components the user has stopped
+	cm.Stopped = NewStopTracker(cm.Store)
+
+	//and which have been quarantined for crashing
+	cm.Crashes = NewCrashTracker(cm.Store)
+
+	//Load the password hash from the store or create a new password
+	cm.Credentials, err = NewCredentials(cm.Store, cm.Options.OverridePasword)
+	libDatabox.ChkErrFatal(err)
+
+	//dashboard accounts other than the built-in admin
+	cm.Users = NewUserStore(cm.Store, cm.Credentials)
+
+	//dashboard logins, kept in the CM store if Sessions.PersistSessions is set
+	cm.ActiveSessions = NewSessionManager(cm.Sessions, cm.Store)
+	go cm.ActiveSessions.run()
+
+	//failed login tracking and lockouts
+	cm.Logins = NewLoginGuard(cm.Login)
+	go cm.Logins.run()
 
 	//expose the CM API through the store before starting the UI
 	go CmZestAPI(&cm)
@@ -146,7 +220,7 @@ func (cm ContainerManager) Start() {
 
 	//start the webUI
 	go ServeInsecure()
-	go ServeSecure(&cm, password)
+	go ServeSecure(&cm)
 
 	libDatabox.Info("Container Manager Ready and waiting")
 
@@ -157,6 +231,12 @@ func (cm ContainerManager) Start() {
 	//start crash detectore
 	go cm.crashDetectore()
 
+	//start sampling resource usage
+	go cm.sampleStats()
+
+	//re-issue certificates before they expire
+	go cm.renewCertificates()
+
 }
 
 //Monitor docker events for crashed apps and drivers
@@ -194,13 +274,15 @@ func (cm ContainerManager) crashDetectore() {
 				} else if _, ok := uninstallDetected[serviceID]; ok { //is it being uninstall?
 					delete(uninstallDetected, serviceID)
 					libDatabox.Debug("Not restarting " + name + " this time uninstall detected")
+				} else if _, ok := cm.Stopped.Stopped(name); ok { //has it been stopped?
+					libDatabox.Debug("Not restarting " + name + " it has been stopped")
+				} else if cm.Updating.Updating(name) { //is its task being replaced?
+					libDatabox.Debug("Not restarting " + name + " its tasks are being updated")
 				} else if _, ok := msg.Actor.Attributes["databox.type"]; !ok {
 					//Its not a databox app or driver do nothing
 					libDatabox.Debug("Not restarting " + name + " its not a databox app or driver")
 				} else { //looks looks a crash restart it
-					libDatabox.Warn("Crash detected for " + name)
-					libDatabox.Warn("Restarting " + name)
-					cm.Restart(name)
+					cm.handleCrash(name, msg.Actor.ID, msg.Actor.Attributes["exitCode"])
 				}
 
 			}
@@ -209,14 +291,69 @@ func (cm ContainerManager) crashDetectore() {
 
 }
 
+// Install validates and starts a new app or driver saving its SLA and the
+// resource limits it asked for so it is restarted with them after a reboot.
+// Progress is reported through job which may be nil if the install is not being tracked.
+func (cm ContainerManager) Install(sla libDatabox.SLA, limits ResourceLimits, job *Job) error {
+
+	validation := cm.ValidateSLA(sla)
+	validateResources(limits, &validation)
+	if !validation.Valid {
+		job.Fail(validation.Err())
+		return validation.Err()
+	}
+
+	//do not replace the limits of a running copy
+	if _, err := cm.serviceByName(sla.Name); err == nil {
+		err = errors.New("Can't install " + sla.Name + " it is already installed")
+		job.Fail(err)
+		return err
+	}
+
+	err := cm.Store.SaveResources(sla.Name, limits)
+	if err != nil {
+		err = errors.New("Can't install " + sla.Name + " failed to save its resource limits " + err.Error())
+		job.Fail(err)
+		return err
+	}
+
+	err = cm.LaunchFromSLA(sla, true, job)
+	if err != nil {
+		cm.Store.DeleteResources(sla.Name)
+	}
+	return err
+}
+
 // LaunchFromSLA will start a databox app or driver with the reliant stores and grant permissions required as described in the SLA
-func (cm ContainerManager) LaunchFromSLA(sla libDatabox.SLA, save bool) error {
+// Progress is reported through job which may be nil if the install is not being tracked.
+func (cm ContainerManager) LaunchFromSLA(sla libDatabox.SLA, save bool, job *Job) error {
+
+	//save is only set for new installs, everything else is a restart of a saved or system component
+	okEvent, failedEvent := EventStarted, EventStartFailed
+	if save {
+		okEvent, failedEvent = EventInstalled, EventInstallFailed
+	}
+
+	err := cm.launchFromSLA(sla, save, job)
+	if err != nil {
+		job.Fail(err)
+		cm.Journal.RecordErr(failedEvent, sla.Name, err, job)
+		return err
+	}
+
+	job.SetState(JobStateRunning)
+	cm.Journal.Record(okEvent, sla.Name, cm.calculateImageNameFromSLA(sla), job)
+	return nil
+}
+
+// launchFromSLA does the work for LaunchFromSLA. Each side effect is recorded as it is
+// made and if a later step fails they are undone in reverse order.
+func (cm ContainerManager) launchFromSLA(sla libDatabox.SLA, save bool, job *Job) (err error) {
 
 	//Make the localContainerName
 	localContainerName := sla.Name
 
 	//Make the requiredStoreName if needed
-	//TODO check store is supported!!
 	requiredStoreName := ""
 	if sla.ResourceRequirements.Store != "" {
 		requiredStoreName = sla.Name + "-" + sla.ResourceRequirements.Store
@@ -224,10 +361,217 @@ func (cm ContainerManager) LaunchFromSLA(sla libDatabox.SLA, save bool) error {
 
 	libDatabox.Info("Installing " + localContainerName)
 
+	//rolling back would break the running copy so stop now
+	if _, serr := cm.serviceByName(localContainerName); serr == nil {
+		return errors.New("Can't install " + localContainerName + " it is already installed")
+	}
+
+	tx := newInstallTransaction(localContainerName)
+	defer func() {
+		if err != nil {
+			tx.rollback()
+		}
+	}()
+
+	//Get the image first there is no point setting anything else up if its missing
+	job.SetState(JobStatePulling)
+	imageName := cm.calculateImageNameFromSLA(sla)
+	pullImageIfRequired(cm.cli, imageName, cm.Options.DefaultRegistry, cm.Options.DefaultRegistryHost)
+
+	//Check image is available and make some noise if its missing !!!
+	if !cm.imageExists(imageName) {
+		return errors.New("Can't install " + localContainerName + " cant find the image " + imageName)
+	}
+
 	//Create the networks and attach to the core-network.
-	netConf := cm.CoreNetworkClient.PreConfig(localContainerName, sla)
+	job.SetState(JobStateNetworking)
+	netConf, createdNetwork := cm.CoreNetworkClient.PreConfig(localContainerName, sla)
+	if createdNetwork {
+		tx.onRollback("remove network "+netConf.NetworkName, func() error {
+			return cm.CoreNetworkClient.RemoveNetwork(netConf.NetworkName)
+		})
+	}
 
 	//start the container
+	service, serviceOptions, requiredNetworks, err := cm.serviceSpecFromSLA(sla, netConf)
+	if err != nil {
+		return err
+	}
+
+	if !cm.isSystemComponent(localContainerName) {
+		var release func()
+		release, err = cm.checkResourceBudget(localContainerName, service.TaskTemplate.Resources)
+		if err != nil {
+			return err
+		}
+		defer release()
+	}
+
+	//Add secrests to container
+	service.TaskTemplate.ContainerSpec.Secrets = cm.genorateSecrets(localContainerName, sla.DataboxType)
+	tx.onRollback("remove secrets and arbiter registration", func() error {
+		return cm.removeGenoratedSecrets(localContainerName)
+	})
+
+	libDatabox.Debug("networksToConnect" + strings.Join(requiredNetworks, ","))
+	cm.CoreNetworkClient.ConnectEndpoints(localContainerName, requiredNetworks)
+	tx.onRollback("disconnect core-network endpoints", func() error {
+		return cm.CoreNetworkClient.DisconnectEndpoints(localContainerName, PostNetworkConfig{NetworkName: netConf.NetworkName})
+	})
+
+	//do this after the networks are configured
+	if requiredStoreName != "" {
+		job.SetState(JobStateStore)
+		_, serr := cm.serviceByName(requiredStoreName)
+		storeExisted := serr == nil
+		volumeExisted := cm.volumeExists(requiredStoreName)
+
+		cm.launchStore(sla.ResourceRequirements.Store, requiredStoreName, netConf)
+		if !storeExisted {
+			tx.onRollback("remove store "+requiredStoreName, func() error {
+				return cm.removeStore(requiredStoreName, !volumeExisted)
+			})
+		}
+		cm.grantStoreCatalogue(tx, requiredStoreName)
+
+		_, err = cm.WaitForService(requiredStoreName, 10)
+		if err != nil {
+			return errors.New("Can't install " + localContainerName + " its store did not start. " + err.Error())
+		}
+	}
+
+	job.SetState(JobStatePermissions)
+	cm.addPermissionsFromSLA(sla)
+	tx.onRollback("revoke permissions", func() error {
+		cm.revokePermissions(permissionsFromSLA(sla))
+		return nil
+	})
+
+	job.SetState(JobStateStarting)
+	_, err = cm.cli.ServiceCreate(context.Background(), service, serviceOptions)
+	if err != nil {
+		libDatabox.Err("[Error launching] " + localContainerName + " " + err.Error())
+		return err
+	}
+
+	if save {
+		//save the sla for persistence over restarts
+		serr := cm.Store.SaveSLA(sla)
+		libDatabox.ChkErr(serr)
+	}
+
+	//keep track of installed components
+	cm.InstalledComponents.add(localContainerName)
+
+	libDatabox.Info("Successfully installed " + sla.Name)
+
+	return nil
+}
+
+// grantStoreCatalogue lets the container manager read the catalogue of storeName, storeProbe
+// needs it to tell when the store is ready. It is revoked again if tx rolls back.
+func (cm ContainerManager) grantStoreCatalogue(tx *installTransaction, storeName string) {
+	perms := []libDatabox.ContainerPermissions{newPermission("container-manager", storeName, "/cat", "GET", "")}
+	cm.grantPermissions(perms)
+	tx.onRollback("revoke the catalogue permission on "+storeName, func() error {
+		cm.revokePermissions(perms)
+		return nil
+	})
+}
+
+// removeGenoratedSecrets removes the secrets made by genorateSecrets for containerName and its arbiter registration
+func (cm ContainerManager) removeGenoratedSecrets(containerName string) error {
+
+	var lastErr error
+	for _, name := range []string{strings.ToUpper(containerName) + ".pem", strings.ToUpper(containerName) + "_KEY"} {
+		secFilters := filters.NewArgs()
+		secFilters.Add("name", name)
+		secList, _ := cm.cli.SecretList(context.Background(), types.SecretListOptions{Filters: secFilters})
+		for _, sec := range secList {
+			if sec.Spec.Name != name {
+				continue
+			}
+			err := cm.cli.SecretRemove(context.Background(), sec.ID)
+			if err != nil {
+				lastErr = err
+			}
+		}
+	}
+
+	//and any renewed copies of its certificate
+	certFilters := filters.NewArgs()
+	certFilters.Add("label", certLabelComponent+"="+containerName)
+	certList, _ := cm.cli.SecretList(context.Background(), types.SecretListOptions{Filters: certFilters})
+	for _, sec := range certList {
+		err := cm.cli.SecretRemove(context.Background(), sec.ID)
+		if err != nil && !strings.Contains(err.Error(), "No such secret") {
+			lastErr = err
+		}
+	}
+
+	err := cm.Arbiter.RemoveDataboxComponent(containerName)
+	if err != nil {
+		lastErr = err
+	}
+
+	return lastErr
+}
+
+// removeStore removes a store service started by launchStore, its secrets and optionally its volume
+func (cm ContainerManager) removeStore(storeName string, removeVolume bool) error {
+
+	store, err := cm.serviceByName(storeName)
+	if err != nil {
+		return err
+	}
+
+	err = cm.cli.ServiceRemove(context.Background(), store.ID)
+	if err != nil {
+		return err
+	}
+
+	err = cm.removeGenoratedSecrets(storeName)
+	if err != nil {
+		return err
+	}
+
+	if !removeVolume {
+		return nil
+	}
+
+	//the volume stays in use until swarm has cleaned up the stores container
+	for i := 0; i < 10; i++ {
+		err = cm.cli.VolumeRemove(context.Background(), storeName, false)
+		if err == nil {
+			return nil
+		}
+		time.Sleep(time.Second)
+	}
+	return err
+}
+
+// volumeExists reports if there is a docker volume called name
+func (cm ContainerManager) volumeExists(name string) bool {
+	volFilters := filters.NewArgs()
+	volFilters.Add("name", name)
+	vols, err := cm.cli.VolumeList(context.Background(), volFilters)
+	if err != nil {
+		return false
+	}
+	for _, v := range vols.Volumes {
+		if v.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// serviceSpecFromSLA builds the swarm service for an app or driver without its secrets.
+// It also returns the names of the other components it needs to talk to on the core-network.
+func (cm ContainerManager) serviceSpecFromSLA(sla libDatabox.SLA, netConf NetworkConfig) (swarm.ServiceSpec, types.ServiceCreateOptions, []string, error) {
+
+	localContainerName := sla.Name
+
 	var service swarm.ServiceSpec
 	var serviceOptions types.ServiceCreateOptions
 	var requiredNetworks []string
@@ -237,14 +581,7 @@ func (cm ContainerManager) LaunchFromSLA(sla libDatabox.SLA, save bool) error {
 	case libDatabox.DataboxTypeDriver:
 		service, serviceOptions, requiredNetworks = cm.getDriverConfig(sla, localContainerName, netConf)
 	default:
-		return errors.New("[LaunchFromSLA] Unsupported image type")
-	}
-
-	pullImageIfRequired(service.TaskTemplate.ContainerSpec.Image, cm.Options.DefaultRegistry, cm.Options.DefaultRegistryHost)
-
-	//Check image is available and make some noise if its missing !!!
-	if !cm.imageExists(service.TaskTemplate.ContainerSpec.Image) {
-		return errors.New("Can't install " + localContainerName + " cant find the image " + service.TaskTemplate.ContainerSpec.Image)
+		return service, serviceOptions, requiredNetworks, errors.New("[LaunchFromSLA] Unsupported image type")
 	}
 
 	exits, devMount := cm.getDevMountFor(localContainerName)
@@ -254,7 +591,7 @@ func (cm ContainerManager) LaunchFromSLA(sla libDatabox.SLA, save bool) error {
 
 		//check path exists and error if its missing!
 		if _, err := os.Stat(devMount.HostSrcPath); err != nil {
-			return errors.New("Can't install " + localContainerName + ". HostSrcPath " + devMount.HostSrcPath + "not found")
+			return service, serviceOptions, requiredNetworks, errors.New("Can't install " + localContainerName + ". HostSrcPath " + devMount.HostSrcPath + "not found")
 		}
 
 		service.TaskTemplate.ContainerSpec.Mounts = []mount.Mount{
@@ -266,11 +603,9 @@ func (cm ContainerManager) LaunchFromSLA(sla libDatabox.SLA, save bool) error {
 		}
 	}
 
-	//Add secrests to container
-	service.TaskTemplate.ContainerSpec.Secrets = cm.genorateSecrets(localContainerName, sla.DataboxType)
-
-	//If we need a store lets create one and set the needed environment variables
-	if requiredStoreName != "" {
+	//If we need a store set the needed environment variables
+	if sla.ResourceRequirements.Store != "" {
+		requiredStoreName := sla.Name + "-" + sla.ResourceRequirements.Store
 		service.TaskTemplate.ContainerSpec.Env = append(
 			service.TaskTemplate.ContainerSpec.Env,
 			"DATABOX_ZMQ_ENDPOINT=tcp://"+requiredStoreName+":5555",
@@ -281,41 +616,55 @@ func (cm ContainerManager) LaunchFromSLA(sla libDatabox.SLA, save bool) error {
 		)
 	}
 
-	libDatabox.Debug("networksToConnect" + strings.Join(requiredNetworks, ","))
-	cm.CoreNetworkClient.ConnectEndpoints(localContainerName, requiredNetworks)
-
-	//do this after the networks are configured
-	if requiredStoreName != "" {
-		cm.launchStore(sla.ResourceRequirements.Store, requiredStoreName, netConf)
-		cm.WaitForService(requiredStoreName, 10)
+	//limit cpu and memory so one component can't starve the rest of databox
+	if !cm.isSystemComponent(localContainerName) {
+		resources, err := cm.resourcesFor(sla).toSwarm()
+		if err != nil {
+			return service, serviceOptions, requiredNetworks, errors.New("Can't install " + localContainerName + ". Invalid resource requirements " + err.Error())
+		}
+		service.TaskTemplate.Resources = resources
 	}
 
-	cm.addPermissionsFromSLA(sla)
+	return service, serviceOptions, requiredNetworks, nil
+}
 
-	_, err := cm.cli.ServiceCreate(context.Background(), service, serviceOptions)
-	if err != nil {
-		libDatabox.Err("[Error launching] " + localContainerName + " " + err.Error())
-		return err
-	}
+//IsInstalled Checks to see a component has been installled
+func (cm *ContainerManager) IsInstalled(name string) bool {
+	return cm.InstalledComponents.Has(name)
+}
 
-	if save {
-		//save the sla for persistence over restarts
-		err = cm.Store.SaveSLA(sla)
-		libDatabox.ChkErr(err)
-	}
+// InstalledSet is the set of components this CM has installed. Installs and uninstalls
+// run in their own goroutines while SLAs are validated so it is locked.
+type InstalledSet struct {
+	mu    sync.Mutex
+	names map[string]bool
+}
 
-	//keep track of installed components
-	cm.InstalledComponents[localContainerName] = localContainerName
+// NewInstalledSet returns an empty InstalledSet
+func NewInstalledSet() *InstalledSet {
+	return &InstalledSet{names: map[string]bool{}}
+}
 
-	libDatabox.Info("Successfully installed " + sla.Name)
+// Has reports if name is installed. It is safe to call on a nil InstalledSet.
+func (is *InstalledSet) Has(name string) bool {
+	if is == nil {
+		return false
+	}
+	is.mu.Lock()
+	defer is.mu.Unlock()
+	return is.names[name]
+}
 
-	return nil
+func (is *InstalledSet) add(name string) {
+	is.mu.Lock()
+	defer is.mu.Unlock()
+	is.names[name] = true
 }
 
-//IsInstalled Checks to see a component has been installled
-func (cm *ContainerManager) IsInstalled(name string) bool {
-	_, ok := cm.InstalledComponents[name]
-	return ok
+func (is *InstalledSet) remove(name string) {
+	is.mu.Lock()
+	defer is.mu.Unlock()
+	delete(is.names, name)
 }
 
 // getDevMountFor return the DevMount from Options.DevMounts that matches contName
@@ -346,7 +695,39 @@ func (cm *ContainerManager) imageExists(image string) bool {
 }
 
 // Restart will restart the databox component, app or driver by service name
-func (cm ContainerManager) Restart(name string) error {
+// Progress is reported through job which may be nil if the restart is not being tracked.
+func (cm ContainerManager) Restart(name string, job *Job) error {
+
+	job.SetState(JobStateStarting)
+
+	//an explicit restart gives a crashing component a fresh start
+	var err error
+	if report, quarantined := cm.Crashes.Quarantined(name); quarantined {
+		//it stays quarantined until it has started
+		err = cm.releaseQuarantine(name, report)
+		if err == nil {
+			cm.Crashes.Reset(name)
+		} else if _, serr := cm.serviceByName(name); serr == nil {
+			if serr = cm.scaleService(name, 0); serr != nil {
+				libDatabox.Err("Failed to stop " + name + " " + serr.Error())
+			}
+		}
+	} else {
+		cm.Crashes.Reset(name)
+		err = cm.restart(name)
+	}
+	if err != nil {
+		job.Fail(err)
+		cm.Journal.RecordErr(EventRestartFailed, name, err, job)
+		return err
+	}
+
+	job.SetState(JobStateRunning)
+	cm.Journal.Record(EventRestarted, name, "", job)
+	return nil
+}
+
+func (cm ContainerManager) restart(name string) error {
 	filters := filters.NewArgs()
 	filters.Add("label", "com.docker.swarm.service.name="+name)
 
@@ -359,14 +740,8 @@ func (cm ContainerManager) Restart(name string) error {
 	}
 
 	//Stash the old container IP
-	oldIP := ""
-	for netName := range contList[0].NetworkSettings.Networks {
-		serviceName := strings.Replace(name, "-"+cm.CoreStoreName, "", 1)
-		if strings.Contains(netName, serviceName) {
-			oldIP = contList[0].NetworkSettings.Networks[netName].IPAMConfig.IPv4Address
-			libDatabox.Debug("Old IP for " + netName + " is " + oldIP)
-		}
-	}
+	oldIP := cm.ipOnServiceNetwork(contList[0], name)
+	libDatabox.Debug("Old IP for " + name + " is " + oldIP)
 
 	//Stop the container then the service will start a new one
 	err := cm.cli.ContainerRemove(context.Background(), contList[0].ID, types.ContainerRemoveOptions{Force: true})
@@ -383,36 +758,73 @@ func (cm ContainerManager) Restart(name string) error {
 
 	//found restarted container !!!
 	//Stash the new container IP
-	newIP := ""
-	for netName := range newCont.NetworkSettings.Networks {
-		serviceName := strings.Replace(name, "-"+cm.CoreStoreName, "", 1)
-		if strings.Contains(netName, serviceName) {
-			newIP = newCont.NetworkSettings.Networks[netName].IPAMConfig.IPv4Address
-			libDatabox.Debug("IP  IP for " + netName + " is " + newIP)
-		}
-	}
+	newIP := cm.ipOnServiceNetwork(newCont, name)
+	libDatabox.Debug("New IP for " + name + " is " + newIP)
 
 	return cm.CoreNetworkClient.ServiceRestart(name, oldIP, newIP)
 }
 
+// ipOnServiceNetwork returns the IP of cont on the network created for the service name (or the service its a store for)
+func (cm ContainerManager) ipOnServiceNetwork(cont types.Container, name string) string {
+	ip := ""
+	serviceName := strings.Replace(name, "-"+cm.CoreStoreName, "", 1)
+	for netName, settings := range cont.NetworkSettings.Networks {
+		if strings.Contains(netName, serviceName) && settings.IPAMConfig != nil {
+			ip = settings.IPAMConfig.IPv4Address
+		}
+	}
+	return ip
+}
+
 // Uninstall will remove the databox app or driver by service name
-func (cm ContainerManager) Uninstall(name string) error {
+// It refuses if other installed components read from its store unless force is set.
+// Progress is reported through job which may be nil if the uninstall is not being tracked.
+func (cm ContainerManager) Uninstall(name string, force bool, job *Job) error {
 
-	serFilters := filters.NewArgs()
-	serFilters.Add("name", name)
-	serList, _ := cm.cli.ServiceList(context.Background(),
-		types.ServiceListOptions{
-			Filters: serFilters,
-		})
+	dependents, err := cm.checkDependents(name, force)
+	if err != nil {
+		job.Fail(err)
+		return err
+	}
+
+	err = cm.uninstall(name)
+	if err != nil {
+		job.Fail(err)
+		return err
+	}
+
+	message := ""
+	if len(dependents) > 0 {
+		message = "forced, used by " + strings.Join(dependents, ", ")
+	}
+	job.SetState(JobStateRemoved)
+	cm.Journal.Record(EventUninstalled, name, message, job)
+	return nil
+}
+
+func (cm ContainerManager) uninstall(name string) error {
 
-	if len(serList) < 1 {
+	service, serr := cm.serviceByName(name)
+	if serr != nil {
+		if _, stopped := cm.Stopped.Stopped(name); stopped {
+			//stopped before the CM last restarted so there is only its saved state to remove
+			cm.Store.DeleteSLA(name)
+			cm.Store.DeleteResources(name)
+			return cm.Stopped.start(name)
+		}
+		if _, quarantined := cm.Crashes.Reset(name); quarantined {
+			//quarantined before the CM last restarted, the same applies
+			cm.Store.DeleteSLA(name)
+			cm.Store.DeleteResources(name)
+			return nil
+		}
 		return errors.New("Service " + name + " not running")
 	}
 
-	networkConfig, err := cm.CoreNetworkClient.NetworkOfService(serList[0], serList[0].Spec.Name)
+	networkConfig, err := cm.CoreNetworkClient.NetworkOfService(service, service.Spec.Name)
 	libDatabox.ChkErr(err)
 
-	err = cm.cli.ServiceRemove(context.Background(), serList[0].ID)
+	err = cm.cli.ServiceRemove(context.Background(), service.ID)
 	libDatabox.ChkErr(err)
 
 	//remove secrets
@@ -431,15 +843,18 @@ func (cm ContainerManager) Uninstall(name string) error {
 	cm.CoreNetworkClient.PostUninstall(name, networkConfig)
 
 	cm.Store.DeleteSLA(name)
+	cm.Store.DeleteResources(name)
+	cm.Crashes.Reset(name)
+	cm.Stopped.start(name)
 
-	delete(cm.InstalledComponents, name)
+	cm.InstalledComponents.remove(name)
 
 	return err
 }
 
 // WaitForService will wait for a container to start searching for it by service name.
-// If the container is found within the timeout it will return a docker/api/types.Container and nil
-// otherwise an error will be returned.
+// If the container is found within the timeout it is then probed until it is ready, see waitUntilReady.
+// Once ready it will return a docker/api/types.Container and nil otherwise an error will be returned.
 func (cm ContainerManager) WaitForService(name string, timeout int) (types.Container, error) {
 	libDatabox.Debug("Waiting for " + name)
 	filters := filters.NewArgs()
@@ -457,10 +872,6 @@ func (cm ContainerManager) WaitForService(name string, timeout int) (types.Conta
 
 		if len(contList) > 0 {
 			//found something!!
-
-			//give it a bit more time
-			//TODO think about using /status here
-			time.Sleep(time.Second * 3)
 			break
 		}
 
@@ -473,16 +884,33 @@ func (cm ContainerManager) WaitForService(name string, timeout int) (types.Conta
 
 	}
 
+	err := cm.waitUntilReady(name, contList[0])
+	if err != nil {
+		return contList[0], errors.New("Service " + name + " started but " + err.Error())
+	}
+
 	return contList[0], nil
 }
 
-func (cm ContainerManager) genoratePassword() string {
+// serviceByName returns the swarm service called name.
+// docker filters services by name prefix so the result is checked for an exact match.
+func (cm ContainerManager) serviceByName(name string) (swarm.Service, error) {
+	serFilters := filters.NewArgs()
+	serFilters.Add("name", name)
+	serList, err := cm.cli.ServiceList(context.Background(), types.ServiceListOptions{
+		Filters: serFilters,
+	})
+	if err != nil {
+		return swarm.Service{}, err
+	}
 
-	b := make([]byte, 24)
-	rand.Read(b)
-	pass := b64.StdEncoding.EncodeToString(b)
-	return pass
+	for _, s := range serList {
+		if s.Spec.Name == name {
+			return s, nil
+		}
+	}
 
+	return swarm.Service{}, errors.New("Service " + name + " not found")
 }
 
 func (cm ContainerManager) reloadApps() {
@@ -491,31 +919,28 @@ func (cm ContainerManager) reloadApps() {
 	libDatabox.ChkErr(err)
 	libDatabox.Debug("reloadApps slaList len=" + strconv.Itoa(len(slaList)))
 
-	//launch drivers
-	var waitGroup sync.WaitGroup
-	LaunchFromSLAandWait := func(sla libDatabox.SLA, wg *sync.WaitGroup) {
-		err := cm.LaunchFromSLA(sla, false)
-		libDatabox.ChkErr(err)
-		wg.Done()
-	}
-
+	//do not try to start anything that can not work, what reads from it is blocked
+	skipped := map[string]error{}
 	for _, sla := range slaList {
-		if sla.DataboxType == libDatabox.DataboxTypeDriver {
-			waitGroup.Add(1)
-			go LaunchFromSLAandWait(sla, &waitGroup)
+		if _, stopped := cm.Stopped.Stopped(sla.Name); stopped {
+			libDatabox.Info("reloadApps not starting " + sla.Name + " it has been stopped")
+			skipped[sla.Name] = errors.New(sla.Name + " has been stopped")
+			continue
 		}
-	}
-	//wait for all drivers to install before we install tha apps
-	waitGroup.Wait()
-
-	//launch apps
-	for _, sla := range slaList {
-		if sla.DataboxType == libDatabox.DataboxTypeApp {
-			waitGroup.Add(1)
-			go LaunchFromSLAandWait(sla, &waitGroup)
+		if _, quarantined := cm.Crashes.Quarantined(sla.Name); quarantined {
+			libDatabox.Info("reloadApps not starting " + sla.Name + " it is quarantined")
+			skipped[sla.Name] = errors.New(sla.Name + " is quarantined")
+			continue
+		}
+		validation := cm.ValidateSLA(sla)
+		if !validation.Valid {
+			libDatabox.Err("reloadApps skipping " + sla.Name + " " + validation.Err().Error())
+			skipped[sla.Name] = validation.Err()
 		}
 	}
-	waitGroup.Wait()
+
+	//start everything after the components whose stores it uses
+	cm.reloadInOrder(slaList, skipped)
 
 }
 
@@ -529,46 +954,26 @@ func (cm ContainerManager) launchUI() {
 		DockerImage: cm.Options.CoreUIImage,
 		DataboxType: libDatabox.DataboxTypeApp,
 		Datasources: []libDatabox.DataSource{
-			libDatabox.DataSource{
-				Type:          "databox:func:ServiceStatus",
-				Required:      true,
-				Name:          "ServiceStatus",
-				Clientid:      "CM_API_ServiceStatus",
-				Granularities: []string{},
-				Hypercat: libDatabox.HypercatItem{
-					ItemMetadata: []interface{}{
-						libDatabox.RelValPairBool{
-							Rel: "urn:X-databox:rels:isFunc",
-							Val: true,
-						},
-						libDatabox.RelValPair{
-							Rel: "urn:X-databox:rels:hasDatasourceid",
-							Val: "ServiceStatus",
-						},
-					},
-					Href: "tcp://container-manager-" + cm.CoreStoreName + ":5555/",
-				},
-			},
-			libDatabox.DataSource{
-				Type:          "databox:func:ListAllDatasources",
-				Required:      true,
-				Name:          "ListAllDatasources",
-				Clientid:      "CM_API_ListAllDatasources",
-				Granularities: []string{},
-				Hypercat: libDatabox.HypercatItem{
-					ItemMetadata: []interface{}{
-						libDatabox.RelValPairBool{
-							Rel: "urn:X-databox:rels:isFunc",
-							Val: true,
-						},
-						libDatabox.RelValPair{
-							Rel: "urn:X-databox:rels:hasDatasourceid",
-							Val: "ListAllDatasources",
-						},
-					},
-					Href: "tcp://container-manager-" + cm.CoreStoreName + ":5555/",
-				},
-			},
+			cm.funcDataSource("ServiceStatus"),
+			cm.funcDataSource("ListAllDatasources"),
+			cm.funcDataSource("JobStatus"),
+			cm.funcDataSource("Validate"),
+			cm.funcDataSource("LifecycleEvents"),
+			cm.funcDataSource("Install"),
+			cm.funcDataSource("Uninstall"),
+			cm.funcDataSource("Restart"),
+			cm.funcDataSource("Logs"),
+			cm.funcDataSource("ResourceUsage"),
+			cm.funcDataSource("DatasourceCatalogue"),
+			cm.funcDataSource("Consumers"),
+			cm.funcDataSource("Dependencies"),
+			cm.funcDataSource("Stop"),
+			cm.funcDataSource("Start"),
+			cm.funcDataSource("ListSessions"),
+			cm.funcDataSource("RevokeSession"),
+			cm.funcDataSource("LoginLockouts"),
+			cm.funcDataSource("Unlock"),
+			cm.funcDataSource("Certificates"),
 			libDatabox.DataSource{
 				Type:          "databox:container-manager:api",
 				Required:      true,
@@ -660,7 +1065,7 @@ func (cm ContainerManager) launchUI() {
 		},
 	}
 
-	err := cm.LaunchFromSLA(sla, false)
+	err := cm.LaunchFromSLA(sla, false, nil)
 	libDatabox.ChkErr(err)
 
 	_, err = cm.WaitForService(name, 10)
@@ -668,6 +1073,30 @@ func (cm ContainerManager) launchUI() {
 
 }
 
+// funcDataSource is the datasource core-ui needs to call the container manager FUNC name
+func (cm ContainerManager) funcDataSource(name string) libDatabox.DataSource {
+	return libDatabox.DataSource{
+		Type:          "databox:func:" + name,
+		Required:      true,
+		Name:          name,
+		Clientid:      "CM_API_" + name,
+		Granularities: []string{},
+		Hypercat: libDatabox.HypercatItem{
+			ItemMetadata: []interface{}{
+				libDatabox.RelValPairBool{
+					Rel: "urn:X-databox:rels:isFunc",
+					Val: true,
+				},
+				libDatabox.RelValPair{
+					Rel: "urn:X-databox:rels:hasDatasourceid",
+					Val: name,
+				},
+			},
+			Href: "tcp://container-manager-" + cm.CoreStoreName + ":5555/",
+		},
+	}
+}
+
 // launchAppStore start the app store driver
 func (cm ContainerManager) launchAppStore() {
 	name := cm.AppStoreName
@@ -687,7 +1116,7 @@ func (cm ContainerManager) launchAppStore() {
 		},
 	}
 
-	err := cm.LaunchFromSLA(sla, false)
+	err := cm.LaunchFromSLA(sla, false, nil)
 	libDatabox.ChkErr(err)
 
 	_, err = cm.WaitForService(name, 10)
@@ -830,7 +1259,7 @@ func (cm ContainerManager) launchStore(requiredStore string, requiredStoreName s
 		},
 	}
 
-	pullImageIfRequired(service.TaskTemplate.ContainerSpec.Image, cm.Options.DefaultRegistry, cm.Options.DefaultRegistryHost)
+	pullImageIfRequired(cm.cli, service.TaskTemplate.ContainerSpec.Image, cm.Options.DefaultRegistry, cm.Options.DefaultRegistryHost)
 
 	_, err := cm.cli.ServiceCreate(context.Background(), service, types.ServiceCreateOptions{})
 	if err != nil {
@@ -840,7 +1269,7 @@ func (cm ContainerManager) launchStore(requiredStore string, requiredStoreName s
 	return storeName
 }
 
-func (cm ContainerManager) createSecret(name string, data []byte, filename string) *swarm.SecretReference {
+func (cm ContainerManager) createSecret(name string, data []byte, filename string, labels map[string]string) *swarm.SecretReference {
 
 	filters := filters.NewArgs()
 	filters.Add("name", name)
@@ -854,7 +1283,8 @@ func (cm ContainerManager) createSecret(name string, data []byte, filename strin
 
 	secret := swarm.SecretSpec{
 		Annotations: swarm.Annotations{
-			Name: name,
+			Name:   name,
+			Labels: labels,
 		},
 		Data: data,
 	}
@@ -881,27 +1311,9 @@ func (cm ContainerManager) genorateSecrets(containerName string, databoxType lib
 
 	secrets := []*swarm.SecretReference{}
 
-	secrets = append(secrets, &swarm.SecretReference{
-		SecretID:   cm.DATABOX_ROOT_CA_ID,
-		SecretName: "DATABOX_ROOT_CA",
-		File: &swarm.SecretReferenceFileTarget{
-			Name: "DATABOX_ROOT_CA",
-			UID:  "0",
-			GID:  "0",
-			Mode: 0444,
-		},
-	})
-
-	secrets = append(secrets, &swarm.SecretReference{
-		SecretID:   cm.ZMQ_PUBLIC_KEY_ID,
-		SecretName: "ZMQ_PUBLIC_KEY",
-		File: &swarm.SecretReferenceFileTarget{
-			Name: "ZMQ_PUBLIC_KEY",
-			UID:  "0",
-			GID:  "0",
-			Mode: 0444,
-		},
-	})
+	//the root CA may have been renewed since cm was made
+	secrets = append(secrets, newestSecretRef(cm.cli, rootCASecret, cm.DATABOX_ROOT_CA_ID))
+	secrets = append(secrets, newestSecretRef(cm.cli, "ZMQ_PUBLIC_KEY", cm.ZMQ_PUBLIC_KEY_ID))
 
 	cert := GenCert(
 		"./certs/containerManager.crt", //TODO Fix this
@@ -909,15 +1321,15 @@ func (cm ContainerManager) genorateSecrets(containerName string, databoxType lib
 		[]string{"127.0.0.1"},
 		[]string{containerName},
 	)
-	secrets = append(secrets, cm.createSecret(strings.ToUpper(containerName)+".pem", cert, "DATABOX.pem"))
+	secrets = append(secrets, cm.createSecret(strings.ToUpper(containerName)+".pem", cert, "DATABOX.pem", certSecretLabels(containerName, cert)))
 
 	rawToken := GenerateArbiterToken()
 	b64TokenString := b64.StdEncoding.EncodeToString(rawToken)
-	secrets = append(secrets, cm.createSecret(strings.ToUpper(containerName)+"_KEY", []byte(b64TokenString), "ARBITER_TOKEN"))
+	secrets = append(secrets, cm.createSecret(strings.ToUpper(containerName)+"_KEY", []byte(b64TokenString), "ARBITER_TOKEN", nil))
 
 	//update the arbiter with the containers token
 	libDatabox.Debug("addSecrets UpdateArbiter " + containerName + " " + b64TokenString + " " + string(databoxType))
-	err := cm.ArbiterClient.RegesterDataboxComponent(containerName, b64TokenString, databoxType)
+	err := cm.Arbiter.RegesterDataboxComponent(containerName, b64TokenString, databoxType)
 	if err != nil {
 		libDatabox.Err("Add Secrets error updating arbiter " + err.Error())
 	}
@@ -925,16 +1337,7 @@ func (cm ContainerManager) genorateSecrets(containerName string, databoxType lib
 	//Only pass the zmq private key to stores.
 	if databoxType == "store" {
 		libDatabox.Debug("[addSecrets] ZMQ_PRIVATE_KEY_ID=" + cm.ZMQ_PRIVATE_KEY_ID)
-		secrets = append(secrets, &swarm.SecretReference{
-			SecretID:   cm.ZMQ_PRIVATE_KEY_ID,
-			SecretName: "ZMQ_SECRET_KEY",
-			File: &swarm.SecretReferenceFileTarget{
-				Name: "ZMQ_SECRET_KEY",
-				UID:  "0",
-				GID:  "0",
-				Mode: 0444,
-			},
-		})
+		secrets = append(secrets, newestSecretRef(cm.cli, "ZMQ_SECRET_KEY", cm.ZMQ_PRIVATE_KEY_ID))
 	}
 
 	return secrets
@@ -943,30 +1346,8 @@ func (cm ContainerManager) genorateSecrets(containerName string, databoxType lib
 //addPermissionsFromSLA parses a databox SLA and updates the arbiter with the correct permissions
 func (cm ContainerManager) addPermissionsFromSLA(sla libDatabox.SLA) {
 
-	var err error
-
 	localContainerName := sla.Name
 
-	//set export permissions from export-whitelist
-	if len(sla.ExportWhitelists) > 0 {
-		for _, whiteList := range sla.ExportWhitelists {
-			caveat := `{"destination":"` + whiteList.Url + `"}`
-
-			libDatabox.Debug("Adding Export permissions for " + localContainerName + " on " + whiteList.Url)
-
-			err = cm.addPermission(localContainerName, "export-service", "/export", "POST", caveat)
-			if err != nil {
-				libDatabox.Err("Adding write permissions for export-service " + err.Error())
-			}
-
-			err = cm.addPermission(localContainerName, "export-service", "/lp/export", "POST", caveat)
-			if err != nil {
-				libDatabox.Err("Adding write permissions for lp export-service " + err.Error())
-			}
-		}
-
-	}
-
 	//set export permissions from ExternalWhitelist
 	if sla.DataboxType == "driver" && len(sla.ExternalWhitelist) > 0 {
 		//TODO move this logic to the coreNetworkClient
@@ -977,6 +1358,7 @@ func (cm ContainerManager) addPermissionsFromSLA(sla libDatabox.SLA) {
 				parsedURL, err := url.Parse(u)
 				if err != nil {
 					libDatabox.Warn("Error parsing url in ExternalWhitelist")
+					continue
 				}
 				externals = append(externals, parsedURL.Hostname())
 			}
@@ -985,105 +1367,98 @@ func (cm ContainerManager) addPermissionsFromSLA(sla libDatabox.SLA) {
 		}
 	}
 
-	//set read permissions from the sla for DATASOURCES.
-	if sla.DataboxType == "app" && len(sla.Datasources) > 0 {
+	cm.grantPermissions(permissionsFromSLA(sla))
+}
 
-		for _, ds := range sla.Datasources {
-			datasourceEndpoint, _ := url.Parse(ds.Hypercat.Href)
-			datasourceName := datasourceEndpoint.Path
+//grantPermissions updates the arbiter with each of perms logging any failures
+func (cm ContainerManager) grantPermissions(perms []libDatabox.ContainerPermissions) {
+	for _, perm := range perms {
+		libDatabox.Debug("Adding " + perm.Route.Method + " permissions for " + perm.Name + " on " + perm.Route.Target + " " + perm.Route.Path)
+		err := cm.Arbiter.GrantContainerPermissions(perm)
+		if err != nil {
+			libDatabox.Err("Adding " + perm.Route.Method + " permissions for " + perm.Name + " on " + perm.Route.Target + " " + perm.Route.Path + " " + err.Error())
+		}
+	}
+}
 
-			libDatabox.Debug("[adding permissions for] datasource" + ds.Name)
-			libDatabox.Debug(ds.Name + " IsActuator " + strconv.FormatBool(libDatabox.IsActuator(ds)))
+//revokePermissions removes each of perms from the arbiter logging any failures
+func (cm ContainerManager) revokePermissions(perms []libDatabox.ContainerPermissions) {
+	for _, perm := range perms {
+		libDatabox.Debug("Revoking " + perm.Route.Method + " permissions for " + perm.Name + " on " + perm.Route.Target + " " + perm.Route.Path)
+		err := cm.Arbiter.RevokeContainerPermissions(perm)
+		if err != nil {
+			libDatabox.Err("Revoking " + perm.Route.Method + " permissions for " + perm.Name + " on " + perm.Route.Target + " " + perm.Route.Path + " " + err.Error())
+		}
+	}
+}
 
-			if libDatabox.IsActuator(ds) { //Deal with Actuators
-				libDatabox.Debug("Adding write permissions for Actuator " + datasourceName + "/* on " + datasourceEndpoint.Hostname())
-				err = cm.addPermission(localContainerName, datasourceEndpoint.Hostname(), datasourceName+"/*", "POST", "")
-				if err != nil {
-					libDatabox.Err("Adding write permissions for Actuator " + err.Error())
-				}
+//permissionsFromSLA lists the arbiter permissions required by a databox SLA
+func permissionsFromSLA(sla libDatabox.SLA) []libDatabox.ContainerPermissions {
 
-				libDatabox.Debug("Adding write permissions for Actuator " + datasourceName + " on " + datasourceEndpoint.Hostname())
-				err = cm.addPermission(localContainerName, datasourceEndpoint.Hostname(), datasourceName, "POST", "")
-				if err != nil {
-					libDatabox.Err("Adding write permissions for Actuator " + err.Error())
-				}
+	perms := []libDatabox.ContainerPermissions{}
 
-				libDatabox.Debug("Adding read permissions for " + localContainerName + " on data source " + datasourceName + " on " + datasourceEndpoint.Hostname())
-				err = cm.addPermission(localContainerName, datasourceEndpoint.Hostname(), datasourceName, "GET", "")
-				if err != nil {
-					libDatabox.Err("Adding write permissions for Datasource " + err.Error())
-				}
+	localContainerName := sla.Name
 
-				libDatabox.Debug("Adding read permissions for " + localContainerName + " on data source " + datasourceName + "/* on " + datasourceEndpoint.Hostname())
-				err = cm.addPermission(localContainerName, datasourceEndpoint.Hostname(), datasourceName+"/*", "GET", "")
-				if err != nil {
-					libDatabox.Err("Adding write permissions for Datasource " + err.Error())
-				}
+	//set export permissions from export-whitelist
+	for _, whiteList := range sla.ExportWhitelists {
+		caveat := `{"destination":"` + whiteList.Url + `"}`
+		perms = append(perms,
+			newPermission(localContainerName, "export-service", "/export", "POST", caveat),
+			newPermission(localContainerName, "export-service", "/lp/export", "POST", caveat),
+		)
+	}
+
+	//set read permissions from the sla for DATASOURCES.
+	if sla.DataboxType == "app" {
+		for _, ds := range sla.Datasources {
+			datasourceEndpoint, err := url.Parse(ds.Hypercat.Href)
+			if err != nil {
+				libDatabox.Warn("Error parsing datasource href " + ds.Hypercat.Href + " for " + ds.Name)
+				continue
+			}
+			datasourceName := datasourceEndpoint.Path
+			storeName := datasourceEndpoint.Hostname()
 
+			libDatabox.Debug(ds.Name + " IsActuator " + strconv.FormatBool(libDatabox.IsActuator(ds)))
+
+			if libDatabox.IsActuator(ds) { //Deal with Actuators
+				perms = append(perms,
+					newPermission(localContainerName, storeName, datasourceName+"/*", "POST", ""),
+					newPermission(localContainerName, storeName, datasourceName, "POST", ""),
+					newPermission(localContainerName, storeName, datasourceName, "GET", ""),
+					newPermission(localContainerName, storeName, datasourceName+"/*", "GET", ""),
+				)
 			} else if libDatabox.IsFunc(ds) { //Deal with databox functions
-				libDatabox.Debug("Adding write permissions for functions request /notification/request/" + ds.Name + "/* on " + datasourceEndpoint.Hostname())
-				err = cm.addPermission(localContainerName, datasourceEndpoint.Hostname(), "/notification/request/"+ds.Name+"/*", "POST", "")
-				if err != nil {
-					libDatabox.Err("Adding write permissions for functions " + err.Error())
-				}
-				libDatabox.Debug("Adding read permissions for functions response /notification/response/" + ds.Name + "/* on " + datasourceEndpoint.Hostname())
-				err = cm.addPermission(localContainerName, datasourceEndpoint.Hostname(), "/notification/response/"+ds.Name+"/*", "GET", "")
-				if err != nil {
-					libDatabox.Err("Adding write permissions for functions " + err.Error())
-				}
+				perms = append(perms,
+					newPermission(localContainerName, storeName, "/notification/request/"+ds.Name+"/*", "POST", ""),
+					newPermission(localContainerName, storeName, "/notification/response/"+ds.Name+"/*", "GET", ""),
+				)
 			} else {
-				libDatabox.Debug("Adding read permissions for " + localContainerName + " on data source " + datasourceName + " on " + datasourceEndpoint.Hostname())
-				err = cm.addPermission(localContainerName, datasourceEndpoint.Hostname(), datasourceName, "GET", "")
-				if err != nil {
-					libDatabox.Err("Adding write permissions for Datasource " + err.Error())
-				}
-
-				libDatabox.Debug("Adding read permissions for " + localContainerName + " on data source " + datasourceName + "/* on " + datasourceEndpoint.Hostname())
-				err = cm.addPermission(localContainerName, datasourceEndpoint.Hostname(), datasourceName+"/*", "GET", "")
-				if err != nil {
-					libDatabox.Err("Adding write permissions for Datasource " + err.Error())
-				}
+				perms = append(perms,
+					newPermission(localContainerName, storeName, datasourceName, "GET", ""),
+					newPermission(localContainerName, storeName, datasourceName+"/*", "GET", ""),
+				)
 			}
-
 		}
-
 	}
 
 	//Add permissions for dependent stores if needed for apps and drivers
 	if sla.ResourceRequirements.Store != "" {
 		requiredStoreName := sla.Name + "-" + sla.ResourceRequirements.Store
-
-		libDatabox.Debug("Adding read permissions for container-manager on " + requiredStoreName + "/cat")
-		err = cm.addPermission("container-manager", requiredStoreName, "/cat", "GET", "")
-		if err != nil {
-			libDatabox.Err("Adding read permissions for container-manager on /cat " + err.Error())
-		}
-
-		libDatabox.Debug("Adding write permissions for dependent store " + localContainerName + " on " + requiredStoreName + "/*")
-		err = cm.addPermission(localContainerName, requiredStoreName, "/*", "POST", "")
-		if err != nil {
-			libDatabox.Err("Adding write permissions for dependent store " + err.Error())
-		}
-
-		libDatabox.Debug("Adding delete permissions for dependent store " + localContainerName + " on " + requiredStoreName + "/*")
-		err = cm.addPermission(localContainerName, requiredStoreName, "/*", "DELETE", "")
-		if err != nil {
-			libDatabox.Err("Adding delete permissions for dependent store " + err.Error())
-		}
-
-		libDatabox.Debug("Adding read permissions for dependent store " + localContainerName + " on " + requiredStoreName + "/*")
-		err = cm.addPermission(localContainerName, requiredStoreName, "/*", "GET", "")
-		if err != nil {
-			libDatabox.Err("Adding read permissions for dependent store " + err.Error())
-		}
-
+		perms = append(perms,
+			newPermission("container-manager", requiredStoreName, "/cat", "GET", ""),
+			newPermission(localContainerName, requiredStoreName, "/*", "POST", ""),
+			newPermission(localContainerName, requiredStoreName, "/*", "DELETE", ""),
+			newPermission(localContainerName, requiredStoreName, "/*", "GET", ""),
+		)
 	}
-}
 
-//addPermission helper function to wraps ArbiterClient.GrantContainerPermissions
-func (cm ContainerManager) addPermission(name string, target string, path string, method string, caveat string) error {
+	return perms
+}
 
-	newPermission := libDatabox.ContainerPermissions{
+//newPermission helper function to build a libDatabox.ContainerPermissions
+func newPermission(name string, target string, path string, method string, caveat string) libDatabox.ContainerPermissions {
+	return libDatabox.ContainerPermissions{
 		Name: name,
 		Route: libDatabox.Route{
 			Target: target,
@@ -1092,9 +1467,6 @@ func (cm ContainerManager) addPermission(name string, target string, path string
 		},
 		Caveat: caveat,
 	}
-
-	return cm.ArbiterClient.GrantContainerPermissions(newPermission)
-
 }
 
 func (cm ContainerManager) startExportService() {
@@ -1119,7 +1491,7 @@ func (cm ContainerManager) startExportService() {
 
 	serviceOptions := types.ServiceCreateOptions{}
 
-	pullImageIfRequired(service.TaskTemplate.ContainerSpec.Image, cm.Options.DefaultRegistry, cm.Options.DefaultRegistryHost)
+	pullImageIfRequired(cm.cli, service.TaskTemplate.ContainerSpec.Image, cm.Options.DefaultRegistry, cm.Options.DefaultRegistryHost)
 
 	_, err := cm.cli.ServiceCreate(context.Background(), service, serviceOptions)
 	libDatabox.ChkErrFatal(err)
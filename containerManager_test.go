@@ -0,0 +1,267 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	libDatabox "github.com/me-box/lib-go-databox"
+)
+
+func TestMain(m *testing.M) {
+
+	//genorateSecrets signs component certificates with ./certs/containerManager.crt
+	dir, err := ioutil.TempDir("", "container-manager-test")
+	if err != nil {
+		panic(err)
+	}
+	err = os.Mkdir(filepath.Join(dir, "certs"), 0700)
+	if err != nil {
+		panic(err)
+	}
+	err = os.Chdir(dir)
+	if err != nil {
+		panic(err)
+	}
+	GenRootCA("./certs/containerManager.crt", "./certs/containerManagerPub.crt")
+
+	readinessInterval = 10 * time.Millisecond
+	askStoreCatalogue = fakeStoreCatalogue
+
+	code := m.Run()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+func TestLaunchFromSLA(t *testing.T) {
+
+	tests := []struct {
+		name         string
+		sla          func(td *testDatabox) libDatabox.SLA
+		save         bool
+		wantErr      string
+		wantServices []string
+		wantSecrets  []string
+	}{
+		{
+			name: "app",
+			sla: func(td *testDatabox) libDatabox.SLA {
+				return td.testSLA("app-one", libDatabox.DataboxTypeApp)
+			},
+			save:         true,
+			wantServices: []string{"app-one"},
+			wantSecrets:  []string{"APP-ONE.pem", "APP-ONE_KEY"},
+		},
+		{
+			name: "driver with a store",
+			sla: func(td *testDatabox) libDatabox.SLA {
+				return td.testSLA("driver-one", libDatabox.DataboxTypeDriver)
+			},
+			wantServices: []string{"driver-one", "driver-one-core-store"},
+			wantSecrets:  []string{"DRIVER-ONE.pem", "DRIVER-ONE_KEY", "DRIVER-ONE-CORE-STORE.pem", "DRIVER-ONE-CORE-STORE_KEY"},
+		},
+		{
+			name: "missing image",
+			sla: func(td *testDatabox) libDatabox.SLA {
+				return libDatabox.SLA{Name: "app-missing", DataboxType: libDatabox.DataboxTypeApp}
+			},
+			save:    true,
+			wantErr: "cant find the image",
+		},
+		{
+			name: "already installed",
+			sla: func(td *testDatabox) libDatabox.SLA {
+				sla := td.testSLA("app-twice", libDatabox.DataboxTypeApp)
+				err := td.cm.LaunchFromSLA(sla, false, nil)
+				if err != nil {
+					t.Fatal(err)
+				}
+				return sla
+			},
+			wantErr:      "already installed",
+			wantServices: []string{"app-twice"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			td := newTestDatabox(t)
+			sla := tt.sla(td)
+			job := td.cm.Jobs.NewJob(JobTypeInstall, sla.Name)
+
+			err := td.cm.LaunchFromSLA(sla, tt.save, job)
+
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("error = %v, want %q", err, tt.wantErr)
+				}
+				if got, _ := td.cm.Jobs.Get(job.ID); got.State != JobStateFailed {
+					t.Errorf("job state = %s, want %s", got.State, JobStateFailed)
+				}
+			} else {
+				if err != nil {
+					t.Fatal(err)
+				}
+				if got, _ := td.cm.Jobs.Get(job.ID); got.State != JobStateRunning {
+					t.Errorf("job state = %s, want %s", got.State, JobStateRunning)
+				}
+			}
+
+			for _, name := range tt.wantServices {
+				if len(td.containers(name)) != 1 {
+					t.Errorf("%s is not running", name)
+				}
+				if !td.arbiter.registered(name) {
+					t.Errorf("%s is not registered with the arbiter", name)
+				}
+			}
+			for _, name := range tt.wantSecrets {
+				if !td.secretExists(name) {
+					t.Errorf("secret %s is missing", name)
+				}
+			}
+
+			_, err = td.cm.Store.GetSLA(sla.Name)
+			if saved := err == nil; saved != (tt.save && tt.wantErr == "") {
+				t.Errorf("SLA saved = %v", saved)
+			}
+		})
+	}
+}
+
+func TestUninstall(t *testing.T) {
+
+	tests := []struct {
+		name      string
+		uninstall string
+		force     bool
+		wantErr   string
+		wantGone  bool
+	}{
+		{name: "app", uninstall: "app-reader", wantGone: true},
+		{name: "unknown", uninstall: "app-unknown", wantErr: "not running", wantGone: true},
+		{name: "driver in use", uninstall: "driver-source", wantErr: "is used by app-reader"},
+		{name: "driver in use forced", uninstall: "driver-source", force: true, wantGone: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			td := newTestDatabox(t)
+			for _, sla := range []libDatabox.SLA{
+				td.testSLA("driver-source", libDatabox.DataboxTypeDriver),
+				td.testSLA("app-reader", libDatabox.DataboxTypeApp, "driver-source"),
+			} {
+				err := td.cm.LaunchFromSLA(sla, true, nil)
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			err := td.cm.Uninstall(tt.uninstall, tt.force, nil)
+
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("error = %v, want %q", err, tt.wantErr)
+				}
+			} else if err != nil {
+				t.Fatal(err)
+			}
+
+			_, err = td.cm.Store.GetSLA(tt.uninstall)
+			if gone := td.service(tt.uninstall) == nil && err != nil; gone != tt.wantGone {
+				t.Errorf("gone = %v, want %v", gone, tt.wantGone)
+			}
+			if tt.wantErr == "" && len(td.network.calls("/disconnect")) == 0 {
+				t.Errorf("core-network was not told to disconnect %s", tt.uninstall)
+			}
+		})
+	}
+}
+
+func TestRestart(t *testing.T) {
+
+	tests := []struct {
+		name    string
+		restart string
+		wantErr string
+	}{
+		{name: "app", restart: "app-restarted"},
+		{name: "store", restart: "driver-restarted-core-store"},
+		{name: "unknown", restart: "app-unknown", wantErr: "not running"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			td := newTestDatabox(t)
+			for _, sla := range []libDatabox.SLA{
+				td.testSLA("app-restarted", libDatabox.DataboxTypeApp),
+				td.testSLA("driver-restarted", libDatabox.DataboxTypeDriver),
+			} {
+				err := td.cm.LaunchFromSLA(sla, true, nil)
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+			before := td.containers(tt.restart)
+
+			err := td.cm.Restart(tt.restart, nil)
+
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			after := td.containers(tt.restart)
+			if len(after) != 1 || after[0].ID == before[0].ID {
+				t.Errorf("%s was not replaced by a new container", tt.restart)
+			}
+			if len(td.network.calls("/restart")) != 1 {
+				t.Errorf("core-network was not told about the new container of %s", tt.restart)
+			}
+		})
+	}
+}
+
+func TestReloadApps(t *testing.T) {
+
+	td := newTestDatabox(t)
+
+	slas := []libDatabox.SLA{
+		td.testSLA("driver-saved", libDatabox.DataboxTypeDriver),
+		td.testSLA("app-saved", libDatabox.DataboxTypeApp, "driver-saved"),
+		td.testSLA("app-stopped", libDatabox.DataboxTypeApp),
+		td.testSLA("Not_Valid", libDatabox.DataboxTypeApp),
+	}
+	for _, sla := range slas {
+		err := td.cm.Store.SaveSLA(sla)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err := td.cm.Stopped.stop("app-stopped", stoppedRecord{Type: "app"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	td.cm.reloadApps()
+
+	for name, wantRunning := range map[string]bool{
+		"driver-saved":            true,
+		"driver-saved-core-store": true,
+		"app-saved":               true,
+		"app-stopped":             false,
+		"Not_Valid":               false,
+	} {
+		if running := len(td.containers(name)) == 1; running != wantRunning {
+			t.Errorf("%s running = %v, want %v", name, running, wantRunning)
+		}
+	}
+}
@@ -17,12 +17,11 @@ import (
 	"github.com/docker/docker/api/types/filters"
 	dockerNetworkTypes "github.com/docker/docker/api/types/network"
 	"github.com/docker/docker/api/types/swarm"
-	"github.com/docker/docker/client"
 	libDatabox "github.com/me-box/lib-go-databox"
 )
 
 type CoreNetworkClient struct {
-	cli     *client.Client
+	cli     Orchestrator
 	request *http.Client
 	CM_KEY  string
 }
@@ -37,9 +36,7 @@ type PostNetworkConfig struct {
 	IPv4Address string
 }
 
-func NewCoreNetworkClient(containerManagerKeyPath string, request *http.Client) *CoreNetworkClient {
-
-	cli, _ := client.NewEnvClient()
+func NewCoreNetworkClient(cli Orchestrator, containerManagerKeyPath string, request *http.Client) *CoreNetworkClient {
 
 	cmKeyBytes, err := ioutil.ReadFile(containerManagerKeyPath)
 	var cmKey string
@@ -57,7 +54,9 @@ func NewCoreNetworkClient(containerManagerKeyPath string, request *http.Client)
 	}
 }
 
-func (cnc CoreNetworkClient) PreConfig(localContainerName string, sla libDatabox.SLA) NetworkConfig {
+// PreConfig creates, if needed, the network for localContainerName and attaches the core-network to it.
+// It returns the network config to use and true if the network was created by this call.
+func (cnc CoreNetworkClient) PreConfig(localContainerName string, sla libDatabox.SLA) (NetworkConfig, bool) {
 
 	networkName := localContainerName + "-network"
 
@@ -73,6 +72,7 @@ func (cnc CoreNetworkClient) PreConfig(localContainerName string, sla libDatabox
 
 	var network types.NetworkResource
 	var err error
+	created := false
 
 	if len(networkList) > 0 {
 		//network exists
@@ -91,6 +91,8 @@ func (cnc CoreNetworkClient) PreConfig(localContainerName string, sla libDatabox
 		})
 		if err != nil {
 			libDatabox.Err("[PreConfig] NetworkCreate Error " + err.Error())
+		} else {
+			created = true
 		}
 
 		network, err = cnc.cli.NetworkInspect(context.Background(), networkCreateResponse.ID, types.NetworkInspectOptions{})
@@ -118,11 +120,22 @@ func (cnc CoreNetworkClient) PreConfig(localContainerName string, sla libDatabox
 		if err != nil {
 			libDatabox.Err("[PreConfig] NetworkConnect Error " + err.Error())
 		}
-		time.Sleep(time.Second * 5)
-		//refresh network status
-		network, err = cnc.cli.NetworkInspect(context.Background(), networkCreateResponse.ID, types.NetworkInspectOptions{})
-		if err != nil {
-			libDatabox.Err("[PreConfig] NetworkInspect3 Error " + err.Error())
+		//refresh network status until core-network shows up on it
+		joined := waitUntil(10*time.Second, func() bool {
+			network, err = cnc.cli.NetworkInspect(context.Background(), networkCreateResponse.ID, types.NetworkInspectOptions{})
+			if err != nil {
+				libDatabox.Err("[PreConfig] NetworkInspect3 Error " + err.Error())
+				return false
+			}
+			for _, cont := range network.Containers {
+				if cont.Name == "databox-network" {
+					return true
+				}
+			}
+			return false
+		})
+		if !joined {
+			libDatabox.Err("[PreConfig] databox-network did not join " + networkName)
 		}
 	}
 
@@ -138,7 +151,26 @@ func (cnc CoreNetworkClient) PreConfig(localContainerName string, sla libDatabox
 
 	libDatabox.Debug("[PreConfig]" + networkName + " " + ipOnNewNet)
 
-	return NetworkConfig{NetworkName: networkName, DNS: ipOnNewNet}
+	return NetworkConfig{NetworkName: networkName, DNS: ipOnNewNet}, created
+}
+
+// RemoveNetwork detaches any remaining containers (the core-network) from networkName and removes it
+func (cnc CoreNetworkClient) RemoveNetwork(networkName string) error {
+
+	network, err := cnc.cli.NetworkInspect(context.Background(), networkName, types.NetworkInspectOptions{})
+	if err != nil {
+		return err
+	}
+
+	for id, cont := range network.Containers {
+		libDatabox.Debug("[RemoveNetwork] disconnecting " + cont.Name + " from " + networkName)
+		err = cnc.cli.NetworkDisconnect(context.Background(), network.ID, id, true)
+		if err != nil {
+			libDatabox.Err("[RemoveNetwork] NetworkDisconnect Error " + err.Error())
+		}
+	}
+
+	return cnc.cli.NetworkRemove(context.Background(), network.ID)
 }
 
 func (cnc CoreNetworkClient) NetworkOfService(service swarm.Service, serviceName string) (PostNetworkConfig, error) {
@@ -0,0 +1,349 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/swarm"
+	"github.com/docker/docker/pkg/stdcopy"
+	libDatabox "github.com/me-box/lib-go-databox"
+)
+
+// crashBackoffBase is how long crashDetectore waits before restarting after the first crash,
+// the wait doubles with each crash in crashWindow up to crashBackoffMax
+var crashBackoffBase = 2 * time.Second
+var crashBackoffMax = 2 * time.Minute
+
+// crashMaxRestarts is how many times a component can crash in crashWindow before it is quarantined
+var crashMaxRestarts = 5
+var crashWindow = 10 * time.Minute
+
+// crashLogLines is how much of the log of the last crash is kept in a CrashReport
+const crashLogLines = 20
+
+// quarantinedStatus is reported by ServiceStatus for quarantined components
+const quarantinedStatus swarm.TaskState = "quarantined"
+
+// CrashReport says why a component was quarantined
+type CrashReport struct {
+	Reason   string    `json:"reason"`
+	ExitCode string    `json:"exitCode"`
+	Crashes  int       `json:"crashes"`
+	Logs     []string  `json:"logs"`
+	Time     time.Time `json:"time"`
+
+	//the IP core-network knows the component by, needed when it is restarted
+	oldIP string
+}
+
+// quarantineRecord is saved in the CM store when a component is quarantined
+type quarantineRecord struct {
+	Report CrashReport `json:"report"`
+	Type   string      `json:"type"`
+	OldIP  string      `json:"oldIP"`
+}
+
+type crashRecord struct {
+	crashes     []time.Time
+	pending     bool
+	quarantined *CrashReport
+	//the databox type of a quarantined component, listed even when it has no service
+	kind string
+}
+
+// CrashTracker keeps count of how often each component has crashed recently.
+// Quarantined components are mirrored in the quarantineStore of the CM store.
+type CrashTracker struct {
+	store   *CMStore
+	mu      sync.Mutex
+	records map[string]*crashRecord
+}
+
+// NewCrashTracker loads the quarantined components saved in store
+func NewCrashTracker(store *CMStore) *CrashTracker {
+	ct := &CrashTracker{
+		store:   store,
+		records: make(map[string]*crashRecord),
+	}
+	if store != nil {
+		quarantined, err := store.GetAllQuarantined()
+		if err != nil {
+			libDatabox.Err("Can't load quarantined components " + err.Error())
+		}
+		for name, saved := range quarantined {
+			report := saved.Report
+			report.oldIP = saved.OldIP
+			ct.records[name] = &crashRecord{quarantined: &report, kind: saved.Type}
+		}
+	}
+	return ct
+}
+
+// crashed records that name crashed at now. It returns how long to wait before restarting it
+// or quarantine if it has crashed more than crashMaxRestarts times within crashWindow.
+// restart is false if a restart is already waiting to happen.
+func (ct *CrashTracker) crashed(name string, now time.Time) (delay time.Duration, restart bool, quarantine bool, count int) {
+	ct.mu.Lock()
+	defer ct.mu.Unlock()
+
+	rec, ok := ct.records[name]
+	if !ok {
+		rec = &crashRecord{}
+		ct.records[name] = rec
+	}
+
+	recent := []time.Time{}
+	for _, t := range rec.crashes {
+		if now.Sub(t) < crashWindow {
+			recent = append(recent, t)
+		}
+	}
+	rec.crashes = append(recent, now)
+	count = len(rec.crashes)
+
+	if count > crashMaxRestarts {
+		return 0, false, true, count
+	}
+	if rec.pending {
+		return 0, false, false, count
+	}
+
+	rec.pending = true
+	delay = crashBackoffBase
+	for i := 1; i < count && delay < crashBackoffMax; i++ {
+		delay = delay * 2
+	}
+	if delay > crashBackoffMax {
+		delay = crashBackoffMax
+	}
+	return delay, true, false, count
+}
+
+// restarting clears the pending restart of name
+func (ct *CrashTracker) restarting(name string) {
+	ct.mu.Lock()
+	defer ct.mu.Unlock()
+
+	if rec, ok := ct.records[name]; ok {
+		rec.pending = false
+	}
+}
+
+func (ct *CrashTracker) quarantine(name string, kind string, report CrashReport) error {
+	ct.mu.Lock()
+	defer ct.mu.Unlock()
+
+	rec, ok := ct.records[name]
+	if !ok {
+		rec = &crashRecord{}
+		ct.records[name] = rec
+	}
+	rec.pending = false
+	rec.quarantined = &report
+	rec.kind = kind
+
+	if ct.store != nil {
+		return ct.store.SaveQuarantined(name, quarantineRecord{Report: report, Type: kind, OldIP: report.oldIP})
+	}
+	return nil
+}
+
+// Quarantined returns the CrashReport of name if it is quarantined
+func (ct *CrashTracker) Quarantined(name string) (CrashReport, bool) {
+	ct.mu.Lock()
+	defer ct.mu.Unlock()
+
+	rec, ok := ct.records[name]
+	if !ok || rec.quarantined == nil {
+		return CrashReport{}, false
+	}
+	return *rec.quarantined, true
+}
+
+// QuarantinedNames lists the quarantined components with their databox type
+func (ct *CrashTracker) QuarantinedNames() map[string]string {
+	ct.mu.Lock()
+	defer ct.mu.Unlock()
+
+	names := map[string]string{}
+	for name, rec := range ct.records {
+		if rec.quarantined != nil {
+			names[name] = rec.kind
+		}
+	}
+	return names
+}
+
+// Reset forgets the crashes of name. If it was quarantined the CrashReport is returned.
+func (ct *CrashTracker) Reset(name string) (CrashReport, bool) {
+	ct.mu.Lock()
+	defer ct.mu.Unlock()
+
+	rec, ok := ct.records[name]
+	delete(ct.records, name)
+	if !ok || rec.quarantined == nil {
+		return CrashReport{}, false
+	}
+	if ct.store != nil {
+		err := ct.store.DeleteQuarantined(name)
+		if err != nil {
+			libDatabox.Err("Can't forget quarantine of " + name + " " + err.Error())
+		}
+	}
+	return *rec.quarantined, true
+}
+
+// handleCrash is called by crashDetectore when the container of the service name dies unexpectedly.
+// The service is restarted after a backoff or quarantined if it keeps crashing.
+func (cm ContainerManager) handleCrash(name string, containerID string, exitCode string) {
+
+	if _, ok := cm.Crashes.Quarantined(name); ok {
+		//its being scaled down
+		return
+	}
+
+	delay, restart, quarantine, count := cm.Crashes.crashed(name, time.Now())
+	libDatabox.Warn("Crash detected for " + name + " exit code " + exitCode + " (" + strconv.Itoa(count) + " in " + crashWindow.String() + ")")
+	cm.Journal.RecordCrash(name, exitCode, strconv.Itoa(count)+" crashes in "+crashWindow.String())
+
+	if quarantine {
+		go cm.quarantine(name, containerID, exitCode, count)
+		return
+	}
+
+	if !restart {
+		libDatabox.Debug("Not restarting " + name + " a restart is already waiting")
+		return
+	}
+
+	libDatabox.Warn("Restarting " + name + " in " + delay.String())
+	go func() {
+		time.Sleep(delay)
+		cm.Crashes.restarting(name)
+		if _, ok := cm.Crashes.Quarantined(name); ok {
+			return
+		}
+		err := cm.restart(name)
+		if err != nil {
+			libDatabox.Err("Failed to restart " + name + " after crash " + err.Error())
+			cm.Journal.RecordErr(EventRestartFailed, name, err, nil)
+			return
+		}
+		cm.Journal.Record(EventRestarted, name, "after crash", nil)
+	}()
+}
+
+// quarantine stops the service name from being restarted by scaling it to zero.
+// It stays stopped until a user restarts it.
+func (cm ContainerManager) quarantine(name string, containerID string, exitCode string, count int) {
+
+	report := CrashReport{
+		Reason:   "crashed " + strconv.Itoa(count) + " times in " + crashWindow.String(),
+		ExitCode: exitCode,
+		Crashes:  count,
+		Logs:     cm.lastLogLines(containerID, crashLogLines),
+		Time:     time.Now(),
+	}
+	if cont, err := cm.runningContainerFor(name); err == nil {
+		report.oldIP = cm.ipOnServiceNetwork(cont, name)
+	}
+	kind := ""
+	if service, err := cm.serviceByName(name); err == nil {
+		kind = service.Spec.Labels["databox.type"]
+	}
+
+	libDatabox.Err("Quarantining " + name + " it has " + report.Reason)
+
+	//record it first so the containers stopping are not seen as more crashes
+	err := cm.Crashes.quarantine(name, kind, report)
+	if err != nil {
+		libDatabox.Err("Can't save quarantine of " + name + " it will be restarted after a reboot " + err.Error())
+	}
+	cm.Journal.Record(EventQuarantined, name, report.Reason, nil)
+	err = cm.scaleService(name, 0)
+	if err != nil {
+		libDatabox.Err("Failed to stop " + name + " " + err.Error())
+	}
+}
+
+// releaseQuarantine starts a quarantined service again
+func (cm ContainerManager) releaseQuarantine(name string, report CrashReport) error {
+
+	libDatabox.Info("Releasing " + name + " from quarantine")
+
+	if _, err := cm.serviceByName(name); err != nil {
+		//quarantined before the CM last restarted so reloadApps never launched it
+		sla, err := cm.Store.GetSLA(name)
+		if err != nil {
+			return err
+		}
+		return cm.launchFromSLA(sla, false, nil)
+	}
+
+	return cm.scaleUp(name, report.oldIP)
+}
+
+// scaleUp starts a service that was scaled to zero and tells core-network
+// its new container replaces the one that had oldIP
+func (cm ContainerManager) scaleUp(name string, oldIP string) error {
+
+	err := cm.scaleService(name, 1)
+	if err != nil {
+		return err
+	}
+
+	newCont, err := cm.WaitForService(name, 20)
+	if err != nil {
+		return err
+	}
+
+	return cm.CoreNetworkClient.ServiceRestart(name, oldIP, cm.ipOnServiceNetwork(newCont, name))
+}
+
+// scaleService sets the number of replicas of the service name
+func (cm ContainerManager) scaleService(name string, replicas uint64) error {
+
+	service, err := cm.serviceByName(name)
+	if err != nil {
+		return err
+	}
+
+	spec := service.Spec
+	spec.Mode = swarm.ServiceMode{
+		Replicated: &swarm.ReplicatedService{Replicas: &replicas},
+	}
+	_, err = cm.cli.ServiceUpdate(context.Background(), service.ID, service.Version, spec, types.ServiceUpdateOptions{})
+	return err
+}
+
+// lastLogLines returns up to n lines from the end of the log of containerID
+func (cm ContainerManager) lastLogLines(containerID string, n int) []string {
+
+	logs, err := cm.cli.ContainerLogs(context.Background(), containerID, types.ContainerLogsOptions{
+		ShowStdout: true,
+		ShowStderr: true,
+		Tail:       strconv.Itoa(n),
+	})
+	if err != nil {
+		libDatabox.Warn("Can't get logs for " + containerID + " " + err.Error())
+		return []string{}
+	}
+	defer logs.Close()
+
+	var out bytes.Buffer
+	_, err = stdcopy.StdCopy(&out, &out, logs)
+	if err != nil {
+		libDatabox.Warn("Can't read logs for " + containerID + " " + err.Error())
+	}
+
+	lines := strings.Split(strings.TrimRight(out.String(), "\n"), "\n")
+	if len(lines) == 1 && lines[0] == "" {
+		return []string{}
+	}
+	return lines
+}
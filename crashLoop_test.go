@@ -0,0 +1,186 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	libDatabox "github.com/me-box/lib-go-databox"
+)
+
+func TestCrashTrackerBackoff(t *testing.T) {
+
+	defer func(base, max time.Duration, restarts int) {
+		crashBackoffBase, crashBackoffMax, crashMaxRestarts = base, max, restarts
+	}(crashBackoffBase, crashBackoffMax, crashMaxRestarts)
+	crashBackoffBase = time.Second
+	crashBackoffMax = 3 * time.Second
+	crashMaxRestarts = 3
+
+	start := time.Unix(1000, 0)
+	tests := []struct {
+		name           string
+		after          time.Duration
+		restarted      bool
+		wantDelay      time.Duration
+		wantRestart    bool
+		wantQuarantine bool
+		wantCount      int
+	}{
+		{name: "first crash", wantDelay: time.Second, wantRestart: true, wantCount: 1},
+		{name: "restart already waiting", after: time.Second, wantCount: 2},
+		{name: "doubles", after: 2 * time.Second, restarted: true, wantDelay: 3 * time.Second, wantRestart: true, wantCount: 3},
+		{name: "too many", after: 3 * time.Second, restarted: true, wantQuarantine: true, wantCount: 4},
+		{name: "old crashes forgotten", after: crashWindow + 4*time.Second, restarted: true, wantDelay: time.Second, wantRestart: true, wantCount: 1},
+	}
+
+	ct := NewCrashTracker(nil)
+	for _, tt := range tests {
+		if tt.restarted {
+			ct.restarting("app-crashing")
+		}
+		delay, restart, quarantine, count := ct.crashed("app-crashing", start.Add(tt.after))
+		if delay != tt.wantDelay || restart != tt.wantRestart || quarantine != tt.wantQuarantine || count != tt.wantCount {
+			t.Errorf("%s: crashed() = %v, %v, %v, %d want %v, %v, %v, %d", tt.name,
+				delay, restart, quarantine, count, tt.wantDelay, tt.wantRestart, tt.wantQuarantine, tt.wantCount)
+		}
+	}
+}
+
+func TestCrashQuarantine(t *testing.T) {
+
+	defer func(base time.Duration, restarts int) {
+		crashBackoffBase, crashMaxRestarts = base, restarts
+	}(crashBackoffBase, crashMaxRestarts)
+	crashBackoffBase = time.Millisecond
+	crashMaxRestarts = 2
+
+	td := newTestDatabox(t)
+	td.cm.Crashes = NewCrashTracker(td.cm.Store)
+	err := td.cm.LaunchFromSLA(td.testSLA("app-crashing", libDatabox.DataboxTypeApp), true, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go td.cm.crashDetectore()
+	waitFor(t, "the crash detector to listen for events", func() bool {
+		td.cli.mu.Lock()
+		defer td.cli.mu.Unlock()
+		return len(td.cli.subscribers) > 0
+	})
+
+	for crashes := 0; crashes <= crashMaxRestarts; {
+		conts := td.containers("app-crashing")
+		if len(conts) == 0 || td.cli.CrashContainer(conts[0].ID, 1) != nil {
+			//between containers while it is restarted
+			time.Sleep(10 * time.Millisecond)
+			continue
+		}
+		crashes++
+		waitFor(t, "app-crashing to be restarted or quarantined", func() bool {
+			_, quarantined := td.cm.Crashes.Quarantined("app-crashing")
+			restarted := td.containers("app-crashing")
+			return quarantined || len(restarted) == 1 && restarted[0].ID != conts[0].ID
+		})
+	}
+
+	waitFor(t, "app-crashing to be quarantined", func() bool {
+		_, quarantined := td.cm.Crashes.Quarantined("app-crashing")
+		return quarantined && td.replicas(t, "app-crashing") == 0
+	})
+	report, _ := td.cm.Crashes.Quarantined("app-crashing")
+	if report.Crashes != crashMaxRestarts+1 || report.ExitCode != "1" {
+		t.Errorf("report = %+v", report)
+	}
+
+	//the quarantine outlives the CM
+	reloaded := NewCrashTracker(td.cm.Store)
+	if _, quarantined := reloaded.Quarantined("app-crashing"); !quarantined {
+		t.Error("the quarantine was not saved")
+	}
+	td.cm.Crashes = reloaded
+	restarts := len(td.network.calls("/restart"))
+	err = td.cm.Restart("app-crashing", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if td.replicas(t, "app-crashing") != 1 {
+		t.Error("app-crashing was not scaled back up")
+	}
+	if len(td.network.calls("/restart")) != restarts+1 {
+		t.Error("core-network was not told about the new container of app-crashing")
+	}
+	if _, quarantined := NewCrashTracker(td.cm.Store).Quarantined("app-crashing"); quarantined {
+		t.Error("the saved quarantine was not removed")
+	}
+}
+
+func TestReloadAppsSkipsQuarantined(t *testing.T) {
+
+	td := newTestDatabox(t)
+	sla := td.testSLA("app-quarantined", libDatabox.DataboxTypeApp)
+	err := td.cm.Store.SaveSLA(sla)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = NewCrashTracker(td.cm.Store).quarantine("app-quarantined", "app", CrashReport{Crashes: 6})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	//as after a reboot
+	td.cm.Crashes = NewCrashTracker(td.cm.Store)
+	td.cm.reloadApps()
+
+	if td.service("app-quarantined") != nil {
+		t.Fatal("a quarantined app was started")
+	}
+
+	err = td.cm.Restart("app-quarantined", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(td.containers("app-quarantined")) != 1 {
+		t.Error("releasing the quarantine did not start app-quarantined")
+	}
+}
+
+// a quarantined component that fails to start again stays quarantined
+func TestReleaseQuarantineFails(t *testing.T) {
+
+	td := newTestDatabox(t)
+	sla := td.testSLA("app-quarantined", libDatabox.DataboxTypeApp)
+	sla.DockerImageTag = "missing"
+	err := td.cm.Store.SaveSLA(sla)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = NewCrashTracker(td.cm.Store).quarantine("app-quarantined", "app", CrashReport{Crashes: 6})
+	if err != nil {
+		t.Fatal(err)
+	}
+	td.cm.Crashes = NewCrashTracker(td.cm.Store)
+
+	err = td.cm.Restart("app-quarantined", nil)
+	if err == nil {
+		t.Fatal("app-quarantined was released without its image")
+	}
+	if report, quarantined := td.cm.Crashes.Quarantined("app-quarantined"); !quarantined || report.Crashes != 6 {
+		t.Errorf("quarantine after a failed release = %+v, %v", report, quarantined)
+	}
+	if _, quarantined := NewCrashTracker(td.cm.Store).Quarantined("app-quarantined"); !quarantined {
+		t.Error("the saved quarantine was removed")
+	}
+	if td.service("app-quarantined") != nil {
+		t.Error("the failed release left a service behind")
+	}
+}
+
+// waitFor polls done until it is true failing the test if that takes more than a few seconds
+func waitFor(t *testing.T, what string, done func() bool) {
+	for i := 0; i < 500; i++ {
+		if done() {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("timed out waiting for " + what)
+}
@@ -0,0 +1,237 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	b64 "encoding/base64"
+	"errors"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	libDatabox "github.com/me-box/lib-go-databox"
+	"golang.org/x/crypto/pbkdf2"
+)
+
+// passwordAlgorithm is the only hash PasswordHash supports so far
+const passwordAlgorithm = "pbkdf2-sha256"
+
+// passwordIterations is the PBKDF2 cost of newly hashed passwords
+const passwordIterations = 100000
+
+const passwordSaltSize = 16
+
+// passwordMinLength is the shortest password ChangePassword accepts
+const passwordMinLength = 8
+
+// initialPasswordFile is where a generated password is written, readable only by its owner,
+// so it can be used to log in the first time. It is removed when the password is changed.
+var initialPasswordFile = certsBasePath + "/dashboard-password"
+
+// errWrongPassword is returned by ChangePassword when the current password does not match
+var errWrongPassword = errors.New("The current password is wrong")
+
+// PasswordHash is a salted slow hash of the dashboard password with the parameters used to make it
+type PasswordHash struct {
+	Algorithm  string `json:"algorithm"`
+	Iterations int    `json:"iterations"`
+	Salt       string `json:"salt"`
+	Hash       string `json:"hash"`
+}
+
+// passwordChangeRequest is the body of a password change
+type passwordChangeRequest struct {
+	Current string `json:"current"`
+	New     string `json:"new"`
+}
+
+// newPasswordHash hashes password with a new random salt
+func newPasswordHash(password string) (PasswordHash, error) {
+
+	salt := make([]byte, passwordSaltSize)
+	_, err := rand.Read(salt)
+	if err != nil {
+		return PasswordHash{}, err
+	}
+
+	return PasswordHash{
+		Algorithm:  passwordAlgorithm,
+		Iterations: passwordIterations,
+		Salt:       b64.StdEncoding.EncodeToString(salt),
+		Hash:       b64.StdEncoding.EncodeToString(pbkdf2.Key([]byte(password), salt, passwordIterations, sha256.Size, sha256.New)),
+	}, nil
+}
+
+// Matches reports if password hashes to ph, the hashes are compared in constant time
+func (ph PasswordHash) Matches(password string) bool {
+
+	if ph.Algorithm != passwordAlgorithm || ph.Iterations <= 0 {
+		return false
+	}
+	salt, err := b64.StdEncoding.DecodeString(ph.Salt)
+	if err != nil {
+		return false
+	}
+	want, err := b64.StdEncoding.DecodeString(ph.Hash)
+	if err != nil || len(want) == 0 {
+		return false
+	}
+
+	got := pbkdf2.Key([]byte(password), salt, ph.Iterations, len(want), sha256.New)
+	return subtle.ConstantTimeCompare(got, want) == 1
+}
+
+// Credentials checks and changes the dashboard password. Only its hash is stored.
+type Credentials struct {
+	store *CMStore
+	//set from OverridePasword, the password can't be changed while it is
+	overridden bool
+
+	mu   sync.Mutex
+	hash PasswordHash
+	//the password if this process has seen it, for the mobile app QR code
+	plain string
+}
+
+// NewCredentials loads the dashboard password hash from store. A plain text password
+// saved by an older version is hashed and the plain text deleted. If there is no
+// password one is generated and written to initialPasswordFile, it is never logged.
+func NewCredentials(store *CMStore, override string) (*Credentials, error) {
+
+	c := &Credentials{store: store}
+
+	if override != "" {
+		libDatabox.Warn("OverridePasword used!")
+		hash, err := newPasswordHash(override)
+		if err != nil {
+			return nil, err
+		}
+		c.hash = hash
+		c.plain = override
+		c.overridden = true
+		return c, nil
+	}
+
+	libDatabox.Debug("Getting password hash from DB")
+	hash, err := store.LoadPasswordHash()
+	if err != nil {
+		return nil, errors.New("Can't load the dashboard password " + err.Error())
+	}
+	if hash.Hash != "" {
+		c.hash = hash
+		return c, nil
+	}
+
+	password, err := store.LoadPassword()
+	if err != nil {
+		return nil, errors.New("Can't load the dashboard password " + err.Error())
+	}
+
+	if password != "" {
+		libDatabox.Info("Replacing the plain text dashboard password with a salted hash")
+		err = c.set(password)
+		if err != nil {
+			return nil, err
+		}
+		err = store.DeletePassword()
+		if err != nil {
+			libDatabox.Err("Can't delete the plain text dashboard password " + err.Error())
+		}
+		return c, nil
+	}
+
+	libDatabox.Debug("Password not set genorating one")
+	password = genoratePassword()
+	err = c.set(password)
+	if err != nil {
+		return nil, err
+	}
+	err = writeFileAtomic(initialPasswordFile, []byte(password+"\n"), 0600)
+	if err != nil {
+		return nil, errors.New("Can't write the generated password to " + initialPasswordFile + " " + err.Error())
+	}
+	libDatabox.Info("Generated a dashboard password, it is in " + initialPasswordFile + " until it is changed")
+
+	return c, nil
+}
+
+// set hashes and saves password
+func (c *Credentials) set(password string) error {
+
+	hash, err := newPasswordHash(password)
+	if err != nil {
+		return err
+	}
+	err = c.store.SavePasswordHash(hash)
+	if err != nil {
+		return errors.New("Can't save the dashboard password " + err.Error())
+	}
+
+	c.mu.Lock()
+	c.hash = hash
+	c.plain = password
+	c.mu.Unlock()
+	return nil
+}
+
+// Check reports if password is the dashboard password
+func (c *Credentials) Check(password string) bool {
+
+	c.mu.Lock()
+	hash := c.hash
+	c.mu.Unlock()
+
+	return hash.Matches(password)
+}
+
+// CheckAuthHeader reports if header is "Token " followed by the dashboard password
+func (c *Credentials) CheckAuthHeader(header string) bool {
+	if !strings.HasPrefix(header, "Token ") {
+		return false
+	}
+	return c.Check(strings.TrimPrefix(header, "Token "))
+}
+
+// Plain returns the dashboard password if this process knows it, or blank
+func (c *Credentials) Plain() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.plain
+}
+
+// Change replaces the dashboard password if current is right
+func (c *Credentials) Change(current string, password string) error {
+
+	if c.overridden {
+		return errors.New("The password is set by OverridePasword and can't be changed")
+	}
+	if !c.Check(current) {
+		return errWrongPassword
+	}
+	if len(password) < passwordMinLength {
+		return errors.New("The new password must be at least " + strconv.Itoa(passwordMinLength) + " characters")
+	}
+
+	err := c.set(password)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(initialPasswordFile); err != nil && !os.IsNotExist(err) {
+		libDatabox.Warn("Can't remove " + initialPasswordFile + " " + err.Error())
+	}
+
+	libDatabox.Info("Dashboard password changed")
+	return nil
+}
+
+// genoratePassword makes a random dashboard password
+func genoratePassword() string {
+
+	b := make([]byte, 24)
+	rand.Read(b)
+	pass := b64.StdEncoding.EncodeToString(b)
+	return pass
+
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"crypto/sha256"
+	b64 "encoding/base64"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+// newCredentialStore returns a CMStore as the real one answers before anything is saved,
+// with the plain text password older versions saved set to legacy
+func newCredentialStore(legacy string) *CMStore {
+	store := &CMStore{Store: newMemoryKV(), Text: newMemoryKV()}
+	store.Store.Write(credentialStoreID, "dashboard", []byte{})
+	store.Text.Write(slaStoreID, "CMPassword", []byte(legacy))
+	return store
+}
+
+func TestPasswordHash(t *testing.T) {
+
+	hash, err := newPasswordHash("correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hash.Algorithm != passwordAlgorithm || hash.Iterations != passwordIterations {
+		t.Errorf("hash parameters = %s, %d want %s, %d", hash.Algorithm, hash.Iterations, passwordAlgorithm, passwordIterations)
+	}
+	if salt, err := b64.StdEncoding.DecodeString(hash.Salt); err != nil || len(salt) != passwordSaltSize {
+		t.Errorf("salt = %q, %v want %d bytes", hash.Salt, err, passwordSaltSize)
+	}
+	if sum, err := b64.StdEncoding.DecodeString(hash.Hash); err != nil || len(sum) != sha256.Size {
+		t.Errorf("hash = %q, %v want %d bytes", hash.Hash, err, sha256.Size)
+	}
+	if again, _ := newPasswordHash("correct horse"); again.Salt == hash.Salt || again.Hash == hash.Hash {
+		t.Error("the same password hashed twice got the same salt or hash")
+	}
+
+	tests := []struct {
+		name     string
+		hash     func(PasswordHash) PasswordHash
+		password string
+		want     bool
+	}{
+		{name: "right password", password: "correct horse", want: true},
+		{name: "wrong password", password: "correct horse!"},
+		{name: "blank password", password: ""},
+		{
+			name:     "fewer iterations",
+			hash:     func(h PasswordHash) PasswordHash { h.Iterations = 1; return h },
+			password: "correct horse",
+		},
+		{
+			name:     "unknown algorithm",
+			hash:     func(h PasswordHash) PasswordHash { h.Algorithm = "sha256"; return h },
+			password: "correct horse",
+		},
+		{
+			name:     "no hash",
+			hash:     func(h PasswordHash) PasswordHash { h.Hash = ""; return h },
+			password: "correct horse",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := hash
+			if tt.hash != nil {
+				h = tt.hash(h)
+			}
+			if got := h.Matches(tt.password); got != tt.want {
+				t.Errorf("Matches(%q) = %v, want %v", tt.password, got, tt.want)
+			}
+		})
+	}
+}
+
+// a plain text password saved by an older version is replaced with its hash
+func TestCredentialsMigration(t *testing.T) {
+
+	store := newCredentialStore("old plain text")
+
+	creds, err := NewCredentials(store, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if plain, _ := store.LoadPassword(); plain != "" {
+		t.Errorf("plain text password %q was left in the store", plain)
+	}
+	hash, err := store.LoadPasswordHash()
+	if err != nil || !hash.Matches("old plain text") {
+		t.Errorf("saved hash = %+v, %v does not match the old password", hash, err)
+	}
+	if !creds.Check("old plain text") {
+		t.Error("the old password was rejected")
+	}
+	if creds.Check("old plain text ") || creds.Check("") {
+		t.Error("a wrong password was accepted")
+	}
+
+	//after a restart only the hash is known
+	reloaded, err := NewCredentials(store, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reloaded.Check("old plain text") || reloaded.Plain() != "" {
+		t.Error("the hashed password did not survive a restart")
+	}
+}
+
+// a generated password is only in initialPasswordFile until it is changed
+func TestCredentialsGenerated(t *testing.T) {
+
+	defer os.Remove(initialPasswordFile)
+	store := newCredentialStore("")
+
+	creds, err := NewCredentials(store, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	info, err := os.Stat(initialPasswordFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("%s mode = %v, want 0600", initialPasswordFile, info.Mode().Perm())
+	}
+	contents, _ := ioutil.ReadFile(initialPasswordFile)
+	generated := strings.TrimSpace(string(contents))
+	if !creds.Check(generated) {
+		t.Fatal("the password in the file is not the dashboard password")
+	}
+
+	tests := []struct {
+		name     string
+		current  string
+		password string
+		wantErr  string
+	}{
+		{name: "wrong current password", current: "not it", password: "new password", wantErr: errWrongPassword.Error()},
+		{name: "too short", current: generated, password: "short", wantErr: "at least"},
+		{name: "changed", current: generated, password: "new password"},
+	}
+	for _, tt := range tests {
+		err := creds.Change(tt.current, tt.password)
+		if tt.wantErr == "" && err != nil || tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+			t.Errorf("%s: Change() = %v, want %q", tt.name, err, tt.wantErr)
+		}
+	}
+
+	if creds.Check(generated) || !creds.Check("new password") {
+		t.Error("the password was not changed")
+	}
+	if _, err := os.Stat(initialPasswordFile); !os.IsNotExist(err) {
+		t.Error("the generated password file was not removed when the password was changed")
+	}
+}
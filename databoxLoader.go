@@ -15,12 +15,11 @@ import (
 	"github.com/docker/docker/api/types/filters"
 	"github.com/docker/docker/api/types/network"
 	"github.com/docker/docker/api/types/swarm"
-	"github.com/docker/docker/client"
 	zmq "github.com/pebbe/zmq4"
 )
 
 type Databox struct {
-	cli                 *client.Client
+	cli                 Orchestrator
 	registry            string
 	DATABOX_ROOT_CA_ID  string
 	CM_KEY_ID           string
@@ -33,8 +32,7 @@ type Databox struct {
 	Options             *libDatabox.ContainerManagerOptions
 }
 
-func NewDataboxLoader(opt *libDatabox.ContainerManagerOptions) Databox {
-	cli, _ := client.NewEnvClient()
+func NewDataboxLoader(cli Orchestrator, opt *libDatabox.ContainerManagerOptions) Databox {
 	return Databox{
 		cli:     cli,
 		Options: opt,
@@ -56,19 +54,19 @@ func (d *Databox) Start() (string, string, string) {
 
 	//Create global secrets that are used in more than one container
 	libDatabox.Debug("Creating secrets")
-	d.DATABOX_ROOT_CA_ID = createSecretFromFileIfNotExists("DATABOX_ROOT_CA", "./certs/containerManagerPub.crt")
-	d.CM_KEY_ID = createSecretFromFileIfNotExists("CM_KEY", "./certs/arbiterToken-container-manager")
+	d.DATABOX_ROOT_CA_ID = createSecretFromFileIfNotExists(d.cli, "DATABOX_ROOT_CA", "./certs/containerManagerPub.crt")
+	d.CM_KEY_ID = createSecretFromFileIfNotExists(d.cli, "CM_KEY", "./certs/arbiterToken-container-manager")
 
-	d.DATABOX_ARBITER_ID = createSecretFromFileIfNotExists("DATABOX_ARBITER.pem", "./certs/arbiter.pem")
+	d.DATABOX_ARBITER_ID = createSecretFromFileIfNotExists(d.cli, "DATABOX_ARBITER.pem", "./certs/arbiter.pem")
 
-	d.DATABOX_PEM = createSecretFromFileIfNotExists("DATABOX.pem", "./certs/container-manager.pem")
-	d.DATABOX_NETWORK_KEY = createSecretFromFileIfNotExists("DATABOX_NETWORK_KEY", "./certs/arbiterToken-databox-network")
+	d.DATABOX_PEM = createSecretFromFileIfNotExists(d.cli, "DATABOX.pem", "./certs/container-manager.pem")
+	d.DATABOX_NETWORK_KEY = createSecretFromFileIfNotExists(d.cli, "DATABOX_NETWORK_KEY", "./certs/arbiterToken-databox-network")
 
 	//make ZMQ secrests
 	public, private, zmqErr := zmq.NewCurveKeypair()
 	libDatabox.ChkErrFatal(zmqErr)
-	d.ZMQ_PUBLIC_KEY_ID = createSecretIfNotExists("ZMQ_PUBLIC_KEY", public)
-	d.ZMQ_SECRET_KEY_ID = createSecretIfNotExists("ZMQ_SECRET_KEY", private)
+	d.ZMQ_PUBLIC_KEY_ID = createSecretIfNotExists(d.cli, "ZMQ_PUBLIC_KEY", public)
+	d.ZMQ_SECRET_KEY_ID = createSecretIfNotExists(d.cli, "ZMQ_SECRET_KEY", private)
 
 	//SET CM DNS, create secrets and join to databox-system-net will restart the ContainerManager if needed.
 	d.updateContainerManager(badRestartDetected)
@@ -80,8 +78,12 @@ func (d *Databox) Start() (string, string, string) {
 
 func (d Databox) getThisContainerID() string {
 	data, _ := ioutil.ReadFile("/proc/self/cgroup")
-	id := strings.Split(string(data), "/docker/")[3]
-	return id
+	parts := strings.Split(string(data), "/docker/")
+	if len(parts) < 4 {
+		//not running in a docker container
+		return ""
+	}
+	return parts[3]
 }
 
 func (d *Databox) checkForAndFixBadRestarts() bool {
@@ -95,7 +97,12 @@ func (d *Databox) checkForAndFixBadRestarts() bool {
 		libDatabox.Warn("Container manager starting up but we have old databox services.")
 		libDatabox.Warn("This was probably caused by a Container manager crash, host reboot, docker daemon restart")
 		libDatabox.Warn("Waiting for docker to settle......")
-		time.Sleep(time.Second * 30)
+		settled := waitUntil(time.Second*30, func() bool {
+			return d.servicesSettled(services)
+		})
+		if !settled {
+			libDatabox.Warn("Docker has not settled cleaning up anyway")
+		}
 		libDatabox.Warn("Starting to clean up......")
 		for _, service := range services {
 			if service.ID == cmServiceID {
@@ -109,8 +116,8 @@ func (d *Databox) checkForAndFixBadRestarts() bool {
 			libDatabox.ChkErr(err)
 		}
 
-		removeContainer("databox-network")
-		removeContainer("databox-network-relay")
+		removeContainer(d.cli, "databox-network")
+		removeContainer(d.cli, "databox-network-relay")
 
 		allNetworks, _ := d.cli.NetworkList(ctx, types.NetworkListOptions{Filters: f})
 		if len(allNetworks) > 0 {
@@ -126,7 +133,19 @@ func (d *Databox) checkForAndFixBadRestarts() bool {
 					}
 					d.cli.NetworkDisconnect(ctx, network.ID, connected.Name, true)
 				}
-				time.Sleep(time.Second * 15)
+				//wait for the disconnects to go through
+				waitUntil(time.Second*15, func() bool {
+					netInfo, err := d.cli.NetworkInspect(ctx, network.ID, types.NetworkInspectOptions{})
+					if err != nil {
+						return true
+					}
+					for _, connected := range netInfo.Containers {
+						if !strings.Contains(connected.Name, "container-manager.") {
+							return false
+						}
+					}
+					return true
+				})
 				d.cli.NetworkRemove(ctx, network.ID)
 			}
 		}
@@ -145,7 +164,13 @@ func (d *Databox) checkForAndFixBadRestarts() bool {
 		}
 
 		libDatabox.Warn("Waiting for docker to recvover ......")
-		time.Sleep(time.Second * 30)
+		recovered := waitUntil(time.Second*30, func() bool {
+			remaining, err := d.cli.ServiceList(ctx, types.ServiceListOptions{Filters: f})
+			return err == nil && len(remaining) <= 1
+		})
+		if !recovered {
+			libDatabox.Warn("Old databox services are still being removed")
+		}
 
 		return true
 	}
@@ -153,6 +178,26 @@ func (d *Databox) checkForAndFixBadRestarts() bool {
 	return false
 }
 
+// servicesSettled reports if none of the tasks of services are still being scheduled or started
+func (d *Databox) servicesSettled(services []swarm.Service) bool {
+	for _, service := range services {
+		taskFilters := filters.NewArgs()
+		taskFilters.Add("service", service.ID)
+		tasks, err := d.cli.TaskList(context.Background(), types.TaskListOptions{Filters: taskFilters})
+		if err != nil {
+			return false
+		}
+		for _, t := range tasks {
+			switch t.Status.State {
+			case swarm.TaskStateNew, swarm.TaskStateAllocated, swarm.TaskStatePending, swarm.TaskStateAssigned,
+				swarm.TaskStateAccepted, swarm.TaskStatePreparing, swarm.TaskStateReady, swarm.TaskStateStarting:
+				return false
+			}
+		}
+	}
+	return true
+}
+
 func (d *Databox) getDNSIP() (string, error) {
 
 	filters := filters.NewArgs()
@@ -241,22 +286,22 @@ func (d *Databox) startCoreNetwork() {
 	}
 	containerName := "databox-network"
 
-	removeContainer(containerName)
+	removeContainer(d.cli, containerName)
 
-	pullImageIfRequired(config.Image, d.Options.DefaultRegistry, d.Options.DefaultRegistryHost)
+	pullImageIfRequired(d.cli, config.Image, d.Options.DefaultRegistry, d.Options.DefaultRegistryHost)
 
 	containerCreateCreatedBody, ccErr := d.cli.ContainerCreate(ctx, config, hostConfig, networkingConfig, containerName)
 	libDatabox.ChkErrFatal(ccErr)
 
 	f, err := os.Open("/certs/arbiterToken-databox-network")
 	libDatabox.ChkErr(err)
-	err = copyFileToContainer("/run/secrets/DATABOX_NETWORK_KEY", f, containerCreateCreatedBody.ID)
+	err = copyFileToContainer(d.cli, "/run/secrets/DATABOX_NETWORK_KEY", f, containerCreateCreatedBody.ID)
 	f.Close()
 	libDatabox.ChkErr(err)
 
 	f, _ = os.Open("/certs/databox-network.pem")
 	libDatabox.ChkErr(err)
-	err = copyFileToContainer("/run/secrets/DATABOX_NETWORK.pem", f, containerCreateCreatedBody.ID)
+	err = copyFileToContainer(d.cli, "/run/secrets/DATABOX_NETWORK.pem", f, containerCreateCreatedBody.ID)
 	f.Close()
 	libDatabox.ChkErr(err)
 
@@ -289,9 +334,9 @@ func (d *Databox) startCoreNetworkRelay() {
 
 	containerName := "databox-broadcast-relay"
 
-	removeContainer(containerName)
+	removeContainer(d.cli, containerName)
 
-	pullImageIfRequired(config.Image, d.Options.DefaultRegistry, d.Options.DefaultRegistryHost)
+	pullImageIfRequired(d.cli, config.Image, d.Options.DefaultRegistry, d.Options.DefaultRegistryHost)
 
 	containerCreateCreatedBody, err := d.cli.ContainerCreate(context.Background(), config, hostConfig, &network.NetworkingConfig{}, containerName)
 	libDatabox.ChkErrFatal(err)
@@ -335,30 +380,12 @@ func (d *Databox) updateContainerManager(badRestartDetected bool) {
 			Target: "databox-system-net",
 		},
 	}
+	//the root CA may be a renewed copy called DATABOX_ROOT_CA.<time>
 	swarmService[0].Spec.TaskTemplate.ContainerSpec.Secrets = append(
 		swarmService[0].Spec.TaskTemplate.ContainerSpec.Secrets,
-		&swarm.SecretReference{
-			SecretID:   d.ZMQ_PUBLIC_KEY_ID,
-			SecretName: "ZMQ_PUBLIC_KEY",
-			File: &swarm.SecretReferenceFileTarget{
-				Name: "ZMQ_PUBLIC_KEY",
-				UID:  "0",
-				GID:  "0",
-				Mode: 0444,
-			},
-		})
-	swarmService[0].Spec.TaskTemplate.ContainerSpec.Secrets = append(
-		swarmService[0].Spec.TaskTemplate.ContainerSpec.Secrets,
-		&swarm.SecretReference{
-			SecretID:   d.DATABOX_ROOT_CA_ID,
-			SecretName: "DATABOX_ROOT_CA",
-			File: &swarm.SecretReferenceFileTarget{
-				Name: "DATABOX_ROOT_CA",
-				UID:  "0",
-				GID:  "0",
-				Mode: 0444,
-			},
-		})
+		newestSecretRef(d.cli, "ZMQ_PUBLIC_KEY", d.ZMQ_PUBLIC_KEY_ID),
+		newestSecretRef(d.cli, rootCASecret, d.DATABOX_ROOT_CA_ID),
+	)
 
 	swarmService[0].Spec.TaskTemplate.ContainerSpec.Env = append(swarmService[0].Spec.TaskTemplate.ContainerSpec.Env, "DATABOX_DNS_IP="+d.DATABOX_DNS_IP)
 
@@ -418,7 +445,7 @@ func (d *Databox) startArbiter() {
 
 	serviceOptions := types.ServiceCreateOptions{}
 
-	pullImageIfRequired(service.TaskTemplate.ContainerSpec.Image, d.Options.DefaultRegistry, d.Options.DefaultRegistryHost)
+	pullImageIfRequired(d.cli, service.TaskTemplate.ContainerSpec.Image, d.Options.DefaultRegistry, d.Options.DefaultRegistryHost)
 
 	_, err := d.cli.ServiceCreate(context.Background(), service, serviceOptions)
 	libDatabox.ChkErrFatal(err)
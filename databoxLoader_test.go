@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/docker/docker/api/types"
+	libDatabox "github.com/me-box/lib-go-databox"
+)
+
+func TestCheckForAndFixBadRestarts(t *testing.T) {
+
+	tests := []struct {
+		name        string
+		leftOver    []string
+		wantRestart bool
+	}{
+		{name: "clean start", leftOver: []string{}},
+		{name: "one service", leftOver: []string{"arbiter"}},
+		{name: "old services", leftOver: []string{"arbiter", "app-old", "driver-old"}, wantRestart: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			td := newTestDatabox(t)
+			for _, name := range tt.leftOver {
+				td.cli.AddImage("databoxsystems/" + name + ":" + testVersion)
+				spec := constructDefaultServiceSpec(name, "databoxsystems/"+name+":"+testVersion, libDatabox.DataboxTypeApp, testVersion, NetworkConfig{NetworkName: name + "-network"})
+				spec.Name = name
+				spec.TaskTemplate.ContainerSpec.Secrets = td.cm.genorateSecrets(name, libDatabox.DataboxTypeApp)
+				_, err := td.cli.NetworkCreate(context.Background(), name+"-network", types.NetworkCreate{
+					Labels: map[string]string{"databox.type": "databox-network"},
+				})
+				if err != nil {
+					t.Fatal(err)
+				}
+				_, err = td.cli.ServiceCreate(context.Background(), spec, types.ServiceCreateOptions{})
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			d := NewDataboxLoader(td.cli, td.cm.Options)
+			restarted := d.checkForAndFixBadRestarts()
+
+			if restarted != tt.wantRestart {
+				t.Errorf("bad restart detected = %v, want %v", restarted, tt.wantRestart)
+			}
+
+			services, _ := td.cli.ServiceList(context.Background(), types.ServiceListOptions{})
+			wantServices := len(tt.leftOver)
+			if tt.wantRestart {
+				wantServices = 0
+			}
+			if len(services) != wantServices {
+				t.Errorf("%d services left, want %d", len(services), wantServices)
+			}
+
+			for _, name := range tt.leftOver {
+				if gone := !td.networkExists(name + "-network"); gone != tt.wantRestart {
+					t.Errorf("network of %s removed = %v", name, gone)
+				}
+				if gone := !td.secretExists(strings.ToUpper(name) + "_KEY"); gone != tt.wantRestart {
+					t.Errorf("secrets of %s removed = %v", name, gone)
+				}
+			}
+			if !td.secretExists("ZMQ_PUBLIC_KEY") {
+				t.Error("the ZMQ keys should be kept")
+			}
+		})
+	}
+}
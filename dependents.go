@@ -0,0 +1,180 @@
+package main
+
+import (
+	"errors"
+	"net/url"
+	"sort"
+	"strings"
+
+	libDatabox "github.com/me-box/lib-go-databox"
+)
+
+// DatasourceRef is a datasource an installed component has asked for in its SLA
+type DatasourceRef struct {
+	Consumer string `json:"consumer"`
+	Clientid string `json:"clientid"`
+	Href     string `json:"href"`
+	Store    string `json:"store"`
+	//the component the store belongs to, blank if it is not installed
+	Owner string `json:"owner,omitempty"`
+}
+
+// consumersRequest asks who reads a datasource, the datasources in a store or
+// the datasources in any store belonging to a component. The first set is used.
+type consumersRequest struct {
+	Href      string `json:"href"`
+	Store     string `json:"store"`
+	Component string `json:"component"`
+}
+
+// consumerIndex indexes the datasources in a set of SLAs by href, store and consumer
+type consumerIndex struct {
+	byHref      map[string][]DatasourceRef
+	byStore     map[string][]DatasourceRef
+	byConsumer  map[string][]DatasourceRef
+	storeOwners map[string]string
+}
+
+// newConsumerIndex indexes the datasources of every SLA in slaList
+func newConsumerIndex(slaList []libDatabox.SLA) consumerIndex {
+
+	ci := consumerIndex{
+		byHref:      map[string][]DatasourceRef{},
+		byStore:     map[string][]DatasourceRef{},
+		byConsumer:  map[string][]DatasourceRef{},
+		storeOwners: map[string]string{},
+	}
+
+	for _, sla := range slaList {
+		if sla.ResourceRequirements.Store != "" {
+			ci.storeOwners[sla.Name+"-"+sla.ResourceRequirements.Store] = sla.Name
+		}
+	}
+
+	for _, sla := range slaList {
+		for _, ds := range sla.Datasources {
+			ref := DatasourceRef{
+				Consumer: sla.Name,
+				Clientid: ds.Clientid,
+				Href:     ds.Hypercat.Href,
+			}
+			if href, err := url.Parse(ds.Hypercat.Href); err == nil {
+				ref.Store = href.Hostname()
+			}
+			ref.Owner = ci.storeOwners[ref.Store]
+
+			ci.byHref[ref.Href] = append(ci.byHref[ref.Href], ref)
+			ci.byConsumer[ref.Consumer] = append(ci.byConsumer[ref.Consumer], ref)
+			if ref.Store != "" {
+				ci.byStore[ref.Store] = append(ci.byStore[ref.Store], ref)
+			}
+		}
+	}
+
+	return ci
+}
+
+// consumers answers a consumersRequest
+func (ci consumerIndex) consumers(req consumersRequest) []DatasourceRef {
+	res := []DatasourceRef{}
+	switch {
+	case req.Href != "":
+		res = append(res, ci.byHref[req.Href]...)
+	case req.Store != "":
+		res = append(res, ci.byStore[req.Store]...)
+	case req.Component != "":
+		for store, owner := range ci.storeOwners {
+			if owner != req.Component {
+				continue
+			}
+			for _, ref := range ci.byStore[store] {
+				if ref.Consumer != req.Component {
+					res = append(res, ref)
+				}
+			}
+		}
+	}
+	sortRefs(res)
+	return res
+}
+
+// dependencies returns the datasources name reads
+func (ci consumerIndex) dependencies(name string) []DatasourceRef {
+	res := append([]DatasourceRef{}, ci.byConsumer[name]...)
+	sortRefs(res)
+	return res
+}
+
+// dependents returns the other components that read from a store belonging to name
+func (ci consumerIndex) dependents(name string) []string {
+	seen := map[string]bool{}
+	res := []string{}
+	for _, ref := range ci.consumers(consumersRequest{Component: name}) {
+		if !seen[ref.Consumer] {
+			seen[ref.Consumer] = true
+			res = append(res, ref.Consumer)
+		}
+	}
+	sort.Strings(res)
+	return res
+}
+
+// dependsOn returns the other components whose stores name reads from. It is
+// the inverse of dependents.
+func (ci consumerIndex) dependsOn(name string) []string {
+	seen := map[string]bool{}
+	res := []string{}
+	for _, ref := range ci.byConsumer[name] {
+		if ref.Owner == "" || ref.Owner == name || seen[ref.Owner] {
+			continue
+		}
+		seen[ref.Owner] = true
+		res = append(res, ref.Owner)
+	}
+	sort.Strings(res)
+	return res
+}
+
+func sortRefs(refs []DatasourceRef) {
+	sort.Slice(refs, func(a, b int) bool {
+		if refs[a].Consumer != refs[b].Consumer {
+			return refs[a].Consumer < refs[b].Consumer
+		}
+		return refs[a].Href < refs[b].Href
+	})
+}
+
+// consumerIndex indexes the saved SLAs of every installed app and driver
+func (cm ContainerManager) consumerIndex() (consumerIndex, error) {
+	slaList, err := cm.Store.GetAllSLAs()
+	if err != nil {
+		return consumerIndex{}, errors.New("Can't read saved SLAs " + err.Error())
+	}
+	return newConsumerIndex(slaList), nil
+}
+
+// checkDependents returns an error listing the installed components that read from
+// the stores of name. If force is set they, or a failure to find them, are only logged.
+func (cm ContainerManager) checkDependents(name string, force bool) ([]string, error) {
+
+	ci, err := cm.consumerIndex()
+	if err != nil && force {
+		libDatabox.Warn("Forcing uninstall of " + name + " without checking what uses it. " + err.Error())
+		return []string{}, nil
+	}
+	if err != nil {
+		return []string{}, err
+	}
+
+	dependents := ci.dependents(name)
+	if len(dependents) == 0 {
+		return dependents, nil
+	}
+
+	msg := name + " is used by " + strings.Join(dependents, ", ")
+	if !force {
+		return dependents, errors.New(msg + ", uninstall them first or force the uninstall")
+	}
+	libDatabox.Warn("Forcing uninstall, " + msg)
+	return dependents, nil
+}
@@ -11,16 +11,14 @@ import (
 	"github.com/docker/docker/api/types"
 	"github.com/docker/docker/api/types/filters"
 	"github.com/docker/docker/api/types/swarm"
-	"github.com/docker/docker/client"
 	libDatabox "github.com/me-box/lib-go-databox"
 )
 
 //pullImageIfRequired will try and pull the image form DefaultRegistry if it dose not exist locally.
 // If the image is taged latest then it will allways attempt to pull the image.
-func pullImageIfRequired(image string, DefaultRegistry string, DefaultRegistryHost string) {
+func pullImageIfRequired(cli Orchestrator, image string, DefaultRegistry string, DefaultRegistryHost string) {
 	needToPull := true
 	ctx := context.Background()
-	cli, _ := client.NewEnvClient()
 
 	//do we have the image on disk?
 	images, _ := cli.ImageList(ctx, types.ImageListOptions{})
@@ -55,9 +53,8 @@ func pullImageIfRequired(image string, DefaultRegistry string, DefaultRegistryHo
 
 // copyFileToContainer copies a single file of any format to the target container
 // dockers CopyToContainer only works with tar archives.
-func copyFileToContainer(targetFullPath string, fileReader io.Reader, containerID string) error {
+func copyFileToContainer(cli Orchestrator, targetFullPath string, fileReader io.Reader, containerID string) error {
 
-	cli, _ := client.NewEnvClient()
 	ctx := context.Background()
 
 	fileBody, _ := ioutil.ReadAll(fileReader)
@@ -86,17 +83,13 @@ func copyFileToContainer(targetFullPath string, fileReader io.Reader, containerI
 	return nil
 }
 
-func createSecretIfNotExists(name, data string) string {
+func createSecretIfNotExists(cli Orchestrator, name, data string) string {
 
-	cli, _ := client.NewEnvClient()
 	ctx := context.Background()
 
-	filters := filters.NewArgs()
-	filters.Add("name", name)
-	secrestsList, _ := cli.SecretList(ctx, types.SecretListOptions{Filters: filters})
-	if len(secrestsList) > 0 {
+	if s, ok := newestSecret(cli, name); ok {
 		//we have made this before just return the ID
-		return secrestsList[0].ID
+		return s.ID
 	}
 
 	secret := swarm.SecretSpec{
@@ -112,16 +105,59 @@ func createSecretIfNotExists(name, data string) string {
 	return secretCreateResponse.ID
 }
 
-func createSecretFromFileIfNotExists(name, dataPath string) string {
+// newestSecret returns the newest version of the secret first created as base. Docker matches
+// names by prefix so the name of each is checked, renewed copies made by rotateSecret are
+// called base.<time> and carry base in their label.
+func newestSecret(cli Orchestrator, base string) (swarm.Secret, bool) {
+
+	secFilters := filters.NewArgs()
+	secFilters.Add("name", base)
+	secList, _ := cli.SecretList(context.Background(), types.SecretListOptions{Filters: secFilters})
+
+	var newest swarm.Secret
+	found := false
+	for _, s := range secList {
+		if secretBase(s) != base {
+			continue
+		}
+		if !found || s.CreatedAt.After(newest.CreatedAt) {
+			newest = s
+			found = true
+		}
+	}
+	return newest, found
+}
+
+// newestSecretRef mounts the newest version of the secret base as the file base. id is
+// used if base can't be found, it is the version made at start up which may since have been renewed.
+func newestSecretRef(cli Orchestrator, base string, id string) *swarm.SecretReference {
+
+	ref := &swarm.SecretReference{
+		SecretID:   id,
+		SecretName: base,
+		File: &swarm.SecretReferenceFileTarget{
+			Name: base,
+			UID:  "0",
+			GID:  "0",
+			Mode: 0444,
+		},
+	}
+	if s, ok := newestSecret(cli, base); ok {
+		ref.SecretID = s.ID
+		ref.SecretName = s.Spec.Name
+	}
+	return ref
+}
+
+func createSecretFromFileIfNotExists(cli Orchestrator, name, dataPath string) string {
 
 	data, _ := ioutil.ReadFile(dataPath)
 
-	return createSecretIfNotExists(name, string(data))
+	return createSecretIfNotExists(cli, name, string(data))
 }
 
-func removeContainer(name string) {
+func removeContainer(cli Orchestrator, name string) {
 
-	cli, _ := client.NewEnvClient()
 	ctx := context.Background()
 
 	filters := filters.NewArgs()
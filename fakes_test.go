@@ -0,0 +1,328 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/api/types/swarm"
+	libDatabox "github.com/me-box/lib-go-databox"
+)
+
+// testVersion is the image tag used for everything the tests install
+const testVersion = "0.5.2"
+
+// memoryKV is a KeyValueStore held in memory
+type memoryKV struct {
+	mu   sync.Mutex
+	data map[string]map[string][]byte
+}
+
+func newMemoryKV() *memoryKV {
+	return &memoryKV{data: map[string]map[string][]byte{}}
+}
+
+func (kv *memoryKV) Write(dataSourceID string, key string, payload []byte) error {
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+	if kv.data[dataSourceID] == nil {
+		kv.data[dataSourceID] = map[string][]byte{}
+	}
+	kv.data[dataSourceID][key] = append([]byte{}, payload...)
+	return nil
+}
+
+func (kv *memoryKV) Read(dataSourceID string, key string) ([]byte, error) {
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+	payload, ok := kv.data[dataSourceID][key]
+	if !ok {
+		return nil, errors.New(key + " not found in " + dataSourceID)
+	}
+	return payload, nil
+}
+
+func (kv *memoryKV) ListKeys(dataSourceID string) ([]string, error) {
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+	keys := []string{}
+	for k := range kv.data[dataSourceID] {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+func (kv *memoryKV) Delete(dataSourceID string, key string) error {
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+	delete(kv.data[dataSourceID], key)
+	return nil
+}
+
+func (kv *memoryKV) DeleteAll(dataSourceID string) error {
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+	delete(kv.data, dataSourceID)
+	return nil
+}
+
+// fakeArbiter records the components and permissions the container manager gives the arbiter
+type fakeArbiter struct {
+	mu          sync.Mutex
+	components  map[string]libDatabox.DataboxType
+	permissions map[string][]libDatabox.ContainerPermissions
+}
+
+func newFakeArbiter() *fakeArbiter {
+	return &fakeArbiter{
+		components:  map[string]libDatabox.DataboxType{},
+		permissions: map[string][]libDatabox.ContainerPermissions{},
+	}
+}
+
+func (a *fakeArbiter) RegesterDataboxComponent(name string, token string, databoxType libDatabox.DataboxType) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.components[name] = databoxType
+	return nil
+}
+
+func (a *fakeArbiter) RemoveDataboxComponent(name string) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	delete(a.components, name)
+	return nil
+}
+
+func (a *fakeArbiter) GrantContainerPermissions(permissions libDatabox.ContainerPermissions) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.permissions[permissions.Name] = append(a.permissions[permissions.Name], permissions)
+	return nil
+}
+
+func (a *fakeArbiter) RevokeContainerPermissions(permissions libDatabox.ContainerPermissions) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	kept := []libDatabox.ContainerPermissions{}
+	for _, p := range a.permissions[permissions.Name] {
+		if p != permissions {
+			kept = append(kept, p)
+		}
+	}
+	a.permissions[permissions.Name] = kept
+	return nil
+}
+
+func (a *fakeArbiter) registered(name string) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	_, ok := a.components[name]
+	return ok
+}
+
+func (a *fakeArbiter) granted(name string) int {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return len(a.permissions[name])
+}
+
+func (a *fakeArbiter) hasPermission(perm libDatabox.ContainerPermissions) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for _, p := range a.permissions[perm.Name] {
+		if p == perm {
+			return true
+		}
+	}
+	return false
+}
+
+// fakeStoreCatalogue stands in for askStoreCatalogue. Like a real store it refuses to
+// give the container manager its catalogue until the arbiter lets it read /cat.
+func fakeStoreCatalogue(cm ContainerManager, storeURL string, timeout time.Duration) error {
+	store := strings.TrimSuffix(strings.TrimPrefix(storeURL, "tcp://"), ":5555")
+	if a, ok := cm.Arbiter.(*fakeArbiter); ok && !a.hasPermission(newPermission("container-manager", store, "/cat", "GET", "")) {
+		return errors.New("container-manager may not read /cat on " + store)
+	}
+	return nil
+}
+
+// fakeNetwork answers the HTTPS requests the container manager makes, core-network calls are
+// recorded and every app and driver reports it is ready on /status
+type fakeNetwork struct {
+	mu    sync.Mutex
+	posts map[string][]string
+}
+
+func newFakeNetwork() *fakeNetwork {
+	return &fakeNetwork{posts: map[string][]string{}}
+}
+
+func (n *fakeNetwork) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.URL.Host == "databox-network:8080" {
+		body := []byte{}
+		if req.Body != nil {
+			body, _ = ioutil.ReadAll(req.Body)
+		}
+		n.mu.Lock()
+		n.posts[req.URL.Path] = append(n.posts[req.URL.Path], string(body))
+		n.mu.Unlock()
+	}
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		Body:       ioutil.NopCloser(bytes.NewReader([]byte("active"))),
+		Request:    req,
+	}, nil
+}
+
+func (n *fakeNetwork) calls(path string) []string {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return append([]string{}, n.posts[path]...)
+}
+
+// testDatabox is a ContainerManager wired to in-memory fakes of docker, the arbiter,
+// core-network and the CM store
+type testDatabox struct {
+	cm      ContainerManager
+	cli     *FakeOrchestrator
+	arbiter *fakeArbiter
+	network *fakeNetwork
+	kv      *memoryKV
+}
+
+func newTestDatabox(t *testing.T) *testDatabox {
+
+	cli := NewFakeOrchestrator()
+	arbiter := newFakeArbiter()
+	network := newFakeNetwork()
+	kv := newMemoryKV()
+	request := &http.Client{Transport: network}
+
+	//core-network has to be running for PreConfig to attach it to new networks
+	cli.AddImage("databoxsystems/core-network:" + testVersion)
+	cont, err := cli.ContainerCreate(context.Background(), &container.Config{Image: "databoxsystems/core-network:" + testVersion}, nil, nil, "databox-network")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = cli.ContainerStart(context.Background(), cont.ID, types.ContainerStartOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cli.AddImage("databoxsystems/core-store:" + testVersion)
+
+	cm := ContainerManager{
+		cli:                cli,
+		Arbiter:            arbiter,
+		CoreNetworkClient:  &CoreNetworkClient{cli: cli, request: request},
+		Request:            request,
+		DATABOX_ROOT_CA_ID: createSecretIfNotExists(cli, "DATABOX_ROOT_CA", "root-ca"),
+		ZMQ_PUBLIC_KEY_ID:  createSecretIfNotExists(cli, "ZMQ_PUBLIC_KEY", "public"),
+		ZMQ_PRIVATE_KEY_ID: createSecretIfNotExists(cli, "ZMQ_SECRET_KEY", "private"),
+		Store:              &CMStore{Store: kv, Text: newMemoryKV()},
+		Options: &libDatabox.ContainerManagerOptions{
+			Version:             testVersion,
+			DefaultRegistry:     "databoxsystems",
+			DefaultRegistryHost: "docker.io",
+			DefaultStoreImage:   "databoxsystems/core-store:" + testVersion,
+		},
+		AppStoreName:        "app-store",
+		CoreIUName:          "core-ui",
+		CoreStoreName:       "core-store",
+		InstalledComponents: NewInstalledSet(),
+		Jobs:                NewJobTracker(nil),
+		Crashes:             NewCrashTracker(nil),
+		Stopped:             NewStopTracker(nil),
+		Updating:            NewUpdateTracker(),
+		Budget:              NewResourceBudget(),
+	}
+
+	return &testDatabox{cm: cm, cli: cli, arbiter: arbiter, network: network, kv: kv}
+}
+
+// testSLA returns the SLA of an app or driver called name and makes its image available.
+// A driver gets a store, an app reads one datasource from each of the drivers in reads.
+func (td *testDatabox) testSLA(name string, databoxType libDatabox.DataboxType, reads ...string) libDatabox.SLA {
+
+	td.cli.AddImage("databoxsystems/" + name + ":" + testVersion)
+
+	sla := libDatabox.SLA{
+		Name:        name,
+		DataboxType: databoxType,
+	}
+	if databoxType == libDatabox.DataboxTypeDriver {
+		sla.ResourceRequirements.Store = "core-store"
+	}
+	for i, driver := range reads {
+		sla.Datasources = append(sla.Datasources, libDatabox.DataSource{
+			Name:     "data" + strconv.Itoa(i),
+			Clientid: "DATA_" + strconv.Itoa(i),
+			Hypercat: libDatabox.HypercatItem{Href: "tcp://" + driver + "-core-store:5555/kv/data"},
+		})
+	}
+	return sla
+}
+
+// service returns the service called name or nil if there is not one
+func (td *testDatabox) service(name string) *swarm.Service {
+	service, err := td.cm.serviceByName(name)
+	if err != nil {
+		return nil
+	}
+	return &service
+}
+
+// containers returns the running containers of the service called name
+func (td *testDatabox) containers(name string) []types.Container {
+	f := filters.NewArgs()
+	f.Add("label", "com.docker.swarm.service.name="+name)
+	list, _ := td.cli.ContainerList(context.Background(), types.ContainerListOptions{Filters: f})
+	return list
+}
+
+// replicas returns how many replicas the service called name wants
+func (td *testDatabox) replicas(t *testing.T, name string) uint64 {
+	service := td.service(name)
+	if service == nil {
+		t.Fatal("no service " + name)
+	}
+	if service.Spec.Mode.Replicated == nil || service.Spec.Mode.Replicated.Replicas == nil {
+		return 1
+	}
+	return *service.Spec.Mode.Replicated.Replicas
+}
+
+// secretExists reports if there is a secret called name
+func (td *testDatabox) secretExists(name string) bool {
+	list, _ := td.cli.SecretList(context.Background(), types.SecretListOptions{})
+	for _, s := range list {
+		if s.Spec.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// networkExists reports if there is a network called name
+func (td *testDatabox) networkExists(name string) bool {
+	list, _ := td.cli.NetworkList(context.Background(), types.NetworkListOptions{})
+	for _, n := range list {
+		if n.Name == name {
+			return true
+		}
+	}
+	return false
+}
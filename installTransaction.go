@@ -0,0 +1,53 @@
+package main
+
+import (
+	libDatabox "github.com/me-box/lib-go-databox"
+)
+
+// installTransaction records how to undo each side effect of an install
+// so a failed install can leave the system as it found it.
+type installTransaction struct {
+	name  string
+	verb  string
+	steps []installStep
+}
+
+type installStep struct {
+	description string
+	undo        func() error
+}
+
+func newInstallTransaction(name string) *installTransaction {
+	return &installTransaction{name: name, verb: "Install"}
+}
+
+// newUpgradeTransaction is an installTransaction for the side effects of an upgrade
+func newUpgradeTransaction(name string) *installTransaction {
+	return &installTransaction{name: name, verb: "Upgrade"}
+}
+
+// onRollback registers undo to be called if the install fails.
+// Steps are undone in the reverse order they were registered.
+func (tx *installTransaction) onRollback(description string, undo func() error) {
+	tx.steps = append(tx.steps, installStep{description: description, undo: undo})
+}
+
+// commit forgets the registered steps so a later failure does not undo them
+func (tx *installTransaction) commit() {
+	tx.steps = nil
+}
+
+// rollback undoes every registered step, newest first. Failures are logged
+// and do not stop the remaining steps from being undone.
+func (tx *installTransaction) rollback() {
+	libDatabox.Warn(tx.verb + " of " + tx.name + " failed rolling back")
+	for i := len(tx.steps) - 1; i >= 0; i-- {
+		step := tx.steps[i]
+		libDatabox.Debug("[rollback] " + tx.name + " " + step.description)
+		err := step.undo()
+		if err != nil {
+			libDatabox.Err("[rollback] " + tx.name + " failed to " + step.description + " " + err.Error())
+		}
+	}
+	tx.steps = nil
+}
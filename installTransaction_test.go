@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types/filters"
+	libDatabox "github.com/me-box/lib-go-databox"
+)
+
+func TestInstallTransactionRollback(t *testing.T) {
+
+	undone := []string{}
+	tx := newInstallTransaction("app-tx")
+	for _, step := range []string{"network", "secrets", "store"} {
+		step := step
+		tx.onRollback(step, func() error {
+			undone = append(undone, step)
+			if step == "secrets" {
+				return errors.New("failed to remove secrets")
+			}
+			return nil
+		})
+	}
+
+	tx.rollback()
+	tx.rollback()
+
+	want := []string{"store", "secrets", "network"}
+	if !reflect.DeepEqual(undone, want) {
+		t.Errorf("undone %v, want %v", undone, want)
+	}
+
+	committed := newInstallTransaction("app-tx")
+	committed.onRollback("network", func() error {
+		t.Error("a committed step was undone")
+		return nil
+	})
+	committed.commit()
+	committed.rollback()
+}
+
+// a driver whose store never becomes ready is removed along with everything made for it
+func TestLaunchFromSLARollback(t *testing.T) {
+
+	defer func(ask func(cm ContainerManager, storeURL string) error) {
+		askStoreCatalogue = ask
+	}(askStoreCatalogue)
+	askStoreCatalogue = func(cm ContainerManager, storeURL string, timeout time.Duration) error {
+		return errors.New("store is not answering")
+	}
+
+	tests := []struct {
+		name          string
+		installBefore bool
+		wantStoreKept bool
+	}{
+		{name: "new driver"},
+		{name: "store left by an earlier install", installBefore: true, wantStoreKept: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			td := newTestDatabox(t)
+			td.cm.Readiness.StoreReadyTimeout = 1
+			sla := td.testSLA("driver-broken", libDatabox.DataboxTypeDriver)
+
+			if tt.installBefore {
+				askStoreCatalogue = fakeStoreCatalogue
+				err := td.cm.LaunchFromSLA(sla, false, nil)
+				if err != nil {
+					t.Fatal(err)
+				}
+				err = td.cm.Uninstall(sla.Name, false, nil)
+				if err != nil {
+					t.Fatal(err)
+				}
+				askStoreCatalogue = func(cm ContainerManager, storeURL string, timeout time.Duration) error {
+					return errors.New("store is not answering")
+				}
+			}
+
+			granted := td.arbiter.granted("driver-broken")
+			err := td.cm.LaunchFromSLA(sla, true, nil)
+			if err == nil || !strings.Contains(err.Error(), "its store did not start") {
+				t.Fatalf("error = %v, want the store to fail", err)
+			}
+
+			if td.service("driver-broken") != nil {
+				t.Error("the driver service was left behind")
+			}
+			if kept := td.service("driver-broken-core-store") != nil; kept != tt.wantStoreKept {
+				t.Errorf("store kept = %v, want %v", kept, tt.wantStoreKept)
+			}
+			if kept := td.volumeExists("driver-broken-core-store"); kept != tt.wantStoreKept {
+				t.Errorf("store volume kept = %v, want %v", kept, tt.wantStoreKept)
+			}
+			if !tt.installBefore && td.networkExists("driver-broken-network") {
+				t.Error("the network was left behind")
+			}
+			for _, name := range []string{"DRIVER-BROKEN.pem", "DRIVER-BROKEN_KEY"} {
+				if td.secretExists(name) {
+					t.Errorf("secret %s was left behind", name)
+				}
+			}
+			if td.arbiter.registered("driver-broken") {
+				t.Error("the driver is still registered with the arbiter")
+			}
+			if td.arbiter.granted("driver-broken") != granted {
+				t.Error("the permissions granted to the driver were not revoked")
+			}
+			if _, err := td.cm.Store.GetSLA("driver-broken"); err == nil {
+				t.Error("the SLA of a failed install was saved")
+			}
+		})
+	}
+}
+
+func (td *testDatabox) volumeExists(name string) bool {
+	f := filters.NewArgs()
+	f.Add("name", name)
+	list, _ := td.cli.VolumeList(context.Background(), f)
+	for _, v := range list.Volumes {
+		if v.Name == name {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"encoding/json"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/me-box/core-container-manager/api"
+	libDatabox "github.com/me-box/lib-go-databox"
+)
+
+// JobType and JobState are shared with API clients, see the api package
+type JobType = api.JobType
+type JobState = api.JobState
+
+const (
+	JobTypeInstall   = api.JobTypeInstall
+	JobTypeUninstall = api.JobTypeUninstall
+	JobTypeRestart   = api.JobTypeRestart
+	JobTypeUpgrade   = api.JobTypeUpgrade
+	JobTypeReload    = api.JobTypeReload
+	JobTypeStop      = api.JobTypeStop
+	JobTypeStart     = api.JobTypeStart
+)
+
+const (
+	JobStateQueued      = api.JobStateQueued
+	JobStatePulling     = api.JobStatePulling
+	JobStateNetworking  = api.JobStateNetworking
+	JobStateStore       = api.JobStateStore
+	JobStatePermissions = api.JobStatePermissions
+	JobStateStarting    = api.JobStateStarting
+	JobStateRunning     = api.JobStateRunning
+	JobStateRemoved     = api.JobStateRemoved
+	JobStateFailed      = api.JobStateFailed
+	JobStateBlocked     = api.JobStateBlocked
+	JobStateStopped     = api.JobStateStopped
+)
+
+// jobsKey is the key in the data datasource the job list is written to
+const jobsKey = "jobs"
+
+// maxFinishedJobs is how many completed jobs are kept before the oldest are dropped
+const maxFinishedJobs = 50
+
+// Job is a single tracked install, uninstall, restart, upgrade or reload
+type Job struct {
+	api.Job
+
+	tracker *JobTracker
+}
+
+// SetState moves the job on to state. It is safe to call on a nil job
+// so code paths that are not tracked do not need to check.
+func (j *Job) SetState(state JobState) {
+	if j == nil {
+		return
+	}
+	j.tracker.update(j.ID, state, "")
+}
+
+// Fail moves the job to failed recording err. It is safe to call on a nil job.
+func (j *Job) Fail(err error) {
+	if j == nil || err == nil {
+		return
+	}
+	j.tracker.update(j.ID, JobStateFailed, err.Error())
+}
+
+// Block moves the job to blocked recording why. It is safe to call on a nil job.
+func (j *Job) Block(err error) {
+	if j == nil || err == nil {
+		return
+	}
+	j.tracker.update(j.ID, JobStateBlocked, err.Error())
+}
+
+// JobTracker keeps the list of jobs and publishes it to the container managers data datasource
+type JobTracker struct {
+	mu        sync.Mutex
+	publishMu sync.Mutex
+	jobs      map[string]*Job
+	nextID    int
+	store     *libDatabox.CoreStoreClient
+}
+
+// NewJobTracker returns a JobTracker writing to the data datasource in store.
+// store may be nil in which case jobs are only held in memory.
+func NewJobTracker(store *libDatabox.CoreStoreClient) *JobTracker {
+	return &JobTracker{
+		jobs:  make(map[string]*Job),
+		store: store,
+	}
+}
+
+// NewJob creates a queued job of jobType for the component name
+func (jt *JobTracker) NewJob(jobType JobType, name string) *Job {
+	jt.mu.Lock()
+	now := time.Now()
+	jt.nextID++
+	job := &Job{
+		Job: api.Job{
+			ID:      strconv.FormatInt(now.Unix(), 10) + "-" + strconv.Itoa(jt.nextID),
+			Type:    jobType,
+			Name:    name,
+			State:   JobStateQueued,
+			Created: now,
+			Updated: now,
+		},
+		tracker: jt,
+	}
+	jt.jobs[job.ID] = job
+	jt.prune()
+	jt.mu.Unlock()
+
+	libDatabox.Debug("[JobTracker] new " + string(jobType) + " job " + job.ID + " for " + name)
+	jt.publish()
+	return job
+}
+
+// Get returns a copy of the job with id
+func (jt *JobTracker) Get(id string) (Job, bool) {
+	jt.mu.Lock()
+	defer jt.mu.Unlock()
+
+	job, ok := jt.jobs[id]
+	if !ok {
+		return Job{}, false
+	}
+	return *job, true
+}
+
+// List returns a copy of all jobs oldest first
+func (jt *JobTracker) List() []Job {
+	jt.mu.Lock()
+	defer jt.mu.Unlock()
+
+	return jt.list()
+}
+
+func (jt *JobTracker) list() []Job {
+	res := []Job{}
+	for _, job := range jt.jobs {
+		res = append(res, *job)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].Created.Before(res[j].Created)
+	})
+	return res
+}
+
+func (jt *JobTracker) update(id string, state JobState, errMsg string) {
+	jt.mu.Lock()
+	job, ok := jt.jobs[id]
+	if !ok {
+		jt.mu.Unlock()
+		return
+	}
+	job.State = state
+	job.Error = errMsg
+	job.Updated = time.Now()
+	jt.mu.Unlock()
+
+	libDatabox.Debug("[JobTracker] " + string(job.Type) + " job " + id + " for " + job.Name + " is " + string(state))
+	jt.publish()
+}
+
+// prune drops the oldest finished jobs once there are more than maxFinishedJobs
+func (jt *JobTracker) prune() {
+	finished := []Job{}
+	for _, job := range jt.list() {
+		if job.Finished() {
+			finished = append(finished, job)
+		}
+	}
+	for i := 0; i < len(finished)-maxFinishedJobs; i++ {
+		delete(jt.jobs, finished[i].ID)
+	}
+}
+
+// publish writes the current job list to the data datasource
+func (jt *JobTracker) publish() {
+	if jt.store == nil {
+		return
+	}
+
+	//serialise writes so the last one always holds the latest state
+	jt.publishMu.Lock()
+	defer jt.publishMu.Unlock()
+
+	payload, err := json.Marshal(jt.List())
+	if err != nil {
+		libDatabox.Err("[JobTracker] Error encoding jobs " + err.Error())
+		return
+	}
+	err = jt.store.KVJSON.Write("data", jobsKey, payload)
+	if err != nil {
+		libDatabox.Err("[JobTracker] Error writing jobs " + err.Error())
+	}
+}
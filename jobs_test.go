@@ -0,0 +1,105 @@
+package main
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestJobStates(t *testing.T) {
+
+	jt := NewJobTracker(nil)
+	job := jt.NewJob(JobTypeInstall, "app-job")
+
+	steps := []struct {
+		name         string
+		change       func()
+		wantState    JobState
+		wantError    string
+		wantFinished bool
+	}{
+		{name: "new", change: func() {}, wantState: JobStateQueued},
+		{name: "pulling", change: func() { job.SetState(JobStatePulling) }, wantState: JobStatePulling},
+		{name: "fail without an error", change: func() { job.Fail(nil) }, wantState: JobStatePulling},
+		{name: "block without an error", change: func() { job.Block(nil) }, wantState: JobStatePulling},
+		{name: "starting", change: func() { job.SetState(JobStateStarting) }, wantState: JobStateStarting},
+		{name: "failed", change: func() { job.Fail(errors.New("no image")) }, wantState: JobStateFailed, wantError: "no image", wantFinished: true},
+		{name: "blocked", change: func() { job.Block(errors.New("driver-one did not start")) }, wantState: JobStateBlocked, wantError: "driver-one did not start", wantFinished: true},
+		{name: "running clears the error", change: func() { job.SetState(JobStateRunning) }, wantState: JobStateRunning, wantFinished: true},
+	}
+
+	for _, step := range steps {
+		before, _ := jt.Get(job.ID)
+		step.change()
+		got, ok := jt.Get(job.ID)
+		if !ok {
+			t.Fatalf("%s: job %s is gone", step.name, job.ID)
+		}
+		if got.State != step.wantState || got.Error != step.wantError || got.Finished() != step.wantFinished {
+			t.Errorf("%s: job = %s %q finished %v, want %s %q finished %v", step.name,
+				got.State, got.Error, got.Finished(), step.wantState, step.wantError, step.wantFinished)
+		}
+		if got.Updated.Before(before.Updated) {
+			t.Errorf("%s: updated went back from %v to %v", step.name, before.Updated, got.Updated)
+		}
+	}
+
+	//Get returns a copy
+	copied, _ := jt.Get(job.ID)
+	copied.State = JobStateFailed
+	if got, _ := jt.Get(job.ID); got.State != JobStateRunning {
+		t.Errorf("changing a copy changed the job to %s", got.State)
+	}
+	if _, ok := jt.Get("missing"); ok {
+		t.Error("found a job that does not exist")
+	}
+}
+
+// code paths that are not tracked pass a nil job
+func TestNilJob(t *testing.T) {
+	var job *Job
+	job.SetState(JobStateRunning)
+	job.Fail(errors.New("failed"))
+	job.Block(errors.New("blocked"))
+}
+
+func TestJobPrune(t *testing.T) {
+
+	jt := NewJobTracker(nil)
+
+	finished := []*Job{}
+	for i := 0; i < maxFinishedJobs+5; i++ {
+		job := jt.NewJob(JobTypeRestart, "app-prune")
+		job.SetState(JobStateRunning)
+		finished = append(finished, job)
+	}
+	running := []*Job{}
+	for i := 0; i < 3; i++ {
+		job := jt.NewJob(JobTypeInstall, "app-busy")
+		job.SetState(JobStatePulling)
+		running = append(running, job)
+	}
+
+	//pruning happens as jobs are created
+	last := jt.NewJob(JobTypeUninstall, "app-prune")
+
+	jobs := jt.List()
+	if len(jobs) != maxFinishedJobs+len(running)+1 {
+		t.Errorf("%d jobs kept, want %d", len(jobs), maxFinishedJobs+len(running)+1)
+	}
+	for i, job := range finished {
+		_, kept := jt.Get(job.ID)
+		if kept != (i >= len(finished)-maxFinishedJobs) {
+			t.Errorf("finished job %d kept = %v", i, kept)
+		}
+	}
+	for _, job := range append(running, last) {
+		if _, kept := jt.Get(job.ID); !kept {
+			t.Errorf("unfinished job %s was dropped", job.ID)
+		}
+	}
+	for i := 1; i < len(jobs); i++ {
+		if jobs[i].Created.Before(jobs[i-1].Created) {
+			t.Fatal("List() is not oldest first")
+		}
+	}
+}
@@ -0,0 +1,232 @@
+package main
+
+import (
+	"encoding/json"
+	"sort"
+	"time"
+
+	libDatabox "github.com/me-box/lib-go-databox"
+)
+
+// journalDatasourceID is the timeseries datasource in the CM store lifecycle events are written to
+const journalDatasourceID = "events"
+
+// journalDefaultLimit is how many events are returned when a query does not give a time range
+const journalDefaultLimit = 100
+
+// tsBlobMaxScan is the most entries a query for one component reads looking for its latest ones
+var tsBlobMaxScan = 10000
+
+// LifecycleEventType is what happened to a component
+type LifecycleEventType string
+
+const (
+	EventInstalled     LifecycleEventType = "installed"
+	EventInstallFailed LifecycleEventType = "install-failed"
+	EventStarted       LifecycleEventType = "started"
+	EventStartFailed   LifecycleEventType = "start-failed"
+	EventUninstalled   LifecycleEventType = "uninstalled"
+	EventRestarted     LifecycleEventType = "restarted"
+	EventRestartFailed LifecycleEventType = "restart-failed"
+	EventCrashed       LifecycleEventType = "crashed"
+	EventQuarantined   LifecycleEventType = "quarantined"
+	EventUpgraded      LifecycleEventType = "upgraded"
+	EventUpgradeFailed LifecycleEventType = "upgrade-failed"
+	EventStopped       LifecycleEventType = "stopped"
+	EventStopFailed    LifecycleEventType = "stop-failed"
+
+	EventCertRenewed     LifecycleEventType = "cert-renewed"
+	EventCertRenewFailed LifecycleEventType = "cert-renew-failed"
+
+	EventRestoreFailed LifecycleEventType = "restore-failed"
+)
+
+// LifecycleEvent is one entry in the journal
+type LifecycleEvent struct {
+	Time      time.Time          `json:"time"`
+	Type      LifecycleEventType `json:"type"`
+	Component string             `json:"component"`
+	Message   string             `json:"message,omitempty"`
+	ExitCode  string             `json:"exitCode,omitempty"`
+	JobID     string             `json:"jobId,omitempty"`
+}
+
+// journalQuery selects events for the LifecycleEvents FUNC. From and To are unix
+// milliseconds, if neither is set the latest Limit events of Component (or of everything if it is blank) are returned.
+type journalQuery struct {
+	Component string `json:"component"`
+	From      int64  `json:"from"`
+	To        int64  `json:"to"`
+	Limit     int    `json:"limit"`
+}
+
+// TSBlobStore is the part of the core-store ts/blob API used by the Journal.
+// The TSBlobJSON client of a libDatabox.CoreStoreClient satisfies it.
+type TSBlobStore interface {
+	WriteAt(dataSourceID string, timestamp int64, payload []byte) error
+	LastN(dataSourceID string, n int) ([]byte, error)
+	Since(dataSourceID string, sinceTimeStamp int64) ([]byte, error)
+	Range(dataSourceID string, fromTimeStamp int64, toTimeStamp int64) ([]byte, error)
+}
+
+// Journal records component lifecycle events in the CM store
+type Journal struct {
+	store TSBlobStore
+}
+
+// NewJournal registers the events datasource in store and returns a Journal writing to it
+func NewJournal(store *libDatabox.CoreStoreClient) *Journal {
+
+	store.RegisterDatasource(libDatabox.DataSourceMetadata{
+		Description:    "Databox container manager lifecycle events",
+		ContentType:    "application/json",
+		Vendor:         "Databox",
+		DataSourceType: "databox:container-manager:events",
+		DataSourceID:   journalDatasourceID,
+		StoreType:      "ts/blob",
+		IsActuator:     false,
+		Unit:           "",
+		Location:       "",
+	})
+
+	return &Journal{store: store.TSBlobJSON}
+}
+
+// Record writes an event of eventType for component. It is safe to call on a nil Journal.
+func (j *Journal) Record(eventType LifecycleEventType, component string, message string, job *Job) {
+	j.record(LifecycleEvent{Type: eventType, Component: component, Message: message}, job)
+}
+
+// RecordErr records eventType with err as the message
+func (j *Journal) RecordErr(eventType LifecycleEventType, component string, err error, job *Job) {
+	message := ""
+	if err != nil {
+		message = err.Error()
+	}
+	j.record(LifecycleEvent{Type: eventType, Component: component, Message: message}, job)
+}
+
+// RecordCrash records a crash of component with the containers exit code
+func (j *Journal) RecordCrash(component string, exitCode string, message string) {
+	j.record(LifecycleEvent{Type: EventCrashed, Component: component, ExitCode: exitCode, Message: message}, nil)
+}
+
+func (j *Journal) record(event LifecycleEvent, job *Job) {
+	if j == nil || j.store == nil {
+		return
+	}
+
+	event.Time = time.Now()
+	if job != nil {
+		event.JobID = job.ID
+	}
+
+	payload, err := json.Marshal(event)
+	if err != nil {
+		libDatabox.Err("[Journal] Error encoding event " + err.Error())
+		return
+	}
+	err = j.store.WriteAt(journalDatasourceID, event.Time.UnixNano()/int64(time.Millisecond), payload)
+	if err != nil {
+		libDatabox.Err("[Journal] Error writing event " + err.Error())
+	}
+}
+
+// Query returns the events matching q oldest first
+func (j *Journal) Query(q journalQuery) ([]LifecycleEvent, error) {
+
+	res := []LifecycleEvent{}
+	if j == nil || j.store == nil {
+		return res, nil
+	}
+
+	limit := q.Limit
+	if limit <= 0 {
+		limit = journalDefaultLimit
+	}
+
+	payload, err := queryTSBlob(j.store, journalDatasourceID, q.From, q.To, limit, q.Component)
+	if err != nil {
+		return res, err
+	}
+
+	//the store returns its own timestamp next to each event
+	var entries []struct {
+		Timestamp int64          `json:"timestamp"`
+		Data      LifecycleEvent `json:"data"`
+	}
+	if len(payload) > 0 {
+		err = json.Unmarshal(payload, &entries)
+		if err != nil {
+			return res, err
+		}
+	}
+
+	for _, e := range entries {
+		if q.Component != "" && e.Data.Component != q.Component {
+			continue
+		}
+		res = append(res, e.Data)
+	}
+
+	sort.Slice(res, func(a, b int) bool {
+		return res[a].Time.Before(res[b].Time)
+	})
+
+	if len(res) > limit {
+		res = res[len(res)-limit:]
+	}
+
+	return res, nil
+}
+
+// queryTSBlob reads the entries of a ts/blob datasource between from and to (unix milliseconds).
+// If neither is set the latest limit entries are read. When component is set the caller only
+// keeps its entries so more are read, doubling each time, until limit of them are found,
+// the datasource has no more or tsBlobMaxScan entries have been read.
+func queryTSBlob(store TSBlobStore, datasourceID string, from int64, to int64, limit int, component string) ([]byte, error) {
+	switch {
+	case from == 0 && to == 0:
+		n := limit
+		for {
+			payload, err := store.LastN(datasourceID, n)
+			if err != nil || component == "" || n >= tsBlobMaxScan {
+				return payload, err
+			}
+			matched, read, err := countComponentEntries(payload, component)
+			if err != nil || matched >= limit || read < n {
+				return payload, err
+			}
+			n = n * 2
+			if n > tsBlobMaxScan {
+				n = tsBlobMaxScan
+			}
+		}
+	case to == 0:
+		return store.Since(datasourceID, from)
+	default:
+		return store.Range(datasourceID, from, to)
+	}
+}
+
+// countComponentEntries counts the entries in payload and how many of them are for component
+func countComponentEntries(payload []byte, component string) (matched int, read int, err error) {
+	if len(payload) == 0 {
+		return 0, 0, nil
+	}
+	var entries []struct {
+		Data struct {
+			Component string `json:"component"`
+		} `json:"data"`
+	}
+	err = json.Unmarshal(payload, &entries)
+	if err != nil {
+		return 0, 0, err
+	}
+	for _, e := range entries {
+		if e.Data.Component == component {
+			matched++
+		}
+	}
+	return matched, len(entries), nil
+}
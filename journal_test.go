@@ -0,0 +1,170 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// memoryTSBlob is a TSBlobStore held in memory that records how many entries each LastN asks for
+type memoryTSBlob struct {
+	mu      sync.Mutex
+	entries map[string][]tsBlobEntry
+	lastN   []int
+}
+
+type tsBlobEntry struct {
+	Timestamp int64           `json:"timestamp"`
+	Data      json.RawMessage `json:"data"`
+}
+
+func newMemoryTSBlob() *memoryTSBlob {
+	return &memoryTSBlob{entries: map[string][]tsBlobEntry{}}
+}
+
+func (ts *memoryTSBlob) WriteAt(dataSourceID string, timestamp int64, payload []byte) error {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	entries := append(ts.entries[dataSourceID], tsBlobEntry{Timestamp: timestamp, Data: append([]byte{}, payload...)})
+	sort.SliceStable(entries, func(a, b int) bool {
+		return entries[a].Timestamp < entries[b].Timestamp
+	})
+	ts.entries[dataSourceID] = entries
+	return nil
+}
+
+func (ts *memoryTSBlob) LastN(dataSourceID string, n int) ([]byte, error) {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	ts.lastN = append(ts.lastN, n)
+	entries := ts.entries[dataSourceID]
+	if n < len(entries) {
+		entries = entries[len(entries)-n:]
+	}
+	return ts.encode(entries)
+}
+
+func (ts *memoryTSBlob) Since(dataSourceID string, sinceTimeStamp int64) ([]byte, error) {
+	return ts.Range(dataSourceID, sinceTimeStamp, int64(^uint64(0)>>1))
+}
+
+func (ts *memoryTSBlob) Range(dataSourceID string, fromTimeStamp int64, toTimeStamp int64) ([]byte, error) {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	res := []tsBlobEntry{}
+	for _, e := range ts.entries[dataSourceID] {
+		if e.Timestamp >= fromTimeStamp && e.Timestamp <= toTimeStamp {
+			res = append(res, e)
+		}
+	}
+	return ts.encode(res)
+}
+
+// encode returns entries newest first
+func (ts *memoryTSBlob) encode(entries []tsBlobEntry) ([]byte, error) {
+	res := []tsBlobEntry{}
+	for i := len(entries) - 1; i >= 0; i-- {
+		res = append(res, entries[i])
+	}
+	return json.Marshal(res)
+}
+
+func TestJournalQuery(t *testing.T) {
+
+	defer func(max int) { tsBlobMaxScan = max }(tsBlobMaxScan)
+
+	//app-busy writes 9 events for every one of app-quiet, then app-rare is not heard of for a long time
+	ts := newMemoryTSBlob()
+	j := &Journal{store: ts}
+	write := func(timestamp int64, component string) {
+		payload, _ := json.Marshal(LifecycleEvent{Time: time.Unix(0, timestamp*int64(time.Millisecond)), Type: EventRestarted, Component: component, Message: strconv.FormatInt(timestamp, 10)})
+		ts.WriteAt(journalDatasourceID, timestamp, payload)
+	}
+	write(1, "app-rare")
+	for i := int64(2); i <= 100; i++ {
+		if i%10 == 0 {
+			write(i, "app-quiet")
+		} else {
+			write(i, "app-busy")
+		}
+	}
+
+	tests := []struct {
+		name      string
+		query     journalQuery
+		maxScan   int
+		want      []string
+		wantLastN []int
+	}{
+		{name: "latest", query: journalQuery{Limit: 3}, want: []string{"98", "99", "100"}, wantLastN: []int{3}},
+		{name: "latest of a component", query: journalQuery{Component: "app-busy", Limit: 2}, want: []string{"98", "99"}, wantLastN: []int{2, 4}},
+		{name: "widens until it has enough", query: journalQuery{Component: "app-quiet", Limit: 3}, want: []string{"80", "90", "100"}, wantLastN: []int{3, 6, 12, 24}},
+		{name: "stops at the max scan", query: journalQuery{Component: "app-rare", Limit: 5}, want: []string{}, wantLastN: []int{5, 10, 20, 40, 64}},
+		{name: "stops at the start of the datasource", query: journalQuery{Component: "app-rare", Limit: 2}, maxScan: 1000, want: []string{"1"}, wantLastN: []int{2, 4, 8, 16, 32, 64, 128}},
+		{name: "since", query: journalQuery{Component: "app-quiet", From: 75}, want: []string{"80", "90", "100"}},
+		{name: "range", query: journalQuery{Component: "app-quiet", From: 20, To: 40}, want: []string{"20", "30", "40"}},
+		{name: "range with a limit", query: journalQuery{From: 20, To: 40, Limit: 2}, want: []string{"39", "40"}},
+	}
+
+	for _, tt := range tests {
+		tsBlobMaxScan = 64
+		if tt.maxScan > 0 {
+			tsBlobMaxScan = tt.maxScan
+		}
+		ts.lastN = nil
+		got, err := j.Query(tt.query)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		messages := []string{}
+		for _, e := range got {
+			if tt.query.Component != "" && e.Component != tt.query.Component {
+				t.Errorf("%s: got an event of %s", tt.name, e.Component)
+			}
+			messages = append(messages, e.Message)
+		}
+		if fmt.Sprint(messages) != fmt.Sprint(tt.want) {
+			t.Errorf("%s: events = %v, want %v", tt.name, messages, tt.want)
+		}
+		if fmt.Sprint(ts.lastN) != fmt.Sprint(tt.wantLastN) {
+			t.Errorf("%s: LastN read %v, want %v", tt.name, ts.lastN, tt.wantLastN)
+		}
+	}
+}
+
+func TestJournalRecord(t *testing.T) {
+
+	ts := newMemoryTSBlob()
+	j := &Journal{store: ts}
+	job := &Job{}
+	job.ID = "job-1"
+
+	j.Record(EventInstalled, "app-one", "", job)
+	j.RecordErr(EventInstallFailed, "app-two", nil, nil)
+	j.RecordCrash("app-one", "137", "oom")
+
+	got, err := j.Query(journalQuery{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 3 {
+		t.Fatalf("events = %+v", got)
+	}
+	if got[0].Type != EventInstalled || got[0].JobID != "job-1" || got[0].Time.IsZero() {
+		t.Errorf("installed event = %+v", got[0])
+	}
+	if got[2].Type != EventCrashed || got[2].ExitCode != "137" || got[2].Message != "oom" {
+		t.Errorf("crash event = %+v", got[2])
+	}
+
+	//code paths without a CM store pass a nil Journal
+	var missing *Journal
+	missing.Record(EventInstalled, "app-one", "", nil)
+	if events, err := missing.Query(journalQuery{}); err != nil || len(events) != 0 {
+		t.Errorf("nil Journal Query() = %v, %v", events, err)
+	}
+}
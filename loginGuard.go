@@ -0,0 +1,309 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	libDatabox "github.com/me-box/lib-go-databox"
+)
+
+// default login limits, used if LoginOptions does not say
+const (
+	defaultLoginMaxFailures       = 5
+	defaultLoginLockout           = 15
+	defaultLoginGlobalMaxFailures = 50
+)
+
+// loginDelayBase is how long a client waits after its first failed login, it doubles
+// with each further failure up to loginDelayMax
+var loginDelayBase = time.Second
+var loginDelayMax = 30 * time.Second
+
+// loginLockoutMax caps the lockout of a client that keeps getting locked out
+var loginLockoutMax = 24 * time.Hour
+
+// loginForgetAfter is how long after its last failure a client's record is dropped
+var loginForgetAfter = 24 * time.Hour
+
+// loginGlobalWindow is the period failures from all clients are counted over
+var loginGlobalWindow = 10 * time.Minute
+
+// loginGlobalDelay is how long after the last failure from anyone a client that has not
+// logged in recently must wait once there have been LoginGlobalMaxFailures in
+// loginGlobalWindow. It slows down guessing from many addresses without locking out the owner.
+var loginGlobalDelay = 5 * time.Second
+
+// loginTrustedFor is how long after a successful login a client IP is not slowed down
+// by loginGlobalDelay
+var loginTrustedFor = 30 * 24 * time.Hour
+
+// loginMaxLoggedName is the longest attempted user name written to the log
+const loginMaxLoggedName = 32
+
+// loginSweepInterval is how often old client records are removed
+var loginSweepInterval = 10 * time.Minute
+
+// LoginOptions limit password guessing. A client IP that fails LoginMaxFailures logins
+// in a row is locked out for LoginLockout minutes, doubling each time it happens again.
+// Once there are LoginGlobalMaxFailures failures from all clients in ten minutes clients
+// that have not logged in recently have to wait a few seconds between tries.
+type LoginOptions struct {
+	LoginMaxFailures       int
+	LoginLockout           int
+	LoginGlobalMaxFailures int
+}
+
+// LoginClient is the failed login record of one client IP
+type LoginClient struct {
+	IP          string    `json:"ip"`
+	Failures    int       `json:"failures"`
+	Lockouts    int       `json:"lockouts"`
+	LastFailure time.Time `json:"lastFailure"`
+	//no login is tried from the client before this
+	NotBefore time.Time `json:"notBefore"`
+	Locked    bool      `json:"locked"`
+}
+
+// LoginStatus is what LoginGuard knows about failed logins
+type LoginStatus struct {
+	Clients        []LoginClient `json:"clients"`
+	RecentFailures int           `json:"recentFailures"`
+	//set while clients that have not logged in recently are slowed down
+	Throttled bool `json:"throttled"`
+}
+
+// unlockRequest asks for the lockout of IP to be lifted
+type unlockRequest struct {
+	IP string `json:"ip"`
+}
+
+// LoginGuard tracks failed logins and says when a client must wait before trying again
+type LoginGuard struct {
+	maxFailures       int
+	lockout           time.Duration
+	globalMaxFailures int
+
+	mu        sync.Mutex
+	clients   map[string]*LoginClient
+	trusted   map[string]time.Time
+	recent    []time.Time
+	throttled bool
+}
+
+// NewLoginGuard returns a LoginGuard configured from opt
+func NewLoginGuard(opt LoginOptions) *LoginGuard {
+
+	g := &LoginGuard{
+		maxFailures:       defaultLoginMaxFailures,
+		lockout:           time.Duration(defaultLoginLockout) * time.Minute,
+		globalMaxFailures: defaultLoginGlobalMaxFailures,
+		clients:           make(map[string]*LoginClient),
+		trusted:           make(map[string]time.Time),
+	}
+	if opt.LoginMaxFailures > 0 {
+		g.maxFailures = opt.LoginMaxFailures
+	}
+	if opt.LoginLockout > 0 {
+		g.lockout = time.Duration(opt.LoginLockout) * time.Minute
+	}
+	if opt.LoginGlobalMaxFailures > 0 {
+		g.globalMaxFailures = opt.LoginGlobalMaxFailures
+	}
+	return g
+}
+
+// clientIP returns the address r came from without its port
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// Wait returns how long ip must wait before it may try to log in, zero if it may now
+func (g *LoginGuard) Wait(ip string) time.Duration {
+
+	now := time.Now()
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	notBefore := time.Time{}
+	if c, ok := g.clients[ip]; ok {
+		notBefore = c.NotBefore
+	}
+	g.pruneRecent(now)
+	if g.throttled && !g.isTrusted(ip, now) {
+		if global := g.recent[len(g.recent)-1].Add(loginGlobalDelay); global.After(notBefore) {
+			notBefore = global
+		}
+	}
+	if !notBefore.After(now) {
+		return 0
+	}
+	return notBefore.Sub(now)
+}
+
+// isTrusted reports if ip logged in within loginTrustedFor, g.mu must be held
+func (g *LoginGuard) isTrusted(ip string, now time.Time) bool {
+	last, ok := g.trusted[ip]
+	return ok && now.Sub(last) < loginTrustedFor
+}
+
+// loggedName is user as it is safe to log. It is chosen by the client and may be a
+// password typed in the wrong box so it is quoted and cut short.
+func loggedName(user string) string {
+	if user == "" {
+		return "an unknown user"
+	}
+	if len(user) > loginMaxLoggedName {
+		user = user[:loginMaxLoggedName] + "..."
+	}
+	return strconv.Quote(user)
+}
+
+// Failed records a failed login as user from ip, user is blank if the name is not a
+// known account. The password is never logged.
+func (g *LoginGuard) Failed(ip string, user string) {
+
+	now := time.Now()
+
+	g.mu.Lock()
+	c, ok := g.clients[ip]
+	if !ok {
+		c = &LoginClient{IP: ip}
+		g.clients[ip] = c
+	}
+	c.Failures++
+	c.LastFailure = now
+
+	msg := "[Login] failed login as " + loggedName(user) + " from " + ip + " at " + now.Format(time.RFC3339) +
+		", " + strconv.Itoa(c.Failures) + " failures"
+	if c.Failures >= g.maxFailures {
+		lockout := g.lockout << uint(c.Lockouts)
+		if lockout > loginLockoutMax || lockout <= 0 {
+			lockout = loginLockoutMax
+		}
+		c.Lockouts++
+		c.Failures = 0
+		c.NotBefore = now.Add(lockout)
+		msg = msg + ", locked out for " + lockout.String()
+	} else {
+		delay := loginDelayBase << uint(c.Failures-1)
+		if delay > loginDelayMax || delay <= 0 {
+			delay = loginDelayMax
+		}
+		c.NotBefore = now.Add(delay)
+	}
+
+	g.recent = append(g.recent, now)
+	g.pruneRecent(now)
+	startThrottling := !g.throttled && len(g.recent) >= g.globalMaxFailures
+	if startThrottling {
+		g.throttled = true
+	}
+	recent := len(g.recent)
+	g.mu.Unlock()
+
+	libDatabox.Warn(msg)
+	if startThrottling {
+		libDatabox.Err("[Login] " + strconv.Itoa(recent) + " failed logins in " + loginGlobalWindow.String() +
+			", clients that have not logged in recently must wait " + loginGlobalDelay.String() + " between tries")
+	}
+}
+
+// Succeeded forgets the failures of ip and exempts it from loginGlobalDelay for loginTrustedFor
+func (g *LoginGuard) Succeeded(ip string) {
+	g.mu.Lock()
+	delete(g.clients, ip)
+	g.trusted[ip] = time.Now()
+	g.mu.Unlock()
+}
+
+// Unlock lifts the lockout of ip and forgets its failures, it reports if there was a record
+func (g *LoginGuard) Unlock(ip string) bool {
+
+	g.mu.Lock()
+	_, ok := g.clients[ip]
+	delete(g.clients, ip)
+	g.mu.Unlock()
+
+	if ok {
+		libDatabox.Info("[Login] lockout of " + ip + " lifted")
+	}
+	return ok
+}
+
+// Status returns the clients with failed logins, locked out ones first
+func (g *LoginGuard) Status() LoginStatus {
+
+	now := time.Now()
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	g.pruneRecent(now)
+	status := LoginStatus{
+		Clients:        []LoginClient{},
+		RecentFailures: len(g.recent),
+		Throttled:      g.throttled,
+	}
+	for _, c := range g.clients {
+		copied := *c
+		copied.Locked = c.NotBefore.After(now) && c.Failures == 0
+		status.Clients = append(status.Clients, copied)
+	}
+	sort.Slice(status.Clients, func(a, b int) bool {
+		if status.Clients[a].Locked != status.Clients[b].Locked {
+			return status.Clients[a].Locked
+		}
+		return status.Clients[a].LastFailure.After(status.Clients[b].LastFailure)
+	})
+	return status
+}
+
+// pruneRecent drops global failures older than loginGlobalWindow and stops throttling
+// once there are fewer than LoginGlobalMaxFailures left, g.mu must be held
+func (g *LoginGuard) pruneRecent(now time.Time) {
+	i := 0
+	for i < len(g.recent) && now.Sub(g.recent[i]) > loginGlobalWindow {
+		i++
+	}
+	g.recent = g.recent[i:]
+	if g.throttled && len(g.recent) < g.globalMaxFailures {
+		g.throttled = false
+		libDatabox.Info("[Login] no longer slowing down logins")
+	}
+}
+
+// run removes old client records every loginSweepInterval, it never returns
+func (g *LoginGuard) run() {
+	for {
+		time.Sleep(loginSweepInterval)
+		g.sweep()
+	}
+}
+
+func (g *LoginGuard) sweep() {
+
+	now := time.Now()
+
+	g.mu.Lock()
+	for ip, c := range g.clients {
+		if now.After(c.NotBefore) && now.Sub(c.LastFailure) > loginForgetAfter {
+			delete(g.clients, ip)
+		}
+	}
+	for ip, last := range g.trusted {
+		if now.Sub(last) > loginTrustedFor {
+			delete(g.trusted, ip)
+		}
+	}
+	g.pruneRecent(now)
+	g.mu.Unlock()
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// waitNear reports if wait is want, less the little time that passed since the failure
+func waitNear(wait time.Duration, want time.Duration) bool {
+	if want == 0 {
+		return wait == 0
+	}
+	return wait <= want && wait > want-time.Second
+}
+
+func TestLoginGuardBackoff(t *testing.T) {
+
+	g := NewLoginGuard(LoginOptions{LoginMaxFailures: 4, LoginLockout: 15})
+
+	tests := []struct {
+		name       string
+		wantWait   time.Duration
+		wantLocked bool
+	}{
+		{name: "first failure", wantWait: loginDelayBase},
+		{name: "doubles", wantWait: 2 * loginDelayBase},
+		{name: "doubles again", wantWait: 4 * loginDelayBase},
+		{name: "locked out", wantWait: 15 * time.Minute, wantLocked: true},
+		{name: "starts again after a lockout", wantWait: loginDelayBase},
+		{name: "doubles after a lockout", wantWait: 2 * loginDelayBase},
+		{name: "doubles again after a lockout", wantWait: 4 * loginDelayBase},
+		{name: "locked out for longer", wantWait: 30 * time.Minute, wantLocked: true},
+	}
+
+	for i, tt := range tests {
+		g.Failed("10.0.0.1", "")
+		wait := g.Wait("10.0.0.1")
+		if !waitNear(wait, tt.wantWait) {
+			t.Errorf("failure %d %s: Wait() = %v, want %v", i+1, tt.name, wait, tt.wantWait)
+		}
+		status := g.Status()
+		if len(status.Clients) != 1 || status.Clients[0].Locked != tt.wantLocked {
+			t.Errorf("failure %d %s: status = %+v, want locked %v", i+1, tt.name, status.Clients, tt.wantLocked)
+		}
+	}
+
+	if wait := g.Wait("10.0.0.2"); wait != 0 {
+		t.Errorf("another client has to wait %v", wait)
+	}
+}
+
+func TestLoginGuardReset(t *testing.T) {
+
+	tests := []struct {
+		name  string
+		reset func(g *LoginGuard, ip string) bool
+	}{
+		{
+			name: "login succeeded",
+			reset: func(g *LoginGuard, ip string) bool {
+				g.Succeeded(ip)
+				return true
+			},
+		},
+		{
+			name:  "unlocked by an admin",
+			reset: (*LoginGuard).Unlock,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewLoginGuard(LoginOptions{LoginMaxFailures: 2})
+			g.Failed("10.0.0.1", builtinAdmin)
+			g.Failed("10.0.0.1", builtinAdmin)
+			if g.Wait("10.0.0.1") == 0 {
+				t.Fatal("not locked out")
+			}
+
+			if !tt.reset(g, "10.0.0.1") {
+				t.Error("there was no record to reset")
+			}
+			if wait := g.Wait("10.0.0.1"); wait != 0 {
+				t.Errorf("Wait() = %v after a reset", wait)
+			}
+			if clients := g.Status().Clients; len(clients) != 0 {
+				t.Errorf("clients = %+v after a reset", clients)
+			}
+
+			//the count starts again
+			g.Failed("10.0.0.1", builtinAdmin)
+			if wait := g.Wait("10.0.0.1"); !waitNear(wait, loginDelayBase) {
+				t.Errorf("Wait() = %v after a failure following a reset, want %v", wait, loginDelayBase)
+			}
+		})
+	}
+}
+
+func TestLoginGuardGlobalThrottle(t *testing.T) {
+
+	g := NewLoginGuard(LoginOptions{LoginGlobalMaxFailures: 3})
+	g.Succeeded("10.0.0.1")
+
+	for _, ip := range []string{"10.0.1.1", "10.0.1.2"} {
+		g.Failed(ip, "")
+	}
+	if g.Status().Throttled || g.Wait("10.0.0.9") != 0 {
+		t.Fatal("throttled before LoginGlobalMaxFailures")
+	}
+	g.Failed("10.0.1.3", "")
+
+	tests := []struct {
+		name     string
+		ip       string
+		wantWait time.Duration
+	}{
+		{name: "trusted client", ip: "10.0.0.1"},
+		{name: "new client", ip: "10.0.0.9", wantWait: loginGlobalDelay},
+		{name: "failing client", ip: "10.0.1.3", wantWait: loginGlobalDelay},
+	}
+
+	if !g.Status().Throttled {
+		t.Fatal("not throttled after LoginGlobalMaxFailures")
+	}
+	for _, tt := range tests {
+		if wait := g.Wait(tt.ip); !waitNear(wait, tt.wantWait) {
+			t.Errorf("%s: Wait() = %v, want %v", tt.name, wait, tt.wantWait)
+		}
+	}
+
+	//trust expires
+	g.trusted["10.0.0.1"] = time.Now().Add(-loginTrustedFor - time.Minute)
+	if wait := g.Wait("10.0.0.1"); !waitNear(wait, loginGlobalDelay) {
+		t.Errorf("Wait() = %v once trust expired, want %v", wait, loginGlobalDelay)
+	}
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/swarm"
+	"github.com/docker/docker/pkg/stdcopy"
+	"github.com/me-box/core-container-manager/api"
+)
+
+// logDefaultLines is how many lines are returned when a request does not say
+const logDefaultLines = 100
+
+// logMaxLines is the most lines a single request can return
+const logMaxLines = 5000
+
+// logsRequest is the payload of the Logs FUNC
+type logsRequest struct {
+	Name  string `json:"name"`
+	Lines int    `json:"lines"`
+}
+
+// logLines clamps the number of lines asked for to something sensible
+func logLines(lines int) int {
+	if lines <= 0 {
+		return logDefaultLines
+	}
+	if lines > logMaxLines {
+		return logMaxLines
+	}
+	return lines
+}
+
+// databoxService returns the service name if it is a databox component. Logs of
+// anything else running on the swarm are not ours to give out.
+func (cm ContainerManager) databoxService(name string) (swarm.Service, error) {
+	service, err := cm.serviceByName(name)
+	if err != nil {
+		return swarm.Service{}, err
+	}
+	if _, ok := service.Spec.Labels["databox.type"]; !ok {
+		return swarm.Service{}, errors.New(name + " is not a databox component")
+	}
+	return service, nil
+}
+
+// ServiceLogs returns the last lines of the log of the service name, oldest first
+func (cm ContainerManager) ServiceLogs(name string, lines int) ([]api.LogLine, error) {
+	res := []api.LogLine{}
+	err := cm.streamLogs(context.Background(), name, lines, false, func(l api.LogLine) error {
+		res = append(res, l)
+		return nil
+	})
+	return res, err
+}
+
+// streamLogs calls emit for each of the last lines of the log of the service name.
+// If follow is true it keeps calling emit as new lines are written until ctx is done.
+func (cm ContainerManager) streamLogs(ctx context.Context, name string, lines int, follow bool, emit func(api.LogLine) error) error {
+
+	service, err := cm.databoxService(name)
+	if err != nil {
+		return err
+	}
+
+	logs, err := cm.cli.ServiceLogs(ctx, service.ID, types.ContainerLogsOptions{
+		ShowStdout: true,
+		ShowStderr: true,
+		Timestamps: true,
+		Follow:     follow,
+		Tail:       strconv.Itoa(logLines(lines)),
+	})
+	if err != nil {
+		return errors.New("Can't get logs for " + name + " " + err.Error())
+	}
+	defer logs.Close()
+
+	stdout := &logLineWriter{stream: "stdout", emit: emit}
+	stderr := &logLineWriter{stream: "stderr", emit: emit}
+	_, err = stdcopy.StdCopy(stdout, stderr, logs)
+	if err != nil && ctx.Err() == nil {
+		return errors.New("Can't read logs for " + name + " " + err.Error())
+	}
+	stdout.flush()
+	stderr.flush()
+
+	return nil
+}
+
+// logLineWriter splits what stdcopy writes for one stream into LogLines
+type logLineWriter struct {
+	stream string
+	buf    []byte
+	emit   func(api.LogLine) error
+}
+
+func (w *logLineWriter) Write(p []byte) (int, error) {
+	w.buf = append(w.buf, p...)
+	for {
+		i := bytes.IndexByte(w.buf, '\n')
+		if i < 0 {
+			return len(p), nil
+		}
+		line := string(w.buf[:i])
+		w.buf = w.buf[i+1:]
+		err := w.emit(parseLogLine(w.stream, line))
+		if err != nil {
+			return 0, err
+		}
+	}
+}
+
+// flush emits anything left that did not end with a newline
+func (w *logLineWriter) flush() {
+	if len(w.buf) > 0 {
+		w.emit(parseLogLine(w.stream, string(w.buf)))
+		w.buf = nil
+	}
+}
+
+// parseLogLine splits off the timestamp docker puts at the start of each line
+func parseLogLine(stream string, line string) api.LogLine {
+	l := api.LogLine{Stream: stream, Line: line}
+	parts := strings.SplitN(line, " ", 2)
+	if t, err := time.Parse(time.RFC3339Nano, parts[0]); err == nil {
+		l.Time = t
+		l.Line = ""
+		if len(parts) == 2 {
+			l.Line = parts[1]
+		}
+	}
+	return l
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/swarm"
+	"github.com/me-box/core-container-manager/api"
+	libDatabox "github.com/me-box/lib-go-databox"
+)
+
+func TestParseLogLine(t *testing.T) {
+
+	stamp := time.Date(2018, 6, 1, 12, 30, 15, 123456789, time.UTC)
+
+	tests := []struct {
+		name     string
+		line     string
+		wantTime time.Time
+		wantLine string
+	}{
+		{name: "timestamped", line: stamp.Format(time.RFC3339Nano) + " listening on 8080", wantTime: stamp, wantLine: "listening on 8080"},
+		{name: "timestamp only", line: stamp.Format(time.RFC3339Nano), wantTime: stamp, wantLine: ""},
+		{name: "keeps later spaces", line: stamp.Format(time.RFC3339Nano) + "  indented  line", wantTime: stamp, wantLine: " indented  line"},
+		{name: "no timestamp", line: "listening on 8080", wantLine: "listening on 8080"},
+		{name: "empty", line: "", wantLine: ""},
+	}
+
+	for _, tt := range tests {
+		got := parseLogLine("stderr", tt.line)
+		if !got.Time.Equal(tt.wantTime) || got.Line != tt.wantLine || got.Stream != "stderr" {
+			t.Errorf("%s: parseLogLine(%q) = %+v, want %v %q", tt.name, tt.line, got, tt.wantTime, tt.wantLine)
+		}
+	}
+}
+
+func TestLogLineWriter(t *testing.T) {
+
+	got := []string{}
+	w := &logLineWriter{stream: "stdout", emit: func(l api.LogLine) error {
+		got = append(got, l.Stream+":"+l.Line)
+		return nil
+	}}
+
+	//stdcopy does not split its writes on lines
+	writes := []string{"first ", "line\nsecond line\nthird", " line\n", "\n", "partial"}
+	for _, p := range writes {
+		n, err := w.Write([]byte(p))
+		if n != len(p) || err != nil {
+			t.Fatalf("Write(%q) = %d, %v", p, n, err)
+		}
+	}
+	want := "stdout:first line,stdout:second line,stdout:third line,stdout:"
+	if strings.Join(got, ",") != want {
+		t.Errorf("lines = %q, want %q", got, want)
+	}
+
+	w.flush()
+	w.flush()
+	want += ",stdout:partial"
+	if strings.Join(got, ",") != want {
+		t.Errorf("lines after flush = %q, want %q", got, want)
+	}
+
+	//a follower that has gone away stops the copy
+	w.emit = func(l api.LogLine) error { return errors.New("client gone") }
+	if n, err := w.Write([]byte("more\n")); n != 0 || err == nil {
+		t.Errorf("Write() = %d, %v after emit failed", n, err)
+	}
+}
+
+func TestServiceLogs(t *testing.T) {
+
+	td := newTestDatabox(t)
+	err := td.cm.LaunchFromSLA(td.testSLA("app-logs", libDatabox.DataboxTypeApp), true, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	containers := td.containers("app-logs")
+	if len(containers) != 1 {
+		t.Fatalf("app-logs has %d containers", len(containers))
+	}
+	for i := 0; i < 5; i++ {
+		td.cli.WriteContainerLog(containers[0].ID, "line "+strconv.Itoa(i))
+	}
+
+	//a service that is not a databox component
+	td.cli.AddImage("portainer/portainer:latest")
+	_, err = td.cli.ServiceCreate(context.Background(), swarm.ServiceSpec{
+		Annotations:  swarm.Annotations{Name: "portainer"},
+		TaskTemplate: swarm.TaskSpec{ContainerSpec: &swarm.ContainerSpec{Image: "portainer/portainer:latest"}},
+	}, types.ServiceCreateOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name      string
+		service   string
+		lines     int
+		wantLines []string
+		wantErr   string
+	}{
+		{name: "tail", service: "app-logs", lines: 2, wantLines: []string{"line 3", "line 4"}},
+		{name: "default", service: "app-logs", lines: 0, wantLines: []string{"line 0", "line 1", "line 2", "line 3", "line 4"}},
+		{name: "not databox", service: "portainer", wantErr: "not a databox component"},
+		{name: "missing", service: "app-missing", wantErr: "app-missing"},
+	}
+
+	for _, tt := range tests {
+		got, err := td.cm.ServiceLogs(tt.service, tt.lines)
+		if tt.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("%s: error = %v, want %q", tt.name, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		lines := []string{}
+		for _, l := range got {
+			if l.Time.IsZero() || l.Stream != "stdout" {
+				t.Errorf("%s: line %+v has no time or stream", tt.name, l)
+			}
+			lines = append(lines, l.Line)
+		}
+		if strings.Join(lines, ",") != strings.Join(tt.wantLines, ",") {
+			t.Errorf("%s: lines = %q, want %q", tt.name, lines, tt.wantLines)
+		}
+	}
+
+	if logLines(-1) != logDefaultLines || logLines(logMaxLines+1) != logMaxLines || logLines(10) != 10 {
+		t.Error("logLines() does not clamp the lines asked for")
+	}
+}
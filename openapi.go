@@ -0,0 +1,560 @@
+package main
+
+// openAPISpec describes the REST API served under restAPIPrefix, see NewRestAPI
+const openAPISpec = `{
+  "openapi": "3.0.0",
+  "info": {
+    "title": "Databox container manager",
+    "description": "Manage the apps and drivers installed on a databox. Authenticate with an 'Authorization: Token <password>' header for the built-in admin, HTTP basic auth for other users, or a session cookie. Users with the viewer role can only read status, and the details and logs of the components in their apps list. Clients that fail to log in too often get 429 with a Retry-After header.",
+    "version": "1"
+  },
+  "servers": [{"url": "/api/v1"}],
+  "components": {
+    "securitySchemes": {
+      "token": {"type": "apiKey", "in": "header", "name": "Authorization"},
+      "basic": {"type": "http", "scheme": "basic"},
+      "session": {"type": "apiKey", "in": "cookie", "name": "session"}
+    },
+    "schemas": {
+      "Error": {
+        "type": "object",
+        "properties": {
+          "error": {"type": "string"},
+          "validation": {"$ref": "#/components/schemas/Validation"},
+          "dependents": {"type": "array", "items": {"type": "string"}}
+        }
+      },
+      "Issue": {
+        "type": "object",
+        "properties": {
+          "field": {"type": "string"},
+          "message": {"type": "string"}
+        }
+      },
+      "Validation": {
+        "type": "object",
+        "properties": {
+          "valid": {"type": "boolean"},
+          "errors": {"type": "array", "items": {"$ref": "#/components/schemas/Issue"}},
+          "warnings": {"type": "array", "items": {"$ref": "#/components/schemas/Issue"}}
+        }
+      },
+      "ManifestRequest": {
+        "type": "object",
+        "required": ["manifest"],
+        "properties": {
+          "manifest": {"type": "object", "description": "A databox app or driver manifest"}
+        }
+      },
+      "UninstallRequest": {
+        "type": "object",
+        "required": ["name"],
+        "properties": {
+          "name": {"type": "string"},
+          "force": {"type": "boolean", "description": "Uninstall even if other components use its datasources"}
+        }
+      },
+      "PasswordChange": {
+        "type": "object",
+        "required": ["current", "new"],
+        "properties": {
+          "current": {"type": "string"},
+          "new": {"type": "string", "minLength": 8}
+        }
+      },
+      "Session": {
+        "type": "object",
+        "properties": {
+          "id": {"type": "string", "description": "SHA-256 of the session token"},
+          "user": {"type": "string"},
+          "created": {"type": "string", "format": "date-time"},
+          "lastSeen": {"type": "string", "format": "date-time"},
+          "expires": {"type": "string", "format": "date-time"},
+          "remoteAddr": {"type": "string"},
+          "userAgent": {"type": "string"},
+          "current": {"type": "boolean", "description": "This is the session making the request"}
+        }
+      },
+      "User": {
+        "type": "object",
+        "properties": {
+          "name": {"type": "string"},
+          "role": {"type": "string", "enum": ["admin", "viewer"]},
+          "apps": {"type": "array", "items": {"type": "string"}, "description": "Components whose UI, details and logs a viewer may see, '*' for all"},
+          "created": {"type": "string", "format": "date-time"}
+        }
+      },
+      "UserRequest": {
+        "type": "object",
+        "properties": {
+          "name": {"type": "string", "description": "Only used when creating a user"},
+          "password": {"type": "string", "minLength": 8},
+          "role": {"type": "string", "enum": ["admin", "viewer"]},
+          "apps": {"type": "array", "items": {"type": "string"}}
+        }
+      },
+      "LoginClient": {
+        "type": "object",
+        "properties": {
+          "ip": {"type": "string"},
+          "failures": {"type": "integer", "description": "Failed logins since the last lockout"},
+          "lockouts": {"type": "integer"},
+          "lastFailure": {"type": "string", "format": "date-time"},
+          "notBefore": {"type": "string", "format": "date-time", "description": "No login is tried from the client before this"},
+          "locked": {"type": "boolean"}
+        }
+      },
+      "LoginStatus": {
+        "type": "object",
+        "properties": {
+          "clients": {"type": "array", "items": {"$ref": "#/components/schemas/LoginClient"}},
+          "recentFailures": {"type": "integer", "description": "Failed logins from all clients in the last ten minutes"},
+          "throttled": {"type": "boolean", "description": "Set while clients that have not logged in recently must wait a few seconds between tries"}
+        }
+      },
+      "CertStatus": {
+        "type": "object",
+        "properties": {
+          "name": {"type": "string"},
+          "kind": {"type": "string", "enum": ["root-ca", "system", "component"]},
+          "notAfter": {"type": "string", "format": "date-time"},
+          "renewAt": {"type": "string", "format": "date-time"},
+          "daysLeft": {"type": "integer"},
+          "error": {"type": "string", "description": "Set if the certificate could not be read or the last renewal failed"}
+        }
+      },
+      "BackupRequest": {
+        "type": "object",
+        "properties": {
+          "passphrase": {"type": "string", "description": "Encrypt the archive with this passphrase"}
+        }
+      },
+      "DatasourceRef": {
+        "type": "object",
+        "properties": {
+          "consumer": {"type": "string"},
+          "clientid": {"type": "string"},
+          "href": {"type": "string"},
+          "store": {"type": "string"},
+          "owner": {"type": "string"}
+        }
+      },
+      "NameRequest": {
+        "type": "object",
+        "required": ["name"],
+        "properties": {
+          "name": {"type": "string"}
+        }
+      },
+      "Job": {
+        "type": "object",
+        "properties": {
+          "id": {"type": "string"},
+          "type": {"type": "string", "enum": ["install", "uninstall", "restart", "upgrade", "reload", "stop", "start"]},
+          "name": {"type": "string"},
+          "state": {"type": "string", "enum": ["queued", "pulling", "networking", "store", "permissions", "starting", "running", "removed", "failed", "blocked", "stopped"]},
+          "error": {"type": "string"},
+          "created": {"type": "string", "format": "date-time"},
+          "updated": {"type": "string", "format": "date-time"}
+        }
+      },
+      "JobAccepted": {
+        "type": "object",
+        "properties": {
+          "job": {"$ref": "#/components/schemas/Job"},
+          "url": {"type": "string"}
+        }
+      },
+      "Component": {
+        "type": "object",
+        "properties": {
+          "name": {"type": "string"},
+          "type": {"type": "string"},
+          "desiredState": {"type": "string"},
+          "state": {"type": "string"},
+          "status": {"type": "string"},
+          "crash": {"type": "object"},
+          "sla": {"type": "object"}
+        }
+      },
+      "LogLine": {
+        "type": "object",
+        "properties": {
+          "time": {"type": "string", "format": "date-time"},
+          "stream": {"type": "string", "enum": ["stdout", "stderr"]},
+          "line": {"type": "string"}
+        }
+      },
+      "Status": {
+        "type": "object",
+        "properties": {
+          "status": {"type": "string"},
+          "version": {"type": "string"},
+          "hostname": {"type": "string"},
+          "components": {"type": "integer"},
+          "certificates": {"type": "array", "items": {"$ref": "#/components/schemas/CertStatus"}, "description": "Certificates that expire soon or failed to renew"}
+        }
+      },
+      "Dashboard": {
+        "type": "object",
+        "properties": {
+          "ip": {"type": "string"},
+          "ips": {"type": "array", "items": {"type": "string"}},
+          "ipExternal": {"type": "string"},
+          "hostname": {"type": "string"},
+          "token": {"type": "string", "description": "Token=<password>, left out if the container manager only has the password hash"},
+          "passwordRequired": {"type": "boolean", "description": "Set when token is left out, the mobile app has to ask for the password"}
+        }
+      }
+    },
+    "responses": {
+      "Error": {
+        "description": "The request failed",
+        "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Error"}}}
+      },
+      "Accepted": {
+        "description": "A job was started, poll its url for progress",
+        "headers": {"Location": {"schema": {"type": "string"}}},
+        "content": {"application/json": {"schema": {"$ref": "#/components/schemas/JobAccepted"}}}
+      }
+    }
+  },
+  "security": [{"token": []}, {"basic": []}, {"session": []}],
+  "paths": {
+    "/openapi.json": {
+      "get": {
+        "summary": "This document",
+        "responses": {"200": {"description": "OpenAPI description"}}
+      }
+    },
+    "/status": {
+      "get": {
+        "summary": "Health of the container manager",
+        "responses": {
+          "200": {"description": "OK", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Status"}}}},
+          "401": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/dashboard": {
+      "get": {
+        "summary": "What the mobile app needs to connect, the same data as the dashboard QR code",
+        "responses": {
+          "200": {"description": "OK", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Dashboard"}}}},
+          "401": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/components": {
+      "get": {
+        "summary": "List all databox components and their state",
+        "responses": {
+          "200": {"description": "OK", "content": {"application/json": {"schema": {"type": "array", "items": {"$ref": "#/components/schemas/Component"}}}}},
+          "401": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/components/{name}": {
+      "get": {
+        "summary": "State and SLA of one component",
+        "parameters": [{"name": "name", "in": "path", "required": true, "schema": {"type": "string"}}],
+        "responses": {
+          "200": {"description": "OK", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Component"}}}},
+          "401": {"$ref": "#/components/responses/Error"},
+          "404": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/components/{name}/logs": {
+      "get": {
+        "summary": "The last lines of the log of one component",
+        "description": "Open it as a websocket to follow the log, each new line is sent as a LogLine message.",
+        "parameters": [
+          {"name": "name", "in": "path", "required": true, "schema": {"type": "string"}},
+          {"name": "lines", "in": "query", "schema": {"type": "integer", "default": 100, "maximum": 5000}}
+        ],
+        "responses": {
+          "200": {"description": "OK", "content": {"application/json": {"schema": {"type": "array", "items": {"$ref": "#/components/schemas/LogLine"}}}}},
+          "401": {"$ref": "#/components/responses/Error"},
+          "404": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/components/{name}/dependencies": {
+      "get": {
+        "summary": "The datasources a component reads",
+        "parameters": [{"name": "name", "in": "path", "required": true, "schema": {"type": "string"}}],
+        "responses": {
+          "200": {"description": "OK", "content": {"application/json": {"schema": {"type": "array", "items": {"$ref": "#/components/schemas/DatasourceRef"}}}}},
+          "401": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/components/{name}/consumers": {
+      "get": {
+        "summary": "The installed components reading from the stores of a component",
+        "parameters": [{"name": "name", "in": "path", "required": true, "schema": {"type": "string"}}],
+        "responses": {
+          "200": {"description": "OK", "content": {"application/json": {"schema": {"type": "array", "items": {"$ref": "#/components/schemas/DatasourceRef"}}}}},
+          "401": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/validate": {
+      "post": {
+        "summary": "Check a manifest without installing it",
+        "requestBody": {"required": true, "content": {"application/json": {"schema": {"$ref": "#/components/schemas/ManifestRequest"}}}},
+        "responses": {
+          "200": {"description": "Validation result", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Validation"}}}},
+          "400": {"$ref": "#/components/responses/Error"},
+          "401": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/install": {
+      "post": {
+        "summary": "Install an app or driver",
+        "requestBody": {"required": true, "content": {"application/json": {"schema": {"$ref": "#/components/schemas/ManifestRequest"}}}},
+        "responses": {
+          "202": {"$ref": "#/components/responses/Accepted"},
+          "400": {"$ref": "#/components/responses/Error"},
+          "401": {"$ref": "#/components/responses/Error"},
+          "409": {"$ref": "#/components/responses/Error"},
+          "422": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/upgrade": {
+      "post": {
+        "summary": "Upgrade an installed app or driver to a new manifest",
+        "description": "The resource-requirements of the new manifest replace the ones it was installed with.",
+        "requestBody": {"required": true, "content": {"application/json": {"schema": {"$ref": "#/components/schemas/ManifestRequest"}}}},
+        "responses": {
+          "202": {"$ref": "#/components/responses/Accepted"},
+          "400": {"$ref": "#/components/responses/Error"},
+          "401": {"$ref": "#/components/responses/Error"},
+          "404": {"$ref": "#/components/responses/Error"},
+          "422": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/uninstall": {
+      "post": {
+        "summary": "Uninstall an app or driver",
+        "description": "Refused with 409 if other installed components read its datasources, unless force is set.",
+        "requestBody": {"required": true, "content": {"application/json": {"schema": {"$ref": "#/components/schemas/UninstallRequest"}}}},
+        "responses": {
+          "202": {"$ref": "#/components/responses/Accepted"},
+          "400": {"$ref": "#/components/responses/Error"},
+          "401": {"$ref": "#/components/responses/Error"},
+          "404": {"$ref": "#/components/responses/Error"},
+          "409": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/restart": {
+      "post": {
+        "summary": "Restart an app or driver",
+        "requestBody": {"required": true, "content": {"application/json": {"schema": {"$ref": "#/components/schemas/NameRequest"}}}},
+        "responses": {
+          "202": {"$ref": "#/components/responses/Accepted"},
+          "400": {"$ref": "#/components/responses/Error"},
+          "401": {"$ref": "#/components/responses/Error"},
+          "404": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/stop": {
+      "post": {
+        "summary": "Stop an app or driver keeping it installed, it stays stopped after a reboot",
+        "requestBody": {"required": true, "content": {"application/json": {"schema": {"$ref": "#/components/schemas/NameRequest"}}}},
+        "responses": {
+          "202": {"$ref": "#/components/responses/Accepted"},
+          "400": {"$ref": "#/components/responses/Error"},
+          "401": {"$ref": "#/components/responses/Error"},
+          "404": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/start": {
+      "post": {
+        "summary": "Start a stopped app or driver",
+        "requestBody": {"required": true, "content": {"application/json": {"schema": {"$ref": "#/components/schemas/NameRequest"}}}},
+        "responses": {
+          "202": {"$ref": "#/components/responses/Accepted"},
+          "400": {"$ref": "#/components/responses/Error"},
+          "401": {"$ref": "#/components/responses/Error"},
+          "404": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/backup": {
+      "post": {
+        "summary": "Download a backup of the saved apps and drivers, certificates, arbiter tokens and store volumes. Each store is paused while its volume is copied. Set restoreArchive in DATABOX_CM_OPTIONS to restore it.",
+        "requestBody": {"required": false, "content": {"application/json": {"schema": {"$ref": "#/components/schemas/BackupRequest"}}}},
+        "responses": {
+          "200": {"description": "A tar.gz archive, encrypted if a passphrase was given", "content": {"application/gzip": {"schema": {"type": "string", "format": "binary"}}, "application/octet-stream": {"schema": {"type": "string", "format": "binary"}}}},
+          "400": {"$ref": "#/components/responses/Error"},
+          "401": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/logout": {
+      "post": {
+        "summary": "End the session of the caller and delete its cookie",
+        "responses": {
+          "204": {"description": "Logged out"},
+          "401": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/password": {
+      "post": {
+        "summary": "Change the password of the caller, their other sessions are logged out",
+        "requestBody": {"required": true, "content": {"application/json": {"schema": {"$ref": "#/components/schemas/PasswordChange"}}}},
+        "responses": {
+          "204": {"description": "Changed"},
+          "400": {"$ref": "#/components/responses/Error"},
+          "401": {"$ref": "#/components/responses/Error"},
+          "403": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/sessions": {
+      "get": {
+        "summary": "List the logged in dashboard sessions",
+        "responses": {
+          "200": {"description": "OK", "content": {"application/json": {"schema": {"type": "array", "items": {"$ref": "#/components/schemas/Session"}}}}},
+          "401": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/sessions/{id}": {
+      "delete": {
+        "summary": "Revoke a dashboard session",
+        "parameters": [{"name": "id", "in": "path", "required": true, "schema": {"type": "string"}}],
+        "responses": {
+          "204": {"description": "Revoked"},
+          "401": {"$ref": "#/components/responses/Error"},
+          "404": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/certificates": {
+      "get": {
+        "summary": "List the root CA and the certificates issued from it with when they expire",
+        "responses": {
+          "200": {"description": "OK", "content": {"application/json": {"schema": {"type": "array", "items": {"$ref": "#/components/schemas/CertStatus"}}}}},
+          "401": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/certificates/check": {
+      "post": {
+        "summary": "Renew any certificates that are due now rather than at the next scheduled check, admin only",
+        "responses": {
+          "202": {"description": "The check has started, the body is the result of the last one", "content": {"application/json": {"schema": {"type": "array", "items": {"$ref": "#/components/schemas/CertStatus"}}}}},
+          "401": {"$ref": "#/components/responses/Error"},
+          "403": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/lockouts": {
+      "get": {
+        "summary": "List clients with failed logins and any lockouts, admin only",
+        "responses": {
+          "200": {"description": "OK", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/LoginStatus"}}}},
+          "401": {"$ref": "#/components/responses/Error"},
+          "403": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/lockouts/{ip}": {
+      "delete": {
+        "summary": "Lift the lockout of a client and forget its failed logins, admin only",
+        "parameters": [{"name": "ip", "in": "path", "required": true, "schema": {"type": "string"}}],
+        "responses": {
+          "204": {"description": "Unlocked"},
+          "401": {"$ref": "#/components/responses/Error"},
+          "403": {"$ref": "#/components/responses/Error"},
+          "404": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/me": {
+      "get": {
+        "summary": "Get the user making the request",
+        "responses": {
+          "200": {"description": "OK", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/User"}}}},
+          "401": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/users": {
+      "get": {
+        "summary": "List the dashboard users, admin only",
+        "responses": {
+          "200": {"description": "OK", "content": {"application/json": {"schema": {"type": "array", "items": {"$ref": "#/components/schemas/User"}}}}},
+          "401": {"$ref": "#/components/responses/Error"},
+          "403": {"$ref": "#/components/responses/Error"}
+        }
+      },
+      "post": {
+        "summary": "Add a dashboard user, admin only",
+        "requestBody": {"required": true, "content": {"application/json": {"schema": {"$ref": "#/components/schemas/UserRequest"}}}},
+        "responses": {
+          "201": {"description": "Created", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/User"}}}},
+          "400": {"$ref": "#/components/responses/Error"},
+          "401": {"$ref": "#/components/responses/Error"},
+          "403": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/users/{name}": {
+      "put": {
+        "summary": "Change the role, apps or password of a user and log out their sessions, admin only",
+        "parameters": [{"name": "name", "in": "path", "required": true, "schema": {"type": "string"}}],
+        "requestBody": {"required": true, "content": {"application/json": {"schema": {"$ref": "#/components/schemas/UserRequest"}}}},
+        "responses": {
+          "200": {"description": "OK", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/User"}}}},
+          "400": {"$ref": "#/components/responses/Error"},
+          "401": {"$ref": "#/components/responses/Error"},
+          "403": {"$ref": "#/components/responses/Error"},
+          "404": {"$ref": "#/components/responses/Error"}
+        }
+      },
+      "delete": {
+        "summary": "Delete a user and log out their sessions, admin only",
+        "parameters": [{"name": "name", "in": "path", "required": true, "schema": {"type": "string"}}],
+        "responses": {
+          "204": {"description": "Deleted"},
+          "400": {"$ref": "#/components/responses/Error"},
+          "401": {"$ref": "#/components/responses/Error"},
+          "403": {"$ref": "#/components/responses/Error"},
+          "404": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/jobs": {
+      "get": {
+        "summary": "List recent jobs",
+        "responses": {
+          "200": {"description": "OK", "content": {"application/json": {"schema": {"type": "array", "items": {"$ref": "#/components/schemas/Job"}}}}},
+          "401": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/jobs/{id}": {
+      "get": {
+        "summary": "Progress of one job",
+        "parameters": [{"name": "id", "in": "path", "required": true, "schema": {"type": "string"}}],
+        "responses": {
+          "200": {"description": "OK", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Job"}}}},
+          "401": {"$ref": "#/components/responses/Error"},
+          "404": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    }
+  }
+}
+`
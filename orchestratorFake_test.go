@@ -0,0 +1,1275 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/events"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/api/types/network"
+	"github.com/docker/docker/api/types/swarm"
+	"github.com/docker/docker/api/types/volume"
+	"github.com/docker/docker/pkg/stdcopy"
+)
+
+// FakeOrchestrator is an in-memory Orchestrator that simulates a single node docker swarm.
+// Services get one task and one container per replica, containers removed from under a
+// service are rescheduled like swarm would and the usual container and service events
+// are emitted so crashDetectore and friends see the same stream they would from docker.
+// Time is simulated with a counter so the ordering of tasks is deterministic.
+type FakeOrchestrator struct {
+	mu sync.Mutex
+
+	services   map[string]*swarm.Service
+	tasks      map[string]*swarm.Task
+	containers map[string]*fakeContainer
+	networks   map[string]*types.NetworkResource
+	secrets    map[string]*swarm.Secret
+	volumes    map[string]*types.Volume
+	images     map[string]types.ImageSummary
+	registry   map[string]bool
+
+	subscribers []chan events.Message
+
+	nextID int
+	nextIP int
+	clock  time.Time
+}
+
+type fakeContainer struct {
+	summary   types.Container
+	taskID    string
+	serviceID string
+	files     map[string][]byte
+	logs      []fakeLogLine
+	stats     types.StatsJSON
+}
+
+type fakeLogLine struct {
+	time time.Time
+	line string
+}
+
+// NewFakeOrchestrator returns an empty FakeOrchestrator
+func NewFakeOrchestrator() *FakeOrchestrator {
+	return &FakeOrchestrator{
+		services:   make(map[string]*swarm.Service),
+		tasks:      make(map[string]*swarm.Task),
+		containers: make(map[string]*fakeContainer),
+		networks:   make(map[string]*types.NetworkResource),
+		secrets:    make(map[string]*swarm.Secret),
+		volumes:    make(map[string]*types.Volume),
+		images:     make(map[string]types.ImageSummary),
+		registry:   make(map[string]bool),
+		clock:      time.Unix(0, 0),
+	}
+}
+
+// AddImage makes image available locally as if it had already been pulled
+func (f *FakeOrchestrator) AddImage(image string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.images[image] = types.ImageSummary{ID: f.newID("sha256:"), RepoTags: []string{image}}
+}
+
+// AddRegistryImage makes image available to ImagePull
+func (f *FakeOrchestrator) AddRegistryImage(image string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.registry[image] = true
+}
+
+// CrashContainer simulates the container stopping on its own with exitCode.
+// If it belongs to a service a replacement task is scheduled.
+func (f *FakeOrchestrator) CrashContainer(containerID string, exitCode int) error {
+	f.mu.Lock()
+	c, ok := f.findContainer(containerID)
+	if !ok {
+		f.mu.Unlock()
+		return errors.New("No such container: " + containerID)
+	}
+	msgs := []events.Message{f.containerEvent(c, "die", map[string]string{"exitCode": strconv.Itoa(exitCode)})}
+	f.stopContainer(c, swarm.TaskStateFailed, "task: non-zero exit ("+strconv.Itoa(exitCode)+")")
+	msgs = append(msgs, f.reconcile()...)
+	f.mu.Unlock()
+
+	f.publish(msgs...)
+	return nil
+}
+
+// WriteContainerLog appends line to the stdout log of the container
+func (f *FakeOrchestrator) WriteContainerLog(containerID string, line string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	c, ok := f.findContainer(containerID)
+	if !ok {
+		return errors.New("No such container: " + containerID)
+	}
+	c.logs = append(c.logs, fakeLogLine{time: f.tick(), line: line})
+	return nil
+}
+
+// SetContainerStats sets what ContainerStats returns for the container
+func (f *FakeOrchestrator) SetContainerStats(containerID string, stats types.StatsJSON) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	c, ok := f.findContainer(containerID)
+	if !ok {
+		return errors.New("No such container: " + containerID)
+	}
+	c.stats = stats
+	return nil
+}
+
+//
+// services and tasks
+//
+
+func (f *FakeOrchestrator) ServiceCreate(ctx context.Context, spec swarm.ServiceSpec, options types.ServiceCreateOptions) (types.ServiceCreateResponse, error) {
+	f.mu.Lock()
+	if spec.Name == "" {
+		f.mu.Unlock()
+		return types.ServiceCreateResponse{}, errors.New("Error response from daemon: service name is required")
+	}
+	for _, s := range f.services {
+		if s.Spec.Name == spec.Name {
+			f.mu.Unlock()
+			return types.ServiceCreateResponse{}, errors.New("Error response from daemon: rpc error: code = AlreadyExists desc = name conflicts with an existing object: service " + spec.Name + " already exists")
+		}
+	}
+	if spec.TaskTemplate.ContainerSpec != nil {
+		for _, ref := range spec.TaskTemplate.ContainerSpec.Secrets {
+			if _, ok := f.secrets[ref.SecretID]; !ok {
+				f.mu.Unlock()
+				return types.ServiceCreateResponse{}, errors.New("Error response from daemon: rpc error: code = InvalidArgument desc = secret not found: " + ref.SecretID)
+			}
+		}
+	}
+
+	now := f.tick()
+	service := &swarm.Service{
+		ID:   f.newID(""),
+		Spec: spec,
+	}
+	service.Version.Index = 1
+	service.CreatedAt = now
+	service.UpdatedAt = now
+	f.services[service.ID] = service
+
+	msgs := []events.Message{f.serviceEvent(service, "create")}
+	msgs = append(msgs, f.reconcile()...)
+	f.mu.Unlock()
+
+	f.publish(msgs...)
+	return types.ServiceCreateResponse{ID: service.ID}, nil
+}
+
+func (f *FakeOrchestrator) ServiceInspectWithRaw(ctx context.Context, serviceID string, options types.ServiceInspectOptions) (swarm.Service, []byte, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	s, ok := f.findService(serviceID)
+	if !ok {
+		return swarm.Service{}, nil, errors.New("Error: No such service: " + serviceID)
+	}
+	return *s, nil, nil
+}
+
+func (f *FakeOrchestrator) ServiceList(ctx context.Context, options types.ServiceListOptions) ([]swarm.Service, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	res := []swarm.Service{}
+	for _, s := range f.services {
+		if !matchName(options.Filters, s.Spec.Name, true) ||
+			!matchLabels(options.Filters, s.Spec.Labels) ||
+			!matchID(options.Filters, s.ID) {
+			continue
+		}
+		res = append(res, *s)
+	}
+	return res, nil
+}
+
+func (f *FakeOrchestrator) ServiceRemove(ctx context.Context, serviceID string) error {
+	f.mu.Lock()
+	s, ok := f.findService(serviceID)
+	if !ok {
+		f.mu.Unlock()
+		return errors.New("Error: No such service: " + serviceID)
+	}
+	delete(f.services, s.ID)
+
+	msgs := []events.Message{f.serviceEvent(s, "remove")}
+	for _, c := range f.containers {
+		if c.serviceID == s.ID {
+			msgs = append(msgs, f.containerEvent(c, "kill", map[string]string{"signal": "15"}))
+			msgs = append(msgs, f.containerEvent(c, "die", map[string]string{"exitCode": "0"}))
+			f.removeContainer(c)
+			msgs = append(msgs, f.containerEvent(c, "destroy", nil))
+		}
+	}
+	for id, t := range f.tasks {
+		if t.ServiceID == s.ID {
+			delete(f.tasks, id)
+		}
+	}
+	f.mu.Unlock()
+
+	f.publish(msgs...)
+	return nil
+}
+
+func (f *FakeOrchestrator) ServiceUpdate(ctx context.Context, serviceID string, version swarm.Version, spec swarm.ServiceSpec, options types.ServiceUpdateOptions) (types.ServiceUpdateResponse, error) {
+	f.mu.Lock()
+	s, ok := f.findService(serviceID)
+	if !ok {
+		f.mu.Unlock()
+		return types.ServiceUpdateResponse{}, errors.New("Error: No such service: " + serviceID)
+	}
+	if s.Version.Index != version.Index {
+		f.mu.Unlock()
+		return types.ServiceUpdateResponse{}, errors.New("Error response from daemon: rpc error: code = Unknown desc = update out of sequence")
+	}
+
+	previous := s.Spec
+	s.PreviousSpec = &previous
+	s.Spec = spec
+	s.Version.Index++
+	s.UpdatedAt = f.tick()
+
+	msgs := []events.Message{f.serviceEvent(s, "update")}
+
+	//a new task template or a forced update replaces the running tasks
+	if taskTemplateChanged(previous.TaskTemplate, spec.TaskTemplate) {
+		for _, c := range f.containers {
+			if c.serviceID == s.ID {
+				msgs = append(msgs, f.containerEvent(c, "kill", map[string]string{"signal": "15"}))
+				msgs = append(msgs, f.containerEvent(c, "die", map[string]string{"exitCode": "0"}))
+				f.stopContainer(c, swarm.TaskStateShutdown, "shutdown")
+				f.removeContainer(c)
+				msgs = append(msgs, f.containerEvent(c, "destroy", nil))
+			}
+		}
+	}
+	msgs = append(msgs, f.reconcile()...)
+	f.mu.Unlock()
+
+	f.publish(msgs...)
+	return types.ServiceUpdateResponse{}, nil
+}
+
+// ServiceLogs returns the logs of every container the service has run, oldest first.
+// Follow is not supported, the logs end at the last line written so far.
+func (f *FakeOrchestrator) ServiceLogs(ctx context.Context, serviceID string, options types.ContainerLogsOptions) (io.ReadCloser, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	s, ok := f.findService(serviceID)
+	if !ok {
+		return nil, errors.New("Error: No such service: " + serviceID)
+	}
+
+	lines := []fakeLogLine{}
+	for _, c := range f.containers {
+		if c.serviceID == s.ID {
+			lines = append(lines, c.logs...)
+		}
+	}
+	sort.Slice(lines, func(a, b int) bool {
+		return lines[a].time.Before(lines[b].time)
+	})
+
+	return fakeLogs(lines, options), nil
+}
+
+func (f *FakeOrchestrator) TaskList(ctx context.Context, options types.TaskListOptions) ([]swarm.Task, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	res := []swarm.Task{}
+	for _, t := range f.tasks {
+		if options.Filters.Len() > 0 && options.Filters.Contains("service") {
+			s, ok := f.services[t.ServiceID]
+			if !ok {
+				continue
+			}
+			matched := false
+			for _, v := range options.Filters.Get("service") {
+				if v == s.ID || v == s.Spec.Name {
+					matched = true
+				}
+			}
+			if !matched {
+				continue
+			}
+		}
+		if options.Filters.Contains("desired-state") {
+			matched := false
+			for _, v := range options.Filters.Get("desired-state") {
+				if v == string(t.DesiredState) {
+					matched = true
+				}
+			}
+			if !matched {
+				continue
+			}
+		}
+		res = append(res, *t)
+	}
+	return res, nil
+}
+
+//
+// containers
+//
+
+func (f *FakeOrchestrator) ContainerCreate(ctx context.Context, config *container.Config, hostConfig *container.HostConfig, networkingConfig *network.NetworkingConfig, containerName string) (container.ContainerCreateCreatedBody, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if _, ok := f.images[config.Image]; !ok {
+		return container.ContainerCreateCreatedBody{}, errors.New("Error: No such image: " + config.Image)
+	}
+	if _, ok := f.findContainer(containerName); ok && containerName != "" {
+		return container.ContainerCreateCreatedBody{}, errors.New("Error response from daemon: Conflict. The container name \"/" + containerName + "\" is already in use")
+	}
+
+	c := f.newContainer(containerName, config.Image, config.Labels)
+	c.summary.State = "created"
+	if networkingConfig != nil {
+		for netName, settings := range networkingConfig.EndpointsConfig {
+			if n, ok := f.findNetwork(netName); ok {
+				var aliases []string
+				if settings != nil {
+					aliases = settings.Aliases
+				}
+				f.attach(n, c, aliases)
+			}
+		}
+	}
+
+	return container.ContainerCreateCreatedBody{ID: c.summary.ID}, nil
+}
+
+func (f *FakeOrchestrator) ContainerInspect(ctx context.Context, containerID string) (types.ContainerJSON, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	c, ok := f.findContainer(containerID)
+	if !ok {
+		return types.ContainerJSON{}, errors.New("Error: No such container: " + containerID)
+	}
+
+	networks := map[string]*network.EndpointSettings{}
+	for name, ep := range c.summary.NetworkSettings.Networks {
+		epCopy := *ep
+		networks[name] = &epCopy
+	}
+
+	return types.ContainerJSON{
+		ContainerJSONBase: &types.ContainerJSONBase{
+			ID:    c.summary.ID,
+			Name:  c.summary.Names[0],
+			Image: c.summary.Image,
+			State: &types.ContainerState{
+				Status:  c.summary.State,
+				Running: c.summary.State == "running",
+			},
+		},
+		Config: &container.Config{
+			Image:  c.summary.Image,
+			Labels: c.summary.Labels,
+		},
+		NetworkSettings: &types.NetworkSettings{
+			Networks: networks,
+		},
+	}, nil
+}
+
+func (f *FakeOrchestrator) ContainerList(ctx context.Context, options types.ContainerListOptions) ([]types.Container, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	res := []types.Container{}
+	for _, c := range f.containers {
+		if !options.All && c.summary.State != "running" {
+			continue
+		}
+		if !matchName(options.Filters, strings.TrimPrefix(c.summary.Names[0], "/"), false) ||
+			!matchLabels(options.Filters, c.summary.Labels) ||
+			!matchID(options.Filters, c.summary.ID) {
+			continue
+		}
+		res = append(res, c.summary)
+	}
+	return res, nil
+}
+
+// ContainerLogs returns the lines written with WriteContainerLog multiplexed like
+// docker does for containers without a TTY. Only Tail, Timestamps and ShowStdout are supported.
+func (f *FakeOrchestrator) ContainerLogs(ctx context.Context, containerID string, options types.ContainerLogsOptions) (io.ReadCloser, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	c, ok := f.findContainer(containerID)
+	if !ok {
+		return nil, errors.New("Error: No such container: " + containerID)
+	}
+
+	return fakeLogs(c.logs, options), nil
+}
+
+// ContainerStats returns the stats set with SetContainerStats. stream is ignored, only one sample is returned.
+func (f *FakeOrchestrator) ContainerStats(ctx context.Context, containerID string, stream bool) (types.ContainerStats, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	c, ok := f.findContainer(containerID)
+	if !ok {
+		return types.ContainerStats{}, errors.New("Error: No such container: " + containerID)
+	}
+
+	stats := c.stats
+	stats.ID = c.summary.ID
+	stats.Name = c.summary.Names[0]
+	stats.Read = f.tick()
+	payload, err := json.Marshal(stats)
+	if err != nil {
+		return types.ContainerStats{}, err
+	}
+	return types.ContainerStats{Body: ioutil.NopCloser(bytes.NewReader(payload)), OSType: "linux"}, nil
+}
+
+func (f *FakeOrchestrator) ContainerRemove(ctx context.Context, containerID string, options types.ContainerRemoveOptions) error {
+	f.mu.Lock()
+	c, ok := f.findContainer(containerID)
+	if !ok {
+		f.mu.Unlock()
+		return errors.New("Error: No such container: " + containerID)
+	}
+	if c.summary.State == "running" && !options.Force {
+		f.mu.Unlock()
+		return errors.New("Error response from daemon: You cannot remove a running container " + c.summary.ID + ". Stop the container before attempting removal or force remove")
+	}
+
+	msgs := []events.Message{}
+	if c.summary.State == "running" {
+		msgs = append(msgs, f.containerEvent(c, "kill", map[string]string{"signal": "9"}))
+		msgs = append(msgs, f.containerEvent(c, "die", map[string]string{"exitCode": "137"}))
+		f.stopContainer(c, swarm.TaskStateFailed, "task: non-zero exit (137)")
+	}
+	f.removeContainer(c)
+	msgs = append(msgs, f.containerEvent(c, "destroy", nil))
+	msgs = append(msgs, f.reconcile()...)
+	f.mu.Unlock()
+
+	f.publish(msgs...)
+	return nil
+}
+
+func (f *FakeOrchestrator) ContainerStart(ctx context.Context, containerID string, options types.ContainerStartOptions) error {
+	f.mu.Lock()
+	c, ok := f.findContainer(containerID)
+	if !ok {
+		f.mu.Unlock()
+		return errors.New("Error: No such container: " + containerID)
+	}
+	c.summary.State = "running"
+	c.summary.Status = "Up"
+	msg := f.containerEvent(c, "start", nil)
+	f.mu.Unlock()
+
+	f.publish(msg)
+	return nil
+}
+
+func (f *FakeOrchestrator) ContainerRestart(ctx context.Context, containerID string, timeout *time.Duration) error {
+	f.mu.Lock()
+	c, ok := f.findContainer(containerID)
+	if !ok {
+		f.mu.Unlock()
+		return errors.New("Error: No such container: " + containerID)
+	}
+	msgs := []events.Message{}
+	if c.summary.State == "running" {
+		msgs = append(msgs, f.containerEvent(c, "die", map[string]string{"exitCode": "0"}))
+	}
+	c.summary.State = "running"
+	c.summary.Status = "Up"
+	msgs = append(msgs, f.containerEvent(c, "start", nil))
+	msgs = append(msgs, f.containerEvent(c, "restart", nil))
+	f.mu.Unlock()
+
+	f.publish(msgs...)
+	return nil
+}
+
+func (f *FakeOrchestrator) ContainerPause(ctx context.Context, containerID string) error {
+	return f.setPaused(containerID, true)
+}
+
+func (f *FakeOrchestrator) ContainerUnpause(ctx context.Context, containerID string) error {
+	return f.setPaused(containerID, false)
+}
+
+func (f *FakeOrchestrator) setPaused(containerID string, paused bool) error {
+	f.mu.Lock()
+	c, ok := f.findContainer(containerID)
+	if !ok {
+		f.mu.Unlock()
+		return errors.New("Error: No such container: " + containerID)
+	}
+	from, to, action := "running", "paused", "pause"
+	if !paused {
+		from, to, action = "paused", "running", "unpause"
+	}
+	if c.summary.State != from {
+		f.mu.Unlock()
+		return errors.New("Error response from daemon: Container " + containerID + " is not " + from)
+	}
+	c.summary.State = to
+	msg := f.containerEvent(c, action, nil)
+	f.mu.Unlock()
+
+	f.publish(msg)
+	return nil
+}
+
+func (f *FakeOrchestrator) CopyToContainer(ctx context.Context, containerID, dstPath string, content io.Reader, options types.CopyToContainerOptions) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	c, ok := f.findContainer(containerID)
+	if !ok {
+		return errors.New("Error: No such container: " + containerID)
+	}
+	data, err := ioutil.ReadAll(content)
+	if err != nil {
+		return err
+	}
+	c.files[dstPath] = data
+	return nil
+}
+
+// CopyFromContainer returns what was copied to srcPath with CopyToContainer
+func (f *FakeOrchestrator) CopyFromContainer(ctx context.Context, containerID, srcPath string) (io.ReadCloser, types.ContainerPathStat, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	c, ok := f.findContainer(containerID)
+	if !ok {
+		return nil, types.ContainerPathStat{}, errors.New("Error: No such container: " + containerID)
+	}
+	data, ok := c.files[srcPath]
+	if !ok {
+		return nil, types.ContainerPathStat{}, errors.New("Error: No such container:path: " + containerID + ":" + srcPath)
+	}
+	stat := types.ContainerPathStat{Name: srcPath, Size: int64(len(data)), Mtime: f.clock}
+	return ioutil.NopCloser(bytes.NewReader(data)), stat, nil
+}
+
+//
+// networks
+//
+
+func (f *FakeOrchestrator) NetworkConnect(ctx context.Context, networkID, containerID string, config *network.EndpointSettings) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	n, ok := f.findNetwork(networkID)
+	if !ok {
+		return errors.New("Error: No such network: " + networkID)
+	}
+	c, ok := f.findContainer(containerID)
+	if !ok {
+		return errors.New("Error: No such container: " + containerID)
+	}
+	if _, ok := n.Containers[c.summary.ID]; ok {
+		return errors.New("Error response from daemon: endpoint with name " + c.summary.Names[0] + " already exists in network " + n.Name)
+	}
+	var aliases []string
+	if config != nil {
+		aliases = config.Aliases
+	}
+	f.attach(n, c, aliases)
+	return nil
+}
+
+func (f *FakeOrchestrator) NetworkCreate(ctx context.Context, name string, options types.NetworkCreate) (types.NetworkCreateResponse, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if _, ok := f.findNetwork(name); ok {
+		return types.NetworkCreateResponse{}, errors.New("Error response from daemon: network with name " + name + " already exists")
+	}
+	n := &types.NetworkResource{
+		Name:       name,
+		ID:         f.newID(""),
+		Created:    f.tick(),
+		Scope:      "swarm",
+		Driver:     options.Driver,
+		Internal:   options.Internal,
+		Attachable: options.Attachable,
+		Labels:     options.Labels,
+		Containers: map[string]types.EndpointResource{},
+	}
+	f.networks[n.ID] = n
+	return types.NetworkCreateResponse{ID: n.ID}, nil
+}
+
+func (f *FakeOrchestrator) NetworkDisconnect(ctx context.Context, networkID, containerID string, force bool) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	n, ok := f.findNetwork(networkID)
+	if !ok {
+		return errors.New("Error: No such network: " + networkID)
+	}
+	c, ok := f.findContainer(containerID)
+	if !ok {
+		return errors.New("Error: No such container: " + containerID)
+	}
+	if _, ok := n.Containers[c.summary.ID]; !ok {
+		return errors.New("Error response from daemon: container " + c.summary.ID + " is not connected to network " + n.Name)
+	}
+	f.detach(n, c)
+	return nil
+}
+
+func (f *FakeOrchestrator) NetworkInspect(ctx context.Context, networkID string, options types.NetworkInspectOptions) (types.NetworkResource, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	n, ok := f.findNetwork(networkID)
+	if !ok {
+		return types.NetworkResource{}, errors.New("Error: No such network: " + networkID)
+	}
+	return copyNetwork(n), nil
+}
+
+func (f *FakeOrchestrator) NetworkList(ctx context.Context, options types.NetworkListOptions) ([]types.NetworkResource, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	res := []types.NetworkResource{}
+	for _, n := range f.networks {
+		if !matchName(options.Filters, n.Name, false) ||
+			!matchLabels(options.Filters, n.Labels) ||
+			!matchID(options.Filters, n.ID) {
+			continue
+		}
+		res = append(res, copyNetwork(n))
+	}
+	return res, nil
+}
+
+func (f *FakeOrchestrator) NetworkRemove(ctx context.Context, networkID string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	n, ok := f.findNetwork(networkID)
+	if !ok {
+		return errors.New("Error: No such network: " + networkID)
+	}
+	if len(n.Containers) > 0 {
+		return errors.New("Error response from daemon: error while removing network: network " + n.Name + " id " + n.ID + " has active endpoints")
+	}
+	for _, s := range f.services {
+		for _, att := range s.Spec.TaskTemplate.Networks {
+			if att.Target == n.ID || att.Target == n.Name {
+				return errors.New("Error response from daemon: rpc error: code = FailedPrecondition desc = network " + n.ID + " is in use by service " + s.ID)
+			}
+		}
+	}
+	delete(f.networks, n.ID)
+	return nil
+}
+
+//
+// secrets
+//
+
+func (f *FakeOrchestrator) SecretCreate(ctx context.Context, spec swarm.SecretSpec) (types.SecretCreateResponse, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for _, s := range f.secrets {
+		if s.Spec.Name == spec.Name {
+			return types.SecretCreateResponse{}, errors.New("Error response from daemon: rpc error: code = AlreadyExists desc = secret " + spec.Name + " already exists")
+		}
+	}
+	secret := &swarm.Secret{
+		ID:   f.newID(""),
+		Spec: spec,
+	}
+	secret.CreatedAt = f.tick()
+	secret.UpdatedAt = secret.CreatedAt
+	f.secrets[secret.ID] = secret
+	return types.SecretCreateResponse{ID: secret.ID}, nil
+}
+
+func (f *FakeOrchestrator) SecretList(ctx context.Context, options types.SecretListOptions) ([]swarm.Secret, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	res := []swarm.Secret{}
+	for _, s := range f.secrets {
+		if !matchName(options.Filters, s.Spec.Name, true) ||
+			!matchLabels(options.Filters, s.Spec.Labels) ||
+			!matchID(options.Filters, s.ID) {
+			continue
+		}
+		res = append(res, *s)
+	}
+	return res, nil
+}
+
+func (f *FakeOrchestrator) SecretRemove(ctx context.Context, id string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	s, ok := f.secrets[id]
+	if !ok {
+		for _, sec := range f.secrets {
+			if sec.Spec.Name == id {
+				s, ok = sec, true
+			}
+		}
+	}
+	if !ok {
+		return errors.New("Error: No such secret: " + id)
+	}
+	for _, service := range f.services {
+		if service.Spec.TaskTemplate.ContainerSpec == nil {
+			continue
+		}
+		for _, ref := range service.Spec.TaskTemplate.ContainerSpec.Secrets {
+			if ref.SecretID == s.ID {
+				return errors.New("Error response from daemon: rpc error: code = InvalidArgument desc = secret '" + s.Spec.Name + "' is in use by the following service: " + service.Spec.Name)
+			}
+		}
+	}
+	delete(f.secrets, s.ID)
+	return nil
+}
+
+//
+// volumes
+//
+
+func (f *FakeOrchestrator) VolumeList(ctx context.Context, filter filters.Args) (volume.VolumeListOKBody, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	res := volume.VolumeListOKBody{Volumes: []*types.Volume{}}
+	for _, v := range f.volumes {
+		if !matchName(filter, v.Name, false) || !matchLabels(filter, v.Labels) {
+			continue
+		}
+		vCopy := *v
+		res.Volumes = append(res.Volumes, &vCopy)
+	}
+	return res, nil
+}
+
+func (f *FakeOrchestrator) VolumeRemove(ctx context.Context, volumeID string, force bool) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if _, ok := f.volumes[volumeID]; !ok {
+		return errors.New("Error: No such volume: " + volumeID)
+	}
+	for _, s := range f.services {
+		if s.Spec.TaskTemplate.ContainerSpec == nil {
+			continue
+		}
+		for _, m := range s.Spec.TaskTemplate.ContainerSpec.Mounts {
+			if m.Source == volumeID && !force {
+				return errors.New("Error response from daemon: remove " + volumeID + ": volume is in use")
+			}
+		}
+	}
+	delete(f.volumes, volumeID)
+	return nil
+}
+
+//
+// images
+//
+
+func (f *FakeOrchestrator) ImageList(ctx context.Context, options types.ImageListOptions) ([]types.ImageSummary, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	res := []types.ImageSummary{}
+	for _, i := range f.images {
+		res = append(res, i)
+	}
+	return res, nil
+}
+
+func (f *FakeOrchestrator) ImagePull(ctx context.Context, ref string, options types.ImagePullOptions) (io.ReadCloser, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	image := stripRegistryHost(ref)
+	if !f.registry[image] {
+		return nil, errors.New("Error response from daemon: pull access denied for " + image + ", repository does not exist or may require 'docker login'")
+	}
+	f.images[image] = types.ImageSummary{ID: f.newID("sha256:"), RepoTags: []string{image}}
+	return ioutil.NopCloser(bytes.NewBufferString(`{"status":"Downloaded newer image for ` + image + `"}`)), nil
+}
+
+//
+// events
+//
+
+func (f *FakeOrchestrator) Events(ctx context.Context, options types.EventsOptions) (<-chan events.Message, <-chan error) {
+	msgs := make(chan events.Message, 256)
+	errs := make(chan error, 1)
+
+	f.mu.Lock()
+	f.subscribers = append(f.subscribers, msgs)
+	f.mu.Unlock()
+
+	go func() {
+		<-ctx.Done()
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		for i, sub := range f.subscribers {
+			if sub == msgs {
+				f.subscribers = append(f.subscribers[:i], f.subscribers[i+1:]...)
+				break
+			}
+		}
+		errs <- ctx.Err()
+	}()
+
+	return msgs, errs
+}
+
+//
+// internals, all called with f.mu held unless noted
+//
+
+func (f *FakeOrchestrator) newID(prefix string) string {
+	f.nextID++
+	return prefix + strconv.Itoa(1000000+f.nextID)
+}
+
+func (f *FakeOrchestrator) tick() time.Time {
+	f.clock = f.clock.Add(time.Millisecond)
+	return f.clock
+}
+
+// publish sends msgs to all subscribers, it must be called without f.mu held
+func (f *FakeOrchestrator) publish(msgs ...events.Message) {
+	f.mu.Lock()
+	subs := append([]chan events.Message{}, f.subscribers...)
+	f.mu.Unlock()
+
+	for _, msg := range msgs {
+		for _, sub := range subs {
+			select {
+			case sub <- msg:
+			default:
+				//slow subscriber, drop it like the docker daemon would
+			}
+		}
+	}
+}
+
+// reconcile starts a task for every service that is short of replicas
+func (f *FakeOrchestrator) reconcile() []events.Message {
+	msgs := []events.Message{}
+	for _, s := range f.services {
+		want := uint64(1)
+		if s.Spec.Mode.Replicated != nil && s.Spec.Mode.Replicated.Replicas != nil {
+			want = *s.Spec.Mode.Replicated.Replicas
+		}
+		running := uint64(0)
+		for _, t := range f.tasks {
+			if t.ServiceID == s.ID && t.DesiredState == swarm.TaskStateRunning {
+				running++
+			}
+		}
+		for ; running < want; running++ {
+			msgs = append(msgs, f.startTask(s)...)
+		}
+		for _, t := range f.tasks {
+			if running <= want {
+				break
+			}
+			if t.ServiceID == s.ID && t.DesiredState == swarm.TaskStateRunning {
+				for _, c := range f.containers {
+					if c.taskID == t.ID {
+						msgs = append(msgs, f.containerEvent(c, "kill", map[string]string{"signal": "15"}))
+						msgs = append(msgs, f.containerEvent(c, "die", map[string]string{"exitCode": "0"}))
+						f.stopContainer(c, swarm.TaskStateShutdown, "shutdown")
+						f.removeContainer(c)
+						msgs = append(msgs, f.containerEvent(c, "destroy", nil))
+					}
+				}
+				t.DesiredState = swarm.TaskStateShutdown
+				running--
+			}
+		}
+	}
+	return msgs
+}
+
+func (f *FakeOrchestrator) startTask(s *swarm.Service) []events.Message {
+	now := f.tick()
+	task := &swarm.Task{
+		ID:           f.newID(""),
+		ServiceID:    s.ID,
+		Slot:         1,
+		Spec:         s.Spec.TaskTemplate,
+		DesiredState: swarm.TaskStateRunning,
+		Status: swarm.TaskStatus{
+			Timestamp: now,
+			State:     swarm.TaskStateRunning,
+			Message:   "started",
+		},
+	}
+	task.CreatedAt = now
+	task.UpdatedAt = now
+	f.tasks[task.ID] = task
+
+	image := ""
+	labels := map[string]string{}
+	if s.Spec.TaskTemplate.ContainerSpec != nil {
+		image = s.Spec.TaskTemplate.ContainerSpec.Image
+		for k, v := range s.Spec.TaskTemplate.ContainerSpec.Labels {
+			labels[k] = v
+		}
+	}
+
+	if _, ok := f.images[image]; !ok {
+		//swarm accepts the service but the task can never start
+		task.DesiredState = swarm.TaskStateShutdown
+		task.Status.State = swarm.TaskStateRejected
+		task.Status.Message = "preparing"
+		task.Status.Err = "No such image: " + image
+		return nil
+	}
+
+	labels["com.docker.swarm.service.name"] = s.Spec.Name
+	labels["com.docker.swarm.service.id"] = s.ID
+	labels["com.docker.swarm.task.id"] = task.ID
+	labels["com.docker.swarm.task.name"] = s.Spec.Name + ".1." + task.ID
+
+	c := f.newContainer(s.Spec.Name+".1."+task.ID, image, labels)
+	c.taskID = task.ID
+	c.serviceID = s.ID
+	c.summary.State = "running"
+	c.summary.Status = "Up"
+	task.Status.ContainerStatus = &swarm.ContainerStatus{ContainerID: c.summary.ID}
+
+	hostname := s.Spec.Name
+	if s.Spec.TaskTemplate.ContainerSpec != nil && s.Spec.TaskTemplate.ContainerSpec.Hostname != "" {
+		hostname = s.Spec.TaskTemplate.ContainerSpec.Hostname
+	}
+	for _, att := range s.Spec.TaskTemplate.Networks {
+		if n, ok := f.findNetwork(att.Target); ok {
+			f.attach(n, c, append([]string{hostname}, att.Aliases...))
+		}
+	}
+
+	if s.Spec.TaskTemplate.ContainerSpec != nil {
+		for _, m := range s.Spec.TaskTemplate.ContainerSpec.Mounts {
+			if m.Type != "volume" {
+				continue
+			}
+			if _, ok := f.volumes[m.Source]; !ok {
+				f.volumes[m.Source] = &types.Volume{
+					Name:       m.Source,
+					Driver:     "local",
+					Mountpoint: "/var/lib/docker/volumes/" + m.Source + "/_data",
+					Scope:      "local",
+					Labels:     map[string]string{},
+				}
+			}
+		}
+	}
+
+	return []events.Message{
+		f.containerEvent(c, "create", nil),
+		f.containerEvent(c, "start", nil),
+	}
+}
+
+// fakeLogs formats lines the way the docker logs endpoints do
+func fakeLogs(lines []fakeLogLine, options types.ContainerLogsOptions) io.ReadCloser {
+	if n, err := strconv.Atoi(options.Tail); err == nil && n >= 0 && n < len(lines) {
+		lines = lines[len(lines)-n:]
+	}
+
+	var buf bytes.Buffer
+	if options.ShowStdout {
+		w := stdcopy.NewStdWriter(&buf, stdcopy.Stdout)
+		for _, l := range lines {
+			line := l.line + "\n"
+			if options.Timestamps {
+				line = l.time.UTC().Format(time.RFC3339Nano) + " " + line
+			}
+			w.Write([]byte(line))
+		}
+	}
+	return ioutil.NopCloser(&buf)
+}
+
+func (f *FakeOrchestrator) newContainer(name string, image string, labels map[string]string) *fakeContainer {
+	id := f.newID("c")
+	if name == "" {
+		name = id
+	}
+	if labels == nil {
+		labels = map[string]string{}
+	}
+	c := &fakeContainer{
+		summary: types.Container{
+			ID:      id,
+			Names:   []string{"/" + name},
+			Image:   image,
+			Labels:  labels,
+			Created: f.tick().Unix(),
+			NetworkSettings: &types.SummaryNetworkSettings{
+				Networks: map[string]*network.EndpointSettings{},
+			},
+		},
+		files: map[string][]byte{},
+	}
+	f.containers[id] = c
+	return c
+}
+
+// stopContainer marks c and its task as no longer running
+func (f *FakeOrchestrator) stopContainer(c *fakeContainer, state swarm.TaskState, message string) {
+	c.summary.State = "exited"
+	c.summary.Status = "Exited"
+	if t, ok := f.tasks[c.taskID]; ok {
+		now := f.tick()
+		t.DesiredState = swarm.TaskStateShutdown
+		t.Status.State = state
+		t.Status.Message = message
+		t.Status.Timestamp = now
+		t.UpdatedAt = now
+	}
+}
+
+func (f *FakeOrchestrator) removeContainer(c *fakeContainer) {
+	for _, n := range f.networks {
+		if _, ok := n.Containers[c.summary.ID]; ok {
+			f.detach(n, c)
+		}
+	}
+	delete(f.containers, c.summary.ID)
+}
+
+func (f *FakeOrchestrator) attach(n *types.NetworkResource, c *fakeContainer, aliases []string) {
+	f.nextIP++
+	ip := "10.0." + strconv.Itoa(f.nextIP/250) + "." + strconv.Itoa(f.nextIP%250+2)
+	n.Containers[c.summary.ID] = types.EndpointResource{
+		Name:        strings.TrimPrefix(c.summary.Names[0], "/"),
+		EndpointID:  f.newID("ep"),
+		IPv4Address: ip + "/24",
+	}
+	c.summary.NetworkSettings.Networks[n.Name] = &network.EndpointSettings{
+		NetworkID:  n.ID,
+		Aliases:    aliases,
+		IPAddress:  ip,
+		IPAMConfig: &network.EndpointIPAMConfig{IPv4Address: ip},
+	}
+}
+
+func (f *FakeOrchestrator) detach(n *types.NetworkResource, c *fakeContainer) {
+	delete(n.Containers, c.summary.ID)
+	delete(c.summary.NetworkSettings.Networks, n.Name)
+}
+
+func (f *FakeOrchestrator) findService(idOrName string) (*swarm.Service, bool) {
+	if s, ok := f.services[idOrName]; ok {
+		return s, true
+	}
+	for _, s := range f.services {
+		if s.Spec.Name == idOrName {
+			return s, true
+		}
+	}
+	return nil, false
+}
+
+func (f *FakeOrchestrator) findContainer(idOrName string) (*fakeContainer, bool) {
+	if c, ok := f.containers[idOrName]; ok {
+		return c, true
+	}
+	for _, c := range f.containers {
+		if c.summary.Names[0] == "/"+idOrName {
+			return c, true
+		}
+	}
+	return nil, false
+}
+
+func (f *FakeOrchestrator) findNetwork(idOrName string) (*types.NetworkResource, bool) {
+	if n, ok := f.networks[idOrName]; ok {
+		return n, true
+	}
+	for _, n := range f.networks {
+		if n.Name == idOrName {
+			return n, true
+		}
+	}
+	return nil, false
+}
+
+func (f *FakeOrchestrator) serviceEvent(s *swarm.Service, action string) events.Message {
+	now := f.tick()
+	return events.Message{
+		Type:   events.ServiceEventType,
+		Action: action,
+		Actor: events.Actor{
+			ID:         s.ID,
+			Attributes: map[string]string{"name": s.Spec.Name},
+		},
+		Scope:    "swarm",
+		Time:     now.Unix(),
+		TimeNano: now.UnixNano(),
+	}
+}
+
+func (f *FakeOrchestrator) containerEvent(c *fakeContainer, action string, extra map[string]string) events.Message {
+	now := f.tick()
+	attributes := map[string]string{
+		"name":  strings.TrimPrefix(c.summary.Names[0], "/"),
+		"image": c.summary.Image,
+	}
+	for k, v := range c.summary.Labels {
+		attributes[k] = v
+	}
+	for k, v := range extra {
+		attributes[k] = v
+	}
+	return events.Message{
+		Status: action,
+		ID:     c.summary.ID,
+		From:   c.summary.Image,
+		Type:   events.ContainerEventType,
+		Action: action,
+		Actor: events.Actor{
+			ID:         c.summary.ID,
+			Attributes: attributes,
+		},
+		Scope:    "local",
+		Time:     now.Unix(),
+		TimeNano: now.UnixNano(),
+	}
+}
+
+func copyNetwork(n *types.NetworkResource) types.NetworkResource {
+	res := *n
+	res.Containers = map[string]types.EndpointResource{}
+	for k, v := range n.Containers {
+		res.Containers[k] = v
+	}
+	return res
+}
+
+func taskTemplateChanged(a swarm.TaskSpec, b swarm.TaskSpec) bool {
+	if a.ForceUpdate != b.ForceUpdate {
+		return true
+	}
+	if (a.ContainerSpec == nil) != (b.ContainerSpec == nil) {
+		return true
+	}
+	if a.ContainerSpec != nil && a.ContainerSpec.Image != b.ContainerSpec.Image {
+		return true
+	}
+	if a.ContainerSpec != nil && !sameSecretRefs(a.ContainerSpec.Secrets, b.ContainerSpec.Secrets) {
+		return true
+	}
+	return false
+}
+
+func sameSecretRefs(a []*swarm.SecretReference, b []*swarm.SecretReference) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i].SecretID != b[i].SecretID || a[i].SecretName != b[i].SecretName {
+			return false
+		}
+		if (a[i].File == nil) != (b[i].File == nil) || (a[i].File != nil && a[i].File.Name != b[i].File.Name) {
+			return false
+		}
+	}
+	return true
+}
+
+// stripRegistryHost turns registry.example.com/org/image:tag into org/image:tag
+func stripRegistryHost(ref string) string {
+	parts := strings.SplitN(ref, "/", 2)
+	if len(parts) == 2 && (strings.ContainsAny(parts[0], ".:") || parts[0] == "localhost") {
+		return parts[1]
+	}
+	return ref
+}
+
+// matchName applies a docker name filter, services and secrets match on prefix
+// everything else matches on substring.
+func matchName(args filters.Args, name string, prefix bool) bool {
+	if !args.Contains("name") {
+		return true
+	}
+	for _, v := range args.Get("name") {
+		if prefix && strings.HasPrefix(name, v) {
+			return true
+		}
+		if !prefix && strings.Contains(name, v) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchLabels applies docker label filters of the form key or key=value
+func matchLabels(args filters.Args, labels map[string]string) bool {
+	for _, v := range args.Get("label") {
+		kv := strings.SplitN(v, "=", 2)
+		val, ok := labels[kv[0]]
+		if !ok {
+			return false
+		}
+		if len(kv) == 2 && val != kv[1] {
+			return false
+		}
+	}
+	return true
+}
+
+func matchID(args filters.Args, id string) bool {
+	if !args.Contains("id") {
+		return true
+	}
+	for _, v := range args.Get("id") {
+		if strings.HasPrefix(id, v) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	libDatabox "github.com/me-box/lib-go-databox"
+	zmq "github.com/pebbe/zmq4"
+)
+
+// ReadinessOptions are how long, in seconds, each kind of component has to become
+// ready after its container starts. Anything not set uses the defaults below.
+type ReadinessOptions struct {
+	AppReadyTimeout     int
+	DriverReadyTimeout  int
+	StoreReadyTimeout   int
+	ArbiterReadyTimeout int
+}
+
+const (
+	defaultAppReadyTimeout     = 60
+	defaultDriverReadyTimeout  = 60
+	defaultStoreReadyTimeout   = 30
+	defaultArbiterReadyTimeout = 30
+)
+
+// readinessInterval is how long to wait between probes
+var readinessInterval = time.Second
+
+// readinessProbeTimeout is how long a single probe has to answer
+var readinessProbeTimeout = 5 * time.Second
+
+// readinessProbe checks once if a component is ready, it returns nil when it is
+type readinessProbe func(ctx context.Context) error
+
+// waitUntil calls check every readinessInterval until it returns true or timeout passes.
+// It reports if check ever returned true.
+func waitUntil(timeout time.Duration, check func() bool) bool {
+	deadline := time.Now().Add(timeout)
+	for {
+		if check() {
+			return true
+		}
+		if time.Now().After(deadline) {
+			return false
+		}
+		time.Sleep(readinessInterval)
+	}
+}
+
+// waitUntilReady probes the component running in cont until it is ready or the
+// timeout for its type passes. Components with no probe are ready once running.
+func (cm ContainerManager) waitUntilReady(name string, cont types.Container) error {
+
+	probe, timeout := cm.probeFor(name, cont.Labels["databox.type"])
+	if probe == nil {
+		return nil
+	}
+
+	libDatabox.Debug("Waiting for " + name + " to be ready")
+	var lastErr error
+	ready := waitUntil(timeout, func() bool {
+		ctx, cancel := context.WithTimeout(context.Background(), readinessProbeTimeout)
+		defer cancel()
+		lastErr = runProbe(ctx, probe)
+		return lastErr == nil
+	})
+	if !ready {
+		msg := name + " was not ready after " + timeout.String()
+		if lastErr != nil {
+			msg = msg + " " + lastErr.Error()
+		}
+		return errors.New(msg)
+	}
+
+	libDatabox.Debug(name + " is ready")
+	return nil
+}
+
+// runProbe stops waiting for probe when ctx is done as the store and arbiter clients do not take a context.
+// Each probe makes its own client so the next one does not share it, the client's socket gives up
+// waiting for an answer at the same deadline and is closed when the probe returns.
+func runProbe(ctx context.Context, probe readinessProbe) error {
+	result := make(chan error, 1)
+	go func() {
+		result <- probe(ctx)
+	}()
+	select {
+	case err := <-result:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// probeFor picks how to check a component called name of databoxType is ready and how long it has
+func (cm ContainerManager) probeFor(name string, databoxType string) (readinessProbe, time.Duration) {
+
+	switch {
+	case name == "arbiter":
+		return cm.arbiterProbe(), readyTimeout(cm.Readiness.ArbiterReadyTimeout, defaultArbiterReadyTimeout)
+	case databoxType == string(libDatabox.DataboxTypeStore):
+		return cm.storeProbe(name), readyTimeout(cm.Readiness.StoreReadyTimeout, defaultStoreReadyTimeout)
+	case databoxType == string(libDatabox.DataboxTypeApp):
+		return cm.statusProbe(name), readyTimeout(cm.Readiness.AppReadyTimeout, defaultAppReadyTimeout)
+	case databoxType == string(libDatabox.DataboxTypeDriver):
+		return cm.statusProbe(name), readyTimeout(cm.Readiness.DriverReadyTimeout, defaultDriverReadyTimeout)
+	}
+
+	return nil, 0
+}
+
+func readyTimeout(seconds int, defaultSeconds int) time.Duration {
+	if seconds <= 0 {
+		seconds = defaultSeconds
+	}
+	return time.Duration(seconds) * time.Second
+}
+
+// statusProbe calls the /status endpoint every databox app and driver serves
+func (cm ContainerManager) statusProbe(name string) readinessProbe {
+	return func(ctx context.Context) error {
+		req, err := http.NewRequest("GET", "https://"+name+":8080/status", nil)
+		if err != nil {
+			return err
+		}
+		resp, err := cm.Request.Do(req.WithContext(ctx))
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		body, _ := ioutil.ReadAll(resp.Body)
+		if resp.StatusCode != http.StatusOK {
+			return errors.New("/status returned " + strconv.Itoa(resp.StatusCode) + " " + strings.TrimSpace(string(body)))
+		}
+		return nil
+	}
+}
+
+// storeProbe asks the store for its datasource catalogue over ZMQ
+func (cm ContainerManager) storeProbe(name string) readinessProbe {
+	storeURL := "tcp://" + name + ":5555"
+	return func(ctx context.Context) error {
+		return askStoreCatalogue(cm, storeURL, probeTimeLeft(ctx))
+	}
+}
+
+// askStoreCatalogue is how storeProbe reaches a store, tests replace it as they have no stores to ask
+var askStoreCatalogue = func(cm ContainerManager, storeURL string, timeout time.Duration) error {
+	sc := libDatabox.NewCoreStoreClient(cm.ArbiterClient, "/run/secrets/ZMQ_PUBLIC_KEY", storeURL, false)
+	defer closeProbeSocket(sc.ZestC.ZMQsoc, timeout)()
+	_, err := sc.GetStoreDataSourceCatalogue(storeURL)
+	return err
+}
+
+// arbiterProbe asks the arbiter for the root catalogue
+func (cm ContainerManager) arbiterProbe() readinessProbe {
+	return func(ctx context.Context) error {
+		ac, err := libDatabox.NewArbiterClient("/certs/arbiterToken-container-manager", "/run/secrets/ZMQ_PUBLIC_KEY", "tcp://arbiter:4444")
+		if err != nil {
+			return err
+		}
+		defer closeProbeSocket(ac.ZestC.ZMQsoc, probeTimeLeft(ctx))()
+		_, err = ac.GetRootDataSourceCatalogue()
+		return err
+	}
+}
+
+// probeTimeLeft is how long a probe has until ctx is done
+func probeTimeLeft(ctx context.Context) time.Duration {
+	if deadline, ok := ctx.Deadline(); ok {
+		return time.Until(deadline)
+	}
+	return readinessProbeTimeout
+}
+
+// closeProbeSocket makes soc give up waiting for an answer after timeout so a probe runProbe
+// has stopped waiting for still returns. Call the func it returns once the probe is done to close soc.
+func closeProbeSocket(soc *zmq.Socket, timeout time.Duration) func() {
+	if soc == nil {
+		return func() {}
+	}
+	soc.SetRcvtimeo(timeout)
+	soc.SetSndtimeo(timeout)
+	return func() {
+		soc.Close()
+	}
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	libDatabox "github.com/me-box/lib-go-databox"
+)
+
+// the store of a new component only answers storeProbe once the container manager
+// may read its catalogue so that has to be granted before waiting for it
+func TestStoreCatalogueGrant(t *testing.T) {
+
+	defer func(settle time.Duration) { upgradeSettleTime = settle }(upgradeSettleTime)
+	upgradeSettleTime = time.Second
+
+	tests := []struct {
+		name    string
+		run     func(td *testDatabox) error
+		store   string
+		broken  bool
+		wantErr bool
+	}{
+		{
+			name: "install",
+			run: func(td *testDatabox) error {
+				return td.cm.LaunchFromSLA(td.testSLA("driver-cat", libDatabox.DataboxTypeDriver), true, nil)
+			},
+			store: "driver-cat-core-store",
+		},
+		{
+			name: "upgrade that adds a store",
+			run: func(td *testDatabox) error {
+				sla := td.testSLA("driver-cat", libDatabox.DataboxTypeDriver)
+				sla.ResourceRequirements.Store = ""
+				err := td.cm.LaunchFromSLA(sla, true, nil)
+				if err != nil {
+					return err
+				}
+				td.cli.AddImage("databoxsystems/driver-cat:" + upgradeVersion)
+				sla.DockerImageTag = upgradeVersion
+				sla.ResourceRequirements.Store = "core-store"
+				return td.cm.Upgrade(sla, ResourceLimits{}, nil)
+			},
+			store: "driver-cat-core-store",
+		},
+		{
+			name: "install rolled back",
+			run: func(td *testDatabox) error {
+				return td.cm.LaunchFromSLA(td.testSLA("driver-cat", libDatabox.DataboxTypeDriver), true, nil)
+			},
+			store:   "driver-cat-core-store",
+			broken:  true,
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.broken {
+				defer func(ask func(ContainerManager, string, time.Duration) error) { askStoreCatalogue = ask }(askStoreCatalogue)
+				askStoreCatalogue = func(cm ContainerManager, storeURL string, timeout time.Duration) error {
+					return errors.New("store is not answering")
+				}
+			}
+
+			td := newTestDatabox(t)
+			td.cm.Readiness.StoreReadyTimeout = 1
+			err := tt.run(td)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("error = %v, want error %v", err, tt.wantErr)
+			}
+
+			granted := td.arbiter.hasPermission(newPermission("container-manager", tt.store, "/cat", "GET", ""))
+			if granted == tt.wantErr {
+				t.Errorf("catalogue permission on %s granted = %v after the %s", tt.store, granted, tt.name)
+			}
+		})
+	}
+}
+
+func TestProbeFor(t *testing.T) {
+
+	tests := []struct {
+		name        string
+		databoxType string
+		readiness   ReadinessOptions
+		wantProbe   bool
+		wantTimeout time.Duration
+	}{
+		{name: "arbiter", databoxType: "system", wantProbe: true, wantTimeout: 30 * time.Second},
+		{name: "arbiter", databoxType: "system", readiness: ReadinessOptions{ArbiterReadyTimeout: 5}, wantProbe: true, wantTimeout: 5 * time.Second},
+		{name: "driver-one-core-store", databoxType: "store", wantProbe: true, wantTimeout: 30 * time.Second},
+		{name: "app-one", databoxType: "app", wantProbe: true, wantTimeout: 60 * time.Second},
+		{name: "app-one", databoxType: "app", readiness: ReadinessOptions{AppReadyTimeout: -1}, wantProbe: true, wantTimeout: 60 * time.Second},
+		{name: "driver-one", databoxType: "driver", readiness: ReadinessOptions{DriverReadyTimeout: 90}, wantProbe: true, wantTimeout: 90 * time.Second},
+		{name: "export-service", databoxType: "system"},
+		{name: "no-label"},
+	}
+
+	for _, tt := range tests {
+		cm := ContainerManager{Readiness: tt.readiness}
+		probe, timeout := cm.probeFor(tt.name, tt.databoxType)
+		if (probe != nil) != tt.wantProbe || timeout != tt.wantTimeout {
+			t.Errorf("probeFor(%s, %s) = probe %v, %v want probe %v, %v", tt.name, tt.databoxType, probe != nil, timeout, tt.wantProbe, tt.wantTimeout)
+		}
+	}
+}
+
+// a store that never answers fails the wait once its timeout passes and every
+// probe given up on still returns, so none of their clients are left behind
+func TestWaitUntilReadyTimeout(t *testing.T) {
+
+	defer func(timeout time.Duration) { readinessProbeTimeout = timeout }(readinessProbeTimeout)
+	readinessProbeTimeout = 20 * time.Millisecond
+	defer func(ask func(ContainerManager, string, time.Duration) error) { askStoreCatalogue = ask }(askStoreCatalogue)
+
+	var mu sync.Mutex
+	running, probes := 0, 0
+	askStoreCatalogue = func(cm ContainerManager, storeURL string, timeout time.Duration) error {
+		mu.Lock()
+		running++
+		probes++
+		mu.Unlock()
+		defer func() {
+			mu.Lock()
+			running--
+			mu.Unlock()
+		}()
+
+		if timeout <= 0 || timeout > readinessProbeTimeout {
+			t.Errorf("probe socket timeout = %v, want up to %v", timeout, readinessProbeTimeout)
+		}
+		//the socket gives up waiting for an answer that never comes
+		time.Sleep(timeout + 10*time.Millisecond)
+		return errors.New("resource temporarily unavailable")
+	}
+
+	cm := ContainerManager{Readiness: ReadinessOptions{StoreReadyTimeout: 1}}
+	cont := types.Container{Labels: map[string]string{"databox.type": "store"}}
+	start := time.Now()
+	err := cm.waitUntilReady("driver-slow-core-store", cont)
+	if err == nil || !strings.Contains(err.Error(), "was not ready after 1s") {
+		t.Fatalf("error = %v, want it not to be ready after 1s", err)
+	}
+	if took := time.Since(start); took > 2*time.Second {
+		t.Errorf("waited %v for a 1s timeout", took)
+	}
+
+	time.Sleep(50 * time.Millisecond)
+	mu.Lock()
+	defer mu.Unlock()
+	if probes < 2 {
+		t.Errorf("the store was probed %d times", probes)
+	}
+	if running != 0 {
+		t.Errorf("%d probes are still running after the wait", running)
+	}
+}
+
+func TestStatusProbe(t *testing.T) {
+
+	defer func(timeout time.Duration) { readinessProbeTimeout = timeout }(readinessProbeTimeout)
+	readinessProbeTimeout = 50 * time.Millisecond
+
+	tests := []struct {
+		name    string
+		status  int
+		body    string
+		delay   time.Duration
+		wantErr string
+	}{
+		{name: "ready", status: http.StatusOK, body: "active"},
+		{name: "starting", status: http.StatusServiceUnavailable, body: "starting\n", wantErr: "/status returned 503 starting"},
+		{name: "not answering", status: http.StatusOK, delay: 200 * time.Millisecond, wantErr: context.DeadlineExceeded.Error()},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path != "/status" {
+					http.NotFound(w, r)
+					return
+				}
+				time.Sleep(tt.delay)
+				w.WriteHeader(tt.status)
+				w.Write([]byte(tt.body))
+			}))
+			defer srv.Close()
+
+			//send https://app-status:8080/status to the test server
+			client := srv.Client()
+			transport := client.Transport
+			client.Transport = roundTripFunc(func(req *http.Request) (*http.Response, error) {
+				req.URL.Host = srv.Listener.Addr().String()
+				return transport.RoundTrip(req)
+			})
+
+			cm := ContainerManager{Request: client}
+			probe, _ := cm.probeFor("app-status", "app")
+			ctx, cancel := context.WithTimeout(context.Background(), readinessProbeTimeout)
+			defer cancel()
+			err := runProbe(ctx, probe)
+			if tt.wantErr == "" && err != nil || tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Errorf("probe error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+type roundTripFunc func(req *http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"errors"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	libDatabox "github.com/me-box/lib-go-databox"
+)
+
+// reloadParallelism is the most components reloadApps will start at the same time
+var reloadParallelism = 4
+
+// dependencyGraph maps each component in slaList to the other components in slaList
+// it depends on. It is built from the same consumerIndex that guards uninstall so
+// the reload order and the uninstall check agree on what depends on what.
+func dependencyGraph(slaList []libDatabox.SLA) map[string][]string {
+
+	ci := newConsumerIndex(slaList)
+	graph := map[string][]string{}
+	for _, sla := range slaList {
+		graph[sla.Name] = ci.dependsOn(sla.Name)
+	}
+
+	return graph
+}
+
+// reloadWaves orders graph into waves. Everything in a wave only depends on
+// components in earlier waves so a whole wave can be started at once.
+// Components in a dependency cycle, or that depend on one, can never be
+// started and are returned in cyclic.
+func reloadWaves(graph map[string][]string) (waves [][]string, cyclic []string) {
+
+	placed := map[string]bool{}
+	for len(placed) < len(graph) {
+		wave := []string{}
+		for name, deps := range graph {
+			if placed[name] {
+				continue
+			}
+			ready := true
+			for _, dep := range deps {
+				if !placed[dep] {
+					ready = false
+					break
+				}
+			}
+			if ready {
+				wave = append(wave, name)
+			}
+		}
+
+		if len(wave) == 0 {
+			//nothing left can start so whatever remains is in or behind a cycle
+			for name := range graph {
+				if !placed[name] {
+					cyclic = append(cyclic, name)
+				}
+			}
+			sort.Strings(cyclic)
+			return waves, cyclic
+		}
+
+		sort.Strings(wave)
+		for _, name := range wave {
+			placed[name] = true
+		}
+		waves = append(waves, wave)
+	}
+
+	return waves, cyclic
+}
+
+// reloadInOrder starts the components in slaList one wave at a time, at most
+// reloadParallelism at once. The components in skipped are not started, they are
+// still in slaList so the order knows what reads from them. A component is not
+// started if anything it depends on was skipped or failed to start, its job is
+// marked as blocked instead.
+func (cm ContainerManager) reloadInOrder(slaList []libDatabox.SLA, skipped map[string]error) {
+
+	slas := map[string]libDatabox.SLA{}
+	for _, sla := range slaList {
+		slas[sla.Name] = sla
+	}
+
+	graph := dependencyGraph(slaList)
+	waves, cyclic := reloadWaves(graph)
+
+	//failed holds why a component did not start, anything depending on it is blocked
+	failed := map[string]error{}
+	var failedMu sync.Mutex
+	for name, err := range skipped {
+		failed[name] = err
+	}
+
+	if len(cyclic) > 0 {
+		libDatabox.Err("reloadApps dependency cycle between " + strings.Join(cyclic, ", "))
+		for _, name := range cyclic {
+			if _, ok := skipped[name]; ok {
+				continue
+			}
+			err := errors.New("Can't start " + name + " it is part of or depends on a dependency cycle")
+			cm.Jobs.NewJob(JobTypeReload, name).Block(err)
+			failed[name] = err
+		}
+	}
+
+	for i, wave := range waves {
+		libDatabox.Debug("reloadApps wave " + strconv.Itoa(i) + " " + strings.Join(wave, ","))
+
+		var waitGroup sync.WaitGroup
+		slots := make(chan struct{}, reloadParallelism)
+
+		for _, name := range wave {
+			if _, ok := skipped[name]; ok {
+				continue
+			}
+			job := cm.Jobs.NewJob(JobTypeReload, name)
+
+			blockedBy := []string{}
+			failedMu.Lock()
+			for _, dep := range graph[name] {
+				if _, ok := failed[dep]; ok {
+					blockedBy = append(blockedBy, dep)
+				}
+			}
+			if len(blockedBy) > 0 {
+				err := errors.New("Can't start " + name + " it depends on " + strings.Join(blockedBy, ", ") + " which did not start")
+				failed[name] = err
+				failedMu.Unlock()
+				libDatabox.Warn("reloadApps " + err.Error())
+				job.Block(err)
+				continue
+			}
+			failedMu.Unlock()
+
+			waitGroup.Add(1)
+			go func(sla libDatabox.SLA, job *Job) {
+				defer waitGroup.Done()
+				slots <- struct{}{}
+				defer func() { <-slots }()
+
+				err := cm.LaunchFromSLA(sla, false, job)
+				if err != nil {
+					libDatabox.Err("reloadApps failed to start " + sla.Name + " " + err.Error())
+					failedMu.Lock()
+					failed[sla.Name] = err
+					failedMu.Unlock()
+				}
+			}(slas[name], job)
+		}
+
+		waitGroup.Wait()
+	}
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	libDatabox "github.com/me-box/lib-go-databox"
+)
+
+func TestReloadWaves(t *testing.T) {
+
+	store := func(name string, reads ...string) libDatabox.SLA {
+		sla := libDatabox.SLA{Name: name, DataboxType: libDatabox.DataboxTypeDriver}
+		sla.ResourceRequirements.Store = "core-store"
+		for _, driver := range reads {
+			sla.Datasources = append(sla.Datasources, libDatabox.DataSource{
+				Hypercat: libDatabox.HypercatItem{Href: "tcp://" + driver + "-core-store:5555/kv/data"},
+			})
+		}
+		return sla
+	}
+	app := func(name string, reads ...string) libDatabox.SLA {
+		sla := store(name, reads...)
+		sla.ResourceRequirements.Store = ""
+		sla.DataboxType = libDatabox.DataboxTypeApp
+		return sla
+	}
+
+	tests := []struct {
+		name       string
+		slas       []libDatabox.SLA
+		wantWaves  [][]string
+		wantCyclic []string
+	}{
+		{
+			name:      "no dependencies",
+			slas:      []libDatabox.SLA{app("app-b"), store("driver-a")},
+			wantWaves: [][]string{{"app-b", "driver-a"}},
+		},
+		{
+			name: "apps after their drivers",
+			slas: []libDatabox.SLA{
+				app("app-both", "driver-a", "driver-b"),
+				app("app-one", "driver-a", "driver-a"),
+				store("driver-a"),
+				store("driver-b", "driver-a"),
+			},
+			wantWaves: [][]string{{"driver-a"}, {"app-one", "driver-b"}, {"app-both"}},
+		},
+		{
+			name:      "store of something not being reloaded",
+			slas:      []libDatabox.SLA{app("app-a", "driver-gone")},
+			wantWaves: [][]string{{"app-a"}},
+		},
+		{
+			name: "cycle",
+			slas: []libDatabox.SLA{
+				store("driver-a", "driver-b"),
+				store("driver-b", "driver-a"),
+				app("app-c", "driver-b"),
+				store("driver-d"),
+			},
+			wantWaves:  [][]string{{"driver-d"}},
+			wantCyclic: []string{"app-c", "driver-a", "driver-b"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			waves, cyclic := reloadWaves(dependencyGraph(tt.slas))
+			if !reflect.DeepEqual(waves, tt.wantWaves) {
+				t.Errorf("waves = %v, want %v", waves, tt.wantWaves)
+			}
+			if !reflect.DeepEqual(cyclic, tt.wantCyclic) {
+				t.Errorf("cyclic = %v, want %v", cyclic, tt.wantCyclic)
+			}
+		})
+	}
+}
+
+func TestReloadInOrder(t *testing.T) {
+
+	td := newTestDatabox(t)
+
+	//driver-missing has no image so it and app-blocked, which reads from it, can not start
+	missing := libDatabox.SLA{Name: "driver-missing", DataboxType: libDatabox.DataboxTypeDriver}
+	missing.ResourceRequirements.Store = "core-store"
+	cycleA := td.testSLA("driver-cycle-a", libDatabox.DataboxTypeDriver, "driver-cycle-b")
+	cycleB := td.testSLA("driver-cycle-b", libDatabox.DataboxTypeDriver, "driver-cycle-a")
+
+	td.cm.reloadInOrder([]libDatabox.SLA{
+		td.testSLA("app-reader", libDatabox.DataboxTypeApp, "driver-source"),
+		td.testSLA("driver-source", libDatabox.DataboxTypeDriver),
+		td.testSLA("app-blocked", libDatabox.DataboxTypeApp, "driver-missing"),
+		missing,
+		cycleA,
+		cycleB,
+	}, nil)
+
+	store := td.service("driver-source-core-store")
+	reader := td.service("app-reader")
+	if store == nil || reader == nil {
+		t.Fatal("app-reader and the store it reads from should be running")
+	}
+	if !store.CreatedAt.Before(reader.CreatedAt) {
+		t.Errorf("app-reader was started at %v before its store at %v", reader.CreatedAt, store.CreatedAt)
+	}
+
+	wantStates := map[string]JobState{
+		"driver-source":  JobStateRunning,
+		"app-reader":     JobStateRunning,
+		"driver-missing": JobStateFailed,
+		"app-blocked":    JobStateBlocked,
+		"driver-cycle-a": JobStateBlocked,
+		"driver-cycle-b": JobStateBlocked,
+	}
+	for _, job := range td.cm.Jobs.List() {
+		if job.Type != JobTypeReload {
+			continue
+		}
+		if job.State != wantStates[job.Name] {
+			t.Errorf("%s job state = %s, want %s", job.Name, job.State, wantStates[job.Name])
+		}
+		delete(wantStates, job.Name)
+	}
+	for name := range wantStates {
+		t.Errorf("no reload job for %s", name)
+	}
+
+	for _, name := range []string{"driver-missing", "app-blocked", "driver-cycle-a", "driver-cycle-b"} {
+		if td.service(name) != nil {
+			t.Errorf("%s should not have been started", name)
+		}
+	}
+}
+
+func TestReloadAppsBlocksReadersOfSkipped(t *testing.T) {
+
+	td := newTestDatabox(t)
+	td.cm.Stopped = NewStopTracker(td.cm.Store)
+	for _, sla := range []libDatabox.SLA{
+		td.testSLA("driver-stopped", libDatabox.DataboxTypeDriver),
+		td.testSLA("app-reader", libDatabox.DataboxTypeApp, "driver-stopped"),
+		td.testSLA("app-other", libDatabox.DataboxTypeApp),
+	} {
+		err := td.cm.Store.SaveSLA(sla)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err := td.cm.Stopped.stop("driver-stopped", stoppedRecord{Type: string(libDatabox.DataboxTypeDriver)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	td.cm.reloadApps()
+
+	wantStates := map[string]JobState{
+		"app-reader": JobStateBlocked,
+		"app-other":  JobStateRunning,
+	}
+	for _, job := range td.cm.Jobs.List() {
+		if job.Type != JobTypeReload {
+			continue
+		}
+		want, ok := wantStates[job.Name]
+		if !ok {
+			t.Errorf("unexpected reload job for %s", job.Name)
+			continue
+		}
+		if job.State != want {
+			t.Errorf("%s job state = %s, want %s", job.Name, job.State, want)
+		}
+		delete(wantStates, job.Name)
+	}
+	for name := range wantStates {
+		t.Errorf("no reload job for %s", name)
+	}
+
+	for _, name := range []string{"driver-stopped", "app-reader"} {
+		if td.service(name) != nil {
+			t.Errorf("%s should not have been started", name)
+		}
+	}
+}
+
+func TestDependencyGraphMatchesDependents(t *testing.T) {
+
+	td := newTestDatabox(t)
+	slas := []libDatabox.SLA{
+		td.testSLA("driver-a", libDatabox.DataboxTypeDriver),
+		td.testSLA("driver-b", libDatabox.DataboxTypeDriver, "driver-a"),
+		td.testSLA("app-c", libDatabox.DataboxTypeApp, "driver-a", "driver-b", "driver-gone"),
+	}
+
+	graph := dependencyGraph(slas)
+	ci := newConsumerIndex(slas)
+	for _, owner := range slas {
+		for _, reader := range slas {
+			edge := false
+			for _, dep := range graph[reader.Name] {
+				edge = edge || dep == owner.Name
+			}
+			dependent := false
+			for _, d := range ci.dependents(owner.Name) {
+				dependent = dependent || d == reader.Name
+			}
+			if edge != dependent {
+				t.Errorf("%s depends on %s in the reload order %v but for uninstall %v", reader.Name, owner.Name, edge, dependent)
+			}
+		}
+	}
+}
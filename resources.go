@@ -0,0 +1,259 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"sync"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/swarm"
+	units "github.com/docker/go-units"
+	libDatabox "github.com/me-box/lib-go-databox"
+)
+
+// ResourceLimits are the CPU and memory an app or driver asks for in the
+// resource-requirements section of its manifest. CPUs are in cores so 0.5 is
+// half a core, memory is a docker style size such as "256m". Anything left
+// out falls back to the defaults for the components DataboxType.
+type ResourceLimits struct {
+	CPULimit          float64 `json:"cpu-limit,omitempty"`
+	CPUReservation    float64 `json:"cpu-reservation,omitempty"`
+	MemoryLimit       string  `json:"memory-limit,omitempty"`
+	MemoryReservation string  `json:"memory-reservation,omitempty"`
+}
+
+// ResourceOptions are the resource settings in DATABOX_CM_OPTIONS that
+// libDatabox.ContainerManagerOptions does not know about. CPUBudget and
+// MemoryBudget are the most all apps and drivers together can reserve,
+// if they are not set installs are never refused.
+type ResourceOptions struct {
+	AppResourceDefaults    ResourceLimits
+	DriverResourceDefaults ResourceLimits
+	CPUBudget              float64
+	MemoryBudget           string
+}
+
+// resourceRequest pulls the CPU and memory settings out of an install request
+// as libDatabox.ResourceRequirements only holds the store
+type resourceRequest struct {
+	Manifest struct {
+		ResourceRequirements ResourceLimits `json:"resource-requirements"`
+	} `json:"manifest"`
+}
+
+// parseResourceRequest returns the ResourceLimits in an install request payload
+func parseResourceRequest(payload []byte) (ResourceLimits, error) {
+	var request resourceRequest
+	err := json.Unmarshal(payload, &request)
+	return request.Manifest.ResourceRequirements, err
+}
+
+// withDefaults fills anything not set in l from defaults
+func (l ResourceLimits) withDefaults(defaults ResourceLimits) ResourceLimits {
+	if l.CPULimit == 0 {
+		l.CPULimit = defaults.CPULimit
+	}
+	if l.CPUReservation == 0 {
+		l.CPUReservation = defaults.CPUReservation
+	}
+	if l.MemoryLimit == "" {
+		l.MemoryLimit = defaults.MemoryLimit
+	}
+	if l.MemoryReservation == "" {
+		l.MemoryReservation = defaults.MemoryReservation
+	}
+	return l
+}
+
+// toSwarm converts l to the swarm TaskTemplate Resources
+func (l ResourceLimits) toSwarm() (*swarm.ResourceRequirements, error) {
+
+	if l.CPULimit < 0 || l.CPUReservation < 0 {
+		return nil, errors.New("cpu-limit and cpu-reservation can not be negative")
+	}
+	if l.CPULimit > 0 && l.CPUReservation > l.CPULimit {
+		return nil, errors.New("cpu-reservation is more than cpu-limit")
+	}
+
+	memLimit, err := parseMemory(l.MemoryLimit)
+	if err != nil {
+		return nil, errors.New("memory-limit " + err.Error())
+	}
+	memReservation, err := parseMemory(l.MemoryReservation)
+	if err != nil {
+		return nil, errors.New("memory-reservation " + err.Error())
+	}
+	if memLimit > 0 && memReservation > memLimit {
+		return nil, errors.New("memory-reservation is more than memory-limit")
+	}
+
+	return &swarm.ResourceRequirements{
+		Limits: &swarm.Resources{
+			NanoCPUs:    int64(l.CPULimit * 1e9),
+			MemoryBytes: memLimit,
+		},
+		Reservations: &swarm.Resources{
+			NanoCPUs:    int64(l.CPUReservation * 1e9),
+			MemoryBytes: memReservation,
+		},
+	}, nil
+}
+
+func parseMemory(size string) (int64, error) {
+	if size == "" {
+		return 0, nil
+	}
+	bytes, err := units.RAMInBytes(size)
+	if err != nil {
+		return 0, err
+	}
+	if bytes < 0 {
+		return 0, errors.New("can not be negative")
+	}
+	return bytes, nil
+}
+
+// validateResources adds an error to res if limits can not be applied
+func validateResources(limits ResourceLimits, res *ValidationResult) {
+	_, err := limits.toSwarm()
+	if err != nil {
+		res.addError("resource-requirements", err.Error())
+	}
+}
+
+// isSystemComponent reports if name is one of the UIs the container manager starts itself.
+// These are never limited or counted against the budget so databox stays usable.
+func (cm ContainerManager) isSystemComponent(name string) bool {
+	return name == cm.CoreIUName || name == cm.AppStoreName
+}
+
+// resourcesFor returns the limits saved when sla was installed filled in with the DataboxType defaults
+func (cm ContainerManager) resourcesFor(sla libDatabox.SLA) ResourceLimits {
+
+	limits, err := cm.Store.GetResources(sla.Name)
+	if err != nil {
+		libDatabox.Debug("No saved resource limits for " + sla.Name + " using defaults")
+	}
+
+	return cm.withTypeDefaults(sla, limits)
+}
+
+// withTypeDefaults fills anything not set in limits from the defaults for the DataboxType of sla
+func (cm ContainerManager) withTypeDefaults(sla libDatabox.SLA, limits ResourceLimits) ResourceLimits {
+	switch sla.DataboxType {
+	case libDatabox.DataboxTypeApp:
+		return limits.withDefaults(cm.Resources.AppResourceDefaults)
+	case libDatabox.DataboxTypeDriver:
+		return limits.withDefaults(cm.Resources.DriverResourceDefaults)
+	}
+	return limits
+}
+
+// ResourceBudget holds the resources of installs and upgrades that have passed
+// checkResourceBudget but whose service has not been created or updated yet, so two
+// running at once can not both fit in what is left of the budget.
+type ResourceBudget struct {
+	mu      sync.Mutex
+	pending map[string]*swarm.ResourceRequirements
+}
+
+// NewResourceBudget returns a ResourceBudget with nothing pending
+func NewResourceBudget() *ResourceBudget {
+	return &ResourceBudget{pending: map[string]*swarm.ResourceRequirements{}}
+}
+
+// checkResourceBudget returns an error if starting name with resources would take the
+// CPU or memory reserved by all apps and drivers over the budget in ResourceOptions.
+// Components with no reservation are counted at their limit. Stopped and quarantined
+// components keep their share, they are started again without another check.
+// If it fits the resources are held for name until release is called, call it once
+// the service has been created or updated or the install has failed.
+func (cm ContainerManager) checkResourceBudget(name string, resources *swarm.ResourceRequirements) (release func(), err error) {
+
+	release = func() {}
+
+	memoryBudget, err := parseMemory(cm.Resources.MemoryBudget)
+	if err != nil {
+		libDatabox.Err("Invalid MemoryBudget " + cm.Resources.MemoryBudget + " " + err.Error())
+		memoryBudget = 0
+	}
+	cpuBudget := int64(cm.Resources.CPUBudget * 1e9)
+	if cpuBudget <= 0 && memoryBudget <= 0 {
+		return release, nil
+	}
+
+	cm.Budget.mu.Lock()
+	defer cm.Budget.mu.Unlock()
+
+	cpu, memory := committedResources(resources)
+
+	services, err := cm.cli.ServiceList(context.Background(), types.ServiceListOptions{})
+	if err != nil {
+		return release, err
+	}
+	for _, s := range services {
+		t := s.Spec.Labels["databox.type"]
+		if s.Spec.Name == name || cm.isSystemComponent(s.Spec.Name) {
+			continue
+		}
+		if t != string(libDatabox.DataboxTypeApp) && t != string(libDatabox.DataboxTypeDriver) {
+			continue
+		}
+		if _, ok := cm.Budget.pending[s.Spec.Name]; ok {
+			//an upgrade in progress, counted below at its new size
+			continue
+		}
+		c, m := committedResources(s.Spec.TaskTemplate.Resources)
+		cpu += c
+		memory += m
+	}
+	for pendingName, r := range cm.Budget.pending {
+		if pendingName == name {
+			continue
+		}
+		c, m := committedResources(r)
+		cpu += c
+		memory += m
+	}
+
+	if cpuBudget > 0 && cpu > cpuBudget {
+		return release, errors.New("Can't install " + name + " it would need " + strconv.FormatFloat(float64(cpu)/1e9, 'f', 2, 64) +
+			" CPUs which is more than the budget of " + strconv.FormatFloat(cm.Resources.CPUBudget, 'f', 2, 64))
+	}
+	if memoryBudget > 0 && memory > memoryBudget {
+		return release, errors.New("Can't install " + name + " it would need " + units.BytesSize(float64(memory)) +
+			" of memory which is more than the budget of " + units.BytesSize(float64(memoryBudget)))
+	}
+
+	cm.Budget.pending[name] = resources
+	return func() {
+		cm.Budget.mu.Lock()
+		defer cm.Budget.mu.Unlock()
+		if cm.Budget.pending[name] == resources {
+			delete(cm.Budget.pending, name)
+		}
+	}, nil
+}
+
+// committedResources is the CPU and memory resources holds on the host,
+// its reservation or its limit if nothing is reserved.
+func committedResources(resources *swarm.ResourceRequirements) (nanoCPUs int64, memory int64) {
+	if resources == nil {
+		return 0, 0
+	}
+	if resources.Limits != nil {
+		nanoCPUs = resources.Limits.NanoCPUs
+		memory = resources.Limits.MemoryBytes
+	}
+	if resources.Reservations != nil {
+		if resources.Reservations.NanoCPUs > 0 {
+			nanoCPUs = resources.Reservations.NanoCPUs
+		}
+		if resources.Reservations.MemoryBytes > 0 {
+			memory = resources.Reservations.MemoryBytes
+		}
+	}
+	return nanoCPUs, memory
+}
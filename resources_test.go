@@ -0,0 +1,204 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/swarm"
+)
+
+func TestParseMemory(t *testing.T) {
+
+	tests := []struct {
+		size    string
+		want    int64
+		wantErr bool
+	}{
+		{size: "", want: 0},
+		{size: "512", want: 512},
+		{size: "256m", want: 256 << 20},
+		{size: "256MB", want: 256 << 20},
+		{size: "1g", want: 1 << 30},
+		{size: "lots", wantErr: true},
+		{size: "-1m", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		got, err := parseMemory(tt.size)
+		if got != tt.want || (err != nil) != tt.wantErr {
+			t.Errorf("parseMemory(%q) = %d, %v want %d, error %v", tt.size, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestResourceLimitsToSwarm(t *testing.T) {
+
+	tests := []struct {
+		name    string
+		limits  ResourceLimits
+		want    swarm.ResourceRequirements
+		wantErr string
+	}{
+		{
+			name:   "none",
+			limits: ResourceLimits{},
+			want:   swarm.ResourceRequirements{Limits: &swarm.Resources{}, Reservations: &swarm.Resources{}},
+		},
+		{
+			name:   "all",
+			limits: ResourceLimits{CPULimit: 1.5, CPUReservation: 0.5, MemoryLimit: "512m", MemoryReservation: "128m"},
+			want: swarm.ResourceRequirements{
+				Limits:       &swarm.Resources{NanoCPUs: 1500000000, MemoryBytes: 512 << 20},
+				Reservations: &swarm.Resources{NanoCPUs: 500000000, MemoryBytes: 128 << 20},
+			},
+		},
+		{
+			name:   "reservation without a limit",
+			limits: ResourceLimits{CPUReservation: 2, MemoryReservation: "1g"},
+			want: swarm.ResourceRequirements{
+				Limits:       &swarm.Resources{},
+				Reservations: &swarm.Resources{NanoCPUs: 2000000000, MemoryBytes: 1 << 30},
+			},
+		},
+		{name: "negative CPU", limits: ResourceLimits{CPULimit: -1}, wantErr: "can not be negative"},
+		{name: "CPU reservation over limit", limits: ResourceLimits{CPULimit: 0.5, CPUReservation: 1}, wantErr: "cpu-reservation is more than cpu-limit"},
+		{name: "bad memory limit", limits: ResourceLimits{MemoryLimit: "lots"}, wantErr: "memory-limit"},
+		{name: "bad memory reservation", limits: ResourceLimits{MemoryReservation: "lots"}, wantErr: "memory-reservation"},
+		{name: "memory reservation over limit", limits: ResourceLimits{MemoryLimit: "128m", MemoryReservation: "256m"}, wantErr: "memory-reservation is more than memory-limit"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.limits.toSwarm()
+
+			res := ValidationResult{Valid: true}
+			validateResources(tt.limits, &res)
+
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("error = %v, want %q", err, tt.wantErr)
+				}
+				if res.Valid || len(res.Errors) != 1 || res.Errors[0].Field != "resource-requirements" {
+					t.Errorf("validateResources() = %+v, want a resource-requirements error", res)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if *got.Limits != *tt.want.Limits || *got.Reservations != *tt.want.Reservations {
+				t.Errorf("toSwarm() = %+v %+v, want %+v %+v", got.Limits, got.Reservations, tt.want.Limits, tt.want.Reservations)
+			}
+			if !res.Valid {
+				t.Errorf("validateResources() = %+v", res)
+			}
+		})
+	}
+}
+
+// addBudgetService creates a service of databoxType that holds cpu cores and memory
+func addBudgetService(t *testing.T, td *testDatabox, name string, databoxType string, replicas uint64, cpu float64, memory string) {
+	resources, err := ResourceLimits{CPUReservation: cpu, MemoryReservation: memory}.toSwarm()
+	if err != nil {
+		t.Fatal(err)
+	}
+	td.cli.AddImage("databoxsystems/" + name + ":" + testVersion)
+	_, err = td.cli.ServiceCreate(context.Background(), swarm.ServiceSpec{
+		Annotations: swarm.Annotations{Name: name, Labels: map[string]string{"databox.type": databoxType}},
+		Mode:        swarm.ServiceMode{Replicated: &swarm.ReplicatedService{Replicas: &replicas}},
+		TaskTemplate: swarm.TaskSpec{
+			ContainerSpec: &swarm.ContainerSpec{Image: "databoxsystems/" + name + ":" + testVersion},
+			Resources:     resources,
+		},
+	}, types.ServiceCreateOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCheckResourceBudget(t *testing.T) {
+
+	td := newTestDatabox(t)
+	td.cm.Resources.CPUBudget = 2
+	td.cm.Resources.MemoryBudget = "1g"
+	addBudgetService(t, td, "app-running", "app", 1, 1, "512m")
+	//stopped and quarantined components can be started again without a check so keep their share
+	addBudgetService(t, td, "driver-stopped", "driver", 0, 0.5, "256m")
+	addBudgetService(t, td, "core-ui", "app", 1, 4, "4g")
+	addBudgetService(t, td, "driver-stopped-core-store", "store", 1, 4, "4g")
+
+	tests := []struct {
+		name     string
+		install  string
+		cpu      float64
+		memory   string
+		noBudget bool
+		wantErr  string
+	}{
+		{name: "fits", install: "app-new", cpu: 0.5, memory: "256m"},
+		{name: "too much CPU", install: "app-new", cpu: 0.6, memory: "256m", wantErr: "CPUs"},
+		{name: "too much memory", install: "app-new", cpu: 0.1, memory: "300m", wantErr: "memory"},
+		{name: "no budget", install: "app-new", cpu: 8, memory: "8g", noBudget: true},
+		{name: "upgrade counted at its new size", install: "app-running", cpu: 1.5, memory: "768m"},
+		{name: "upgrade too big", install: "app-running", cpu: 1.6, memory: "768m", wantErr: "CPUs"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cm := td.cm
+			if tt.noBudget {
+				cm.Resources = ResourceOptions{}
+			}
+			resources, err := ResourceLimits{CPUReservation: tt.cpu, MemoryReservation: tt.memory}.toSwarm()
+			if err != nil {
+				t.Fatal(err)
+			}
+			release, err := cm.checkResourceBudget(tt.install, resources)
+			defer release()
+			if tt.wantErr == "" && err != nil || tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Errorf("error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// two installs that each fit but not together can not both pass the check
+// before either has created its service
+func TestCheckResourceBudgetPending(t *testing.T) {
+
+	td := newTestDatabox(t)
+	td.cm.Resources.CPUBudget = 1
+	most, err := ResourceLimits{CPUReservation: 0.6}.toSwarm()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	releaseFirst, err := td.cm.checkResourceBudget("app-first", most)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = td.cm.checkResourceBudget("app-second", most)
+	if err == nil {
+		t.Fatal("both installs passed the budget check")
+	}
+
+	//the first install failed before creating its service
+	releaseFirst()
+	releaseSecond, err := td.cm.checkResourceBudget("app-second", most)
+	if err != nil {
+		t.Fatalf("the released resources are still held %v", err)
+	}
+	defer releaseSecond()
+
+	//a full install holds its share until its service exists
+	td.cm.Resources.CPUBudget = 2
+	err = td.cm.LaunchFromSLA(td.testSLA("app-third", "app"), true, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(td.cm.Budget.pending) != 1 {
+		t.Errorf("pending after the install = %v, want only app-second", td.cm.Budget.pending)
+	}
+}
@@ -0,0 +1,629 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/gorilla/websocket"
+	"github.com/me-box/core-container-manager/api"
+	libDatabox "github.com/me-box/lib-go-databox"
+)
+
+// restAPIPrefix is where the REST API is served by ServeSecure
+const restAPIPrefix = "/api/v1"
+
+// apiError is the api.Error returned when an SLA is rejected with the validation result
+type apiError struct {
+	api.Error
+	Validation *ValidationResult `json:"validation,omitempty"`
+}
+
+// componentDetail is the status and saved SLA of one component
+type componentDetail struct {
+	serviceStatusResult
+	SLA *libDatabox.SLA `json:"sla,omitempty"`
+}
+
+// cmStatus is the health of the container manager itself
+type cmStatus struct {
+	Status     string `json:"status"`
+	Version    string `json:"version"`
+	Hostname   string `json:"hostname"`
+	Components int    `json:"components"`
+	//certificates that expire soon or failed to renew
+	Certificates []CertStatus `json:"certificates,omitempty"`
+}
+
+// NewRestAPI returns the handler for the versioned REST API. It is the same API
+// core-ui uses through the api datasource but over plain HTTPS and JSON.
+// Authentication is left to the caller, see ServeSecure.
+func NewRestAPI(cm *ContainerManager) http.Handler {
+
+	router := mux.NewRouter()
+
+	router.HandleFunc(restAPIPrefix+"/openapi.json", restOpenAPI).Methods("GET")
+	router.HandleFunc(restAPIPrefix+"/status", restStatus(cm)).Methods("GET")
+	router.HandleFunc(restAPIPrefix+"/dashboard", restDashboard(cm)).Methods("GET")
+	router.HandleFunc(restAPIPrefix+"/components", restComponents(cm)).Methods("GET")
+	router.HandleFunc(restAPIPrefix+"/components/{name}", restComponent(cm)).Methods("GET")
+	router.HandleFunc(restAPIPrefix+"/components/{name}/logs", restLogs(cm)).Methods("GET")
+	router.HandleFunc(restAPIPrefix+"/components/{name}/dependencies", restDependencies(cm)).Methods("GET")
+	router.HandleFunc(restAPIPrefix+"/components/{name}/consumers", restConsumers(cm)).Methods("GET")
+	router.HandleFunc(restAPIPrefix+"/validate", restValidate(cm)).Methods("POST")
+	router.HandleFunc(restAPIPrefix+"/install", restInstall(cm)).Methods("POST")
+	router.HandleFunc(restAPIPrefix+"/upgrade", restUpgrade(cm)).Methods("POST")
+	router.HandleFunc(restAPIPrefix+"/uninstall", restUninstall(cm)).Methods("POST")
+	router.HandleFunc(restAPIPrefix+"/restart", restRestart(cm)).Methods("POST")
+	router.HandleFunc(restAPIPrefix+"/stop", restStop(cm)).Methods("POST")
+	router.HandleFunc(restAPIPrefix+"/start", restStart(cm)).Methods("POST")
+	router.HandleFunc(restAPIPrefix+"/backup", restBackup(cm)).Methods("POST")
+	router.HandleFunc(restAPIPrefix+"/logout", restLogout(cm)).Methods("POST")
+	router.HandleFunc(restAPIPrefix+"/password", restChangePassword(cm)).Methods("POST")
+	router.HandleFunc(restAPIPrefix+"/sessions", restSessions(cm)).Methods("GET")
+	router.HandleFunc(restAPIPrefix+"/sessions/{id}", restRevokeSession(cm)).Methods("DELETE")
+	router.HandleFunc(restAPIPrefix+"/certificates", restCertificates(cm)).Methods("GET")
+	router.HandleFunc(restAPIPrefix+"/certificates/check", restCheckCertificates(cm)).Methods("POST")
+	router.HandleFunc(restAPIPrefix+"/lockouts", restLockouts(cm)).Methods("GET")
+	router.HandleFunc(restAPIPrefix+"/lockouts/{ip}", restUnlock(cm)).Methods("DELETE")
+	router.HandleFunc(restAPIPrefix+"/me", restMe).Methods("GET")
+	router.HandleFunc(restAPIPrefix+"/users", restUsers(cm)).Methods("GET")
+	router.HandleFunc(restAPIPrefix+"/users", restCreateUser(cm)).Methods("POST")
+	router.HandleFunc(restAPIPrefix+"/users/{name}", restUpdateUser(cm)).Methods("PUT")
+	router.HandleFunc(restAPIPrefix+"/users/{name}", restDeleteUser(cm)).Methods("DELETE")
+	router.HandleFunc(restAPIPrefix+"/jobs", restJobs(cm)).Methods("GET")
+	router.HandleFunc(restAPIPrefix+"/jobs/{id}", restJob(cm)).Methods("GET")
+
+	notFound := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeAPIError(w, http.StatusNotFound, errors.New("No such endpoint "+r.URL.Path))
+	})
+	notAllowed := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeAPIError(w, http.StatusMethodNotAllowed, errors.New(r.Method+" is not allowed on "+r.URL.Path))
+	})
+	router.NotFoundHandler = notFound
+	router.MethodNotAllowedHandler = notAllowed
+
+	return router
+}
+
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	payload, err := json.Marshal(body)
+	if err != nil {
+		libDatabox.Err("[REST API] Error encoding response " + err.Error())
+		status = http.StatusInternalServerError
+		payload = []byte(`{"error":"failed to encode response"}`)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(payload)
+}
+
+func writeAPIError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, api.Error{Error: err.Error()})
+}
+
+// readJSON decodes the request body into v and returns its raw bytes
+func readJSON(r *http.Request, v interface{}) ([]byte, error) {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return body, err
+	}
+	err = json.Unmarshal(body, v)
+	if err != nil {
+		return body, errors.New("Invalid JSON " + err.Error())
+	}
+	return body, nil
+}
+
+func writeJobAccepted(w http.ResponseWriter, job Job) {
+	url := restAPIPrefix + "/jobs/" + job.ID
+	w.Header().Set("Location", url)
+	writeJSON(w, http.StatusAccepted, api.JobAccepted{Job: job.Job, URL: url})
+}
+
+func restOpenAPI(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Write([]byte(openAPISpec))
+}
+
+func restStatus(cm *ContainerManager) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, cmStatus{
+			Status:       "ok",
+			Version:      cm.Options.Version,
+			Hostname:     cm.Options.Hostname,
+			Components:   len(cm.serviceStatus()),
+			Certificates: cm.Certs.Expiring(),
+		})
+	}
+}
+
+func restDashboard(cm *ContainerManager) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		info, err := cm.dashboardInfo()
+		if err != nil {
+			writeAPIError(w, http.StatusInternalServerError, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, info)
+	}
+}
+
+func restComponents(cm *ContainerManager) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, cm.serviceStatus())
+	}
+}
+
+func restComponent(cm *ContainerManager) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		name := mux.Vars(r)["name"]
+		for _, s := range cm.serviceStatus() {
+			if s.Name != name {
+				continue
+			}
+			detail := componentDetail{serviceStatusResult: s}
+			if sla, err := cm.Store.GetSLA(name); err == nil && sla.Name == name {
+				detail.SLA = &sla
+			}
+			writeJSON(w, http.StatusOK, detail)
+			return
+		}
+		writeAPIError(w, http.StatusNotFound, errors.New("Component "+name+" not found"))
+	}
+}
+
+// restLogs returns the last lines of a components log. If the request is a websocket
+// upgrade the log is followed instead, each line is sent as a JSON LogLine message.
+func restLogs(cm *ContainerManager) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		name := mux.Vars(r)["name"]
+		lines, _ := strconv.Atoi(r.URL.Query().Get("lines"))
+
+		if _, err := cm.databoxService(name); err != nil {
+			writeAPIError(w, http.StatusNotFound, errors.New("Component "+name+" not found"))
+			return
+		}
+
+		if websocket.IsWebSocketUpgrade(r) {
+			followLogs(cm, w, r, name, lines)
+			return
+		}
+
+		logs, err := cm.ServiceLogs(name, lines)
+		if err != nil {
+			writeAPIError(w, http.StatusInternalServerError, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, logs)
+	}
+}
+
+func followLogs(cm *ContainerManager, w http.ResponseWriter, r *http.Request, name string, lines int) {
+
+	upgrader := websocket.Upgrader{}
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		//Upgrade has already replied
+		libDatabox.Debug("[followLogs] Could not open websocket connection " + err.Error())
+		return
+	}
+	defer conn.Close()
+
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+
+	//nothing is expected from the client, reading just notices when it goes away
+	go func() {
+		for {
+			if _, _, err := conn.NextReader(); err != nil {
+				cancel()
+				return
+			}
+		}
+	}()
+
+	libDatabox.Debug("[followLogs] following " + name)
+	err = cm.streamLogs(ctx, name, lines, true, func(l api.LogLine) error {
+		return conn.WriteJSON(l)
+	})
+	if ctx.Err() != nil {
+		return
+	}
+
+	closeMsg := websocket.FormatCloseMessage(websocket.CloseNormalClosure, name+" log ended")
+	if err != nil {
+		libDatabox.Err("[followLogs] " + err.Error())
+		closeMsg = websocket.FormatCloseMessage(websocket.CloseInternalServerErr, err.Error())
+	}
+	conn.WriteMessage(websocket.CloseMessage, closeMsg)
+}
+
+func restValidate(cm *ContainerManager) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var request api.InstallRequest
+		body, err := readJSON(r, &request)
+		if err != nil {
+			writeAPIError(w, http.StatusBadRequest, err)
+			return
+		}
+		validation := cm.ValidateSLA(convertManifestToSLA(request))
+		limits, _ := parseResourceRequest(body)
+		validateResources(limits, &validation)
+		writeJSON(w, http.StatusOK, validation)
+	}
+}
+
+func restInstall(cm *ContainerManager) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var request api.InstallRequest
+		body, err := readJSON(r, &request)
+		if err != nil {
+			writeAPIError(w, http.StatusBadRequest, err)
+			return
+		}
+		sla := convertManifestToSLA(request)
+		limits, _ := parseResourceRequest(body)
+
+		//check what we can now so the caller gets a useful status code
+		validation := cm.ValidateSLA(sla)
+		validateResources(limits, &validation)
+		if !validation.Valid {
+			writeJSON(w, http.StatusUnprocessableEntity, apiError{Error: api.Error{Error: validation.Err().Error()}, Validation: &validation})
+			return
+		}
+		if _, err := cm.serviceByName(sla.Name); err == nil {
+			writeAPIError(w, http.StatusConflict, errors.New(sla.Name+" is already installed"))
+			return
+		}
+
+		job := cm.Jobs.NewJob(JobTypeInstall, sla.Name)
+		writeJobAccepted(w, *job)
+		go func() {
+			err := cm.Install(sla, limits, job)
+			libDatabox.ChkErr(err)
+		}()
+	}
+}
+
+func restUpgrade(cm *ContainerManager) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var request api.InstallRequest
+		body, err := readJSON(r, &request)
+		if err != nil {
+			writeAPIError(w, http.StatusBadRequest, err)
+			return
+		}
+		if request.Manifest.Name == "" {
+			writeAPIError(w, http.StatusBadRequest, errors.New("manifest.name is required"))
+			return
+		}
+		if _, err := cm.serviceByName(request.Manifest.Name); err != nil {
+			writeAPIError(w, http.StatusNotFound, errors.New(request.Manifest.Name+" is not installed"))
+			return
+		}
+
+		sla := convertManifestToSLA(request)
+		limits, _ := parseResourceRequest(body)
+		validation := cm.ValidateSLA(sla)
+		validateResources(limits, &validation)
+		if !validation.Valid {
+			writeJSON(w, http.StatusUnprocessableEntity, apiError{Error: api.Error{Error: validation.Err().Error()}, Validation: &validation})
+			return
+		}
+
+		job := cm.Jobs.NewJob(JobTypeUpgrade, sla.Name)
+		writeJobAccepted(w, *job)
+		go func() {
+			err := cm.Upgrade(sla, limits, job)
+			libDatabox.ChkErr(err)
+		}()
+	}
+}
+
+// restNamedJob handles the requests that only need a component name
+func restNamedJob(cm *ContainerManager, jobType JobType, run func(name string, job *Job) error) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var request api.NameRequest
+		_, err := readJSON(r, &request)
+		if err != nil {
+			writeAPIError(w, http.StatusBadRequest, err)
+			return
+		}
+		if request.Name == "" {
+			writeAPIError(w, http.StatusBadRequest, errors.New("name is required"))
+			return
+		}
+		if !cm.knownComponent(request.Name) {
+			writeAPIError(w, http.StatusNotFound, errors.New(request.Name+" is not installed"))
+			return
+		}
+
+		job := cm.Jobs.NewJob(jobType, request.Name)
+		writeJobAccepted(w, *job)
+		go func() {
+			err := run(request.Name, job)
+			libDatabox.ChkErr(err)
+		}()
+	}
+}
+
+func restUninstall(cm *ContainerManager) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var request api.UninstallRequest
+		_, err := readJSON(r, &request)
+		if err != nil {
+			writeAPIError(w, http.StatusBadRequest, err)
+			return
+		}
+		if request.Name == "" {
+			writeAPIError(w, http.StatusBadRequest, errors.New("name is required"))
+			return
+		}
+		if !cm.knownComponent(request.Name) {
+			writeAPIError(w, http.StatusNotFound, errors.New(request.Name+" is not installed"))
+			return
+		}
+		if dependents, err := cm.checkDependents(request.Name, request.Force); err != nil {
+			writeJSON(w, http.StatusConflict, api.Error{Error: err.Error(), Dependents: dependents})
+			return
+		}
+
+		job := cm.Jobs.NewJob(JobTypeUninstall, request.Name)
+		writeJobAccepted(w, *job)
+		go func() {
+			err := cm.Uninstall(request.Name, request.Force, job)
+			libDatabox.ChkErr(err)
+		}()
+	}
+}
+
+func restRestart(cm *ContainerManager) http.HandlerFunc {
+	return restNamedJob(cm, JobTypeRestart, cm.Restart)
+}
+
+func restStop(cm *ContainerManager) http.HandlerFunc {
+	return restNamedJob(cm, JobTypeStop, cm.StopService)
+}
+
+func restStart(cm *ContainerManager) http.HandlerFunc {
+	return restNamedJob(cm, JobTypeStart, cm.StartService)
+}
+
+func restDependencies(cm *ContainerManager) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ci, err := cm.consumerIndex()
+		if err != nil {
+			writeAPIError(w, http.StatusInternalServerError, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, ci.dependencies(mux.Vars(r)["name"]))
+	}
+}
+
+// restConsumers lists who reads from the stores of a component
+func restConsumers(cm *ContainerManager) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ci, err := cm.consumerIndex()
+		if err != nil {
+			writeAPIError(w, http.StatusInternalServerError, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, ci.consumers(consumersRequest{Component: mux.Vars(r)["name"]}))
+	}
+}
+
+// restBackup streams a backup archive, it is encrypted if a passphrase is given
+func restBackup(cm *ContainerManager) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req backupRequest
+		body, err := ioutil.ReadAll(r.Body)
+		if err == nil && len(body) > 0 {
+			err = json.Unmarshal(body, &req)
+		}
+		if err != nil {
+			writeAPIError(w, http.StatusBadRequest, errors.New("Invalid JSON "+err.Error()))
+			return
+		}
+
+		filename := "databox-backup-" + time.Now().UTC().Format("20060102T150405Z") + ".tar.gz"
+		contentType := "application/gzip"
+		if req.Passphrase != "" {
+			filename = filename + ".enc"
+			contentType = "application/octet-stream"
+		}
+		w.Header().Set("Content-Type", contentType)
+		w.Header().Set("Content-Disposition", "attachment; filename=\""+filename+"\"")
+
+		err = cm.WriteBackup(w, req.Passphrase)
+		if err != nil {
+			libDatabox.Err("[REST API] Backup failed " + err.Error())
+			//the archive has no manifest so it can't be restored, drop the connection so the client knows
+			panic(http.ErrAbortHandler)
+		}
+	}
+}
+
+// restLogout ends the session of the caller and deletes its cookie
+func restLogout(cm *ContainerManager) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if token := sessionToken(r); token != "" {
+			cm.ActiveSessions.RevokeToken(token)
+		}
+		http.SetCookie(w, sessionCookie("", -1))
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// restChangePassword changes the password of the caller and logs out their other sessions
+func restChangePassword(cm *ContainerManager) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var request passwordChangeRequest
+		_, err := readJSON(r, &request)
+		if err != nil {
+			writeAPIError(w, http.StatusBadRequest, err)
+			return
+		}
+
+		user := requestUser(r)
+		err = cm.Users.ChangePassword(user.Name, request.Current, request.New)
+		if err == errWrongPassword {
+			writeAPIError(w, http.StatusForbidden, err)
+			return
+		}
+		if err != nil {
+			writeAPIError(w, http.StatusBadRequest, err)
+			return
+		}
+
+		cm.ActiveSessions.RevokeUser(user.Name, sessionToken(r))
+		if user.Name == builtinAdmin {
+			//the mobile app QR code holds the password
+			go populateMobileAppQrCodeAndCerts(cm)
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func restSessions(cm *ContainerManager) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, cm.ActiveSessions.List(sessionToken(r)))
+	}
+}
+
+func restRevokeSession(cm *ContainerManager) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := mux.Vars(r)["id"]
+		if !cm.ActiveSessions.Revoke(id) {
+			writeAPIError(w, http.StatusNotFound, errors.New("Session "+id+" not found"))
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func restCertificates(cm *ContainerManager) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, cm.Certs.List())
+	}
+}
+
+// restCheckCertificates renews anything that is due now rather than at the next
+// scheduled check. Renewing restarts services so it carries on in the background.
+func restCheckCertificates(cm *ContainerManager) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		go cm.CheckCertificates()
+		writeJSON(w, http.StatusAccepted, cm.Certs.List())
+	}
+}
+
+func restLockouts(cm *ContainerManager) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, cm.Logins.Status())
+	}
+}
+
+func restUnlock(cm *ContainerManager) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ip := mux.Vars(r)["ip"]
+		if !cm.Logins.Unlock(ip) {
+			writeAPIError(w, http.StatusNotFound, errors.New("No failed logins from "+ip))
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// restMe returns the user making the request
+func restMe(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, requestUser(r))
+}
+
+func restUsers(cm *ContainerManager) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, cm.Users.List())
+	}
+}
+
+func restCreateUser(cm *ContainerManager) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var request userRequest
+		_, err := readJSON(r, &request)
+		if err != nil {
+			writeAPIError(w, http.StatusBadRequest, err)
+			return
+		}
+
+		user, err := cm.Users.Create(request)
+		if err != nil {
+			writeAPIError(w, http.StatusBadRequest, err)
+			return
+		}
+		writeJSON(w, http.StatusCreated, user)
+	}
+}
+
+// restUpdateUser changes a user, their sessions are ended so the change applies straight away
+func restUpdateUser(cm *ContainerManager) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		name := mux.Vars(r)["name"]
+		var request userRequest
+		_, err := readJSON(r, &request)
+		if err != nil {
+			writeAPIError(w, http.StatusBadRequest, err)
+			return
+		}
+
+		if _, ok := cm.Users.Get(name); !ok {
+			writeAPIError(w, http.StatusNotFound, errUserNotFound(name))
+			return
+		}
+		user, err := cm.Users.Update(name, request)
+		if err != nil {
+			writeAPIError(w, http.StatusBadRequest, err)
+			return
+		}
+		cm.ActiveSessions.RevokeUser(name, "")
+		writeJSON(w, http.StatusOK, user)
+	}
+}
+
+func restDeleteUser(cm *ContainerManager) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		name := mux.Vars(r)["name"]
+		if _, ok := cm.Users.Get(name); !ok {
+			writeAPIError(w, http.StatusNotFound, errUserNotFound(name))
+			return
+		}
+		err := cm.Users.Delete(name)
+		if err != nil {
+			writeAPIError(w, http.StatusBadRequest, err)
+			return
+		}
+		cm.ActiveSessions.RevokeUser(name, "")
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func restJobs(cm *ContainerManager) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, cm.Jobs.List())
+	}
+}
+
+func restJob(cm *ContainerManager) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := mux.Vars(r)["id"]
+		job, ok := cm.Jobs.Get(id)
+		if !ok {
+			writeAPIError(w, http.StatusNotFound, errors.New("Job "+id+" not found"))
+			return
+		}
+		writeJSON(w, http.StatusOK, job)
+	}
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/me-box/core-container-manager/api"
+	libDatabox "github.com/me-box/lib-go-databox"
+)
+
+func installBody(t *testing.T, name string, databoxType libDatabox.DataboxType) string {
+	body, err := json.Marshal(api.InstallRequest{Manifest: libDatabox.Manifest{Name: name, DataboxType: databoxType}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(body)
+}
+
+func TestRestAPIStatusCodes(t *testing.T) {
+
+	td := newTestDatabox(t)
+	err := td.cm.LaunchFromSLA(td.testSLA("driver-rest", libDatabox.DataboxTypeDriver), true, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = td.cm.LaunchFromSLA(td.testSLA("app-rest", libDatabox.DataboxTypeApp, "driver-rest"), true, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	td.cli.AddImage("databoxsystems/app-new:" + testVersion)
+
+	srv := httptest.NewServer(NewRestAPI(&td.cm))
+	defer srv.Close()
+
+	tests := []struct {
+		name           string
+		method         string
+		path           string
+		body           string
+		wantStatus     int
+		wantError      string
+		wantDependents []string
+		wantValidation bool
+	}{
+		{name: "bad JSON", method: "POST", path: "/install", body: `{"manifest":`, wantStatus: http.StatusBadRequest, wantError: "Invalid JSON"},
+		{name: "invalid SLA", method: "POST", path: "/install", body: installBody(t, "App_Bad", libDatabox.DataboxTypeApp), wantStatus: http.StatusUnprocessableEntity, wantError: "Invalid SLA", wantValidation: true},
+		{name: "already installed", method: "POST", path: "/install", body: installBody(t, "driver-rest", libDatabox.DataboxTypeDriver), wantStatus: http.StatusConflict, wantError: "already installed"},
+		{name: "upgrade not installed", method: "POST", path: "/upgrade", body: installBody(t, "app-missing", libDatabox.DataboxTypeApp), wantStatus: http.StatusNotFound},
+		{name: "upgrade without a name", method: "POST", path: "/upgrade", body: `{"manifest":{}}`, wantStatus: http.StatusBadRequest},
+		{name: "uninstall unknown", method: "POST", path: "/uninstall", body: `{"name":"app-missing"}`, wantStatus: http.StatusNotFound},
+		{name: "uninstall with dependents", method: "POST", path: "/uninstall", body: `{"name":"driver-rest"}`, wantStatus: http.StatusConflict, wantDependents: []string{"app-rest"}},
+		{name: "restart without a name", method: "POST", path: "/restart", body: `{}`, wantStatus: http.StatusBadRequest, wantError: "name is required"},
+		{name: "stop unknown", method: "POST", path: "/stop", body: `{"name":"app-missing"}`, wantStatus: http.StatusNotFound},
+		{name: "component", method: "GET", path: "/components/driver-rest", wantStatus: http.StatusOK},
+		{name: "unknown component", method: "GET", path: "/components/app-missing", wantStatus: http.StatusNotFound},
+		{name: "unknown job", method: "GET", path: "/jobs/1-1", wantStatus: http.StatusNotFound},
+		{name: "no such endpoint", method: "GET", path: "/nothing", wantStatus: http.StatusNotFound, wantError: "No such endpoint"},
+		{name: "wrong method", method: "GET", path: "/install", wantStatus: http.StatusMethodNotAllowed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequest(tt.method, srv.URL+restAPIPrefix+tt.path, strings.NewReader(tt.body))
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+			body, _ := ioutil.ReadAll(resp.Body)
+
+			if resp.StatusCode != tt.wantStatus {
+				t.Fatalf("status = %d %s, want %d", resp.StatusCode, body, tt.wantStatus)
+			}
+			if resp.StatusCode < 400 {
+				return
+			}
+			if ct := resp.Header.Get("Content-Type"); ct != "application/json" {
+				t.Errorf("error Content-Type = %q", ct)
+			}
+			var apiErr apiError
+			if err := json.Unmarshal(body, &apiErr); err != nil || apiErr.Error.Error == "" {
+				t.Fatalf("error body = %s, %v", body, err)
+			}
+			if !strings.Contains(apiErr.Error.Error, tt.wantError) {
+				t.Errorf("error = %q, want %q", apiErr.Error.Error, tt.wantError)
+			}
+			if strings.Join(apiErr.Dependents, ",") != strings.Join(tt.wantDependents, ",") {
+				t.Errorf("dependents = %v, want %v", apiErr.Dependents, tt.wantDependents)
+			}
+			if (apiErr.Validation != nil) != tt.wantValidation {
+				t.Errorf("validation = %+v, want it %v", apiErr.Validation, tt.wantValidation)
+			}
+		})
+	}
+}
+
+// an accepted install returns its job at once and the Location to follow it at
+func TestRestAPIInstallAccepted(t *testing.T) {
+
+	td := newTestDatabox(t)
+	td.cli.AddImage("databoxsystems/app-new:" + testVersion)
+	srv := httptest.NewServer(NewRestAPI(&td.cm))
+	defer srv.Close()
+
+	resp, err := http.Post(srv.URL+restAPIPrefix+"/install", "application/json", strings.NewReader(installBody(t, "app-new", libDatabox.DataboxTypeApp)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusAccepted {
+		t.Fatalf("status = %d, want %d", resp.StatusCode, http.StatusAccepted)
+	}
+	var accepted api.JobAccepted
+	if err := json.NewDecoder(resp.Body).Decode(&accepted); err != nil {
+		t.Fatal(err)
+	}
+	location := resp.Header.Get("Location")
+	if location == "" || location != accepted.URL || location != restAPIPrefix+"/jobs/"+accepted.Job.ID {
+		t.Fatalf("Location = %q, url = %q for job %s", location, accepted.URL, accepted.Job.ID)
+	}
+	if accepted.Job.Type != api.JobTypeInstall || accepted.Job.Name != "app-new" {
+		t.Errorf("job = %+v", accepted.Job)
+	}
+
+	var job api.Job
+	waitFor(t, "the install to finish", func() bool {
+		resp, err := http.Get(srv.URL + location)
+		if err != nil {
+			return false
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK || json.NewDecoder(resp.Body).Decode(&job) != nil {
+			return false
+		}
+		return job.Finished()
+	})
+	if job.State != api.JobStateRunning {
+		t.Errorf("install ended %s %s", job.State, job.Error)
+	}
+	if td.service("app-new") == nil {
+		t.Error("app-new was not installed")
+	}
+}
+
+// every route is documented in the OpenAPI spec and the spec documents nothing that is not served
+func TestOpenAPIMatchesRouter(t *testing.T) {
+
+	var spec struct {
+		Servers []struct {
+			URL string `json:"url"`
+		} `json:"servers"`
+		Paths map[string]map[string]json.RawMessage `json:"paths"`
+	}
+	if err := json.Unmarshal([]byte(openAPISpec), &spec); err != nil {
+		t.Fatalf("openAPISpec is not valid JSON %v", err)
+	}
+	if len(spec.Servers) != 1 || spec.Servers[0].URL != restAPIPrefix {
+		t.Errorf("servers = %+v, want %s", spec.Servers, restAPIPrefix)
+	}
+
+	documented := []string{}
+	for path, methods := range spec.Paths {
+		for method := range methods {
+			if method == "parameters" {
+				continue
+			}
+			documented = append(documented, strings.ToUpper(method)+" "+restAPIPrefix+path)
+		}
+	}
+
+	routed := []string{}
+	router := NewRestAPI(&ContainerManager{}).(*mux.Router)
+	err := router.Walk(func(route *mux.Route, router *mux.Router, ancestors []*mux.Route) error {
+		path, err := route.GetPathTemplate()
+		if err != nil {
+			return err
+		}
+		methods, err := route.GetMethods()
+		if err != nil {
+			return err
+		}
+		for _, method := range methods {
+			routed = append(routed, method+" "+path)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sort.Strings(documented)
+	sort.Strings(routed)
+	if strings.Join(documented, "\n") != strings.Join(routed, "\n") {
+		t.Errorf("documented:\n%s\n\nrouted:\n%s", strings.Join(documented, "\n"), strings.Join(routed, "\n"))
+	}
+}
@@ -1,14 +1,14 @@
 package main
 
 import (
-	"crypto/rand"
 	"crypto/tls"
 	"crypto/x509"
-	b64 "encoding/base64"
+	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"net/http"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -17,81 +17,190 @@ import (
 	"github.com/me-box/lib-go-databox"
 )
 
-func ServeSecure(cm *ContainerManager, password string) {
+func ServeSecure(cm *ContainerManager) {
 
 	databoxHttpsClient := libDatabox.NewDataboxHTTPsAPIWithPaths("/certs/containerManager.crt")
 	CM_HTTPS_CA_ROOT_CERT, _ := ioutil.ReadFile("/certs/containerManager.crt")
 	roots := x509.NewCertPool()
 	roots.AppendCertsFromPEM([]byte(CM_HTTPS_CA_ROOT_CERT))
 
+	//REST API
+	restAPI := NewRestAPI(cm)
+	http.HandleFunc(restAPIPrefix+"/", func(w http.ResponseWriter, r *http.Request) {
+		if user, ok := apiAuth(w, r, cm.Users, cm.ActiveSessions, cm.Logins); ok {
+			restAPI.ServeHTTP(w, withUser(r, user))
+		}
+	})
+
 	//Proxy
 	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
 		//Auth
-		if auth(w, r, password) {
+		if user, ok := auth(w, r, cm.Users, cm.ActiveSessions, cm.Logins); ok {
 			if websocket.IsWebSocketUpgrade(r) {
-				webSocketProxy(w, r, roots, cm)
+				webSocketProxy(w, r, roots, cm, user)
 			} else {
-				proxy(w, r, databoxHttpsClient, cm)
+				proxy(w, r, databoxHttpsClient, cm, user)
 			}
 		}
 
 	})
 
-	libDatabox.ChkErrFatal(http.ListenAndServeTLS(":443", "./certs/container-manager.pem", "./certs/container-manager.pem", nil))
+	//the certificate is reloaded when it is renewed
+	certs := newCertFileLoader(certsBasePath + "/container-manager.pem")
+	server := &http.Server{
+		Addr:      ":443",
+		TLSConfig: &tls.Config{GetCertificate: certs.GetCertificate},
+	}
+	libDatabox.ChkErrFatal(server.ListenAndServeTLS("", ""))
 }
 
 // Allows access to all /core-ui/ui/ paths except /core-ui/ui/api paths
 var allowedStaticPath = "/core-ui/ui/"
 var exceptPath = "/core-ui/ui/api/"
 
-//A map to hold session tokens
-var sessionTokens sync.Map
-
-func auth(w http.ResponseWriter, r *http.Request, password string) bool {
+// auth checks the request to the dashboard proxy is logged in and returns who made it.
+// A request with a valid Authorization header is a login, a session cookie is set and
+// no further handling is needed.
+func auth(w http.ResponseWriter, r *http.Request, users *UserStore, sessions *SessionManager, guard *LoginGuard) (User, bool) {
 	if strings.HasPrefix(r.URL.Path, allowedStaticPath) && !strings.HasPrefix(r.URL.Path, exceptPath) {
 		//its allowed no auth needed
-		return true
+		return User{}, true
 	}
 
-	if ("Token " + password) == r.Header.Get("Authorization") {
-		libDatabox.Debug("Password OK!")
-		//make a new session token
-		b := make([]byte, 24)
-		rand.Read(b) //TODO This could error should check
-		token := b64.StdEncoding.EncodeToString(b)
-		sessionTokens.Store(token, 1)
-
-		cookie := http.Cookie{
-			Name:   "session",
-			Value:  token,
-			Domain: r.URL.Hostname(),
-			Path:   "/",
+	if r.Header.Get("Authorization") != "" {
+		user, wait, ok := login(r, users, guard)
+		if wait > 0 {
+			setRetryAfter(w, wait)
+			w.WriteHeader(http.StatusTooManyRequests)
+			fmt.Fprintf(w, "Too many failed logins, try again later")
+			return User{}, false
 		}
-		http.SetCookie(w, &cookie)
-		fmt.Fprintf(w, "connected")
-		return false
-	}
-
-	sessionCookie, _ := r.Cookie("session")
-	if sessionCookie != nil {
-		_, ok := sessionTokens.Load(sessionCookie.Value)
 		if ok {
-			return true
+			libDatabox.Debug("Password OK! for " + user.Name)
+			//make a new session
+			token, err := sessions.Create(r, user.Name)
+			if err != nil {
+				libDatabox.Err(err.Error())
+				w.WriteHeader(http.StatusInternalServerError)
+				return User{}, false
+			}
+			http.SetCookie(w, sessionCookie(token, int(sessions.maxAge.Seconds())))
+			fmt.Fprintf(w, "connected")
+			return User{}, false
 		}
 	}
 
-	libDatabox.Err("Password validation error!" + r.Header.Get("Authorization"))
+	if user, ok := sessionOwner(r, users, sessions); ok {
+		return user, true
+	}
+
+	libDatabox.Debug("No session for " + r.URL.Path + " from " + r.RemoteAddr)
 	w.WriteHeader(http.StatusUnauthorized)
 	fmt.Fprintf(w, "Authorization Required")
-	return false
+	return User{}, false
 }
 
-func webSocketProxy(w http.ResponseWriter, r *http.Request, roots *x509.CertPool, cm *ContainerManager) {
+// login checks the credentials in the Authorization header of r. They are not checked
+// while the client has to wait after failed logins, how long is returned instead.
+func login(r *http.Request, users *UserStore, guard *LoginGuard) (User, time.Duration, bool) {
+
+	ip := clientIP(r)
+	if wait := guard.Wait(ip); wait > 0 {
+		libDatabox.Debug("[Login] refused login from " + ip + " for another " + wait.String())
+		return User{}, wait, false
+	}
+
+	user, ok := users.Authenticate(r)
+	if !ok {
+		attempted := builtinAdmin
+		if name, _, basic := r.BasicAuth(); basic {
+			//only name real accounts in the log
+			attempted = ""
+			if _, known := users.Get(name); known && name != "" {
+				attempted = name
+			}
+		}
+		guard.Failed(ip, attempted)
+		return User{}, 0, false
+	}
+
+	guard.Succeeded(ip)
+	return user, 0, true
+}
+
+// setRetryAfter tells the client to wait before trying again, in whole seconds
+func setRetryAfter(w http.ResponseWriter, wait time.Duration) {
+	seconds := int((wait + time.Second - 1) / time.Second)
+	w.Header().Set("Retry-After", strconv.Itoa(seconds))
+}
+
+// sessionOwner returns the user whose live session cookie r has
+func sessionOwner(r *http.Request, users *UserStore, sessions *SessionManager) (User, bool) {
+	name, ok := sessions.Validate(sessionToken(r))
+	if !ok {
+		return User{}, false
+	}
+	//the user may have been deleted since logging in
+	return users.Get(name)
+}
+
+// apiAuth is auth for the REST API. Scripts can send the password with every
+// request instead of logging in first and errors are returned as JSON.
+func apiAuth(w http.ResponseWriter, r *http.Request, users *UserStore, sessions *SessionManager, guard *LoginGuard) (User, bool) {
+	user, ok := sessionOwner(r, users, sessions)
+	if !ok && r.Header.Get("Authorization") != "" {
+		var wait time.Duration
+		user, wait, ok = login(r, users, guard)
+		if wait > 0 {
+			setRetryAfter(w, wait)
+			writeAPIError(w, http.StatusTooManyRequests, errors.New("Too many failed logins, try again later"))
+			return User{}, false
+		}
+	}
+	if !ok {
+		libDatabox.Err("REST API authorization failed for " + r.URL.Path)
+		writeAPIError(w, http.StatusUnauthorized, errors.New("Authorization Required"))
+		return User{}, false
+	}
+
+	if !user.IsAdmin() && !viewerAllowedAPI(r, user) {
+		libDatabox.Warn("REST API " + r.Method + " " + r.URL.Path + " refused for viewer " + user.Name)
+		writeAPIError(w, http.StatusForbidden, errors.New("Only admins can do that"))
+		return User{}, false
+	}
+	return user, true
+}
+
+// proxyAllowed reports if user may open the UI path of component, writing a 403 if not.
+// Anyone logged in gets the dashboard but only admins can change things through it.
+func proxyAllowed(w http.ResponseWriter, r *http.Request, cm *ContainerManager, user User, component string) bool {
+
+	if user.Name == "" {
+		//static dashboard files need no login
+		return true
+	}
+
+	allowed := user.CanOpen(component)
+	if component == cm.CoreIUName {
+		allowed = user.IsAdmin() || viewerAllowedUI(r)
+	}
+	if !allowed {
+		libDatabox.Warn("[HTTP proxy] " + user.Name + " may not " + r.Method + " " + r.URL.Path)
+		http.Error(w, "Forbidden", http.StatusForbidden)
+	}
+	return allowed
+}
+
+func webSocketProxy(w http.ResponseWriter, r *http.Request, roots *x509.CertPool, cm *ContainerManager, user User) {
 
 	libDatabox.Debug("[proxyWebSocket] started")
 
 	parts := strings.Split(r.URL.Path, "/")
 
+	if !proxyAllowed(w, r, cm, user, parts[1]) {
+		return
+	}
+
 	backendURL := "wss://" + parts[1] + ":8080/" + strings.Join(parts[2:], "/")
 
 	//libDatabox.Debug("[proxyWebSocket] proxing to " + backendURL)
@@ -190,12 +299,18 @@ func webSocketProxy(w http.ResponseWriter, r *http.Request, roots *x509.CertPool
 	return
 }
 
-func proxy(w http.ResponseWriter, r *http.Request, databoxHttpsClient *http.Client, cm *ContainerManager) {
+func proxy(w http.ResponseWriter, r *http.Request, databoxHttpsClient *http.Client, cm *ContainerManager, user User) {
 	parts := strings.Split(r.URL.Path, "/")
 	var RequestURI string
 	if len(parts) < 3 {
+		if !proxyAllowed(w, r, cm, user, cm.CoreIUName) {
+			return
+		}
 		RequestURI = "https://core-ui:8080/ui/"
 	} else {
+		if !proxyAllowed(w, r, cm, user, parts[1]) {
+			return
+		}
 		RequestURI = "https://" + parts[1] + ":8080/" + strings.Join(parts[2:], "/")
 		if !cm.IsInstalled(parts[1]) {
 			//we have no host this is probably a malformed path (error in app or driver html)
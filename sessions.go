@@ -0,0 +1,347 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	b64 "encoding/base64"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	libDatabox "github.com/me-box/lib-go-databox"
+)
+
+// sessionCookieName is the cookie auth sets when the password is accepted
+const sessionCookieName = "session"
+
+// default session timeouts in minutes, used if SessionOptions does not say
+const (
+	defaultSessionIdleTimeout = 60
+	defaultSessionMaxAge      = 24 * 60
+)
+
+// sessionTouchInterval is how often the last seen time of a persisted session is written to the CM store
+var sessionTouchInterval = time.Minute
+
+// sessionSweepInterval is how often expired sessions are removed
+var sessionSweepInterval = 5 * time.Minute
+
+// SessionOptions control dashboard sessions. The timeouts are in minutes. A session ends
+// once it has not been used for SessionIdleTimeout or is older than SessionMaxAge.
+// If PersistSessions is set the hashed session IDs are kept in the CM store so a
+// CM restart does not log everyone out.
+type SessionOptions struct {
+	SessionIdleTimeout int
+	SessionMaxAge      int
+	PersistSessions    bool
+}
+
+// Session is a logged in dashboard session. The token in the cookie is never stored,
+// ID is its SHA-256 so sessions can be listed and revoked without exposing it.
+type Session struct {
+	ID         string    `json:"id"`
+	User       string    `json:"user"`
+	Created    time.Time `json:"created"`
+	LastSeen   time.Time `json:"lastSeen"`
+	Expires    time.Time `json:"expires"`
+	RemoteAddr string    `json:"remoteAddr"`
+	UserAgent  string    `json:"userAgent"`
+	//set when listing for the session making the request
+	Current bool `json:"current,omitempty"`
+
+	persisted time.Time
+}
+
+// revokeSessionRequest asks for the session with ID to be ended
+type revokeSessionRequest struct {
+	ID string `json:"id"`
+}
+
+// SessionManager issues, checks and revokes dashboard sessions
+type SessionManager struct {
+	idleTimeout time.Duration
+	maxAge      time.Duration
+	store       *CMStore
+
+	mu       sync.Mutex
+	sessions map[string]*Session
+}
+
+// NewSessionManager returns a SessionManager configured from opt. Sessions are saved
+// in store if opt.PersistSessions is set and store is not nil.
+func NewSessionManager(opt SessionOptions, store *CMStore) *SessionManager {
+
+	sm := &SessionManager{
+		idleTimeout: time.Duration(defaultSessionIdleTimeout) * time.Minute,
+		maxAge:      time.Duration(defaultSessionMaxAge) * time.Minute,
+		sessions:    make(map[string]*Session),
+	}
+	if opt.SessionIdleTimeout > 0 {
+		sm.idleTimeout = time.Duration(opt.SessionIdleTimeout) * time.Minute
+	}
+	if opt.SessionMaxAge > 0 {
+		sm.maxAge = time.Duration(opt.SessionMaxAge) * time.Minute
+	}
+
+	if opt.PersistSessions && store != nil {
+		sm.store = store
+		saved, err := store.GetAllSessions()
+		if err != nil {
+			libDatabox.Err("[Sessions] Can't load saved sessions " + err.Error())
+		}
+		now := time.Now()
+		for id, s := range saved {
+			s := s
+			if sm.expired(&s, now) {
+				store.DeleteSession(id)
+				continue
+			}
+			if s.User == "" {
+				//saved before there were users, when only the built-in admin could log in
+				s.User = builtinAdmin
+				err := store.SaveSession(s)
+				if err != nil {
+					libDatabox.Err("[Sessions] Can't save migrated session " + err.Error())
+				}
+			}
+			s.persisted = s.LastSeen
+			sm.sessions[id] = &s
+		}
+		libDatabox.Debug("[Sessions] loaded saved sessions")
+	} else if store != nil {
+		//drop any saved while persistence was turned on
+		store.ClearSessions()
+	}
+
+	return sm
+}
+
+// hashSessionToken returns the ID a session token is stored under
+func hashSessionToken(token string) string {
+	h := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(h[:])
+}
+
+func (sm *SessionManager) expired(s *Session, now time.Time) bool {
+	return now.Sub(s.LastSeen) > sm.idleTimeout || now.Sub(s.Created) > sm.maxAge
+}
+
+func (sm *SessionManager) expires(s *Session) time.Time {
+	idle := s.LastSeen.Add(sm.idleTimeout)
+	absolute := s.Created.Add(sm.maxAge)
+	if idle.Before(absolute) {
+		return idle
+	}
+	return absolute
+}
+
+// Create starts a session for user and returns its token
+func (sm *SessionManager) Create(r *http.Request, user string) (string, error) {
+
+	b := make([]byte, 32)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", errors.New("Can't make a session token " + err.Error())
+	}
+	token := b64.RawURLEncoding.EncodeToString(b)
+
+	now := time.Now()
+	s := &Session{
+		ID:         hashSessionToken(token),
+		User:       user,
+		Created:    now,
+		LastSeen:   now,
+		RemoteAddr: r.RemoteAddr,
+		UserAgent:  r.UserAgent(),
+	}
+
+	sm.mu.Lock()
+	sm.sessions[s.ID] = s
+	sm.mu.Unlock()
+
+	sm.save(s)
+	return token, nil
+}
+
+// Validate reports if token belongs to a live session, and whose it is, and records that it was used
+func (sm *SessionManager) Validate(token string) (string, bool) {
+
+	if token == "" {
+		return "", false
+	}
+	id := hashSessionToken(token)
+	now := time.Now()
+
+	sm.mu.Lock()
+	s, ok := sm.sessions[id]
+	if !ok {
+		sm.mu.Unlock()
+		return "", false
+	}
+	if sm.expired(s, now) {
+		delete(sm.sessions, id)
+		sm.mu.Unlock()
+		sm.unsave(id)
+		return "", false
+	}
+	s.LastSeen = now
+	touch := now.Sub(s.persisted) > sessionTouchInterval
+	copied := *s
+	sm.mu.Unlock()
+
+	if touch {
+		sm.save(&copied)
+	}
+	return copied.User, true
+}
+
+// Revoke ends the session with id, it reports if there was one
+func (sm *SessionManager) Revoke(id string) bool {
+
+	sm.mu.Lock()
+	_, ok := sm.sessions[id]
+	delete(sm.sessions, id)
+	sm.mu.Unlock()
+
+	if ok {
+		sm.unsave(id)
+		libDatabox.Info("[Sessions] session " + id[:8] + " ended")
+	}
+	return ok
+}
+
+// RevokeToken ends the session token belongs to
+func (sm *SessionManager) RevokeToken(token string) bool {
+	return sm.Revoke(hashSessionToken(token))
+}
+
+// RevokeUser ends every session of user except the one keepToken belongs to
+func (sm *SessionManager) RevokeUser(user string, keepToken string) {
+
+	keep := ""
+	if keepToken != "" {
+		keep = hashSessionToken(keepToken)
+	}
+
+	sm.mu.Lock()
+	gone := []string{}
+	for id, s := range sm.sessions {
+		if id != keep && s.User == user {
+			delete(sm.sessions, id)
+			gone = append(gone, id)
+		}
+	}
+	sm.mu.Unlock()
+
+	for _, id := range gone {
+		sm.unsave(id)
+	}
+	libDatabox.Info("[Sessions] ended " + strconv.Itoa(len(gone)) + " sessions of " + user)
+}
+
+// List returns the live sessions oldest first. The session of currentToken is marked.
+func (sm *SessionManager) List(currentToken string) []Session {
+
+	current := ""
+	if currentToken != "" {
+		current = hashSessionToken(currentToken)
+	}
+	now := time.Now()
+
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+
+	res := []Session{}
+	for _, s := range sm.sessions {
+		if sm.expired(s, now) {
+			continue
+		}
+		copied := *s
+		copied.Expires = sm.expires(s)
+		copied.Current = s.ID == current
+		res = append(res, copied)
+	}
+	sort.Slice(res, func(a, b int) bool {
+		return res[a].Created.Before(res[b].Created)
+	})
+	return res
+}
+
+// run removes expired sessions every sessionSweepInterval, it never returns
+func (sm *SessionManager) run() {
+	for {
+		time.Sleep(sessionSweepInterval)
+		sm.sweep()
+	}
+}
+
+func (sm *SessionManager) sweep() {
+
+	now := time.Now()
+	gone := []string{}
+
+	sm.mu.Lock()
+	for id, s := range sm.sessions {
+		if sm.expired(s, now) {
+			delete(sm.sessions, id)
+			gone = append(gone, id)
+		}
+	}
+	sm.mu.Unlock()
+
+	for _, id := range gone {
+		sm.unsave(id)
+	}
+}
+
+func (sm *SessionManager) save(s *Session) {
+	if sm.store == nil {
+		return
+	}
+	err := sm.store.SaveSession(*s)
+	if err != nil {
+		libDatabox.Err("[Sessions] Can't save session " + err.Error())
+		return
+	}
+	sm.mu.Lock()
+	if live, ok := sm.sessions[s.ID]; ok {
+		live.persisted = s.LastSeen
+	}
+	sm.mu.Unlock()
+}
+
+func (sm *SessionManager) unsave(id string) {
+	if sm.store == nil {
+		return
+	}
+	err := sm.store.DeleteSession(id)
+	if err != nil {
+		libDatabox.Err("[Sessions] Can't delete session " + err.Error())
+	}
+}
+
+// sessionToken returns the session token sent with r, if any
+func sessionToken(r *http.Request) string {
+	cookie, err := r.Cookie(sessionCookieName)
+	if err != nil {
+		return ""
+	}
+	return cookie.Value
+}
+
+// sessionCookie is the hardened cookie holding token. A negative maxAge deletes it.
+func sessionCookie(token string, maxAge int) *http.Cookie {
+	return &http.Cookie{
+		Name:     sessionCookieName,
+		Value:    token,
+		Path:     "/",
+		MaxAge:   maxAge,
+		Secure:   true,
+		HttpOnly: true,
+		SameSite: http.SameSiteStrictMode,
+	}
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestSessionExpiry(t *testing.T) {
+
+	tests := []struct {
+		name      string
+		age       time.Duration
+		idle      time.Duration
+		wantValid bool
+	}{
+		{name: "new", wantValid: true},
+		{name: "in use", age: 2 * time.Hour, idle: time.Minute, wantValid: true},
+		{name: "idle too long", age: 2 * time.Hour, idle: 61 * time.Minute},
+		{name: "too old while in use", age: 25 * time.Hour, idle: time.Minute},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := &CMStore{Store: newMemoryKV()}
+			sm := NewSessionManager(SessionOptions{SessionIdleTimeout: 60, SessionMaxAge: 24 * 60, PersistSessions: true}, store)
+
+			token, err := sm.Create(httptest.NewRequest("POST", "/", nil), "viewer-one")
+			if err != nil {
+				t.Fatal(err)
+			}
+			now := time.Now()
+			sm.sessions[hashSessionToken(token)].Created = now.Add(-tt.age)
+			sm.sessions[hashSessionToken(token)].LastSeen = now.Add(-tt.idle)
+
+			user, ok := sm.Validate(token)
+			if ok != tt.wantValid {
+				t.Fatalf("Validate() = %v, want %v", ok, tt.wantValid)
+			}
+			if ok && user != "viewer-one" {
+				t.Errorf("session user = %q, want viewer-one", user)
+			}
+
+			listed := len(sm.List(""))
+			saved, _ := store.GetAllSessions()
+			if tt.wantValid && (listed != 1 || len(saved) != 1) {
+				t.Errorf("%d sessions listed and %d saved, want the live one", listed, len(saved))
+			}
+			if !tt.wantValid && (listed != 0 || len(saved) != 0) {
+				t.Errorf("%d sessions listed and %d saved after it expired", listed, len(saved))
+			}
+			if _, ok := sm.Validate(token); ok != tt.wantValid {
+				t.Errorf("second Validate() = %v, want %v", ok, tt.wantValid)
+			}
+		})
+	}
+}
+
+func TestSessionRevoke(t *testing.T) {
+
+	store := &CMStore{Store: newMemoryKV()}
+	sm := NewSessionManager(SessionOptions{PersistSessions: true}, store)
+	r := httptest.NewRequest("POST", "/", nil)
+
+	tokens := map[string]string{}
+	for _, name := range []string{"alice-1", "alice-2", "alice-3", "admin"} {
+		user := "alice"
+		if name == "admin" {
+			user = builtinAdmin
+		}
+		token, err := sm.Create(r, user)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tokens[name] = token
+	}
+
+	if !sm.RevokeToken(tokens["alice-1"]) {
+		t.Error("RevokeToken() found no session")
+	}
+	if sm.Revoke(hashSessionToken(tokens["alice-1"])) {
+		t.Error("a session was revoked twice")
+	}
+	sm.RevokeUser("alice", tokens["alice-2"])
+
+	want := map[string]bool{"alice-1": false, "alice-2": true, "alice-3": false, "admin": true}
+	for name, wantValid := range want {
+		if _, ok := sm.Validate(tokens[name]); ok != wantValid {
+			t.Errorf("%s valid = %v, want %v", name, ok, wantValid)
+		}
+	}
+
+	saved, _ := store.GetAllSessions()
+	if len(saved) != 2 {
+		t.Errorf("%d sessions saved, want 2", len(saved))
+	}
+	reloaded := NewSessionManager(SessionOptions{PersistSessions: true}, store)
+	if _, ok := reloaded.Validate(tokens["alice-3"]); ok {
+		t.Error("a revoked session came back after a restart")
+	}
+	if user, ok := reloaded.Validate(tokens["alice-2"]); !ok || user != "alice" {
+		t.Errorf("kept session = %q, %v after a restart", user, ok)
+	}
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"errors"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+
+	libDatabox "github.com/me-box/lib-go-databox"
+)
+
+// ValidationIssue is a single problem found in an SLA
+type ValidationIssue struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// ValidationResult holds everything wrong with an SLA. Errors stop an install,
+// warnings are things the user should know about but will still work.
+type ValidationResult struct {
+	Valid    bool              `json:"valid"`
+	Errors   []ValidationIssue `json:"errors"`
+	Warnings []ValidationIssue `json:"warnings"`
+}
+
+func (r *ValidationResult) addError(field string, message string) {
+	r.Errors = append(r.Errors, ValidationIssue{Field: field, Message: message})
+	r.Valid = false
+}
+
+func (r *ValidationResult) addWarning(field string, message string) {
+	r.Warnings = append(r.Warnings, ValidationIssue{Field: field, Message: message})
+}
+
+// Err returns nil if the SLA is valid otherwise an error listing all the problems
+func (r ValidationResult) Err() error {
+	if r.Valid {
+		return nil
+	}
+	msgs := []string{}
+	for _, e := range r.Errors {
+		msgs = append(msgs, e.Field+": "+e.Message)
+	}
+	return errors.New("Invalid SLA " + strings.Join(msgs, "; "))
+}
+
+// dnsLabel is a RFC 1123 label, swarm service names are used as host names on the databox networks
+var dnsLabel = regexp.MustCompile(`^[a-z0-9]([a-z0-9-]*[a-z0-9])?$`)
+
+// envName is what can follow DATASOURCE_ in an environment variable name
+var envName = regexp.MustCompile(`^[A-Za-z0-9_]+$`)
+
+// systemServiceNames are taken by the container manager and the components it starts so can not be used by apps or drivers
+var systemServiceNames = []string{
+	"arbiter",
+	"export-service",
+	"container-manager",
+	"databox-network",
+	"databox-network-relay",
+	"databox-broadcast-relay",
+	//the REST API is served from /api/ so an app called api could not be reached
+	"api",
+}
+
+// ValidateSLA checks an app or driver SLA before it is installed
+func (cm ContainerManager) ValidateSLA(sla libDatabox.SLA) ValidationResult {
+
+	res := ValidationResult{
+		Valid:    true,
+		Errors:   []ValidationIssue{},
+		Warnings: []ValidationIssue{},
+	}
+
+	cm.validateName(sla, &res)
+
+	switch sla.DataboxType {
+	case libDatabox.DataboxTypeApp, libDatabox.DataboxTypeDriver:
+	default:
+		res.addError("databox-type", "unsupported type '"+string(sla.DataboxType)+"' must be app or driver")
+	}
+
+	if sla.ResourceRequirements.Store != "" && sla.ResourceRequirements.Store != cm.CoreStoreName {
+		res.addError("resource-requirements.store", "unsupported store '"+sla.ResourceRequirements.Store+"' only "+cm.CoreStoreName+" is available")
+	}
+
+	cm.validateDatasources(sla, &res)
+
+	for i, wl := range sla.ExportWhitelists {
+		field := "export-whitelist[" + strconv.Itoa(i) + "].url"
+		u, err := url.Parse(wl.Url)
+		if err != nil || u.Hostname() == "" {
+			res.addError(field, "'"+wl.Url+"' is not a valid URL")
+		}
+	}
+
+	for i, wl := range sla.ExternalWhitelist {
+		if sla.DataboxType != libDatabox.DataboxTypeDriver {
+			res.addWarning("external-whitelist", "only drivers can access external hosts this will be ignored")
+			break
+		}
+		for j, u := range wl.Urls {
+			field := "external-whitelist[" + strconv.Itoa(i) + "].urls[" + strconv.Itoa(j) + "]"
+			parsed, err := url.Parse(u)
+			if err != nil || parsed.Hostname() == "" {
+				res.addError(field, "'"+u+"' is not a valid URL")
+			}
+		}
+	}
+
+	return res
+}
+
+func (cm ContainerManager) validateName(sla libDatabox.SLA, res *ValidationResult) {
+
+	name := sla.Name
+	if name == "" {
+		res.addError("name", "name is required")
+		return
+	}
+
+	if !dnsLabel.MatchString(name) {
+		res.addError("name", "'"+name+"' must only contain lower case letters, numbers and '-' and must start and end with a letter or number")
+	}
+
+	fullName := name
+	if sla.ResourceRequirements.Store != "" {
+		fullName = name + "-" + sla.ResourceRequirements.Store
+	}
+	if len(fullName) > 63 {
+		res.addError("name", "'"+fullName+"' is longer than 63 characters")
+	}
+
+	for _, reserved := range append(systemServiceNames, cm.CoreIUName, cm.AppStoreName) {
+		if name == reserved {
+			res.addError("name", "'"+name+"' is reserved for a databox system component")
+		}
+	}
+
+	if strings.HasSuffix(name, "-"+cm.CoreStoreName) {
+		res.addError("name", "'"+name+"' would clash with the store of another component")
+	}
+}
+
+func (cm ContainerManager) validateDatasources(sla libDatabox.SLA, res *ValidationResult) {
+
+	if sla.DataboxType == libDatabox.DataboxTypeDriver && len(sla.Datasources) > 0 {
+		res.addWarning("datasources", "drivers can not request datasources these will be ignored")
+		return
+	}
+
+	clientIDs := map[string]bool{}
+	for i, ds := range sla.Datasources {
+		field := "datasources[" + strconv.Itoa(i) + "]"
+
+		if !envName.MatchString(ds.Clientid) {
+			res.addError(field+".clientid", "'"+ds.Clientid+"' must only contain letters, numbers and '_'")
+		} else if clientIDs[ds.Clientid] {
+			res.addError(field+".clientid", "'"+ds.Clientid+"' is used by more than one datasource")
+		}
+		clientIDs[ds.Clientid] = true
+
+		href, err := url.Parse(ds.Hypercat.Href)
+		if err != nil || href.Hostname() == "" {
+			res.addError(field+".hypercat.href", "'"+ds.Hypercat.Href+"' is not a valid datasource URL")
+			continue
+		}
+
+		storeName := href.Hostname()
+		if !strings.HasSuffix(storeName, "-"+cm.CoreStoreName) {
+			res.addWarning(field+".hypercat.href", "'"+storeName+"' is not a databox store")
+			continue
+		}
+		owner := strings.TrimSuffix(storeName, "-"+cm.CoreStoreName)
+		if owner != "container-manager" && !cm.IsInstalled(owner) {
+			res.addWarning(field+".hypercat.href", "'"+owner+"' that provides this datasource is not installed")
+		}
+	}
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"strings"
+	"sync"
+	"testing"
+
+	libDatabox "github.com/me-box/lib-go-databox"
+)
+
+func TestValidateSLA(t *testing.T) {
+
+	datasource := func(clientID string, href string) libDatabox.DataSource {
+		return libDatabox.DataSource{Clientid: clientID, Hypercat: libDatabox.HypercatItem{Href: href}}
+	}
+
+	tests := []struct {
+		name         string
+		sla          libDatabox.SLA
+		wantErrors   []string
+		wantWarnings []string
+	}{
+		{
+			name: "app",
+			sla: libDatabox.SLA{Name: "app-valid", DataboxType: libDatabox.DataboxTypeApp, Datasources: []libDatabox.DataSource{
+				datasource("SENSOR", "tcp://driver-installed-core-store:5555/kv/temp"),
+				datasource("CM_API", "tcp://container-manager-core-store:5555/kv/api"),
+			}},
+		},
+		{
+			name: "driver",
+			sla:  libDatabox.SLA{Name: "driver-valid", DataboxType: libDatabox.DataboxTypeDriver, ResourceRequirements: libDatabox.ResourceRequirements{Store: "core-store"}},
+		},
+		{
+			name:       "no name",
+			sla:        libDatabox.SLA{DataboxType: libDatabox.DataboxTypeApp},
+			wantErrors: []string{"name"},
+		},
+		{
+			name:       "upper case",
+			sla:        libDatabox.SLA{Name: "App-Bad", DataboxType: libDatabox.DataboxTypeApp},
+			wantErrors: []string{"name"},
+		},
+		{
+			name:       "ends with a dash",
+			sla:        libDatabox.SLA{Name: "app-bad-", DataboxType: libDatabox.DataboxTypeApp},
+			wantErrors: []string{"name"},
+		},
+		{
+			name:       "too long with its store",
+			sla:        libDatabox.SLA{Name: "driver-" + strings.Repeat("a", 50), DataboxType: libDatabox.DataboxTypeDriver, ResourceRequirements: libDatabox.ResourceRequirements{Store: "core-store"}},
+			wantErrors: []string{"name"},
+		},
+		{
+			name:       "reserved",
+			sla:        libDatabox.SLA{Name: "arbiter", DataboxType: libDatabox.DataboxTypeApp},
+			wantErrors: []string{"name"},
+		},
+		{
+			name:       "reserved ui",
+			sla:        libDatabox.SLA{Name: "core-ui", DataboxType: libDatabox.DataboxTypeApp},
+			wantErrors: []string{"name"},
+		},
+		{
+			name:       "store suffix",
+			sla:        libDatabox.SLA{Name: "driver-other-core-store", DataboxType: libDatabox.DataboxTypeDriver},
+			wantErrors: []string{"name"},
+		},
+		{
+			name:       "type",
+			sla:        libDatabox.SLA{Name: "store-valid", DataboxType: libDatabox.DataboxTypeStore},
+			wantErrors: []string{"databox-type"},
+		},
+		{
+			name:       "other store",
+			sla:        libDatabox.SLA{Name: "driver-valid", DataboxType: libDatabox.DataboxTypeDriver, ResourceRequirements: libDatabox.ResourceRequirements{Store: "other-store"}},
+			wantErrors: []string{"resource-requirements.store"},
+		},
+		{
+			name: "bad and repeated client IDs",
+			sla: libDatabox.SLA{Name: "app-valid", DataboxType: libDatabox.DataboxTypeApp, Datasources: []libDatabox.DataSource{
+				datasource("SENSOR", "tcp://driver-installed-core-store:5555/kv/temp"),
+				datasource("SENSOR", "tcp://driver-installed-core-store:5555/kv/light"),
+				datasource("SENSOR-2", "tcp://driver-installed-core-store:5555/kv/sound"),
+			}},
+			wantErrors: []string{"datasources[1].clientid", "datasources[2].clientid"},
+		},
+		{
+			name: "bad datasource href",
+			sla: libDatabox.SLA{Name: "app-valid", DataboxType: libDatabox.DataboxTypeApp, Datasources: []libDatabox.DataSource{
+				datasource("SENSOR", "/kv/temp"),
+			}},
+			wantErrors: []string{"datasources[0].hypercat.href"},
+		},
+		{
+			name: "datasource not from a store",
+			sla: libDatabox.SLA{Name: "app-valid", DataboxType: libDatabox.DataboxTypeApp, Datasources: []libDatabox.DataSource{
+				datasource("SENSOR", "tcp://example.com:5555/kv/temp"),
+			}},
+			wantWarnings: []string{"datasources[0].hypercat.href"},
+		},
+		{
+			name: "datasource of a driver not installed",
+			sla: libDatabox.SLA{Name: "app-valid", DataboxType: libDatabox.DataboxTypeApp, Datasources: []libDatabox.DataSource{
+				datasource("SENSOR", "tcp://driver-missing-core-store:5555/kv/temp"),
+			}},
+			wantWarnings: []string{"datasources[0].hypercat.href"},
+		},
+		{
+			name: "driver datasources",
+			sla: libDatabox.SLA{Name: "driver-valid", DataboxType: libDatabox.DataboxTypeDriver, Datasources: []libDatabox.DataSource{
+				datasource("bad id", "not a url"),
+			}},
+			wantWarnings: []string{"datasources"},
+		},
+		{
+			name: "export whitelist",
+			sla: libDatabox.SLA{Name: "app-valid", DataboxType: libDatabox.DataboxTypeApp, ExportWhitelists: []libDatabox.ExportWhitelist{
+				{Url: "https://example.com/export"},
+				{Url: "example.com"},
+			}},
+			wantErrors: []string{"export-whitelist[1].url"},
+		},
+		{
+			name: "external whitelist",
+			sla: libDatabox.SLA{Name: "driver-valid", DataboxType: libDatabox.DataboxTypeDriver, ExternalWhitelist: []libDatabox.ExternalWhitelist{
+				{Urls: []string{"https://github.com", "github.com"}},
+			}},
+			wantErrors: []string{"external-whitelist[0].urls[1]"},
+		},
+		{
+			name: "app external whitelist",
+			sla: libDatabox.SLA{Name: "app-valid", DataboxType: libDatabox.DataboxTypeApp, ExternalWhitelist: []libDatabox.ExternalWhitelist{
+				{Urls: []string{"github.com"}},
+				{Urls: []string{"https://github.com"}},
+			}},
+			wantWarnings: []string{"external-whitelist"},
+		},
+	}
+
+	td := newTestDatabox(t)
+	td.cm.InstalledComponents.add("driver-installed")
+
+	fields := func(issues []ValidationIssue) string {
+		f := []string{}
+		for _, i := range issues {
+			f = append(f, i.Field)
+		}
+		return strings.Join(f, ",")
+	}
+
+	for _, tt := range tests {
+		res := td.cm.ValidateSLA(tt.sla)
+		if got, want := fields(res.Errors), strings.Join(tt.wantErrors, ","); got != want {
+			t.Errorf("%s: errors = %v, want %s", tt.name, res.Errors, want)
+		}
+		if got, want := fields(res.Warnings), strings.Join(tt.wantWarnings, ","); got != want {
+			t.Errorf("%s: warnings = %v, want %s", tt.name, res.Warnings, want)
+		}
+		if res.Valid != (len(tt.wantErrors) == 0) || (res.Err() == nil) != res.Valid {
+			t.Errorf("%s: valid = %v, Err() = %v", tt.name, res.Valid, res.Err())
+		}
+	}
+}
+
+// SLAs are validated while installs and uninstalls change the installed components
+func TestInstalledSetConcurrent(t *testing.T) {
+
+	is := NewInstalledSet()
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 1000; j++ {
+				is.add("driver-busy")
+				is.remove("driver-busy")
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 1000; j++ {
+				is.Has("driver-busy")
+			}
+		}()
+	}
+	wg.Wait()
+
+	if is.Has("driver-busy") {
+		t.Error("driver-busy is still installed")
+	}
+	var missing *InstalledSet
+	if missing.Has("driver-busy") {
+		t.Error("a nil InstalledSet has driver-busy")
+	}
+}
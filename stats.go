@@ -0,0 +1,411 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	libDatabox "github.com/me-box/lib-go-databox"
+)
+
+// statsHistoryID is the kv datasource in the CM store resource usage is kept in. The samples are
+// grouped under one key per statsBucket so whole buckets can be dropped once they are older than
+// StatsRetention, a ts/blob datasource has no way to remove old entries and grows without bound.
+const statsHistoryID = "statsHistory"
+
+// statsBucket is how much time the samples under one key cover
+const statsBucket = 10 * time.Minute
+
+// statsDefaultLimit is how many samples are returned when a query does not give a time range
+const statsDefaultLimit = 100
+
+// defaultStatsInterval is how often, in seconds, usage is sampled if StatsOptions does not say
+const defaultStatsInterval = 30
+
+// defaultStatsRetention is how many hours of samples are kept if StatsOptions does not say
+const defaultStatsRetention = 24
+
+// StatsOptions control the resource usage sampler. StatsInterval is in seconds,
+// StatsRetention is how many hours of samples are kept.
+type StatsOptions struct {
+	StatsInterval  int
+	StatsRetention int
+}
+
+// ComponentStats is the resource usage of one component at a point in time
+type ComponentStats struct {
+	Time          time.Time `json:"time"`
+	Component     string    `json:"component"`
+	Container     string    `json:"container"`
+	CPUPercent    float64   `json:"cpuPercent"`
+	MemoryUsage   uint64    `json:"memoryUsage"`
+	MemoryLimit   uint64    `json:"memoryLimit"`
+	MemoryPercent float64   `json:"memoryPercent"`
+	NetworkRx     uint64    `json:"networkRx"`
+	NetworkTx     uint64    `json:"networkTx"`
+	BlockRead     uint64    `json:"blockRead"`
+	BlockWrite    uint64    `json:"blockWrite"`
+	Pids          uint64    `json:"pids"`
+}
+
+// statsQuery selects samples for the ResourceUsage FUNC. From and To are unix
+// milliseconds, if neither is set the latest Limit samples of Component (or of everything if it is blank) are returned.
+type statsQuery struct {
+	Component string `json:"component"`
+	From      int64  `json:"from"`
+	To        int64  `json:"to"`
+	Limit     int    `json:"limit"`
+}
+
+// statsResult is returned by the ResourceUsage FUNC
+type statsResult struct {
+	Current []ComponentStats `json:"current"`
+	History []ComponentStats `json:"history"`
+}
+
+// StatsSampler keeps the latest resource usage of each component and the samples of the last StatsRetention hours in the CM store
+type StatsSampler struct {
+	store     KeyValueStore
+	retention time.Duration
+	mu        sync.Mutex
+	latest    map[string]ComponentStats
+
+	//the bucket being filled, only used by record
+	bucketKey string
+	bucket    []ComponentStats
+}
+
+// NewStatsSampler registers the stats datasource in store and returns a StatsSampler
+// keeping retention hours of samples in it
+func NewStatsSampler(store *libDatabox.CoreStoreClient, retention int) *StatsSampler {
+
+	store.RegisterDatasource(libDatabox.DataSourceMetadata{
+		Description:    "Databox container manager component resource usage",
+		ContentType:    "application/json",
+		Vendor:         "Databox",
+		DataSourceType: "databox:container-manager:stats",
+		DataSourceID:   statsHistoryID,
+		StoreType:      "kv",
+		IsActuator:     false,
+		Unit:           "",
+		Location:       "",
+	})
+
+	return newStatsSampler(store.KVJSON, retention)
+}
+
+func newStatsSampler(store KeyValueStore, retention int) *StatsSampler {
+	if retention <= 0 {
+		retention = defaultStatsRetention
+	}
+	return &StatsSampler{
+		store:     store,
+		retention: time.Duration(retention) * time.Hour,
+		latest:    make(map[string]ComponentStats),
+	}
+}
+
+// statsBucketKey is the key of the bucket t falls in, the unix milliseconds it starts at
+func statsBucketKey(t time.Time) string {
+	return strconv.FormatInt(t.Truncate(statsBucket).UnixNano()/int64(time.Millisecond), 10)
+}
+
+// statsBucketStart is the start of the bucket called key
+func statsBucketStart(key string) (time.Time, bool) {
+	ms, err := strconv.ParseInt(key, 10, 64)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return time.Unix(0, ms*int64(time.Millisecond)), true
+}
+
+// record replaces the latest samples with samples, adds them to the history and
+// drops the buckets that are older than the retention time
+func (s *StatsSampler) record(samples []ComponentStats) {
+
+	latest := make(map[string]ComponentStats)
+	for _, sample := range samples {
+		latest[sample.Component] = sample
+	}
+	s.mu.Lock()
+	s.latest = latest
+	s.mu.Unlock()
+
+	if len(samples) == 0 {
+		return
+	}
+
+	keys, err := s.store.ListKeys(statsHistoryID)
+	if err != nil {
+		libDatabox.Err("[Stats] Error listing samples " + err.Error())
+		return
+	}
+	stored := make(map[string]bool)
+	for _, key := range keys {
+		stored[key] = true
+	}
+
+	//samples are taken together but can still straddle two buckets
+	newest := samples[0].Time
+	for _, sample := range samples {
+		if sample.Time.After(newest) {
+			newest = sample.Time
+		}
+		key := statsBucketKey(sample.Time)
+		if key != s.bucketKey {
+			s.writeBucket()
+			s.bucketKey, s.bucket = key, nil
+			if stored[key] {
+				//carry on filling the bucket written before a restart
+				s.bucket, err = s.readBucket(key)
+				if err != nil {
+					libDatabox.Err("[Stats] Error reading samples " + err.Error())
+					s.bucketKey, s.bucket = "", nil
+					continue
+				}
+			}
+		}
+		s.bucket = append(s.bucket, sample)
+	}
+	s.writeBucket()
+
+	s.prune(keys, newest)
+}
+
+// writeBucket saves the bucket being filled
+func (s *StatsSampler) writeBucket() {
+	if s.bucketKey == "" {
+		return
+	}
+	payload, err := json.Marshal(s.bucket)
+	if err != nil {
+		libDatabox.Err("[Stats] Error encoding samples " + err.Error())
+		return
+	}
+	err = s.store.Write(statsHistoryID, s.bucketKey, payload)
+	if err != nil {
+		libDatabox.Err("[Stats] Error writing samples " + err.Error())
+	}
+}
+
+// prune deletes the buckets in keys that end more than the retention time before newest
+func (s *StatsSampler) prune(keys []string, newest time.Time) {
+	cutoff := newest.Add(-s.retention)
+	for _, key := range keys {
+		start, ok := statsBucketStart(key)
+		if !ok || start.Add(statsBucket).After(cutoff) {
+			continue
+		}
+		err := s.store.Delete(statsHistoryID, key)
+		if err != nil {
+			libDatabox.Err("[Stats] Error deleting old samples " + err.Error())
+		}
+	}
+}
+
+func (s *StatsSampler) readBucket(key string) ([]ComponentStats, error) {
+	var samples []ComponentStats
+	payload, err := s.store.Read(statsHistoryID, key)
+	if err != nil || len(payload) == 0 {
+		return samples, err
+	}
+	err = json.Unmarshal(payload, &samples)
+	return samples, err
+}
+
+// Current returns the latest sample of component, or of every component if it is blank
+func (s *StatsSampler) Current(component string) []ComponentStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	res := []ComponentStats{}
+	for name, sample := range s.latest {
+		if component == "" || name == component {
+			res = append(res, sample)
+		}
+	}
+	sort.Slice(res, func(a, b int) bool {
+		return res[a].Component < res[b].Component
+	})
+	return res
+}
+
+// Query returns the samples matching q oldest first
+func (s *StatsSampler) Query(q statsQuery) ([]ComponentStats, error) {
+
+	res := []ComponentStats{}
+
+	limit := q.Limit
+	if limit <= 0 {
+		limit = statsDefaultLimit
+	}
+	from := time.Unix(0, q.From*int64(time.Millisecond))
+	to := time.Unix(0, q.To*int64(time.Millisecond))
+
+	keys, err := s.store.ListKeys(statsHistoryID)
+	if err != nil {
+		return res, err
+	}
+	sort.Slice(keys, func(a, b int) bool {
+		startA, _ := statsBucketStart(keys[a])
+		startB, _ := statsBucketStart(keys[b])
+		return startA.After(startB)
+	})
+
+	//newest bucket first, the buckets do not overlap so once limit samples
+	//are found the older buckets can not hold any of the latest ones
+	for _, key := range keys {
+		start, ok := statsBucketStart(key)
+		if !ok || q.To != 0 && start.After(to) {
+			continue
+		}
+		if q.From != 0 && !start.Add(statsBucket).After(from) {
+			break
+		}
+		samples, err := s.readBucket(key)
+		if err != nil {
+			return res, err
+		}
+		for _, sample := range samples {
+			if q.Component != "" && sample.Component != q.Component {
+				continue
+			}
+			if q.From != 0 && sample.Time.Before(from) || q.To != 0 && sample.Time.After(to) {
+				continue
+			}
+			res = append(res, sample)
+		}
+		if len(res) >= limit {
+			break
+		}
+	}
+
+	sort.Slice(res, func(a, b int) bool {
+		return res[a].Time.Before(res[b].Time)
+	})
+
+	if len(res) > limit {
+		res = res[len(res)-limit:]
+	}
+
+	return res, nil
+}
+
+// sampleStats samples the resource usage of every databox component forever
+func (cm ContainerManager) sampleStats() {
+
+	interval := time.Duration(defaultStatsInterval) * time.Second
+	if cm.Stats.StatsInterval > 0 {
+		interval = time.Duration(cm.Stats.StatsInterval) * time.Second
+	}
+
+	for {
+		cm.Usage.record(cm.sampleStatsOnce())
+		time.Sleep(interval)
+	}
+}
+
+// sampleStatsOnce reads the stats of the running container of every databox service
+func (cm ContainerManager) sampleStatsOnce() []ComponentStats {
+
+	samples := []ComponentStats{}
+
+	services, err := cm.cli.ServiceList(context.Background(), types.ServiceListOptions{})
+	if err != nil {
+		libDatabox.Err("[Stats] Can't list services " + err.Error())
+		return samples
+	}
+
+	for _, service := range services {
+		if _, ok := service.Spec.Labels["databox.type"]; !ok {
+			continue
+		}
+
+		cont, err := cm.runningContainerFor(service.Spec.Name)
+		if err != nil {
+			//stopped or quarantined, nothing to sample
+			continue
+		}
+
+		sample, err := cm.containerStats(cont.ID)
+		if err != nil {
+			libDatabox.Warn("[Stats] Can't get stats for " + service.Spec.Name + " " + err.Error())
+			continue
+		}
+		sample.Component = service.Spec.Name
+		samples = append(samples, sample)
+	}
+
+	return samples
+}
+
+// containerStats takes a single sample of the usage of containerID
+func (cm ContainerManager) containerStats(containerID string) (ComponentStats, error) {
+
+	resp, err := cm.cli.ContainerStats(context.Background(), containerID, false)
+	if err != nil {
+		return ComponentStats{}, err
+	}
+	defer resp.Body.Close()
+
+	var s types.StatsJSON
+	err = json.NewDecoder(resp.Body).Decode(&s)
+	if err != nil {
+		return ComponentStats{}, err
+	}
+
+	return statsFromDocker(containerID, s), nil
+}
+
+// statsFromDocker works out usage the same way the docker stats command does
+func statsFromDocker(containerID string, s types.StatsJSON) ComponentStats {
+
+	res := ComponentStats{
+		Time:      s.Read,
+		Container: containerID,
+		Pids:      s.PidsStats.Current,
+	}
+	if res.Time.IsZero() {
+		res.Time = time.Now()
+	}
+
+	cpuDelta := float64(s.CPUStats.CPUUsage.TotalUsage) - float64(s.PreCPUStats.CPUUsage.TotalUsage)
+	systemDelta := float64(s.CPUStats.SystemUsage) - float64(s.PreCPUStats.SystemUsage)
+	cpus := float64(s.CPUStats.OnlineCPUs)
+	if cpus == 0 {
+		cpus = float64(len(s.CPUStats.CPUUsage.PercpuUsage))
+	}
+	if cpuDelta > 0 && systemDelta > 0 {
+		res.CPUPercent = cpuDelta / systemDelta * cpus * 100
+	}
+
+	//page cache can be reclaimed so it does not count
+	res.MemoryUsage = s.MemoryStats.Usage
+	if cache, ok := s.MemoryStats.Stats["cache"]; ok && cache < res.MemoryUsage {
+		res.MemoryUsage = res.MemoryUsage - cache
+	}
+	res.MemoryLimit = s.MemoryStats.Limit
+	if res.MemoryLimit > 0 {
+		res.MemoryPercent = float64(res.MemoryUsage) / float64(res.MemoryLimit) * 100
+	}
+
+	for _, n := range s.Networks {
+		res.NetworkRx += n.RxBytes
+		res.NetworkTx += n.TxBytes
+	}
+
+	for _, b := range s.BlkioStats.IoServiceBytesRecursive {
+		switch b.Op {
+		case "Read", "read":
+			res.BlockRead += b.Value
+		case "Write", "write":
+			res.BlockWrite += b.Value
+		}
+	}
+
+	return res
+}
@@ -0,0 +1,282 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/swarm"
+	libDatabox "github.com/me-box/lib-go-databox"
+)
+
+func TestStatsFromDocker(t *testing.T) {
+
+	read := time.Date(2018, 6, 1, 12, 0, 0, 0, time.UTC)
+	cpu := func(total uint64, system uint64, online uint32, percpu int) types.CPUStats {
+		return types.CPUStats{
+			CPUUsage:    types.CPUUsage{TotalUsage: total, PercpuUsage: make([]uint64, percpu)},
+			SystemUsage: system,
+			OnlineCPUs:  online,
+		}
+	}
+
+	tests := []struct {
+		name    string
+		stats   types.StatsJSON
+		want    ComponentStats
+		wantNow bool
+	}{
+		{
+			name: "cpu",
+			stats: types.StatsJSON{Stats: types.Stats{
+				Read:        read,
+				PreCPUStats: cpu(1000, 10000, 2, 0),
+				CPUStats:    cpu(1500, 20000, 2, 0),
+			}},
+			want: ComponentStats{Time: read, CPUPercent: 10},
+		},
+		{
+			name: "cpus from the per cpu usage",
+			stats: types.StatsJSON{Stats: types.Stats{
+				Read:        read,
+				PreCPUStats: cpu(1000, 10000, 0, 4),
+				CPUStats:    cpu(1500, 20000, 0, 4),
+			}},
+			want: ComponentStats{Time: read, CPUPercent: 20},
+		},
+		{
+			name: "first sample has no previous cpu",
+			stats: types.StatsJSON{Stats: types.Stats{
+				Read:     read,
+				CPUStats: cpu(1500, 20000, 2, 0),
+			}},
+			want: ComponentStats{Time: read, CPUPercent: 15},
+		},
+		{
+			name: "idle",
+			stats: types.StatsJSON{Stats: types.Stats{
+				Read:        read,
+				PreCPUStats: cpu(1500, 10000, 2, 0),
+				CPUStats:    cpu(1500, 20000, 2, 0),
+			}},
+			want: ComponentStats{Time: read},
+		},
+		{
+			name: "memory without the page cache",
+			stats: types.StatsJSON{Stats: types.Stats{
+				Read:        read,
+				MemoryStats: types.MemoryStats{Usage: 300 << 20, Limit: 400 << 20, Stats: map[string]uint64{"cache": 100 << 20}},
+			}},
+			want: ComponentStats{Time: read, MemoryUsage: 200 << 20, MemoryLimit: 400 << 20, MemoryPercent: 50},
+		},
+		{
+			name: "cache bigger than usage",
+			stats: types.StatsJSON{Stats: types.Stats{
+				Read:        read,
+				MemoryStats: types.MemoryStats{Usage: 100 << 20, Stats: map[string]uint64{"cache": 200 << 20}},
+			}},
+			want: ComponentStats{Time: read, MemoryUsage: 100 << 20},
+		},
+		{
+			name: "network, block io and pids",
+			stats: types.StatsJSON{
+				Stats: types.Stats{
+					Read:      read,
+					PidsStats: types.PidsStats{Current: 7},
+					BlkioStats: types.BlkioStats{IoServiceBytesRecursive: []types.BlkioStatEntry{
+						{Op: "Read", Value: 10}, {Op: "read", Value: 5}, {Op: "Write", Value: 20}, {Op: "Total", Value: 35},
+					}},
+				},
+				Networks: map[string]types.NetworkStats{
+					"eth0": {RxBytes: 100, TxBytes: 10},
+					"eth1": {RxBytes: 50, TxBytes: 5},
+				},
+			},
+			want: ComponentStats{Time: read, NetworkRx: 150, NetworkTx: 15, BlockRead: 15, BlockWrite: 20, Pids: 7},
+		},
+		{
+			name:    "no read time",
+			stats:   types.StatsJSON{},
+			wantNow: true,
+		},
+	}
+
+	for _, tt := range tests {
+		before := time.Now()
+		got := statsFromDocker("c1", tt.stats)
+
+		if got.Container != "c1" {
+			t.Errorf("%s: container = %q", tt.name, got.Container)
+		}
+		if tt.wantNow {
+			if got.Time.Before(before) {
+				t.Errorf("%s: time = %v, want now", tt.name, got.Time)
+			}
+			continue
+		}
+		tt.want.Container = "c1"
+		if math.Abs(got.CPUPercent-tt.want.CPUPercent) > 1e-9 || math.Abs(got.MemoryPercent-tt.want.MemoryPercent) > 1e-9 {
+			t.Errorf("%s: cpu %v%% memory %v%%, want %v%% %v%%", tt.name, got.CPUPercent, got.MemoryPercent, tt.want.CPUPercent, tt.want.MemoryPercent)
+		}
+		got.CPUPercent, got.MemoryPercent = tt.want.CPUPercent, tt.want.MemoryPercent
+		if got != tt.want {
+			t.Errorf("%s: statsFromDocker() = %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestSampleStatsOnce(t *testing.T) {
+
+	td := newTestDatabox(t)
+	for _, name := range []string{"app-busy", "app-stopped"} {
+		err := td.cm.LaunchFromSLA(td.testSLA(name, libDatabox.DataboxTypeApp), true, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err := td.cm.StopService("app-stopped", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	td.cli.AddImage("portainer/portainer:latest")
+	_, err = td.cli.ServiceCreate(context.Background(), swarm.ServiceSpec{
+		Annotations:  swarm.Annotations{Name: "portainer"},
+		TaskTemplate: swarm.TaskSpec{ContainerSpec: &swarm.ContainerSpec{Image: "portainer/portainer:latest"}},
+	}, types.ServiceCreateOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	busy := td.containers("app-busy")
+	if len(busy) != 1 {
+		t.Fatalf("app-busy has %d containers", len(busy))
+	}
+	td.cli.SetContainerStats(busy[0].ID, types.StatsJSON{Stats: types.Stats{
+		MemoryStats: types.MemoryStats{Usage: 64 << 20, Limit: 256 << 20},
+		PidsStats:   types.PidsStats{Current: 3},
+	}})
+
+	samples := td.cm.sampleStatsOnce()
+	if len(samples) != 1 {
+		t.Fatalf("samples = %+v, want only app-busy", samples)
+	}
+	got := samples[0]
+	if got.Component != "app-busy" || got.Container != busy[0].ID || got.MemoryPercent != 25 || got.Pids != 3 || got.Time.IsZero() {
+		t.Errorf("sample = %+v", got)
+	}
+}
+
+func TestStatsHistory(t *testing.T) {
+
+	kv := newMemoryKV()
+	s := newStatsSampler(kv, 1)
+	start := time.Date(2018, 6, 1, 12, 0, 0, 0, time.UTC)
+	at := func(minutes int) time.Time {
+		return start.Add(time.Duration(minutes) * time.Minute)
+	}
+	ms := func(minutes int) int64 {
+		return at(minutes).UnixNano() / int64(time.Millisecond)
+	}
+
+	//a sample of each app every 5 minutes for an hour
+	for m := 0; m < 60; m += 5 {
+		s.record([]ComponentStats{
+			{Time: at(m), Component: "app-one", Pids: uint64(m)},
+			{Time: at(m), Component: "app-two", Pids: uint64(m)},
+		})
+	}
+
+	if current := s.Current("app-two"); len(current) != 1 || current[0].Pids != 55 {
+		t.Errorf("Current(app-two) = %+v", current)
+	}
+	if current := s.Current(""); len(current) != 2 || current[0].Component != "app-one" {
+		t.Errorf("Current() = %+v", current)
+	}
+
+	pids := func(samples []ComponentStats) []uint64 {
+		res := []uint64{}
+		for _, s := range samples {
+			res = append(res, s.Pids)
+		}
+		return res
+	}
+
+	tests := []struct {
+		name  string
+		query statsQuery
+		want  []uint64
+	}{
+		{name: "latest of one", query: statsQuery{Component: "app-one", Limit: 3}, want: []uint64{45, 50, 55}},
+		{name: "latest across buckets", query: statsQuery{Component: "app-one", Limit: 4}, want: []uint64{40, 45, 50, 55}},
+		{name: "latest of all", query: statsQuery{Limit: 3}, want: []uint64{50, 55, 55}},
+		{name: "range", query: statsQuery{Component: "app-two", From: ms(10), To: ms(20)}, want: []uint64{10, 15, 20}},
+		{name: "since", query: statsQuery{Component: "app-two", From: ms(48)}, want: []uint64{50, 55}},
+		{name: "until", query: statsQuery{Component: "app-two", To: ms(5)}, want: []uint64{0, 5}},
+		{name: "range with a limit", query: statsQuery{Component: "app-one", From: ms(0), To: ms(30), Limit: 2}, want: []uint64{25, 30}},
+		{name: "no such app", query: statsQuery{Component: "app-missing"}, want: []uint64{}},
+	}
+
+	for _, tt := range tests {
+		got, err := s.Query(tt.query)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if fmt.Sprint(pids(got)) != fmt.Sprint(tt.want) {
+			t.Errorf("%s: pids = %v, want %v", tt.name, pids(got), tt.want)
+		}
+	}
+
+	//a restarted CM carries on filling the bucket it was writing
+	restarted := newStatsSampler(kv, 1)
+	restarted.record([]ComponentStats{{Time: at(58), Component: "app-one", Pids: 58}})
+	got, err := restarted.Query(statsQuery{Component: "app-one", Limit: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(pids(got)) != "[50 55 58]" {
+		t.Errorf("after a restart pids = %v, want [50 55 58]", pids(got))
+	}
+}
+
+func TestStatsRetention(t *testing.T) {
+
+	kv := newMemoryKV()
+	s := newStatsSampler(kv, 2)
+	start := time.Date(2018, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	//a sample every 10 minutes for 5 hours
+	for m := 0; m <= 300; m += 10 {
+		s.record([]ComponentStats{{Time: start.Add(time.Duration(m) * time.Minute), Component: "app-one"}})
+	}
+
+	keys, _ := kv.ListKeys(statsHistoryID)
+	oldest := time.Time{}
+	for _, key := range keys {
+		bucket, ok := statsBucketStart(key)
+		if !ok {
+			t.Fatalf("bad bucket key %q", key)
+		}
+		if oldest.IsZero() || bucket.Before(oldest) {
+			oldest = bucket
+		}
+	}
+	//the newest sample is at 17:00 so everything from 15:00 is kept
+	if want := start.Add(3 * time.Hour); !oldest.Equal(want) || len(keys) != 13 {
+		t.Errorf("%d buckets kept from %v, want 13 from %v", len(keys), oldest.UTC(), want)
+	}
+
+	got, err := s.Query(statsQuery{Limit: 1000})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 13 || !got[0].Time.Equal(start.Add(3*time.Hour)) {
+		t.Errorf("%d samples from %v kept, want 13 from 15:00", len(got), got[0].Time)
+	}
+
+	if s := newStatsSampler(kv, 0); s.retention != defaultStatsRetention*time.Hour {
+		t.Errorf("default retention = %v", s.retention)
+	}
+}
@@ -0,0 +1,306 @@
+package main
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/events"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/api/types/swarm"
+	"github.com/me-box/core-container-manager/api"
+	libDatabox "github.com/me-box/lib-go-databox"
+)
+
+// statusResyncInterval is how often the StatusCache is rebuilt from scratch in case an event was missed
+var statusResyncInterval = time.Minute
+
+// statusSettleDelay is how long after an event a service is looked at again.
+// Swarm updates the task state a little after the container event arrives.
+var statusSettleDelay = 2 * time.Second
+
+// serviceStatusResult is the state of a databox service as reported by ServiceStatus
+type serviceStatusResult struct {
+	api.ComponentStatus
+	Crash *CrashReport `json:"crash,omitempty"`
+}
+
+// StatusCache holds the state of every databox service. It is kept up to date from the
+// docker events stream so ServiceStatus does not have to ask docker on every call.
+type StatusCache struct {
+	cli         Orchestrator
+	mu          sync.Mutex
+	services    map[string]serviceStatusResult
+	subscribers []chan struct{}
+}
+
+// NewStatusCache returns a StatusCache filled from cli, call run to keep it up to date
+func NewStatusCache(cli Orchestrator) *StatusCache {
+	sc := &StatusCache{
+		cli:      cli,
+		services: make(map[string]serviceStatusResult),
+	}
+	sc.resync()
+	return sc
+}
+
+// List returns the cached state of every databox service sorted by name
+func (sc *StatusCache) List() []serviceStatusResult {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	res := []serviceStatusResult{}
+	for _, s := range sc.services {
+		res = append(res, s)
+	}
+	sort.Slice(res, func(a, b int) bool {
+		return res[a].Name < res[b].Name
+	})
+	return res
+}
+
+// Subscribe returns a channel that is sent to whenever the cache changes.
+// Changes made before the last one has been received are merged into one.
+func (sc *StatusCache) Subscribe() <-chan struct{} {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	ch := make(chan struct{}, 1)
+	sc.subscribers = append(sc.subscribers, ch)
+	return ch
+}
+
+// run keeps the cache up to date from the docker events stream, it never returns
+func (sc *StatusCache) run() {
+
+	resync := time.NewTicker(statusResyncInterval)
+	defer resync.Stop()
+
+	for {
+		ctx, cancel := context.WithCancel(context.Background())
+		eventChan, errChan := sc.cli.Events(ctx, types.EventsOptions{})
+
+		//anything could have happened while we were not listening
+		sc.resync()
+
+	listen:
+		for {
+			select {
+			case msg := <-eventChan:
+				name := serviceForEvent(msg)
+				if name == "" {
+					continue
+				}
+				sc.refresh(name)
+				time.AfterFunc(statusSettleDelay, func() {
+					sc.refresh(name)
+				})
+			case <-resync.C:
+				sc.resync()
+			case err := <-errChan:
+				if err != nil {
+					libDatabox.Warn("[StatusCache] docker events stream ended " + err.Error())
+				}
+				break listen
+			}
+		}
+
+		cancel()
+		time.Sleep(time.Second)
+	}
+}
+
+// serviceForEvent returns the name of the service msg is about, if any
+func serviceForEvent(msg events.Message) string {
+	switch msg.Type {
+	case events.ServiceEventType:
+		return msg.Actor.Attributes["name"]
+	case events.ContainerEventType:
+		return msg.Actor.Attributes["com.docker.swarm.service.name"]
+	}
+	return ""
+}
+
+// resync replaces the cache with the state of every service
+func (sc *StatusCache) resync() {
+
+	services, err := sc.cli.ServiceList(context.Background(), types.ServiceListOptions{})
+	if err != nil {
+		libDatabox.Err("[StatusCache] Can't list services " + err.Error())
+		return
+	}
+
+	fresh := make(map[string]serviceStatusResult)
+	for _, service := range services {
+		if _, ok := service.Spec.Labels["databox.type"]; !ok {
+			//its not a databox service
+			continue
+		}
+		fresh[service.Spec.Name] = sc.statusOf(service)
+	}
+
+	sc.mu.Lock()
+	changed := len(fresh) != len(sc.services)
+	for name, s := range fresh {
+		if old, ok := sc.services[name]; !ok || old != s {
+			changed = true
+		}
+	}
+	sc.services = fresh
+	sc.mu.Unlock()
+
+	if changed {
+		sc.notify()
+	}
+}
+
+// refresh updates the cached state of the service name
+func (sc *StatusCache) refresh(name string) {
+
+	serFilters := filters.NewArgs()
+	serFilters.Add("name", name)
+	services, err := sc.cli.ServiceList(context.Background(), types.ServiceListOptions{
+		Filters: serFilters,
+	})
+	if err != nil {
+		libDatabox.Err("[StatusCache] Can't get service " + name + " " + err.Error())
+		return
+	}
+
+	var status *serviceStatusResult
+	for _, service := range services {
+		if _, ok := service.Spec.Labels["databox.type"]; ok && service.Spec.Name == name {
+			s := sc.statusOf(service)
+			status = &s
+		}
+	}
+
+	sc.mu.Lock()
+	old, existed := sc.services[name]
+	changed := false
+	switch {
+	case status == nil && existed:
+		delete(sc.services, name)
+		changed = true
+	case status != nil && (!existed || old != *status):
+		sc.services[name] = *status
+		changed = true
+	}
+	sc.mu.Unlock()
+
+	if changed {
+		sc.notify()
+	}
+}
+
+// statusOf reports the state of the latest task of service
+func (sc *StatusCache) statusOf(service swarm.Service) serviceStatusResult {
+
+	lr := serviceStatusResult{ComponentStatus: api.ComponentStatus{
+		Name: service.Spec.Name,
+		Type: service.Spec.Labels["databox.type"],
+	}}
+
+	taskFilters := filters.NewArgs()
+	taskFilters.Add("service", service.Spec.Name)
+	tasks, _ := sc.cli.TaskList(context.Background(), types.TaskListOptions{
+		Filters: taskFilters,
+	})
+	if len(tasks) > 0 {
+		latestTasks := tasks[0]
+		latestTime := latestTasks.UpdatedAt
+
+		for _, t := range tasks {
+			if t.UpdatedAt.After(latestTime) {
+				latestTasks = t
+				latestTime = latestTasks.UpdatedAt
+			}
+		}
+
+		lr.DesiredState = latestTasks.DesiredState
+		lr.State = latestTasks.Status.State
+		lr.Status = latestTasks.Status.State
+	}
+
+	return lr
+}
+
+func (sc *StatusCache) notify() {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	for _, ch := range sc.subscribers {
+		select {
+		case ch <- struct{}{}:
+		default:
+			//there is already a change waiting to be read
+		}
+	}
+}
+
+// serviceStatus lists the state of every databox service from the status cache
+func (cm *ContainerManager) serviceStatus() []serviceStatusResult {
+
+	var res []serviceStatusResult
+	if cm.Status != nil {
+		res = cm.Status.List()
+	} else {
+		//not started yet, ask docker
+		res = NewStatusCache(cm.cli).List()
+	}
+
+	listed := map[string]bool{}
+	for i := range res {
+		listed[res[i].Name] = true
+		if _, stopped := cm.Stopped.Stopped(res[i].Name); stopped {
+			res[i].Status = stoppedStatus
+		} else if report, quarantined := cm.Crashes.Quarantined(res[i].Name); quarantined {
+			res[i].Status = quarantinedStatus
+			res[i].Crash = &report
+		}
+	}
+
+	//components stopped before the CM last restarted have no service
+	for _, name := range cm.Stopped.Names() {
+		if listed[name] {
+			continue
+		}
+		rec, _ := cm.Stopped.Stopped(name)
+		res = append(res, serviceStatusResult{ComponentStatus: api.ComponentStatus{
+			Name:         name,
+			Type:         rec.Type,
+			DesiredState: swarm.TaskStateShutdown,
+			State:        swarm.TaskStateShutdown,
+			Status:       stoppedStatus,
+		}})
+		listed[name] = true
+	}
+
+	//and so do components quarantined before it restarted
+	quarantined := cm.Crashes.QuarantinedNames()
+	names := make([]string, 0, len(quarantined))
+	for name := range quarantined {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if listed[name] {
+			continue
+		}
+		report, _ := cm.Crashes.Quarantined(name)
+		res = append(res, serviceStatusResult{
+			ComponentStatus: api.ComponentStatus{
+				Name:         name,
+				Type:         quarantined[name],
+				DesiredState: swarm.TaskStateShutdown,
+				State:        swarm.TaskStateShutdown,
+				Status:       quarantinedStatus,
+			},
+			Crash: &report,
+		})
+	}
+
+	return res
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/swarm"
+	libDatabox "github.com/me-box/lib-go-databox"
+)
+
+// cached returns the status of name in sc and if it is there
+func cached(sc *StatusCache, name string) (serviceStatusResult, bool) {
+	for _, s := range sc.List() {
+		if s.Name == name {
+			return s, true
+		}
+	}
+	return serviceStatusResult{}, false
+}
+
+// changed reports if ch has been sent a change
+func changed(ch <-chan struct{}) bool {
+	select {
+	case <-ch:
+		return true
+	default:
+		return false
+	}
+}
+
+func TestStatusCacheEvents(t *testing.T) {
+
+	defer func(settle time.Duration, resync time.Duration) {
+		statusSettleDelay, statusResyncInterval = settle, resync
+	}(statusSettleDelay, statusResyncInterval)
+	statusSettleDelay = 10 * time.Millisecond
+	//only events can update the cache
+	statusResyncInterval = time.Hour
+
+	td := newTestDatabox(t)
+	sc := NewStatusCache(td.cli)
+	changes := sc.Subscribe()
+	go sc.run()
+	waitFor(t, "the cache to listen for events", func() bool {
+		td.cli.mu.Lock()
+		defer td.cli.mu.Unlock()
+		return len(td.cli.subscribers) > 0
+	})
+
+	err := td.cm.LaunchFromSLA(td.testSLA("app-status", libDatabox.DataboxTypeApp), true, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "app-status to be running", func() bool {
+		s, ok := cached(sc, "app-status")
+		return ok && s.State == swarm.TaskStateRunning && s.Type == "app"
+	})
+	if !changed(changes) {
+		t.Error("subscribers were not told app-status started")
+	}
+
+	//services that are not databox components are left out
+	td.cli.AddImage("portainer/portainer:latest")
+	_, err = td.cli.ServiceCreate(context.Background(), swarm.ServiceSpec{
+		Annotations:  swarm.Annotations{Name: "portainer"},
+		TaskTemplate: swarm.TaskSpec{ContainerSpec: &swarm.ContainerSpec{Image: "portainer/portainer:latest"}},
+	}, types.ServiceCreateOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = td.cm.StopService("app-status", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "app-status to be shut down", func() bool {
+		s, ok := cached(sc, "app-status")
+		return ok && s.DesiredState == swarm.TaskStateShutdown
+	})
+
+	service := td.service("app-status")
+	err = td.cli.ServiceRemove(context.Background(), service.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "app-status to be dropped", func() bool {
+		_, ok := cached(sc, "app-status")
+		return !ok
+	})
+	if _, ok := cached(sc, "portainer"); ok {
+		t.Error("portainer is in the cache")
+	}
+}
+
+func TestStatusCacheResync(t *testing.T) {
+
+	td := newTestDatabox(t)
+	sc := NewStatusCache(td.cli)
+	changes := sc.Subscribe()
+
+	//without run nothing is heard of the install until the resync
+	err := td.cm.LaunchFromSLA(td.testSLA("app-missed", libDatabox.DataboxTypeApp), true, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := cached(sc, "app-missed"); ok {
+		t.Fatal("app-missed is cached without an event")
+	}
+
+	sc.resync()
+	if s, ok := cached(sc, "app-missed"); !ok || s.State != swarm.TaskStateRunning {
+		t.Errorf("after the resync app-missed = %+v, %v", s, ok)
+	}
+	if !changed(changes) {
+		t.Error("subscribers were not told of the resync")
+	}
+
+	sc.resync()
+	if changed(changes) {
+		t.Error("subscribers were told of a resync that changed nothing")
+	}
+
+	err = td.cli.ServiceRemove(context.Background(), td.service("app-missed").ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sc.resync()
+	if _, ok := cached(sc, "app-missed"); ok || !changed(changes) {
+		t.Errorf("app-missed is still cached %v or the removal was not sent", ok)
+	}
+}
+
+func TestServiceStatusOverlay(t *testing.T) {
+
+	td := newTestDatabox(t)
+	td.cm.Stopped = NewStopTracker(td.cm.Store)
+	td.cm.Crashes = NewCrashTracker(td.cm.Store)
+	for _, name := range []string{"app-running", "app-stopped", "app-quarantined"} {
+		err := td.cm.LaunchFromSLA(td.testSLA(name, libDatabox.DataboxTypeApp), true, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err := td.cm.StopService("app-stopped", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = td.cm.Crashes.quarantine("app-quarantined", "app", CrashReport{Crashes: 6})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	//stopped and quarantined before the CM restarted so there is no service
+	err = td.cm.Stopped.stop("app-gone", stoppedRecord{Type: "app"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = td.cm.Crashes.quarantine("driver-gone", "driver", CrashReport{Crashes: 7})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	td.cm.Status = NewStatusCache(td.cli)
+
+	tests := []struct {
+		name        string
+		wantType    string
+		wantStatus  swarm.TaskState
+		wantCrashes int
+	}{
+		{name: "app-quarantined", wantType: "app", wantStatus: quarantinedStatus, wantCrashes: 6},
+		{name: "app-running", wantType: "app", wantStatus: swarm.TaskStateRunning},
+		{name: "app-stopped", wantType: "app", wantStatus: stoppedStatus},
+		{name: "app-gone", wantType: "app", wantStatus: stoppedStatus},
+		{name: "driver-gone", wantType: "driver", wantStatus: quarantinedStatus, wantCrashes: 7},
+	}
+
+	got := td.cm.serviceStatus()
+	if len(got) != len(tests) {
+		t.Fatalf("serviceStatus() = %+v", got)
+	}
+	for i, tt := range tests {
+		s := got[i]
+		crashes := 0
+		if s.Crash != nil {
+			crashes = s.Crash.Crashes
+		}
+		if s.Name != tt.name || s.Type != tt.wantType || s.Status != tt.wantStatus || crashes != tt.wantCrashes {
+			t.Errorf("%d: %s %s %s crashes %d, want %s %s %s crashes %d", i,
+				s.Name, s.Type, s.Status, crashes, tt.name, tt.wantType, tt.wantStatus, tt.wantCrashes)
+		}
+	}
+
+	//the overlay is not written back into the cache
+	if s, _ := cached(td.cm.Status, "app-quarantined"); s.Status != swarm.TaskStateRunning || s.Crash != nil {
+		t.Errorf("cached app-quarantined = %+v", s)
+	}
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/docker/docker/api/types/swarm"
+	libDatabox "github.com/me-box/lib-go-databox"
+)
+
+// stoppedStatus is reported by ServiceStatus for components a user has stopped
+const stoppedStatus swarm.TaskState = "stopped"
+
+// stoppedRecord is saved in the CM store when a component is stopped
+type stoppedRecord struct {
+	Time time.Time `json:"time"`
+	Type string    `json:"type"`
+	//the IP core-network knows the component by, needed when it is started again
+	OldIP string `json:"oldIP"`
+}
+
+// StopTracker knows which components have been stopped. It mirrors the stoppedStore
+// in the CM store so crashDetectore can check it on every container event.
+type StopTracker struct {
+	store   *CMStore
+	mu      sync.Mutex
+	stopped map[string]stoppedRecord
+}
+
+// NewStopTracker loads the stopped components saved in store
+func NewStopTracker(store *CMStore) *StopTracker {
+	st := &StopTracker{
+		store:   store,
+		stopped: map[string]stoppedRecord{},
+	}
+	if store != nil {
+		stopped, err := store.GetAllStopped()
+		if err != nil {
+			libDatabox.Err("Can't load stopped components " + err.Error())
+		}
+		st.stopped = stopped
+	}
+	return st
+}
+
+// Stopped returns the stoppedRecord of name if it is stopped. It is safe to call on a nil StopTracker.
+func (st *StopTracker) Stopped(name string) (stoppedRecord, bool) {
+	if st == nil {
+		return stoppedRecord{}, false
+	}
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	rec, ok := st.stopped[name]
+	return rec, ok
+}
+
+// Names lists the stopped components
+func (st *StopTracker) Names() []string {
+	names := []string{}
+	if st == nil {
+		return names
+	}
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	for name := range st.stopped {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (st *StopTracker) stop(name string, rec stoppedRecord) error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if st.store != nil {
+		err := st.store.SaveStopped(name, rec)
+		if err != nil {
+			return err
+		}
+	}
+	st.stopped[name] = rec
+	return nil
+}
+
+func (st *StopTracker) start(name string) error {
+	if st == nil {
+		return nil
+	}
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	delete(st.stopped, name)
+	if st.store != nil {
+		return st.store.DeleteStopped(name)
+	}
+	return nil
+}
+
+// knownComponent reports if name is running, has been stopped or is quarantined
+func (cm ContainerManager) knownComponent(name string) bool {
+	if _, stopped := cm.Stopped.Stopped(name); stopped {
+		return true
+	}
+	if _, quarantined := cm.Crashes.Quarantined(name); quarantined {
+		return true
+	}
+	_, err := cm.serviceByName(name)
+	return err == nil
+}
+
+// StopService scales an installed app or driver to zero replicas. Its SLA, secrets,
+// store, network and arbiter registration are kept so StartService can bring it back.
+// It stays stopped across CM restarts. Progress is reported through job which may be nil.
+func (cm ContainerManager) StopService(name string, job *Job) error {
+
+	err := cm.stopService(name)
+	if err != nil {
+		job.Fail(err)
+		cm.Journal.RecordErr(EventStopFailed, name, err, job)
+		return err
+	}
+
+	job.SetState(JobStateStopped)
+	cm.Journal.Record(EventStopped, name, "", job)
+	return nil
+}
+
+func (cm ContainerManager) stopService(name string) error {
+
+	sla, err := cm.Store.GetSLA(name)
+	if err != nil || sla.Name != name {
+		return errors.New(name + " is not an installed app or driver")
+	}
+	if _, stopped := cm.Stopped.Stopped(name); stopped {
+		return errors.New(name + " is already stopped")
+	}
+
+	rec := stoppedRecord{
+		Time: time.Now(),
+		Type: string(sla.DataboxType),
+	}
+	if report, quarantined := cm.Crashes.Quarantined(name); quarantined {
+		rec.OldIP = report.oldIP
+	} else if cont, err := cm.runningContainerFor(name); err == nil {
+		rec.OldIP = cm.ipOnServiceNetwork(cont, name)
+	}
+
+	libDatabox.Info("Stopping " + name)
+
+	//record it first so the containers stopping are not seen as crashes
+	err = cm.Stopped.stop(name, rec)
+	if err != nil {
+		return errors.New("Can't save stopped state of " + name + " " + err.Error())
+	}
+	cm.Crashes.Reset(name)
+
+	if _, err := cm.serviceByName(name); err != nil {
+		//quarantined before the CM last restarted so it has no service to scale
+		return nil
+	}
+
+	err = cm.scaleService(name, 0)
+	if err != nil {
+		cm.Stopped.start(name)
+		return errors.New("Can't stop " + name + " " + err.Error())
+	}
+
+	return nil
+}
+
+// StartService starts a component stopped by StopService
+func (cm ContainerManager) StartService(name string, job *Job) error {
+
+	rec, stopped := cm.Stopped.Stopped(name)
+	if !stopped {
+		err := errors.New(name + " is not stopped")
+		job.Fail(err)
+		return err
+	}
+
+	libDatabox.Info("Starting " + name)
+	err := cm.Stopped.start(name)
+	if err != nil {
+		err = errors.New("Can't save stopped state of " + name + " " + err.Error())
+		job.Fail(err)
+		return err
+	}
+
+	if _, err := cm.serviceByName(name); err != nil {
+		//stopped before the CM last restarted so reloadApps never launched it
+		sla, err := cm.Store.GetSLA(name)
+		if err != nil {
+			cm.Stopped.stop(name, rec)
+			job.Fail(err)
+			return err
+		}
+		return cm.LaunchFromSLA(sla, false, job)
+	}
+
+	job.SetState(JobStateStarting)
+	err = cm.scaleUp(name, rec.OldIP)
+	if err != nil {
+		job.Fail(err)
+		cm.Journal.RecordErr(EventStartFailed, name, err, job)
+		return err
+	}
+
+	job.SetState(JobStateRunning)
+	cm.Journal.Record(EventStarted, name, "after stop", job)
+	return nil
+}
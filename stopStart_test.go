@@ -0,0 +1,156 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	libDatabox "github.com/me-box/lib-go-databox"
+)
+
+func TestStopService(t *testing.T) {
+
+	tests := []struct {
+		name    string
+		stop    string
+		before  func(td *testDatabox)
+		wantErr string
+	}{
+		{name: "app", stop: "app-stoppable"},
+		{name: "driver keeps its store", stop: "driver-stoppable"},
+		{name: "not installed", stop: "app-unknown", wantErr: "is not an installed app or driver"},
+		{name: "store", stop: "driver-stoppable-core-store", wantErr: "is not an installed app or driver"},
+		{
+			name: "already stopped",
+			stop: "app-stoppable",
+			before: func(td *testDatabox) {
+				td.cm.StopService("app-stoppable", nil)
+			},
+			wantErr: "is already stopped",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			td := newTestDatabox(t)
+			td.cm.Stopped = NewStopTracker(td.cm.Store)
+			for _, sla := range []libDatabox.SLA{
+				td.testSLA("app-stoppable", libDatabox.DataboxTypeApp),
+				td.testSLA("driver-stoppable", libDatabox.DataboxTypeDriver),
+			} {
+				err := td.cm.LaunchFromSLA(sla, true, nil)
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+			if tt.before != nil {
+				tt.before(td)
+			}
+			job := td.cm.Jobs.NewJob(JobTypeStop, tt.stop)
+
+			err := td.cm.StopService(tt.stop, job)
+
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("error = %v, want %q", err, tt.wantErr)
+				}
+				if got, _ := td.cm.Jobs.Get(job.ID); got.State != JobStateFailed {
+					t.Errorf("job state = %s, want %s", got.State, JobStateFailed)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got, _ := td.cm.Jobs.Get(job.ID); got.State != JobStateStopped {
+				t.Errorf("job state = %s, want %s", got.State, JobStateStopped)
+			}
+			if td.replicas(t, tt.stop) != 0 || len(td.containers(tt.stop)) != 0 {
+				t.Errorf("%s is still running", tt.stop)
+			}
+			if td.service(tt.stop+"-core-store") != nil && len(td.containers(tt.stop+"-core-store")) != 1 {
+				t.Errorf("the store of %s was stopped", tt.stop)
+			}
+			if !td.arbiter.registered(tt.stop) || !td.secretExists(strings.ToUpper(tt.stop)+"_KEY") {
+				t.Errorf("%s lost its arbiter registration or secrets", tt.stop)
+			}
+			if _, stopped := NewStopTracker(td.cm.Store).Stopped(tt.stop); !stopped {
+				t.Error("the stop was not saved")
+			}
+		})
+	}
+}
+
+func TestStartService(t *testing.T) {
+
+	tests := []struct {
+		name      string
+		cmRestart bool
+		stop      bool
+		wantErr   string
+	}{
+		{name: "stopped", stop: true},
+		{name: "stopped before the CM restarted", stop: true, cmRestart: true},
+		{name: "not stopped", wantErr: "is not stopped"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			td := newTestDatabox(t)
+			td.cm.Stopped = NewStopTracker(td.cm.Store)
+			err := td.cm.LaunchFromSLA(td.testSLA("app-startable", libDatabox.DataboxTypeApp), true, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.stop {
+				err = td.cm.StopService("app-startable", nil)
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+			if tt.cmRestart {
+				//reloadApps skips stopped components so there is no service after a restart
+				err = td.cm.uninstall("app-startable")
+				if err != nil {
+					t.Fatal(err)
+				}
+				err = td.cm.Store.SaveSLA(td.testSLA("app-startable", libDatabox.DataboxTypeApp))
+				if err != nil {
+					t.Fatal(err)
+				}
+				err = td.cm.Stopped.stop("app-startable", stoppedRecord{Type: "app"})
+				if err != nil {
+					t.Fatal(err)
+				}
+				td.cm.Stopped = NewStopTracker(td.cm.Store)
+			}
+			restarts := len(td.network.calls("/restart"))
+			job := td.cm.Jobs.NewJob(JobTypeStart, "app-startable")
+
+			err = td.cm.StartService("app-startable", job)
+
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got, _ := td.cm.Jobs.Get(job.ID); got.State != JobStateRunning {
+				t.Errorf("job state = %s, want %s", got.State, JobStateRunning)
+			}
+			if len(td.containers("app-startable")) != 1 {
+				t.Error("app-startable is not running")
+			}
+			if !tt.cmRestart && len(td.network.calls("/restart")) != restarts+1 {
+				t.Error("core-network was not told about the new container of app-startable")
+			}
+			if _, stopped := NewStopTracker(td.cm.Store).Stopped("app-startable"); stopped {
+				t.Error("the saved stop was not removed")
+			}
+		})
+	}
+}
@@ -0,0 +1,432 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/api/types/swarm"
+	libDatabox "github.com/me-box/lib-go-databox"
+)
+
+// upgradeTimeout is how long an upgraded task has to start running
+var upgradeTimeout = 60 * time.Second
+
+// upgradeSettleTime is how long an upgraded task must stay running to count as healthy
+var upgradeSettleTime = 10 * time.Second
+
+// updateGracePeriod is how long after an update ends the die events of its old tasks
+// are still not treated as crashes, docker events can arrive late
+var updateGracePeriod = 10 * time.Second
+
+// UpdateTracker knows which services are having their tasks replaced by an upgrade,
+// a rollback or a certificate renewal so crashDetectore does not restart them.
+type UpdateTracker struct {
+	mu       sync.Mutex
+	updating map[string]int
+	ended    map[string]time.Time
+}
+
+// NewUpdateTracker returns an empty UpdateTracker
+func NewUpdateTracker() *UpdateTracker {
+	return &UpdateTracker{
+		updating: map[string]int{},
+		ended:    map[string]time.Time{},
+	}
+}
+
+// Begin records that the tasks of name are about to be replaced. It is safe to call on a nil UpdateTracker.
+func (ut *UpdateTracker) Begin(name string) {
+	if ut == nil {
+		return
+	}
+	ut.mu.Lock()
+	defer ut.mu.Unlock()
+	ut.updating[name]++
+}
+
+// End records that the update of name begun with Begin has finished
+func (ut *UpdateTracker) End(name string) {
+	if ut == nil {
+		return
+	}
+	ut.mu.Lock()
+	defer ut.mu.Unlock()
+	ut.updating[name]--
+	if ut.updating[name] <= 0 {
+		delete(ut.updating, name)
+	}
+	ut.ended[name] = time.Now()
+}
+
+// Updating reports if name is being updated or was in the last updateGracePeriod
+func (ut *UpdateTracker) Updating(name string) bool {
+	if ut == nil {
+		return false
+	}
+	ut.mu.Lock()
+	defer ut.mu.Unlock()
+	if ut.updating[name] > 0 {
+		return true
+	}
+	if ended, ok := ut.ended[name]; ok {
+		if time.Since(ended) < updateGracePeriod {
+			return true
+		}
+		delete(ut.ended, name)
+	}
+	return false
+}
+
+// Upgrade moves an installed app or driver to the image, configuration and resource
+// limits in sla and limits. The swarm service is updated in place so its secrets, arbiter
+// token, store volume and network are kept. If the new task does not become healthy the
+// service is rolled back to its previous spec and anything the upgrade added is removed.
+// The saved SLA and limits are only replaced once the upgrade has worked.
+// Progress is reported through job which may be nil if the upgrade is not being tracked.
+func (cm ContainerManager) Upgrade(sla libDatabox.SLA, limits ResourceLimits, job *Job) error {
+
+	err := cm.upgrade(sla, limits, job)
+	if err != nil {
+		job.Fail(err)
+		cm.Journal.RecordErr(EventUpgradeFailed, sla.Name, err, job)
+		return err
+	}
+
+	if _, stopped := cm.Stopped.Stopped(sla.Name); stopped {
+		job.SetState(JobStateStopped)
+	} else {
+		job.SetState(JobStateRunning)
+	}
+	cm.Journal.Record(EventUpgraded, sla.Name, cm.calculateImageNameFromSLA(sla), job)
+	return nil
+}
+
+func (cm ContainerManager) upgrade(sla libDatabox.SLA, limits ResourceLimits, job *Job) (err error) {
+
+	name := sla.Name
+
+	validation := cm.ValidateSLA(sla)
+	validateResources(limits, &validation)
+	if !validation.Valid {
+		return errors.New("Can't upgrade " + name + ". " + validation.Err().Error())
+	}
+
+	oldSLA, err := cm.Store.GetSLA(name)
+	if err != nil || oldSLA.Name != name {
+		return errors.New("Can't upgrade " + name + " it is not installed")
+	}
+	if oldSLA.DataboxType != sla.DataboxType {
+		return errors.New("Can't upgrade " + name + " from " + string(oldSLA.DataboxType) + " to " + string(sla.DataboxType))
+	}
+	if oldSLA.ResourceRequirements.Store != "" && oldSLA.ResourceRequirements.Store != sla.ResourceRequirements.Store {
+		return errors.New("Can't upgrade " + name + " changing its store from " + oldSLA.ResourceRequirements.Store + " would lose its data")
+	}
+	resources, err := cm.withTypeDefaults(sla, limits).toSwarm()
+	if err != nil {
+		return errors.New("Can't upgrade " + name + ". Invalid resource requirements " + err.Error())
+	}
+
+	service, err := cm.serviceByName(name)
+	if err != nil {
+		return errors.New("Can't upgrade " + name + " " + err.Error())
+	}
+
+	libDatabox.Info("Upgrading " + name)
+
+	tx := newUpgradeTransaction(name)
+	defer func() {
+		if err != nil {
+			tx.rollback()
+		}
+	}()
+
+	job.SetState(JobStatePulling)
+	imageName := cm.calculateImageNameFromSLA(sla)
+	pullImageIfRequired(cm.cli, imageName, cm.Options.DefaultRegistry, cm.Options.DefaultRegistryHost)
+	if !cm.imageExists(imageName) {
+		return errors.New("Can't upgrade " + name + " cant find the image " + imageName)
+	}
+
+	//build the new spec on the existing network keeping the existing secrets
+	netConf := NetworkConfig{NetworkName: name + "-network"}
+	if dns := service.Spec.TaskTemplate.ContainerSpec.DNSConfig; dns != nil && len(dns.Nameservers) > 0 {
+		netConf.DNS = dns.Nameservers[0]
+	}
+	newSpec, _, requiredNetworks, err := cm.serviceSpecFromSLA(sla, netConf)
+	if err != nil {
+		return err
+	}
+	newSpec.TaskTemplate.ContainerSpec.Secrets = service.Spec.TaskTemplate.ContainerSpec.Secrets
+	//a stopped or quarantined component has no replicas and stays that way
+	newSpec.Mode = service.Spec.Mode
+	if !cm.isSystemComponent(name) {
+		newSpec.TaskTemplate.Resources = resources
+		var release func()
+		release, err = cm.checkResourceBudget(name, resources)
+		if err != nil {
+			return err
+		}
+		defer release()
+	}
+	//make sure swarm replaces the task even if only the config has changed
+	newSpec.TaskTemplate.ForceUpdate = service.Spec.TaskTemplate.ForceUpdate + 1
+
+	_, _, oldNetworks, err := cm.serviceSpecFromSLA(oldSLA, netConf)
+	if err != nil {
+		return err
+	}
+
+	job.SetState(JobStateNetworking)
+	libDatabox.Debug("networksToConnect" + strings.Join(requiredNetworks, ","))
+	if addedNetworks := addedPeers(oldNetworks, requiredNetworks); len(addedNetworks) > 0 {
+		//core-network can only drop all of a service's endpoints so the old ones are connected again
+		tx.onRollback("disconnect core-network endpoints "+strings.Join(addedNetworks, ","), func() error {
+			err := cm.CoreNetworkClient.DisconnectEndpoints(name, PostNetworkConfig{NetworkName: netConf.NetworkName})
+			if err != nil {
+				return err
+			}
+			return cm.CoreNetworkClient.ConnectEndpoints(name, oldNetworks)
+		})
+	}
+	err = cm.CoreNetworkClient.ConnectEndpoints(name, requiredNetworks)
+	if err != nil {
+		return errors.New("Can't upgrade " + name + " failed to connect its network endpoints. " + err.Error())
+	}
+
+	//the dependent store is kept as is, but the new version may need one for the first time
+	if sla.ResourceRequirements.Store != "" && oldSLA.ResourceRequirements.Store == "" {
+		job.SetState(JobStateStore)
+		requiredStoreName := sla.Name + "-" + sla.ResourceRequirements.Store
+		_, serr := cm.serviceByName(requiredStoreName)
+		storeExisted := serr == nil
+		volumeExisted := cm.volumeExists(requiredStoreName)
+
+		cm.launchStore(sla.ResourceRequirements.Store, requiredStoreName, netConf)
+		if !storeExisted {
+			tx.onRollback("remove store "+requiredStoreName, func() error {
+				return cm.removeStore(requiredStoreName, !volumeExisted)
+			})
+		}
+		cm.grantStoreCatalogue(tx, requiredStoreName)
+
+		_, err = cm.WaitForService(requiredStoreName, 10)
+		if err != nil {
+			return errors.New("Can't upgrade " + name + " its store did not start. " + err.Error())
+		}
+	}
+
+	//grant the new permissions now, the ones the new version no longer needs
+	//are only revoked once we know we will not roll back
+	job.SetState(JobStatePermissions)
+	added, removed := diffPermissions(permissionsFromSLA(oldSLA), permissionsFromSLA(sla))
+	cm.addPermissionsFromSLA(sla)
+	tx.onRollback("revoke added permissions", func() error {
+		cm.revokePermissions(added)
+		return nil
+	})
+
+	oldIP := ""
+	oldTasks := map[string]bool{}
+	if oldCont, err := cm.runningContainerFor(name); err == nil {
+		oldIP = cm.ipOnServiceNetwork(oldCont, name)
+	}
+	if tasks, err := cm.tasksFor(name); err == nil {
+		for _, t := range tasks {
+			oldTasks[t.ID] = true
+		}
+	}
+
+	job.SetState(JobStateStarting)
+	//the old task stopping is not a crash
+	cm.Updating.Begin(name)
+	defer cm.Updating.End(name)
+	_, err = cm.cli.ServiceUpdate(context.Background(), service.ID, service.Version, newSpec, types.ServiceUpdateOptions{})
+	if err != nil {
+		return errors.New("Can't upgrade " + name + " " + err.Error())
+	}
+
+	_, stopped := cm.Stopped.Stopped(name)
+	_, quarantined := cm.Crashes.Quarantined(name)
+	if stopped || quarantined {
+		//there is no task to wait for, the new version runs when it is started or released
+		libDatabox.Info(name + " is not running, it will start as the upgraded version")
+	} else {
+		newCont, err := cm.waitForNewTask(name, oldTasks)
+		if err != nil {
+			libDatabox.Warn("Upgrade of " + name + " failed rolling back. " + err.Error())
+			rollbackErr := cm.rollbackService(name, service.Spec, oldIP)
+			if rollbackErr != nil {
+				return errors.New("Upgrade of " + name + " failed (" + err.Error() + ") and could not be rolled back " + rollbackErr.Error())
+			}
+			return errors.New("Upgrade of " + name + " failed and was rolled back. " + err.Error())
+		}
+
+		err = cm.CoreNetworkClient.ServiceRestart(name, oldIP, cm.ipOnServiceNetwork(newCont, name))
+		libDatabox.ChkErr(err)
+	}
+
+	//the new version is running so nothing is rolled back from here
+	tx.commit()
+	cm.revokePermissions(removed)
+
+	err = cm.Store.SaveResources(name, limits)
+	if err != nil {
+		return errors.New("Upgraded " + name + " but failed to save its resource limits " + err.Error())
+	}
+	err = cm.Store.SaveSLA(sla)
+	if err != nil {
+		return errors.New("Upgraded " + name + " but failed to save its SLA " + err.Error())
+	}
+
+	libDatabox.Info("Successfully upgraded " + name + " to " + imageName)
+
+	return nil
+}
+
+// rollbackService puts the service name back to spec and waits for it to start
+func (cm ContainerManager) rollbackService(name string, spec swarm.ServiceSpec, oldIP string) error {
+
+	service, err := cm.serviceByName(name)
+	if err != nil {
+		return err
+	}
+
+	oldTasks := map[string]bool{}
+	if tasks, err := cm.tasksFor(name); err == nil {
+		for _, t := range tasks {
+			oldTasks[t.ID] = true
+		}
+	}
+
+	spec.TaskTemplate.ForceUpdate = service.Spec.TaskTemplate.ForceUpdate + 1
+	cm.Updating.Begin(name)
+	defer cm.Updating.End(name)
+	_, err = cm.cli.ServiceUpdate(context.Background(), service.ID, service.Version, spec, types.ServiceUpdateOptions{})
+	if err != nil {
+		return err
+	}
+
+	cont, err := cm.waitForNewTask(name, oldTasks)
+	if err != nil {
+		return err
+	}
+
+	return cm.CoreNetworkClient.ServiceRestart(name, oldIP, cm.ipOnServiceNetwork(cont, name))
+}
+
+// waitForNewTask waits for a task of service name that is not in oldTasks to start
+// and stay running for upgradeSettleTime. It fails as soon as a new task fails.
+func (cm ContainerManager) waitForNewTask(name string, oldTasks map[string]bool) (types.Container, error) {
+
+	deadline := time.Now().Add(upgradeTimeout)
+	var runningSince time.Time
+	runningTask := ""
+
+	for time.Now().Before(deadline) {
+		tasks, err := cm.tasksFor(name)
+		if err != nil {
+			return types.Container{}, err
+		}
+
+		running := ""
+		for _, t := range tasks {
+			if oldTasks[t.ID] {
+				continue
+			}
+			switch t.Status.State {
+			case swarm.TaskStateFailed, swarm.TaskStateRejected:
+				return types.Container{}, errors.New("task " + t.ID + " " + string(t.Status.State) + " " + t.Status.Err)
+			case swarm.TaskStateRunning:
+				running = t.ID
+			}
+		}
+
+		if running == "" || running != runningTask {
+			runningTask = running
+			runningSince = time.Now()
+		} else if time.Since(runningSince) >= upgradeSettleTime {
+			contFilters := filters.NewArgs()
+			contFilters.Add("label", "com.docker.swarm.task.id="+runningTask)
+			contList, err := cm.cli.ContainerList(context.Background(), types.ContainerListOptions{Filters: contFilters})
+			if err == nil && len(contList) > 0 {
+				return contList[0], nil
+			}
+		}
+
+		time.Sleep(time.Second)
+	}
+
+	return types.Container{}, errors.New("no healthy task for " + name + " after " + upgradeTimeout.String())
+}
+
+// tasksFor lists the swarm tasks of service name
+func (cm ContainerManager) tasksFor(name string) ([]swarm.Task, error) {
+	taskFilters := filters.NewArgs()
+	taskFilters.Add("service", name)
+	return cm.cli.TaskList(context.Background(), types.TaskListOptions{
+		Filters: taskFilters,
+	})
+}
+
+// runningContainerFor returns the running container of service name
+func (cm ContainerManager) runningContainerFor(name string) (types.Container, error) {
+	contFilters := filters.NewArgs()
+	contFilters.Add("label", "com.docker.swarm.service.name="+name)
+	contList, err := cm.cli.ContainerList(context.Background(), types.ContainerListOptions{
+		Filters: contFilters,
+	})
+	if err != nil {
+		return types.Container{}, err
+	}
+	if len(contList) < 1 {
+		return types.Container{}, errors.New("Service " + name + " not running")
+	}
+	return contList[0], nil
+}
+
+// diffPermissions returns the permissions in newPerms but not oldPerms (added)
+// and the ones in oldPerms but not newPerms (removed)
+func diffPermissions(oldPerms []libDatabox.ContainerPermissions, newPerms []libDatabox.ContainerPermissions) (added []libDatabox.ContainerPermissions, removed []libDatabox.ContainerPermissions) {
+
+	inOld := map[libDatabox.ContainerPermissions]bool{}
+	for _, p := range oldPerms {
+		inOld[p] = true
+	}
+	inNew := map[libDatabox.ContainerPermissions]bool{}
+	for _, p := range newPerms {
+		inNew[p] = true
+	}
+
+	for _, p := range newPerms {
+		if !inOld[p] {
+			added = append(added, p)
+		}
+	}
+	for _, p := range oldPerms {
+		if !inNew[p] {
+			removed = append(removed, p)
+		}
+	}
+
+	return added, removed
+}
+
+// addedPeers returns the core-network peers in newPeers that are not in oldPeers
+func addedPeers(oldPeers []string, newPeers []string) []string {
+	inOld := map[string]bool{}
+	for _, p := range oldPeers {
+		inOld[p] = true
+	}
+	added := []string{}
+	for _, p := range newPeers {
+		if !inOld[p] {
+			added = append(added, p)
+		}
+	}
+	return added
+}
@@ -0,0 +1,247 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	libDatabox "github.com/me-box/lib-go-databox"
+)
+
+const upgradeVersion = "0.6.0"
+
+func TestUpgrade(t *testing.T) {
+
+	defer func(settle time.Duration) { upgradeSettleTime = settle }(upgradeSettleTime)
+	upgradeSettleTime = time.Second
+
+	tests := []struct {
+		name    string
+		stopped bool
+	}{
+		{name: "running"},
+		{name: "stopped", stopped: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			td := newTestDatabox(t)
+			sla := td.testSLA("driver-upgrade", libDatabox.DataboxTypeDriver)
+			err := td.cm.LaunchFromSLA(sla, true, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.stopped {
+				err = td.cm.StopService("driver-upgrade", nil)
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			newImage := "databoxsystems/driver-upgrade:" + upgradeVersion
+			td.cli.AddImage(newImage)
+			newSLA := sla
+			newSLA.DockerImageTag = upgradeVersion
+
+			done := make(chan error, 1)
+			go func() {
+				done <- td.cm.Upgrade(newSLA, ResourceLimits{}, nil)
+			}()
+
+			if !tt.stopped {
+				waitFor(t, "the new version to start", func() bool {
+					conts := td.containers("driver-upgrade")
+					return len(conts) == 1 && conts[0].Image == newImage
+				})
+				if saved, _ := td.cm.Store.GetSLA("driver-upgrade"); saved.DockerImageTag != "" {
+					t.Error("the saved SLA was replaced before the new version was healthy")
+				}
+			}
+
+			err = <-done
+			if err != nil {
+				t.Fatal(err)
+			}
+			saved, err := td.cm.Store.GetSLA("driver-upgrade")
+			if err != nil || saved.DockerImageTag != upgradeVersion {
+				t.Errorf("saved SLA tag = %q, %v want %q", saved.DockerImageTag, err, upgradeVersion)
+			}
+			if image := td.service("driver-upgrade").Spec.TaskTemplate.ContainerSpec.Image; image != newImage {
+				t.Errorf("service image = %s, want %s", image, newImage)
+			}
+			if !tt.stopped {
+				return
+			}
+
+			if replicas := td.replicas(t, "driver-upgrade"); replicas != 0 {
+				t.Errorf("the upgrade scaled the stopped service to %d", replicas)
+			}
+			if conts := td.containers("driver-upgrade"); len(conts) != 0 {
+				t.Errorf("%d containers are running after upgrading a stopped component", len(conts))
+			}
+
+			err = td.cm.StartService("driver-upgrade", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			waitFor(t, "the new version to start", func() bool {
+				conts := td.containers("driver-upgrade")
+				return len(conts) == 1 && conts[0].Image == newImage
+			})
+		})
+	}
+}
+
+// a new version that crashes as soon as it starts is rolled back to the old one
+func TestUpgradeRollback(t *testing.T) {
+
+	defer func(settle time.Duration) { upgradeSettleTime = settle }(upgradeSettleTime)
+	upgradeSettleTime = 2 * time.Second
+
+	tests := []struct {
+		name  string
+		sla   func(td *testDatabox) libDatabox.SLA
+		reads string
+	}{
+		{
+			name: "driver keeps its store",
+			sla: func(td *testDatabox) libDatabox.SLA {
+				return td.testSLA("driver-upgrade", libDatabox.DataboxTypeDriver)
+			},
+		},
+		{
+			name: "app loses the new datasource",
+			sla: func(td *testDatabox) libDatabox.SLA {
+				return td.testSLA("app-upgrade", libDatabox.DataboxTypeApp)
+			},
+			reads: "driver-source",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			td := newTestDatabox(t)
+			sla := tt.sla(td)
+			err := td.cm.LaunchFromSLA(sla, true, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			oldImage := td.service(sla.Name).Spec.TaskTemplate.ContainerSpec.Image
+
+			newImage := "databoxsystems/" + sla.Name + ":" + upgradeVersion
+			td.cli.AddImage(newImage)
+			newSLA := sla
+			newSLA.DockerImageTag = upgradeVersion
+			if tt.reads != "" {
+				newSLA.Datasources = td.testSLA(sla.Name, sla.DataboxType, tt.reads).Datasources
+			}
+			disconnects := len(td.network.calls("/disconnect"))
+
+			stop := make(chan struct{})
+			defer close(stop)
+			go func() {
+				for {
+					select {
+					case <-stop:
+						return
+					default:
+					}
+					for _, c := range td.containers(sla.Name) {
+						if c.Image == newImage {
+							td.cli.CrashContainer(c.ID, 1)
+						}
+					}
+					time.Sleep(10 * time.Millisecond)
+				}
+			}()
+
+			err = td.cm.Upgrade(newSLA, ResourceLimits{}, nil)
+			if err == nil || !strings.Contains(err.Error(), "was rolled back") {
+				t.Fatalf("error = %v, want the upgrade to be rolled back", err)
+			}
+
+			if image := td.service(sla.Name).Spec.TaskTemplate.ContainerSpec.Image; image != oldImage {
+				t.Errorf("service image = %s, want %s", image, oldImage)
+			}
+			if saved, err := td.cm.Store.GetSLA(sla.Name); err != nil || saved.DockerImageTag != "" || len(saved.Datasources) != len(sla.Datasources) {
+				t.Errorf("saved SLA = %+v, %v want the old one", saved, err)
+			}
+
+			if sla.DataboxType == libDatabox.DataboxTypeDriver {
+				if td.service(sla.Name+"-core-store") == nil {
+					t.Error("the store was removed")
+				}
+				if !td.volumeExists(sla.Name + "-core-store") {
+					t.Error("the store volume was removed")
+				}
+			}
+
+			if tt.reads != "" {
+				if len(td.network.calls("/disconnect")) != disconnects+1 {
+					t.Error("the endpoints the upgrade connected were not disconnected")
+				}
+				connects := td.network.calls("/connect")
+				if last := connects[len(connects)-1]; strings.Contains(last, tt.reads) {
+					t.Errorf("reconnected with %s after the rollback", last)
+				}
+			}
+		})
+	}
+}
+
+func TestDiffPermissions(t *testing.T) {
+
+	read := func(store string) []libDatabox.ContainerPermissions {
+		return []libDatabox.ContainerPermissions{
+			newPermission("app-diff", store, "/kv/data", "GET", ""),
+			newPermission("app-diff", store, "/kv/data/*", "GET", ""),
+		}
+	}
+	export := newPermission("app-diff", "export-service", "/export", "POST", `{"destination":"https://example.com"}`)
+
+	tests := []struct {
+		name        string
+		oldPerms    []libDatabox.ContainerPermissions
+		newPerms    []libDatabox.ContainerPermissions
+		wantAdded   []libDatabox.ContainerPermissions
+		wantRemoved []libDatabox.ContainerPermissions
+	}{
+		{
+			name:     "unchanged",
+			oldPerms: read("driver-a-core-store"),
+			newPerms: read("driver-a-core-store"),
+		},
+		{
+			name:      "datasource added",
+			oldPerms:  read("driver-a-core-store"),
+			newPerms:  append(read("driver-a-core-store"), read("driver-b-core-store")...),
+			wantAdded: read("driver-b-core-store"),
+		},
+		{
+			name:        "datasource removed",
+			oldPerms:    append(read("driver-a-core-store"), export),
+			newPerms:    []libDatabox.ContainerPermissions{export},
+			wantRemoved: read("driver-a-core-store"),
+		},
+		{
+			name:        "datasource replaced",
+			oldPerms:    read("driver-a-core-store"),
+			newPerms:    read("driver-b-core-store"),
+			wantAdded:   read("driver-b-core-store"),
+			wantRemoved: read("driver-a-core-store"),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			added, removed := diffPermissions(tt.oldPerms, tt.newPerms)
+			if !reflect.DeepEqual(added, tt.wantAdded) {
+				t.Errorf("added = %v, want %v", added, tt.wantAdded)
+			}
+			if !reflect.DeepEqual(removed, tt.wantRemoved) {
+				t.Errorf("removed = %v, want %v", removed, tt.wantRemoved)
+			}
+		})
+	}
+}
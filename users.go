@@ -0,0 +1,400 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	libDatabox "github.com/me-box/lib-go-databox"
+)
+
+// Role is what a user may do
+type Role string
+
+const (
+	// RoleAdmin can do everything including install, uninstall and managing users
+	RoleAdmin Role = "admin"
+	// RoleViewer can see status and open the UIs of the apps in its list
+	RoleViewer Role = "viewer"
+)
+
+// builtinAdmin is the account that logs in with the dashboard password in Credentials.
+// It always exists and can't be edited through the users API.
+const builtinAdmin = "admin"
+
+// appsAll in a user's app list lets them open every app UI
+const appsAll = "*"
+
+var validUserName = regexp.MustCompile(`^[a-z0-9][a-z0-9._-]{0,63}$`)
+
+// dummyPasswordHash is checked against when a user does not exist
+var dummyPasswordHash, _ = newPasswordHash("databox")
+
+// User is a dashboard account
+type User struct {
+	Name    string    `json:"name"`
+	Role    Role      `json:"role"`
+	Apps    []string  `json:"apps"`
+	Created time.Time `json:"created"`
+}
+
+// storedUser is a User as saved in the CM store
+type storedUser struct {
+	User
+	Password PasswordHash `json:"password"`
+}
+
+// userRequest creates or updates a user. Fields left out of an update are not changed.
+type userRequest struct {
+	Name     string    `json:"name"`
+	Password string    `json:"password"`
+	Role     Role      `json:"role"`
+	Apps     *[]string `json:"apps"`
+}
+
+// IsAdmin reports if u has the admin role
+func (u User) IsAdmin() bool {
+	return u.Role == RoleAdmin
+}
+
+// CanOpen reports if u may use the UI of the component app
+func (u User) CanOpen(app string) bool {
+	if u.IsAdmin() {
+		return true
+	}
+	for _, a := range u.Apps {
+		if a == app || a == appsAll {
+			return true
+		}
+	}
+	return false
+}
+
+// UserStore holds the dashboard accounts. The built-in admin is backed by Credentials,
+// the others are kept in the CM store with their password hashes.
+type UserStore struct {
+	store *CMStore
+	creds *Credentials
+
+	mu    sync.Mutex
+	users map[string]storedUser
+}
+
+// NewUserStore loads the accounts saved in store
+func NewUserStore(store *CMStore, creds *Credentials) *UserStore {
+
+	us := &UserStore{
+		store: store,
+		creds: creds,
+		users: make(map[string]storedUser),
+	}
+
+	saved, err := store.GetAllUsers()
+	if err != nil {
+		libDatabox.Err("[Users] Can't load users " + err.Error())
+	}
+	for name, u := range saved {
+		if name == "" || name == builtinAdmin || u.Name != name {
+			libDatabox.Warn("[Users] ignoring a saved user with a bad name \"" + name + "\"")
+			continue
+		}
+		us.users[name] = u
+	}
+
+	return us
+}
+
+func adminUser() User {
+	return User{Name: builtinAdmin, Role: RoleAdmin, Apps: []string{appsAll}}
+}
+
+// Get returns the user called name
+func (us *UserStore) Get(name string) (User, bool) {
+	if name == builtinAdmin {
+		return adminUser(), true
+	}
+	if name == "" {
+		return User{}, false
+	}
+
+	us.mu.Lock()
+	defer us.mu.Unlock()
+	u, ok := us.users[name]
+	return u.User, ok
+}
+
+// List returns every user sorted by name, the built-in admin first
+func (us *UserStore) List() []User {
+
+	us.mu.Lock()
+	res := []User{}
+	for _, u := range us.users {
+		res = append(res, u.User)
+	}
+	us.mu.Unlock()
+
+	sort.Slice(res, func(a, b int) bool {
+		return res[a].Name < res[b].Name
+	})
+	return append([]User{adminUser()}, res...)
+}
+
+// Authenticate checks the Authorization header of r. "Token <password>" is the built-in
+// admin, other users send "Basic" credentials.
+func (us *UserStore) Authenticate(r *http.Request) (User, bool) {
+
+	header := r.Header.Get("Authorization")
+	if strings.HasPrefix(header, "Token ") {
+		if us.creds.CheckAuthHeader(header) {
+			return adminUser(), true
+		}
+		return User{}, false
+	}
+
+	name, password, ok := r.BasicAuth()
+	if !ok {
+		return User{}, false
+	}
+	if name == builtinAdmin {
+		if us.creds.Check(password) {
+			return adminUser(), true
+		}
+		return User{}, false
+	}
+
+	us.mu.Lock()
+	u, found := us.users[name]
+	us.mu.Unlock()
+	if !found {
+		//spend the same time as a wrong password so names can't be probed
+		dummyPasswordHash.Matches(password)
+		return User{}, false
+	}
+	if !u.Password.Matches(password) {
+		return User{}, false
+	}
+	return u.User, true
+}
+
+// Create adds a user
+func (us *UserStore) Create(req userRequest) (User, error) {
+
+	if req.Name == builtinAdmin {
+		return User{}, errors.New(builtinAdmin + " is the built-in account")
+	}
+	if !validUserName.MatchString(req.Name) {
+		return User{}, errors.New("User names must be lower case letters, digits, '.', '_' or '-'")
+	}
+	if req.Role != RoleAdmin && req.Role != RoleViewer {
+		return User{}, errors.New("role must be " + string(RoleAdmin) + " or " + string(RoleViewer))
+	}
+	if len(req.Password) < passwordMinLength {
+		return User{}, errors.New("The password must be at least " + strconv.Itoa(passwordMinLength) + " characters")
+	}
+
+	hash, err := newPasswordHash(req.Password)
+	if err != nil {
+		return User{}, err
+	}
+	u := storedUser{
+		User: User{
+			Name:    req.Name,
+			Role:    req.Role,
+			Apps:    []string{},
+			Created: time.Now(),
+		},
+		Password: hash,
+	}
+	if req.Apps != nil {
+		u.Apps = *req.Apps
+	}
+
+	us.mu.Lock()
+	defer us.mu.Unlock()
+	if _, exists := us.users[req.Name]; exists {
+		return User{}, errors.New("User " + req.Name + " already exists")
+	}
+	err = us.store.SaveUser(u)
+	if err != nil {
+		return User{}, errors.New("Can't save user " + req.Name + " " + err.Error())
+	}
+	us.users[u.Name] = u
+
+	libDatabox.Info("[Users] added " + string(u.Role) + " " + u.Name)
+	return u.User, nil
+}
+
+// Update changes the role, apps or password of the user called name
+func (us *UserStore) Update(name string, req userRequest) (User, error) {
+
+	if name == builtinAdmin {
+		return User{}, errors.New(builtinAdmin + " is the built-in account, change its password with the password API")
+	}
+	if req.Role != "" && req.Role != RoleAdmin && req.Role != RoleViewer {
+		return User{}, errors.New("role must be " + string(RoleAdmin) + " or " + string(RoleViewer))
+	}
+	if req.Password != "" && len(req.Password) < passwordMinLength {
+		return User{}, errors.New("The password must be at least " + strconv.Itoa(passwordMinLength) + " characters")
+	}
+
+	us.mu.Lock()
+	defer us.mu.Unlock()
+
+	u, ok := us.users[name]
+	if !ok {
+		return User{}, errUserNotFound(name)
+	}
+	if req.Role != "" {
+		u.Role = req.Role
+	}
+	if req.Apps != nil {
+		u.Apps = *req.Apps
+	}
+	if req.Password != "" {
+		hash, err := newPasswordHash(req.Password)
+		if err != nil {
+			return User{}, err
+		}
+		u.Password = hash
+	}
+
+	err := us.store.SaveUser(u)
+	if err != nil {
+		return User{}, errors.New("Can't save user " + name + " " + err.Error())
+	}
+	us.users[name] = u
+	return u.User, nil
+}
+
+// Delete removes the user called name
+func (us *UserStore) Delete(name string) error {
+
+	if name == builtinAdmin {
+		return errors.New(builtinAdmin + " is the built-in account and can't be deleted")
+	}
+
+	us.mu.Lock()
+	defer us.mu.Unlock()
+
+	if _, ok := us.users[name]; !ok {
+		return errUserNotFound(name)
+	}
+	err := us.store.DeleteUser(name)
+	if err != nil {
+		return errors.New("Can't delete user " + name + " " + err.Error())
+	}
+	delete(us.users, name)
+
+	libDatabox.Info("[Users] deleted " + name)
+	return nil
+}
+
+// ChangePassword changes the password of name if current is right
+func (us *UserStore) ChangePassword(name string, current string, password string) error {
+
+	if name == builtinAdmin {
+		return us.creds.Change(current, password)
+	}
+
+	us.mu.Lock()
+	u, ok := us.users[name]
+	us.mu.Unlock()
+	if !ok {
+		return errUserNotFound(name)
+	}
+	if !u.Password.Matches(current) {
+		return errWrongPassword
+	}
+
+	_, err := us.Update(name, userRequest{Password: password})
+	return err
+}
+
+func errUserNotFound(name string) error {
+	return errors.New("User " + name + " not found")
+}
+
+type userContextKey struct{}
+
+// withUser returns r carrying the user who made it
+func withUser(r *http.Request, u User) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), userContextKey{}, u))
+}
+
+// requestUser returns the user added to r by withUser
+func requestUser(r *http.Request) User {
+	u, _ := r.Context().Value(userContextKey{}).(User)
+	return u
+}
+
+// viewerAPIPaths are the REST API paths a viewer may GET, they only show status.
+// Paths under /components/{name} are allowed if the viewer can open name.
+var viewerAPIPaths = map[string]bool{
+	"/openapi.json": true,
+	"/status":       true,
+	"/components":   true,
+	"/certificates": true,
+	"/jobs":         true,
+	"/me":           true,
+}
+
+// viewerComponentPaths are what a viewer may GET under /components/{name}
+var viewerComponentPaths = map[string]bool{
+	"":              true,
+	"/logs":         true,
+	"/dependencies": true,
+	"/consumers":    true,
+}
+
+// viewerAllowedAPI reports if the viewer u may make the REST API request r. They can
+// read status, look at the components in their app list, change their own password
+// and log out. Everything else needs an admin.
+func viewerAllowedAPI(r *http.Request, u User) bool {
+
+	path := strings.TrimPrefix(r.URL.Path, restAPIPrefix)
+	if r.Method == "POST" {
+		return path == "/logout" || path == "/password"
+	}
+	if r.Method != "GET" && r.Method != "HEAD" {
+		return false
+	}
+	if viewerAPIPaths[path] || strings.HasPrefix(path, "/jobs/") {
+		return true
+	}
+
+	if strings.HasPrefix(path, "/components/") {
+		rest := strings.TrimPrefix(path, "/components/")
+		name := rest
+		sub := ""
+		if i := strings.Index(rest, "/"); i >= 0 {
+			name, sub = rest[:i], rest[i:]
+		}
+		return name != "" && viewerComponentPaths[sub] && u.CanOpen(name)
+	}
+	return false
+}
+
+// viewerUIAPIPaths are the core-ui API calls under exceptPath a viewer may GET,
+// they only read status. The QR code holds the admin password so it is not one of them.
+var viewerUIAPIPaths = map[string]bool{
+	"containerStatus": true,
+	"dataSources":     true,
+}
+
+// viewerAllowedUI reports if a viewer may make the dashboard request r. The dashboard
+// pages are open to them but only the core-ui API calls in viewerUIAPIPaths are.
+func viewerAllowedUI(r *http.Request) bool {
+	if !strings.HasPrefix(r.URL.Path, exceptPath) {
+		return true
+	}
+	if r.Method != "GET" && r.Method != "HEAD" && r.Method != "OPTIONS" {
+		return false
+	}
+	return viewerUIAPIPaths[strings.TrimPrefix(r.URL.Path, exceptPath)]
+}
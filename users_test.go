@@ -0,0 +1,131 @@
+package main
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestViewerAllowedAPI(t *testing.T) {
+
+	viewer := User{Name: "viewer-one", Role: RoleViewer, Apps: []string{"app-mine"}}
+	viewerAll := User{Name: "viewer-all", Role: RoleViewer, Apps: []string{appsAll}}
+
+	tests := []struct {
+		method string
+		path   string
+		user   User
+		want   bool
+	}{
+		{method: "GET", path: "/status", user: viewer, want: true},
+		{method: "HEAD", path: "/components", user: viewer, want: true},
+		{method: "GET", path: "/jobs/1234", user: viewer, want: true},
+		{method: "GET", path: "/me", user: viewer, want: true},
+		{method: "POST", path: "/logout", user: viewer, want: true},
+		{method: "POST", path: "/password", user: viewer, want: true},
+		{method: "GET", path: "/components/app-mine", user: viewer, want: true},
+		{method: "GET", path: "/components/app-mine/logs", user: viewer, want: true},
+		{method: "GET", path: "/components/app-mine/consumers", user: viewer, want: true},
+		{method: "GET", path: "/components/app-other/logs", user: viewer},
+		{method: "GET", path: "/components/app-other/logs", user: viewerAll, want: true},
+		{method: "GET", path: "/components/app-mine/stats/raw", user: viewer},
+		{method: "GET", path: "/components/", user: viewer},
+		{method: "POST", path: "/install", user: viewerAll},
+		{method: "POST", path: "/uninstall", user: viewerAll},
+		{method: "POST", path: "/components/app-mine/restart", user: viewer},
+		{method: "DELETE", path: "/sessions", user: viewer},
+		{method: "GET", path: "/users", user: viewer},
+		{method: "GET", path: "/lockouts", user: viewer},
+		{method: "GET", path: "/sessions", user: viewer},
+		{method: "GET", path: "/backup", user: viewerAll},
+	}
+
+	for _, tt := range tests {
+		r := httptest.NewRequest(tt.method, restAPIPrefix+tt.path, nil)
+		if got := viewerAllowedAPI(r, tt.user); got != tt.want {
+			t.Errorf("%s %s as %s = %v, want %v", tt.method, tt.path, tt.user.Name, got, tt.want)
+		}
+	}
+}
+
+func TestViewerAllowedUI(t *testing.T) {
+
+	tests := []struct {
+		method string
+		path   string
+		want   bool
+	}{
+		{method: "GET", path: "/", want: true},
+		{method: "GET", path: "/core-ui/ui/index.html", want: true},
+		{method: "GET", path: exceptPath + "containerStatus", want: true},
+		{method: "OPTIONS", path: exceptPath + "dataSources", want: true},
+		{method: "GET", path: exceptPath + "qrcode.png"},
+		{method: "POST", path: exceptPath + "containerStatus"},
+		{method: "POST", path: exceptPath + "install"},
+		{method: "POST", path: exceptPath + "uninstall"},
+	}
+
+	for _, tt := range tests {
+		r := httptest.NewRequest(tt.method, tt.path, nil)
+		if got := viewerAllowedUI(r); got != tt.want {
+			t.Errorf("%s %s = %v, want %v", tt.method, tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestUserStoreGet(t *testing.T) {
+
+	store := &CMStore{Store: newMemoryKV()}
+	//a record with no name, it must not become anyone
+	store.Store.Write(userStoreID, "", []byte(`{"name":"","role":"admin"}`))
+	us := NewUserStore(store, nil)
+	_, err := us.Create(userRequest{Name: "viewer-one", Password: "viewer password", Role: RoleViewer})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		wantOK   bool
+		wantRole Role
+	}{
+		{name: builtinAdmin, wantOK: true, wantRole: RoleAdmin},
+		{name: "viewer-one", wantOK: true, wantRole: RoleViewer},
+		{name: ""},
+		{name: "nobody"},
+	}
+
+	for _, tt := range tests {
+		u, ok := us.Get(tt.name)
+		if ok != tt.wantOK || u.Role != tt.wantRole {
+			t.Errorf("Get(%q) = %s, %v want %s, %v", tt.name, u.Role, ok, tt.wantRole, tt.wantOK)
+		}
+	}
+
+	r := httptest.NewRequest("GET", "/", nil)
+	r.SetBasicAuth("", "")
+	if u, ok := us.Authenticate(r); ok || u.IsAdmin() {
+		t.Error("a blank user name was authenticated")
+	}
+}
+
+// sessions saved before there were users belong to the built-in admin
+func TestSessionMigration(t *testing.T) {
+
+	store := &CMStore{Store: newMemoryKV()}
+	now := time.Now()
+	err := store.SaveSession(Session{ID: hashSessionToken("old-token"), Created: now, LastSeen: now})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sm := NewSessionManager(SessionOptions{PersistSessions: true}, store)
+
+	saved, _ := store.GetAllSessions()
+	if saved[hashSessionToken("old-token")].User != builtinAdmin {
+		t.Errorf("saved session user = %q, want %s", saved[hashSessionToken("old-token")].User, builtinAdmin)
+	}
+	if user, ok := sm.Validate("old-token"); !ok || user != builtinAdmin {
+		t.Errorf("Validate() = %q, %v want %s", user, ok, builtinAdmin)
+	}
+}